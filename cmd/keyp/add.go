@@ -9,7 +9,11 @@ import (
 	"github.com/TheEditor/keyp/internal/ui"
 )
 
-var addNotes string
+var (
+	addNotes   string
+	addExpires string
+	addRotate  string
+)
 
 var addCmd = &cobra.Command{
 	Use:   "add <name>",
@@ -21,20 +25,25 @@ var addCmd = &cobra.Command{
 
 func init() {
 	addCmd.Flags().StringVar(&addNotes, "notes", "", "Optional notes for the secret")
+	addCmd.Flags().StringVar(&addExpires, "expires", "", "Date the secret expires, such as 2027-01-01")
+	addCmd.Flags().StringVar(&addRotate, "rotate", "", "How often the values should be rotated, such as 90d")
 	rootCmd.AddCommand(addCmd)
 }
 
 func runAdd(cmd *cobra.Command, args []string) error {
 	name := args[0]
 
+	secret := model.NewSecretObject(name)
+	if err := applyLifecycle(secret, addExpires, addRotate); err != nil {
+		return err
+	}
+
 	// Get or unlock vault
 	handle, err := getOrUnlockVault(cmd, 0)
 	if err != nil {
 		return err
 	}
 
-	// Create new secret
-	secret := model.NewSecretObject(name)
 	if addNotes != "" {
 		secret.Notes = addNotes
 	}
@@ -60,7 +69,7 @@ func runAdd(cmd *cobra.Command, args []string) error {
 	}
 
 	// Create secret
-	if err := handle.Store().Create(cmd.Context(), secret); err != nil {
+	if err := handle.Create(cmd.Context(), secret); err != nil {
 		return fmt.Errorf("failed to create secret: %w", err)
 	}
 
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/agent"
+	"github.com/TheEditor/keyp/internal/config"
+)
+
+var (
+	agentIdleTimeout time.Duration
+	agentMaxLifetime time.Duration
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Hold unlocked vault keys in memory for other commands",
+	Long: `Run the keyp agent in the foreground. While it runs, unlocking a vault hands
+its key to the agent, and later commands fetch it from the agent over a
+Unix socket that only the current user can connect to. Without the agent
+each command asks for the password again, unless session_file is on.
+
+The agent forgets a key once it has gone unused for the idle timeout, or
+has been held for the maximum lifetime, whichever comes first. keyp lock
+makes it forget every key at once.
+
+The socket is ~/.keyp/agent.sock, or KEYP_AGENT_SOCK if set.`,
+	Args: cobra.NoArgs,
+	RunE: runAgent,
+}
+
+func init() {
+	agentCmd.Flags().DurationVar(&agentIdleTimeout, "idle-timeout", 0, "Forget keys unused for this long (default: session timeout)")
+	agentCmd.Flags().DurationVar(&agentMaxLifetime, "max-lifetime", 0, "Forget keys held for this long, even if in use (default: session max lifetime)")
+	rootCmd.AddCommand(agentCmd)
+}
+
+func runAgent(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	idleTimeout, maxLifetime := cfg.SessionTimeout, cfg.SessionMaxLifetime
+	if agentIdleTimeout > 0 {
+		idleTimeout = agentIdleTimeout
+	}
+	if agentMaxLifetime > 0 {
+		maxLifetime = agentMaxLifetime
+	}
+
+	socketPath := agent.DefaultSocketPath()
+	listener, err := agent.Listen(socketPath)
+	if err != nil {
+		return err
+	}
+
+	a := agent.New(idleTimeout, maxLifetime)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- a.Serve(listener)
+	}()
+
+	fmt.Printf("Agent listening on %s\n", socketPath)
+	fmt.Println("Press Ctrl+C to shutdown...")
+
+	// Wait for interrupt or error
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-sigChan:
+		fmt.Printf("\nReceived signal: %v\n", sig)
+		listener.Close()
+		if err := <-errs; err != nil {
+			return err
+		}
+		fmt.Println("Agent stopped, all keys forgotten")
+		return nil
+	}
+}
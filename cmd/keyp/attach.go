@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+	"github.com/TheEditor/keyp/internal/store"
+	"github.com/TheEditor/keyp/internal/vault"
+)
+
+var attachName string
+
+var attachCmd = &cobra.Command{
+	Use:   "attach <name> <file>",
+	Short: "Attach an encrypted file to a secret",
+	Long: `Store a file, such as an SSH private key, a recovery document or a .p12
+certificate, encrypted alongside a secret. The file is encrypted in chunks
+as it is read and may be up to 16 MiB.
+
+The attachment is named after the file unless --name is given. Use '-' as
+the file to read from standard input, which needs --name.
+
+List attachments with 'keyp attachments <name>' and get one back with
+'keyp extract <name> <file>'.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runAttach,
+}
+
+func init() {
+	attachCmd.Flags().StringVar(&attachName, "name", "", "Name to store the attachment under (default: the file name)")
+	rootCmd.AddCommand(attachCmd)
+}
+
+func runAttach(cmd *cobra.Command, args []string) error {
+	name, path := args[0], args[1]
+
+	file := attachName
+	var r io.Reader
+	if path == "-" {
+		if file == "" {
+			return fmt.Errorf("--name is required when reading from standard input")
+		}
+		r = os.Stdin
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+		if file == "" {
+			file = filepath.Base(path)
+		}
+	}
+
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	attachment, err := handle.Attach(cmd.Context(), name, file, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return fmt.Errorf("secret '%s' not found: %w", name, err)
+		case errors.Is(err, vault.ErrAttachmentExists):
+			return fmt.Errorf("secret '%s' already has an attachment '%s' (remove it with 'keyp detach %s %s')", name, file, name, file)
+		}
+		return fmt.Errorf("failed to attach file: %w", err)
+	}
+
+	fmt.Println(color.Success(fmt.Sprintf("Attached '%s' (%s) to '%s'", attachment.Name, formatSize(attachment.Size), name)))
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+	"github.com/TheEditor/keyp/internal/store"
+)
+
+var attachmentsCmd = &cobra.Command{
+	Use:   "attachments <name>",
+	Short: "List the files attached to a secret",
+	Long: `List the files attached to a secret with their size and when they were
+added. The contents stay encrypted; get one with 'keyp extract'.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAttachments,
+}
+
+func init() {
+	rootCmd.AddCommand(attachmentsCmd)
+}
+
+func runAttachments(cmd *cobra.Command, args []string) error {
+	name := args[0]
+
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	attachments, err := handle.Attachments(cmd.Context(), name)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("secret '%s' not found: %w", name, err)
+		}
+		return fmt.Errorf("failed to list attachments: %w", err)
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(attachments)
+	}
+
+	if len(attachments) == 0 {
+		fmt.Printf("Secret '%s' has no attachments\n", name)
+		return nil
+	}
+
+	header := fmt.Sprintf("%-30s %-10s %s", "NAME", "SIZE", "ADDED")
+	fmt.Println(color.Header(header))
+	for _, a := range attachments {
+		fmt.Printf("%-30s %-10s %s\n", a.Name, formatSize(a.Size), a.CreatedAt.Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+// formatSize returns a byte count in the largest binary unit it reaches
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, suffix := float64(size)/unit, "KiB"
+	for _, next := range []string{"MiB", "GiB"} {
+		if value < unit {
+			break
+		}
+		value, suffix = value/unit, next
+	}
+	return fmt.Sprintf("%.1f %s", value, suffix)
+}
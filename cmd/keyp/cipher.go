@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+	"github.com/TheEditor/keyp/internal/core"
+)
+
+var cipherCmd = &cobra.Command{
+	Use:   "cipher [name]",
+	Short: "Show or set the cipher for new writes",
+	Long: `Show the cipher used to encrypt new and updated values, or select another.
+
+Supported ciphers: ` + strings.Join(core.Ciphers(), ", ") + `
+
+Existing values keep the cipher they were written with and stay readable;
+they move to the new cipher the next time they are saved.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runCipher,
+}
+
+func init() {
+	rootCmd.AddCommand(cipherCmd)
+}
+
+func runCipher(cmd *cobra.Command, args []string) error {
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		name, err := handle.Cipher()
+		if err != nil {
+			return err
+		}
+		fmt.Println(name)
+		return nil
+	}
+
+	if err := handle.SetCipher(args[0]); err != nil {
+		return fmt.Errorf("failed to set cipher: %w", err)
+	}
+	fmt.Println(color.Success(fmt.Sprintf("New values will be encrypted with %s", args[0])))
+	return nil
+}
@@ -2,11 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/agent"
+	"github.com/TheEditor/keyp/internal/config"
+	"github.com/TheEditor/keyp/internal/cli"
 	"github.com/TheEditor/keyp/internal/model"
+	"github.com/TheEditor/keyp/internal/session"
 	"github.com/TheEditor/keyp/internal/store"
 	"github.com/TheEditor/keyp/internal/vault"
 )
@@ -20,18 +28,19 @@ func setupTestVault(t *testing.T) (string, string) {
 
 	// Initialize vault
 	password := "testpassword"
-	v, err := vault.Open(tmpDir, password)
+	vaultPath := filepath.Join(tmpDir, "vault.db")
+	v, err := vault.Init(vaultPath, password)
 	if err != nil {
 		t.Fatalf("failed to initialize vault: %v", err)
 	}
 	defer v.Close()
 
-	return tmpDir, password
+	return vaultPath, password
 }
 
 // cleanupTestVault removes the test vault
-func cleanupTestVault(t *testing.T, tmpDir string) {
-	if err := os.RemoveAll(tmpDir); err != nil {
+func cleanupTestVault(t *testing.T, vaultPath string) {
+	if err := os.RemoveAll(filepath.Dir(vaultPath)); err != nil {
 		t.Logf("warning: failed to cleanup temp dir: %v", err)
 	}
 }
@@ -441,9 +450,9 @@ func TestVaultHandleIntegration(t *testing.T) {
 			t.Errorf("expected vault handle to be unlocked after unlock")
 		}
 
-		// Store should be accessible
-		if handle.Store() == nil {
-			t.Errorf("expected store to be non-nil after unlock")
+		// Secrets should be accessible
+		if _, err := handle.List(context.Background(), nil); err != nil {
+			t.Errorf("expected list to succeed after unlock: %v", err)
 		}
 	})
 
@@ -478,9 +487,9 @@ func TestVaultHandleIntegration(t *testing.T) {
 			t.Errorf("expected vault handle to be locked after lock")
 		}
 
-		// Store should be nil
-		if handle.Store() != nil {
-			t.Errorf("expected store to be nil after lock")
+		// Secrets should no longer be accessible
+		if _, err := handle.List(context.Background(), nil); !errors.Is(err, vault.ErrLocked) {
+			t.Errorf("expected ErrLocked after lock, got %v", err)
 		}
 	})
 
@@ -636,10 +645,10 @@ func TestCLIInitWorkflow(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to create temp dir: %v", err)
 	}
-	defer cleanupTestVault(t, tmpDir)
 
 	// Vault path doesn't exist yet
 	vaultPath := tmpDir + "/vault.db"
+	defer cleanupTestVault(t, vaultPath)
 
 	// Create and initialize vault
 	v, err := vault.Init(vaultPath, "testpassword")
@@ -839,3 +848,114 @@ func TestCLIDeleteWorkflow(t *testing.T) {
 		t.Errorf("secret should not exist after delete")
 	}
 }
+
+// TestCLIStatus tests that status reads a session without prompting
+func TestCLIStatus(t *testing.T) {
+	vaultPath, password := setupTestVault(t)
+	defer cleanupTestVault(t, vaultPath)
+
+	// Keep sessions and the agent socket out of the real home directory
+	t.Setenv("HOME", t.TempDir())
+	oldMgr, oldClient, oldCfg := sessionMgr, agentClient, sessionCfg
+	sessionMgr = session.New(time.Minute, time.Hour)
+	agentClient = agent.NewClient(filepath.Join(t.TempDir(), "none.sock"))
+	sessionCfg = &config.Config{SessionTimeout: time.Minute, SessionMaxLifetime: time.Hour}
+	defer func() { sessionMgr, agentClient, sessionCfg = oldMgr, oldClient, oldCfg }()
+
+	cmd := &cobra.Command{}
+	cmd.SetContext(context.Background())
+
+	status := &vaultStatus{Path: vaultPath, Exists: true}
+	if err := collectStatus(cmd, status); err != nil {
+		t.Fatalf("collectStatus failed: %v", err)
+	}
+	if status.Unlocked || status.Secrets != nil || len(status.Slots) != 1 {
+		t.Errorf("expected a locked vault with one slot, got %+v", status)
+	}
+
+	handle := vault.NewHandle(vaultPath)
+	if err := handle.Unlock(password, 0); err != nil {
+		t.Fatalf("failed to unlock: %v", err)
+	}
+
+	// Without an agent the key is not written to disk unless session files are on
+	saveSession(handle)
+	if _, _, err := sessionMgr.Load(handle.ID()); err == nil {
+		t.Error("expected no session file without session_file")
+	}
+	sessionCfg.SessionFile = true
+	saveSession(handle)
+	handle.Lock()
+
+	status = &vaultStatus{Path: vaultPath, Exists: true}
+	if err := collectStatus(cmd, status); err != nil {
+		t.Fatalf("collectStatus failed: %v", err)
+	}
+	if !status.Unlocked || status.Session != sessionSourceFile || status.Secrets == nil || *status.Secrets != 0 {
+		t.Errorf("expected an unlocked, empty vault, got %+v", status)
+	}
+	if status.ExpiresInSeconds <= 0 || status.ExpiresInSeconds > 60 {
+		t.Errorf("expected session to expire within a minute, got %ds", status.ExpiresInSeconds)
+	}
+
+	// Checking the status does not slide the idle timeout
+	key, before, err := sessionMgr.Load(status.ID)
+	if err != nil {
+		t.Fatalf("failed to load session: %v", err)
+	}
+	key.Destroy()
+	time.Sleep(1100 * time.Millisecond)
+	if err := collectStatus(cmd, &vaultStatus{Path: vaultPath, Exists: true}); err != nil {
+		t.Fatalf("collectStatus failed: %v", err)
+	}
+	key, after, err := sessionMgr.Load(status.ID)
+	if err != nil {
+		t.Fatalf("failed to load session: %v", err)
+	}
+	key.Destroy()
+	if !after.Equal(before) {
+		t.Errorf("status moved the session expiry from %v to %v", before, after)
+	}
+}
+
+// TestCLIStatusExitCodes tests the exit codes scripts branch on
+func TestCLIStatusExitCodes(t *testing.T) {
+	if code := getExitCode(vault.ErrLocked); code != cli.ExitVaultLocked {
+		t.Errorf("locked vault: got exit code %d, want %d", code, cli.ExitVaultLocked)
+	}
+	if code := getExitCode(vault.ErrNotExists); code != cli.ExitNotFound {
+		t.Errorf("missing vault: got exit code %d, want %d", code, cli.ExitNotFound)
+	}
+}
+
+// TestCLIExpiring tests the --expires and --rotate values and the exit
+// codes of keyp expiring
+func TestCLIExpiring(t *testing.T) {
+	secret := model.NewSecretObject("cert")
+	if err := applyLifecycle(secret, "2027-01-01", "90d"); err != nil {
+		t.Fatalf("applyLifecycle failed: %v", err)
+	}
+	want := time.Date(2027, 1, 1, 0, 0, 0, 0, time.Local)
+	if !secret.ExpiresAt.Equal(want) || secret.RotateEvery != model.Period(90*24*time.Hour) {
+		t.Errorf("expected expiry %v every 90d, got %v every %v", want, secret.ExpiresAt, secret.RotateEvery)
+	}
+	// Empty values leave both alone, never clears them
+	if err := applyLifecycle(secret, "", ""); err != nil || secret.ExpiresAt.IsZero() || secret.RotateEvery == 0 {
+		t.Errorf("expected empty values to keep the lifecycle, got %+v, %v", secret, err)
+	}
+	if err := applyLifecycle(secret, "never", "never"); err != nil || !secret.ExpiresAt.IsZero() || secret.RotateEvery != 0 {
+		t.Errorf("expected never to clear the lifecycle, got %+v, %v", secret, err)
+	}
+	for _, bad := range [][2]string{{"tomorrow", ""}, {"", "often"}, {"", "0d"}} {
+		if err := applyLifecycle(secret, bad[0], bad[1]); err == nil {
+			t.Errorf("expected an error for --expires %q --rotate %q", bad[0], bad[1])
+		}
+	}
+
+	if code := getExitCode(fmt.Errorf("2 secret(s) %w", errSecretsOverdue)); code != cli.ExitOverdue {
+		t.Errorf("overdue: got exit code %d, want %d", code, cli.ExitOverdue)
+	}
+	if code := getExitCode(fmt.Errorf("1 secret(s) %w within 30d", errSecretsDue)); code != cli.ExitDue {
+		t.Errorf("due: got exit code %d, want %d", code, cli.ExitDue)
+	}
+}
@@ -13,9 +13,13 @@ import (
 var deleteForce bool
 
 var deleteCmdObj = &cobra.Command{
-	Use:     "delete <name>",
-	Short:   "Delete a secret",
-	Long:    "Remove a secret from the vault. Requires confirmation unless --force is used.",
+	Use:   "delete <name>",
+	Short: "Move a secret to the trash",
+	Long: `Move a secret to the trash. Requires confirmation unless --force is used.
+
+Deleted secrets are hidden from list and search but keep their fields,
+history and attachments. Bring one back with 'keyp restore <name>'; 'keyp trash purge'
+removes them for good.`,
 	Aliases: []string{"rm"},
 	Args:    cobra.ExactArgs(1),
 	RunE:    runDelete,
@@ -37,7 +41,7 @@ func runDelete(cmd *cobra.Command, args []string) error {
 	}
 
 	// Verify exists
-	_, err = handle.Store().GetByName(cmd.Context(), name)
+	_, err = handle.GetByName(cmd.Context(), name)
 	if err != nil {
 		if errors.Is(err, store.ErrNotFound) {
 			return fmt.Errorf("secret '%s' not found: %w", name, err)
@@ -57,11 +61,11 @@ func runDelete(cmd *cobra.Command, args []string) error {
 	}
 
 	// Delete
-	if err := handle.Store().Delete(cmd.Context(), name); err != nil {
+	if err := handle.Delete(cmd.Context(), name); err != nil {
 		return fmt.Errorf("failed to delete secret: %w", err)
 	}
 
-	msg := fmt.Sprintf("Secret '%s' deleted", name)
+	msg := fmt.Sprintf("Secret '%s' moved to trash (undo with 'keyp restore %s')", name, name)
 	fmt.Println(color.Success(msg))
 	return nil
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+	"github.com/TheEditor/keyp/internal/store"
+	"github.com/TheEditor/keyp/internal/ui"
+	"github.com/TheEditor/keyp/internal/vault"
+)
+
+var detachForce bool
+
+var detachCmd = &cobra.Command{
+	Use:   "detach <name> <file>",
+	Short: "Remove a file attached to a secret",
+	Long: `Permanently remove a file attached to a secret. Attachments do not go
+through the trash, so this cannot be undone.
+
+Requires confirmation unless --force is used.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runDetach,
+}
+
+func init() {
+	detachCmd.Flags().BoolVarP(&detachForce, "force", "f", false, "Skip confirmation prompt")
+	rootCmd.AddCommand(detachCmd)
+}
+
+func runDetach(cmd *cobra.Command, args []string) error {
+	name, file := args[0], args[1]
+
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	// Verify exists
+	if _, err := handle.Attachment(cmd.Context(), name, file); err != nil {
+		return detachError(name, file, err)
+	}
+
+	// Confirm removal
+	if !detachForce {
+		confirm, err := ui.PromptVisible(fmt.Sprintf("Type '%s' to confirm removal: ", file))
+		if err != nil {
+			return err
+		}
+		if confirm != file {
+			return fmt.Errorf("removal cancelled")
+		}
+	}
+
+	if err := handle.Detach(cmd.Context(), name, file); err != nil {
+		return detachError(name, file, err)
+	}
+
+	fmt.Println(color.Success(fmt.Sprintf("Removed '%s' from '%s'", file, name)))
+	return nil
+}
+
+// detachError explains why an attachment could not be removed
+func detachError(name, file string, err error) error {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return fmt.Errorf("secret '%s' not found: %w", name, err)
+	case errors.Is(err, vault.ErrAttachmentNotFound):
+		return fmt.Errorf("secret '%s' has no attachment '%s'", name, file)
+	}
+	return fmt.Errorf("failed to remove attachment: %w", err)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+	"github.com/TheEditor/keyp/internal/vault"
+)
+
+var doctorRepair bool
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the vault for corruption and inconsistencies",
+	Long: `Check the health of the vault:
+
+  integrity        SQLite integrity check of the database file
+  verify           the verification value decrypts with the vault key
+  decrypt          every encrypted field value, in the secrets, the trash and
+                   their history, and every attachment decrypts and
+                   authenticates
+  duplicate_names  no two secrets share a name, and each is found by its own
+  tags             every secret's tags are a JSON array of strings
+  orphaned_fields  no field rows are left behind by deleted secrets
+
+Use --repair to fix the problems marked repairable: orphaned fields are
+removed, broken tags are reset (or rebuilt from encrypted metadata), and
+secrets sharing a name are renamed with a numeric suffix, such as github-2.
+A verified backup is taken before anything is changed.
+
+Exits non-zero if any problem remains.`,
+	Args: cobra.NoArgs,
+	RunE: runDoctor,
+}
+
+func init() {
+	doctorCmd.Flags().BoolVar(&doctorRepair, "repair", false, "Back up the vault and fix repairable problems")
+	rootCmd.AddCommand(doctorCmd)
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	report, err := handle.Doctor(cmd.Context(), doctorRepair)
+	if report != nil && report.Backup != "" && !jsonOutput {
+		fmt.Printf("Backup written to %s\n", report.Backup)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check vault: %w", err)
+	}
+
+	remaining := 0
+	for _, p := range report.Problems {
+		if !p.Repaired {
+			remaining++
+		}
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		for _, p := range report.Problems {
+			fmt.Println(formatProblem(p))
+		}
+		if remaining == 0 {
+			fmt.Println(color.Success("Vault is healthy"))
+		}
+	}
+
+	if remaining > 0 {
+		return fmt.Errorf("vault has %d unresolved problem(s)", remaining)
+	}
+	return nil
+}
+
+// formatProblem renders a doctor finding on one line
+func formatProblem(p vault.Problem) string {
+	where := p.Secret
+	if where == "" {
+		where = p.SecretID
+	}
+	if p.Field != "" {
+		where += "/" + p.Field
+	} else if p.FieldID != "" {
+		where += "/" + p.FieldID
+	}
+	if p.Attachment != "" {
+		where += " attachment " + p.Attachment
+	}
+	if p.Version != 0 {
+		where += fmt.Sprintf(" (version %d)", p.Version)
+	}
+	if where != "" {
+		where = " " + where + ":"
+	}
+
+	line := fmt.Sprintf("[%s]%s %s", p.Check, where, p.Message)
+	switch {
+	case p.Repaired:
+		return color.Success(line + " (repaired)")
+	case p.Repairable:
+		return color.Warning(line + " (repairable with --repair)")
+	default:
+		return color.Warning(line)
+	}
+}
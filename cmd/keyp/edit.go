@@ -35,7 +35,7 @@ func runEdit(cmd *cobra.Command, args []string) error {
 	}
 
 	// Get secret
-	secret, err := handle.Store().GetByName(cmd.Context(), name)
+	secret, err := handle.GetByName(cmd.Context(), name)
 	if err != nil {
 		return fmt.Errorf("failed to get secret: %w", err)
 	}
@@ -84,7 +84,7 @@ func runEdit(cmd *cobra.Command, args []string) error {
 	}
 
 	// Update secret
-	if err := handle.Store().Update(cmd.Context(), secret); err != nil {
+	if err := handle.Update(cmd.Context(), secret); err != nil {
 		return fmt.Errorf("failed to update secret: %w", err)
 	}
 
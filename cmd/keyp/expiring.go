@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+	"github.com/TheEditor/keyp/internal/config"
+	"github.com/TheEditor/keyp/internal/model"
+)
+
+var (
+	// errSecretsDue is returned when a secret expires or is due for rotation
+	// within the window, but none already has or is
+	errSecretsDue = errors.New("expiring or due for rotation")
+
+	// errSecretsOverdue is returned when a secret has expired or is overdue
+	// for rotation
+	errSecretsOverdue = errors.New("expired or overdue for rotation")
+)
+
+var expiringWithin string
+
+var expiringCmd = &cobra.Command{
+	Use:   "expiring",
+	Short: "List secrets that have expired or are due for rotation",
+	Long: `List the secrets that have expired or are overdue for rotation, and those
+that will be within --within (default 30d), soonest first.
+
+A secret expires on the date set with --expires on 'keyp set' or 'keyp add',
+and is due for rotation --rotate after any of its field values last changed.
+
+Exits 0 if nothing is listed, 6 if secrets are only coming due, and 7 if
+any has expired or is overdue, so it can be run from cron.`,
+	Args: cobra.NoArgs,
+	RunE: runExpiring,
+}
+
+func init() {
+	expiringCmd.Flags().StringVar(&expiringWithin, "within", "30d", "Also list secrets due within this time, such as 7d or 12h")
+	rootCmd.AddCommand(expiringCmd)
+}
+
+// expiringEntry is the JSON form of a secret that needs attention
+type expiringEntry struct {
+	Name        string       `json:"name"`
+	Status      string       `json:"status"`
+	Due         time.Time    `json:"due"`
+	ExpiresAt   time.Time    `json:"expires_at,omitzero"`
+	RotateEvery model.Period `json:"rotate_every,omitzero"`
+	RotatedAt   time.Time    `json:"rotated_at,omitzero"`
+}
+
+func runExpiring(cmd *cobra.Command, args []string) error {
+	within, err := config.ParseDuration(expiringWithin)
+	if err != nil || within < 0 {
+		return fmt.Errorf("invalid --within: %q", expiringWithin)
+	}
+
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	secrets, err := handle.Expiring(cmd.Context(), within)
+	if err != nil {
+		return fmt.Errorf("failed to list expiring secrets: %w", err)
+	}
+
+	now := time.Now()
+	entries := make([]expiringEntry, 0, len(secrets))
+	overdue := 0
+	for _, s := range secrets {
+		status, due, ok := s.Due(now, now.Add(within))
+		if !ok {
+			continue
+		}
+		if status == model.DueExpired || status == model.DueRotationOverdue {
+			overdue++
+		}
+		entries = append(entries, expiringEntry{
+			Name:        s.Name,
+			Status:      status,
+			Due:         due,
+			ExpiresAt:   s.ExpiresAt,
+			RotateEvery: s.RotateEvery,
+			RotatedAt:   s.RotatedAt,
+		})
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(entries); err != nil {
+			return err
+		}
+	} else if len(entries) == 0 {
+		fmt.Println(color.Success("Nothing expiring within " + expiringWithin))
+	} else {
+		header := fmt.Sprintf("%-30s %-18s %s", "NAME", "STATUS", "DUE")
+		fmt.Println(color.Header(header))
+		for _, e := range entries {
+			line := fmt.Sprintf("%-30s %-18s %s", e.Name, e.Status, formatDue(e.Due, now))
+			if e.Status == model.DueExpired || e.Status == model.DueRotationOverdue {
+				line = color.Warning(line)
+			}
+			fmt.Println(line)
+		}
+	}
+
+	switch {
+	case overdue > 0:
+		return fmt.Errorf("%d secret(s) %w", overdue, errSecretsOverdue)
+	case len(entries) > 0:
+		return fmt.Errorf("%d secret(s) %w within %s", len(entries), errSecretsDue, expiringWithin)
+	}
+	return nil
+}
+
+// formatDue renders a due time as a date and how far it is from now in
+// whole days
+func formatDue(due, now time.Time) string {
+	date := due.Local().Format("2006-01-02")
+	days := int(due.Sub(now).Hours() / 24)
+	switch {
+	case due.Before(now):
+		return fmt.Sprintf("%s (%dd ago)", date, -days)
+	case days == 0:
+		return fmt.Sprintf("%s (today)", date)
+	default:
+		return fmt.Sprintf("%s (in %dd)", date, days)
+	}
+}
+
+// parseExpires parses the value of an --expires flag: a date, such as
+// 2027-01-01, taken as local midnight, an RFC 3339 time, or "never" to
+// clear the expiry
+func parseExpires(value string) (time.Time, error) {
+	if value == "never" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --expires: %q (want a date such as 2027-01-01, or never)", value)
+	}
+	return t, nil
+}
+
+// parseRotate parses the value of a --rotate flag: a period such as 90d,
+// or "never" to stop tracking rotation
+func parseRotate(value string) (model.Period, error) {
+	if value == "never" {
+		return 0, nil
+	}
+	d, err := config.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid --rotate: %q (want a period such as 90d, or never)", value)
+	}
+	return model.Period(d), nil
+}
+
+// applyLifecycle sets the expiry and rotation period of a secret from the
+// values of --expires and --rotate, leaving either as is when empty
+func applyLifecycle(secret *model.SecretObject, expires, rotate string) error {
+	if expires != "" {
+		t, err := parseExpires(expires)
+		if err != nil {
+			return err
+		}
+		secret.ExpiresAt = t
+	}
+	if rotate != "" {
+		p, err := parseRotate(rotate)
+		if err != nil {
+			return err
+		}
+		secret.RotateEvery = p
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+	"github.com/TheEditor/keyp/internal/store"
+	"github.com/TheEditor/keyp/internal/vault"
+)
+
+var (
+	extractOutput string
+	extractForce  bool
+)
+
+var extractCmd = &cobra.Command{
+	Use:   "extract <name> <file>",
+	Short: "Decrypt a file attached to a secret",
+	Long: `Decrypt a file attached to a secret and write it to the current directory
+under its own name, or to the path given with -o. Use '-o -' to write it
+to standard output.
+
+The file is created readable by you alone, and an existing file is not
+replaced unless --force is used. Nothing is left behind if the attachment
+turns out to be corrupted.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runExtract,
+}
+
+func init() {
+	extractCmd.Flags().StringVarP(&extractOutput, "output", "o", "", "Path to write the file to, or - for standard output")
+	extractCmd.Flags().BoolVarP(&extractForce, "force", "f", false, "Replace an existing file")
+	rootCmd.AddCommand(extractCmd)
+}
+
+func runExtract(cmd *cobra.Command, args []string) error {
+	name, file := args[0], args[1]
+
+	path := extractOutput
+	if path == "" {
+		path = file
+	}
+	if path != "-" && !extractForce {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("'%s' already exists (use --force to replace it)", path)
+		}
+	}
+
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	if path == "-" {
+		_, err := handle.Extract(cmd.Context(), name, file, os.Stdout)
+		return extractError(name, file, err)
+	}
+
+	// Write to a temporary file beside the destination, so a failure leaves
+	// nothing half-written in its place
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keyp-extract-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	attachment, err := handle.Extract(cmd.Context(), name, file, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return extractError(name, file, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write '%s': %w", path, err)
+	}
+
+	fmt.Println(color.Success(fmt.Sprintf("Extracted '%s' (%s) to %s", attachment.Name, formatSize(attachment.Size), path)))
+	return nil
+}
+
+// extractError explains why an attachment could not be extracted
+func extractError(name, file string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, store.ErrNotFound):
+		return fmt.Errorf("secret '%s' not found: %w", name, err)
+	case errors.Is(err, vault.ErrAttachmentNotFound):
+		return fmt.Errorf("secret '%s' has no attachment '%s' (see 'keyp attachments %s')", name, file, name)
+	}
+	return fmt.Errorf("failed to extract attachment: %w", err)
+}
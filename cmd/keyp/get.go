@@ -10,6 +10,7 @@ import (
 	"github.com/TheEditor/keyp/internal/color"
 	"github.com/TheEditor/keyp/internal/store"
 	"github.com/TheEditor/keyp/internal/ui"
+	"github.com/TheEditor/keyp/internal/vault"
 )
 
 var (
@@ -40,36 +41,25 @@ func runGet(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	// Get secret
-	secret, err := handle.Store().GetByName(cmd.Context(), name)
+	// Decrypt only the requested field, into memory that is wiped afterwards
+	value, err := handle.RevealField(cmd.Context(), name, getField)
 	if err != nil {
 		if errors.Is(err, store.ErrNotFound) {
 			return fmt.Errorf("secret '%s' not found: %w", name, err)
 		}
-		return fmt.Errorf("failed to get secret: %w", err)
-	}
-
-	// Find field
-	var value string
-	if getField != "" {
-		for _, f := range secret.Fields {
-			if f.Label == getField {
-				value = f.Value
-				break
+		if errors.Is(err, vault.ErrFieldNotFound) {
+			if getField != "" {
+				return fmt.Errorf("field '%s' not found", getField)
 			}
+			return fmt.Errorf("secret has no fields")
 		}
-		if value == "" {
-			return fmt.Errorf("field '%s' not found", getField)
-		}
-	} else if len(secret.Fields) > 0 {
-		value = secret.Fields[0].Value
-	} else {
-		return fmt.Errorf("secret has no fields")
+		return fmt.Errorf("failed to get secret: %w", err)
 	}
+	defer value.Destroy()
 
 	// JSON output
 	if jsonOutput {
-		output := map[string]string{"value": value}
+		output := map[string]string{"value": string(value.Bytes())}
 		enc := json.NewEncoder(os.Stdout)
 		enc.SetIndent("", "  ")
 		enc.SetEscapeHTML(false)
@@ -78,9 +68,10 @@ func runGet(cmd *cobra.Command, args []string) error {
 
 	// Output
 	if getStdout {
-		fmt.Println(value)
+		os.Stdout.Write(value.Bytes())
+		fmt.Println()
 	} else {
-		if err := ui.CopyWithAutoClear(value, ui.DefaultClearDuration); err != nil {
+		if err := ui.CopyWithAutoClear(string(value.Bytes()), ui.DefaultClearDuration); err != nil {
 			return fmt.Errorf("failed to copy to clipboard: %w", err)
 		}
 		fmt.Println(color.Success("Copied to clipboard (clears in 45s)"))
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history <name>",
+	Short: "List the versions of a secret",
+	Long: `List every saved version of a secret, oldest first, with when it was saved
+and when it was replaced. Each edit keeps the previous state as a version,
+encrypted like the secret itself.
+
+View a version with 'keyp show <name> --version N' and restore it with
+'keyp rollback <name> N'.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runHistory,
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+}
+
+func runHistory(cmd *cobra.Command, args []string) error {
+	name := args[0]
+
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	versions, err := handle.History(cmd.Context(), name)
+	if err != nil {
+		return fmt.Errorf("failed to get history: %w", err)
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(versions)
+	}
+
+	header := fmt.Sprintf("%-8s %-17s %s", "VERSION", "SAVED", "REPLACED")
+	fmt.Println(color.Header(header))
+	for _, v := range versions {
+		replaced := "(current)"
+		if !v.ReplacedAt.IsZero() {
+			replaced = v.ReplacedAt.Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%-8d %-17s %s\n", v.Version, v.UpdatedAt.Format("2006-01-02 15:04"), replaced)
+	}
+	return nil
+}
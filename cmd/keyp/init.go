@@ -2,23 +2,48 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+	"github.com/TheEditor/keyp/internal/core"
 	"github.com/TheEditor/keyp/internal/ui"
 	"github.com/TheEditor/keyp/internal/vault"
 )
 
-var initCmdPath string
+var (
+	initCmdPath     string
+	initRecoveryKey bool
+	initEncryptMeta bool
+	initCipher      string
+	initIgnoreCase  bool
+)
 
 var initCmdObj = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize a new keyp vault",
-	Long:  "Create a new encrypted vault for storing secrets.",
+	Long: `Create a new encrypted vault for storing secrets.
+
+With --keyfile, unlocking also requires the given key file, for example one
+kept on a USB stick. A new random key file is created if none exists there.
+
+With --recovery-key, a recovery key is generated and printed once. It can set
+a new password with 'keyp recover' if the password is forgotten.
+
+With --encrypt-metadata, secret names, tags, notes and field labels are stored
+encrypted as well, so the vault file does not reveal which accounts it holds.
+
+With --case-insensitive-names, secret names match regardless of case, so
+'GitHub' and 'github' are the same secret.`,
 	RunE:  runInit,
 }
 
 func init() {
 	initCmdObj.Flags().StringVar(&initCmdPath, "path", "", "Path to vault directory (default: ~/.keyp)")
+	initCmdObj.Flags().BoolVar(&initRecoveryKey, "recovery-key", false, "Generate a recovery key for a forgotten password")
+	initCmdObj.Flags().BoolVar(&initEncryptMeta, "encrypt-metadata", false, "Encrypt secret names, tags, notes and field labels")
+	initCmdObj.Flags().StringVar(&initCipher, "cipher", "", "Cipher for encrypted values (aes-256-gcm or xchacha20-poly1305)")
+	initCmdObj.Flags().BoolVar(&initIgnoreCase, "case-insensitive-names", false, "Match secret names regardless of case")
 	rootCmd.AddCommand(initCmdObj)
 }
 
@@ -32,6 +57,11 @@ func runInit(cmd *cobra.Command, args []string) error {
 	if vault.Exists(path) {
 		return fmt.Errorf("vault already exists at %s", path)
 	}
+	if initCipher != "" {
+		if err := core.ValidateCipher(initCipher); err != nil {
+			return err
+		}
+	}
 
 	// Prompt for password with confirmation
 	password, err := ui.PromptConfirmPassword(
@@ -47,25 +77,70 @@ func runInit(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("password must be at least 8 characters")
 	}
 
+	// Generate the key file on first use
+	keyFilePath := getKeyFilePath()
+	if keyFilePath != "" {
+		if _, err := os.Stat(keyFilePath); os.IsNotExist(err) {
+			if err := vault.GenerateKeyFile(keyFilePath); err != nil {
+				return err
+			}
+			fmt.Printf("Key file created at %s (keep a backup: the vault cannot be opened without it)\n", keyFilePath)
+		}
+	}
+
 	// Create vault with encryption
-	v, err := vault.Init(path, password)
+	v, err := vault.InitWithKeyFile(path, password, keyFilePath)
 	if err != nil {
 		return fmt.Errorf("failed to initialize vault: %w", err)
 	}
+	if initCipher != "" {
+		if err := v.SetCipher(initCipher); err != nil {
+			v.Close()
+			return fmt.Errorf("failed to set cipher: %w", err)
+		}
+	}
+	if initIgnoreCase {
+		if err := v.SetCaseInsensitiveNames(cmd.Context(), true); err != nil {
+			v.Close()
+			return fmt.Errorf("failed to set name matching: %w", err)
+		}
+	}
+	if initEncryptMeta {
+		if _, err := v.EncryptMetadata(cmd.Context()); err != nil {
+			v.Close()
+			return fmt.Errorf("failed to enable metadata encryption: %w", err)
+		}
+	}
+	var recoveryKey string
+	if initRecoveryKey {
+		recoveryKey, err = v.AddRecoveryKey()
+		if err != nil {
+			v.Close()
+			return fmt.Errorf("failed to create recovery key: %w", err)
+		}
+	}
 	v.Close()
 
 	// Auto-unlock vault after successful init since user just proved they know the password
 	handle := vault.NewHandle(path)
-	if err := handle.Unlock(password, 0); err != nil {
+	if err := handle.UnlockWithKeyFile(password, keyFilePath, 0); err != nil {
 		return fmt.Errorf("failed to unlock vault after init: %w", err)
 	}
 	setVaultHandle(handle)
 
 	// Save session to avoid prompting for password on subsequent commands
-	if derivedKey := handle.GetDerivedKey(); derivedKey != nil {
-		sessionMgr.Save(derivedKey)
-	}
+	saveSession(handle)
 
 	fmt.Printf("Vault initialized at %s\n", path)
+
+	if recoveryKey != "" {
+		fmt.Println()
+		fmt.Println(color.Header("Recovery key (shown only once):"))
+		fmt.Println()
+		fmt.Printf("  %s\n", recoveryKey)
+		fmt.Println()
+		fmt.Println("Write it down and keep it somewhere safe, away from this computer.")
+		fmt.Println("Anyone with this key can open the vault with 'keyp recover'.")
+	}
 	return nil
 }
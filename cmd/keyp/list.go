@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/model"
 	"github.com/TheEditor/keyp/internal/color"
 	"github.com/TheEditor/keyp/internal/store"
 )
@@ -17,13 +18,27 @@ var (
 )
 
 var listCmdObj = &cobra.Command{
-	Use:     "list",
-	Short:   "List all secrets",
-	Long:    "Show all secrets in the vault with optional tag filtering.",
+	Use:   "list [folder]",
+	Short: "List all secrets",
+	Long: `Show all secrets in the vault with optional tag filtering.
+
+Secret names are paths, such as work/aws/prod. Given a folder, such as
+work/aws/, only its direct children are shown: subfolders, ending in a
+slash, followed by the secrets it holds.`,
 	Aliases: []string{"ls"},
+	Args:    cobra.MaximumNArgs(1),
 	RunE:    runList,
 }
 
+// listEntry is the JSON form of a direct child of a folder
+type listEntry struct {
+	Name    string              `json:"name"`
+	Path    string              `json:"path"`
+	Folder  bool                `json:"folder"`
+	Secrets int                 `json:"secrets,omitempty"`
+	Secret  *model.SecretObject `json:"secret,omitempty"`
+}
+
 func init() {
 	listCmdObj.Flags().StringSliceVar(&listTags, "tags", nil, "Filter by tags (comma-separated)")
 	listCmdObj.Flags().BoolVar(&listPorcelain, "porcelain", false, "Output tab-separated values (no headers)")
@@ -37,24 +52,35 @@ func runList(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	// Build SearchOptions for tag filtering
+	// Build SearchOptions for tag and folder filtering
 	var opts *store.SearchOptions
-	if len(listTags) > 0 {
+	if len(listTags) > 0 || len(args) == 1 {
 		opts = &store.SearchOptions{Tags: listTags}
 	}
+	if len(args) == 1 {
+		opts.Prefix = model.FolderPrefix(args[0])
+	}
 
 	// List secrets
-	secrets, err := handle.Store().List(cmd.Context(), opts)
+	secrets, err := handle.List(cmd.Context(), opts)
 	if err != nil {
 		return fmt.Errorf("failed to list secrets: %w", err)
 	}
 
+	if len(args) == 1 {
+		return printFolder(model.ListFolder(secrets, args[0]))
+	}
+
 	// Output
 	if jsonOutput {
 		enc := json.NewEncoder(os.Stdout)
 		enc.SetIndent("", "  ")
 		enc.SetEscapeHTML(false)
-		return enc.Encode(secrets)
+		redacted := make([]*model.SecretObject, len(secrets))
+		for i, s := range secrets {
+			redacted[i] = s.Redacted()
+		}
+		return enc.Encode(redacted)
 	}
 
 	// Porcelain output (tab-separated, no headers)
@@ -62,7 +88,8 @@ func runList(cmd *cobra.Command, args []string) error {
 		for _, s := range secrets {
 			tags := strings.Join(s.Tags, ", ")
 			updated := s.UpdatedAt.Format("2006-01-02")
-			fmt.Printf("%s\t%s\t%s\n", s.Name, tags, updated)
+			expires, rotate := dueDates(s)
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", s.Name, tags, updated, expires, rotate)
 		}
 		return nil
 	}
@@ -74,15 +101,79 @@ func runList(cmd *cobra.Command, args []string) error {
 	}
 
 	// Print colored header
-	header := fmt.Sprintf("%-30s %-20s %s", "NAME", "TAGS", "UPDATED")
+	header := fmt.Sprintf("%-30s %-20s %-16s %-10s %s", "NAME", "TAGS", "UPDATED", "EXPIRES", "ROTATE BY")
 	fmt.Println(color.Header(header))
 
 	// Print rows
 	for _, s := range secrets {
 		tags := strings.Join(s.Tags, ", ")
 		updated := s.UpdatedAt.Format("2006-01-02 15:04")
-		fmt.Printf("%-30s %-20s %s\n", s.Name, tags, updated)
+		expires, rotate := dueDates(s)
+		fmt.Printf("%-30s %-20s %-16s %-10s %s\n", s.Name, tags, updated, expires, rotate)
 	}
 
 	return nil
 }
+
+// printFolder writes the direct children of a folder
+func printFolder(entries []model.PathEntry) error {
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		items := make([]listEntry, len(entries))
+		for i, e := range entries {
+			items[i] = listEntry{Name: e.Name, Path: e.Path, Folder: e.IsFolder(), Secrets: e.Secrets}
+			if !e.IsFolder() {
+				items[i].Secret = e.Secret.Redacted()
+			}
+		}
+		return enc.Encode(items)
+	}
+
+	if listPorcelain {
+		for _, e := range entries {
+			if e.IsFolder() {
+				fmt.Printf("%s/\t\t\t\t\n", e.Path)
+				continue
+			}
+			tags := strings.Join(e.Secret.Tags, ", ")
+			updated := e.Secret.UpdatedAt.Format("2006-01-02")
+			expires, rotate := dueDates(e.Secret)
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", e.Path, tags, updated, expires, rotate)
+		}
+		return nil
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No secrets found")
+		return nil
+	}
+
+	header := fmt.Sprintf("%-30s %-20s %-16s %-10s %s", "NAME", "TAGS", "UPDATED", "EXPIRES", "ROTATE BY")
+	fmt.Println(color.Header(header))
+	for _, e := range entries {
+		if e.IsFolder() {
+			fmt.Printf("%-30s %-20s %s\n", e.Name+"/", "", fmt.Sprintf("(%d secrets)", e.Secrets))
+			continue
+		}
+		tags := strings.Join(e.Secret.Tags, ", ")
+		updated := e.Secret.UpdatedAt.Format("2006-01-02 15:04")
+		expires, rotate := dueDates(e.Secret)
+		fmt.Printf("%-30s %-20s %-16s %-10s %s\n", e.Name, tags, updated, expires, rotate)
+	}
+	return nil
+}
+
+// dueDates returns the dates a secret expires and should next be rotated,
+// each empty if not set
+func dueDates(s *model.SecretObject) (string, string) {
+	var expires, rotate string
+	if !s.ExpiresAt.IsZero() {
+		expires = s.ExpiresAt.Local().Format("2006-01-02")
+	}
+	if due := s.RotationDue(); !due.IsZero() {
+		rotate = due.Local().Format("2006-01-02")
+	}
+	return expires, rotate
+}
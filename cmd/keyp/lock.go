@@ -7,18 +7,29 @@ import (
 	"github.com/TheEditor/keyp/internal/color"
 )
 
+var lockAll bool
+
 var lockCmd = &cobra.Command{
 	Use:   "lock",
 	Short: "Explicitly lock the vault",
-	Long:  "Lock the vault immediately, requiring password re-entry for next access.",
-	RunE:  runLock,
+	Long: `Lock the vault immediately, requiring password re-entry for next access.
+
+Use --all to lock every vault with a saved session or a key held by the agent.`,
+	RunE: runLock,
 }
 
 func init() {
+	lockCmd.Flags().BoolVar(&lockAll, "all", false, "Lock every vault, not only the current one")
 	rootCmd.AddCommand(lockCmd)
 }
 
 func runLock(cmd *cobra.Command, args []string) error {
+	if lockAll {
+		clearAllSessions()
+		fmt.Println(color.Success("All vaults locked"))
+		return nil
+	}
+
 	// Clear the global handle and session file
 	clearVaultHandle()
 
@@ -10,12 +10,14 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/TheEditor/keyp/internal/cli"
 	"github.com/TheEditor/keyp/internal/store"
+	"github.com/TheEditor/keyp/internal/vault"
 )
 
 var version = "2.0.0-dev"
 
 // Global flags
 var jsonOutput bool
+var keyFileFlag string
 
 // getVaultPath returns the vault path from flag or default
 func getVaultPath() string {
@@ -28,6 +30,14 @@ func getVaultPath() string {
 	return filepath.Join(home, ".keyp", "vault.db")
 }
 
+// getKeyFilePath returns the key file path from flag or KEYP_KEYFILE
+func getKeyFilePath() string {
+	if keyFileFlag != "" {
+		return keyFileFlag
+	}
+	return os.Getenv("KEYP_KEYFILE")
+}
+
 var rootCmd = &cobra.Command{
 	Use:   "keyp",
 	Short: "Local-first secret manager",
@@ -67,6 +77,7 @@ var versionCmd = &cobra.Command{
 
 func init() {
 	rootCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "Output results in JSON format")
+	rootCmd.PersistentFlags().StringVar(&keyFileFlag, "keyfile", "", "Key file required to unlock the vault (or KEYP_KEYFILE)")
 	rootCmd.AddCommand(versionCmd)
 }
 
@@ -85,15 +96,27 @@ func getExitCode(err error) int {
 	}
 
 	// Check for specific error types
-	if errors.Is(err, store.ErrNotFound) {
+	if errors.Is(err, store.ErrNotFound) || errors.Is(err, store.ErrVersionNotFound) || errors.Is(err, vault.ErrNotExists) {
 		return cli.ExitNotFound
 	}
 
-	if errors.Is(err, store.ErrInvalidPassword) {
+	if errors.Is(err, store.ErrInvalidPassword) || errors.Is(err, vault.ErrInvalidRecoveryKey) {
 		return cli.ExitAuthFailed
 	}
 
-	if errors.Is(err, store.ErrVaultClosed) || errors.Is(err, store.ErrDatabaseLocked) {
+	if errors.Is(err, vault.ErrKeyFileRequired) || errors.Is(err, vault.ErrKeyFileMissing) {
+		return cli.ExitKeyFile
+	}
+
+	if errors.Is(err, errSecretsOverdue) {
+		return cli.ExitOverdue
+	}
+
+	if errors.Is(err, errSecretsDue) {
+		return cli.ExitDue
+	}
+
+	if errors.Is(err, store.ErrVaultClosed) || errors.Is(err, store.ErrDatabaseLocked) || errors.Is(err, vault.ErrLocked) {
 		return cli.ExitVaultLocked
 	}
 
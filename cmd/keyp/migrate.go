@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+)
+
+var (
+	migrateSQLCipher bool
+	migrateMetadata  bool
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Convert the vault to a new storage format",
+	Long: `Convert the vault in place. A verified backup of the original file is kept next to it.
+
+Use --sqlcipher to encrypt the whole database, so secret names, tags, notes and
+field labels can no longer be read from the file. Requires a build linked against SQLCipher.
+
+Use --metadata to encrypt secret names, tags, notes, field labels and all field
+values without SQLCipher. Names and tags are indexed with keyed hashes, so exact
+lookups and tag filters keep working; search decrypts the vault in memory.`,
+	RunE: runMigrate,
+}
+
+func init() {
+	migrateCmd.Flags().BoolVar(&migrateSQLCipher, "sqlcipher", false, "Encrypt the whole database with SQLCipher")
+	migrateCmd.Flags().BoolVar(&migrateMetadata, "metadata", false, "Encrypt secret names, tags, notes and field labels")
+	rootCmd.AddCommand(migrateCmd)
+}
+
+func runMigrate(cmd *cobra.Command, args []string) error {
+	if !migrateSQLCipher && !migrateMetadata {
+		return fmt.Errorf("no migration selected (use --sqlcipher or --metadata)")
+	}
+
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	if migrateMetadata {
+		backupPath, err := handle.EncryptMetadata(cmd.Context())
+		if backupPath != "" {
+			fmt.Printf("Backup written to %s (it still holds readable names; delete it once the vault is verified)\n", backupPath)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to migrate vault: %w", err)
+		}
+		fmt.Println(color.Success("Vault metadata is now encrypted"))
+	}
+
+	if migrateSQLCipher {
+		backupPath, err := handle.MigrateToSQLCipher(cmd.Context())
+		if backupPath != "" {
+			fmt.Printf("Backup written to %s\n", backupPath)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to migrate vault: %w", err)
+		}
+		fmt.Println(color.Success("Vault database is now encrypted with SQLCipher"))
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+	"github.com/TheEditor/keyp/internal/store"
+)
+
+var mvCmd = &cobra.Command{
+	Use:   "mv <from> <to>",
+	Short: "Move a secret or a folder of secrets",
+	Long: `Move a secret, or a folder with everything inside it, to a new path.
+
+'keyp mv work/aws personal/aws' moves work/aws/prod to personal/aws/prod,
+and so on for every secret under work/aws, as well as a secret named
+work/aws itself. Each secret keeps its old name in its history.
+
+Nothing is moved if any of the new names is already taken.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runMv,
+}
+
+func init() {
+	rootCmd.AddCommand(mvCmd)
+}
+
+func runMv(cmd *cobra.Command, args []string) error {
+	from, to := args[0], args[1]
+
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	moved, err := handle.Move(cmd.Context(), from, to)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return fmt.Errorf("no secret or folder '%s': %w", from, err)
+		case errors.Is(err, store.ErrAlreadyExists):
+			return fmt.Errorf("cannot move '%s' to '%s': %w", from, to, err)
+		}
+		return fmt.Errorf("failed to move: %w", err)
+	}
+
+	fmt.Println(color.Success(fmt.Sprintf("Moved %d secret(s) from '%s' to '%s'", moved, from, to)))
+	return nil
+}
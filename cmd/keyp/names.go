@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+	"github.com/TheEditor/keyp/internal/store"
+)
+
+// Name matching modes accepted by keyp names
+const (
+	namesExact           = "exact"
+	namesCaseInsensitive = "case-insensitive"
+)
+
+var namesCmd = &cobra.Command{
+	Use:   "names [exact|case-insensitive]",
+	Short: "Show or set how secret names are matched",
+	Long: `Show how secret names are matched, or select another mode.
+
+Secret names are unique. With exact matching, 'GitHub' and 'github' are two
+different secrets; with case-insensitive matching they are the same one, and
+either spelling finds it.
+
+Switching to case-insensitive fails if two secrets have names that differ
+only in case; rename one of them first.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runNames,
+}
+
+func init() {
+	rootCmd.AddCommand(namesCmd)
+}
+
+func runNames(cmd *cobra.Command, args []string) error {
+	if len(args) == 1 && args[0] != namesExact && args[0] != namesCaseInsensitive {
+		return fmt.Errorf("unknown name matching %q (use %s or %s)", args[0], namesExact, namesCaseInsensitive)
+	}
+
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		ignoreCase, err := handle.CaseInsensitiveNames()
+		if err != nil {
+			return err
+		}
+		if ignoreCase {
+			fmt.Println(namesCaseInsensitive)
+		} else {
+			fmt.Println(namesExact)
+		}
+		return nil
+	}
+
+	if err := handle.SetCaseInsensitiveNames(cmd.Context(), args[0] == namesCaseInsensitive); err != nil {
+		if errors.Is(err, store.ErrAlreadyExists) {
+			return fmt.Errorf("cannot match names regardless of case: %w", err)
+		}
+		return fmt.Errorf("failed to set name matching: %w", err)
+	}
+	fmt.Println(color.Success(fmt.Sprintf("Secret names now use %s matching", args[0])))
+	return nil
+}
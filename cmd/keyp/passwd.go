@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+	"github.com/TheEditor/keyp/internal/ui"
+	"github.com/TheEditor/keyp/internal/vault"
+)
+
+var passwdCmd = &cobra.Command{
+	Use:   "passwd",
+	Short: "Change the vault password",
+	Long: `Change the password of the key slot that the current password opens.
+
+This only re-seals the slot's copy of the data key under the new password;
+the data key itself is not rotated. Copies of the vault taken before, such
+as backups or synced clones, still open with the old password. To retire
+the old password everywhere, add a slot with 'keyp slot add' and remove
+this one with 'keyp slot remove', which moves the vault to a new data key.
+
+All saved sessions are cleared, so the next command asks for the new password.`,
+	Args: cobra.NoArgs,
+	RunE: runPasswd,
+}
+
+func init() {
+	rootCmd.AddCommand(passwdCmd)
+}
+
+func runPasswd(cmd *cobra.Command, args []string) error {
+	oldPassword, err := ui.PromptPassword("Current password: ")
+	if err != nil {
+		return err
+	}
+
+	// Always check the current password, even if a session is active
+	handle := vault.NewHandle(getVaultPath())
+	if err := handle.UnlockWithKeyFile(oldPassword, getKeyFilePath(), 0); err != nil {
+		return fmt.Errorf("failed to unlock vault: %w", err)
+	}
+	defer handle.Lock()
+
+	newPassword, err := ui.PromptConfirmPassword(
+		"New password: ",
+		"Confirm new password: ",
+	)
+	if err != nil {
+		return err
+	}
+
+	// Validate length
+	if len(newPassword) < 8 {
+		return fmt.Errorf("password must be at least 8 characters")
+	}
+
+	if err := handle.ChangePassword(cmd.Context(), oldPassword, newPassword); err != nil {
+		return fmt.Errorf("failed to change password: %w", err)
+	}
+
+	// Sessions hold the data key, which has not changed; end them anyway so
+	// the next command asks for the new password
+	clearVaultHandle()
+
+	fmt.Println(color.Success("Password changed"))
+	return nil
+}
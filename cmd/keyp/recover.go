@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+	"github.com/TheEditor/keyp/internal/ui"
+	"github.com/TheEditor/keyp/internal/vault"
+)
+
+var recoverSlot string
+
+var recoverCmd = &cobra.Command{
+	Use:   "recover",
+	Short: "Set a new password using the recovery key",
+	Long: `Open the vault with the recovery key printed by 'keyp init --recovery-key'
+and set a new password for a key slot (the default slot unless --slot is given).
+
+If --keyfile is given, the new password also requires that key file.
+All saved sessions are cleared.`,
+	Args: cobra.NoArgs,
+	RunE: runRecover,
+}
+
+func init() {
+	recoverCmd.Flags().StringVar(&recoverSlot, "slot", "default", "Key slot to set the new password on")
+	rootCmd.AddCommand(recoverCmd)
+}
+
+func runRecover(cmd *cobra.Command, args []string) error {
+	recoveryKey, err := ui.PromptPassword("Recovery key: ")
+	if err != nil {
+		return err
+	}
+
+	v, err := vault.OpenWithRecoveryKey(getVaultPath(), recoveryKey)
+	if err != nil {
+		return fmt.Errorf("failed to open vault: %w", err)
+	}
+	defer v.Close()
+
+	password, err := ui.PromptConfirmPassword(
+		"New password: ",
+		"Confirm new password: ",
+	)
+	if err != nil {
+		return err
+	}
+
+	// Validate length
+	if len(password) < 8 {
+		return fmt.Errorf("password must be at least 8 characters")
+	}
+
+	if err := v.ResetPassword(recoverSlot, password, getKeyFilePath()); err != nil {
+		return fmt.Errorf("failed to set new password: %w", err)
+	}
+
+	// Sessions may belong to whoever lost the old password
+	clearVaultHandle()
+
+	fmt.Println(color.Success(fmt.Sprintf("New password set for key slot '%s'", recoverSlot)))
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+	"github.com/TheEditor/keyp/internal/store"
+)
+
+var renameCmd = &cobra.Command{
+	Use:   "rename <old> <new>",
+	Short: "Rename a secret",
+	Long: `Give a secret a new name, keeping its fields, tags and notes.
+
+The state under the old name is kept in the secret's history. Fails if
+another secret already has the new name.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runRename,
+}
+
+func init() {
+	rootCmd.AddCommand(renameCmd)
+}
+
+func runRename(cmd *cobra.Command, args []string) error {
+	oldName, newName := args[0], args[1]
+
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	if _, err := handle.Rename(cmd.Context(), oldName, newName); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return fmt.Errorf("secret '%s' not found: %w", oldName, err)
+		case errors.Is(err, store.ErrAlreadyExists):
+			return fmt.Errorf("cannot rename to '%s': a secret with that name exists: %w", newName, err)
+		}
+		return fmt.Errorf("failed to rename secret: %w", err)
+	}
+
+	fmt.Println(color.Success(fmt.Sprintf("Secret '%s' renamed to '%s'", oldName, newName)))
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+	"github.com/TheEditor/keyp/internal/store"
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <name>",
+	Short: "Restore a secret from the trash",
+	Long: `Take a deleted secret out of the trash, with its fields, history
+and attachments. If several deleted secrets had the name, the most
+recently deleted one is restored. Fails if another secret has taken the name since.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRestore,
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+}
+
+func runRestore(cmd *cobra.Command, args []string) error {
+	name := args[0]
+
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	if err := handle.Restore(cmd.Context(), name); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return fmt.Errorf("secret '%s' not found in trash: %w", name, err)
+		case errors.Is(err, store.ErrAlreadyExists):
+			return fmt.Errorf("cannot restore '%s': a secret with that name exists: %w", name, err)
+		}
+		return fmt.Errorf("failed to restore secret: %w", err)
+	}
+
+	fmt.Println(color.Success(fmt.Sprintf("Secret '%s' restored", name)))
+	return nil
+}
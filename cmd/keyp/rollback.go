@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback <name> <version>",
+	Short: "Restore an earlier version of a secret",
+	Long: `Make an earlier version of a secret current again. The version being
+replaced is kept in the history, so a rollback can itself be undone.
+
+List versions with 'keyp history <name>'.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runRollback,
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+}
+
+func runRollback(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	number, err := strconv.Atoi(args[1])
+	if err != nil || number < 1 {
+		return fmt.Errorf("invalid version %q", args[1])
+	}
+
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	if _, err := handle.Rollback(cmd.Context(), name, number); err != nil {
+		return fmt.Errorf("failed to roll back secret: %w", err)
+	}
+
+	msg := fmt.Sprintf("Secret '%s' rolled back to version %d", name, number)
+	fmt.Println(color.Success(msg))
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/model"
 )
 
 var searchPorcelain bool
@@ -34,7 +35,7 @@ func runSearch(cmd *cobra.Command, args []string) error {
 	}
 
 	// Search secrets
-	secrets, err := handle.Store().Search(cmd.Context(), query, nil)
+	secrets, err := handle.Search(cmd.Context(), query, nil)
 	if err != nil {
 		return fmt.Errorf("failed to search secrets: %w", err)
 	}
@@ -44,7 +45,11 @@ func runSearch(cmd *cobra.Command, args []string) error {
 		enc := json.NewEncoder(os.Stdout)
 		enc.SetIndent("", "  ")
 		enc.SetEscapeHTML(false)
-		return enc.Encode(secrets)
+		redacted := make([]*model.SecretObject, len(secrets))
+		for i, s := range secrets {
+			redacted[i] = s.Redacted()
+		}
+		return enc.Encode(redacted)
 	}
 
 	// Porcelain output (tab-separated, no headers)
@@ -9,13 +9,15 @@ import (
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/config"
 	"github.com/TheEditor/keyp/internal/server"
 )
 
 var (
-	servePort    = 8080
-	serveBind    = "127.0.0.1"
-	serveTimeout = 15 * time.Minute
+	servePort        = 8080
+	serveBind        = "127.0.0.1"
+	serveTimeout     time.Duration
+	serveMaxLifetime time.Duration
 )
 
 var serveCmd = &cobra.Command{
@@ -28,7 +30,8 @@ var serveCmd = &cobra.Command{
 func init() {
 	serveCmd.Flags().IntVar(&servePort, "port", 8080, "HTTP server port (default: 8080)")
 	serveCmd.Flags().StringVar(&serveBind, "bind", "127.0.0.1", "Address to bind to (default: 127.0.0.1)")
-	serveCmd.Flags().DurationVar(&serveTimeout, "timeout", 15*time.Minute, "Session timeout duration (default: 15m)")
+	serveCmd.Flags().DurationVar(&serveTimeout, "timeout", 0, "Idle session timeout, extended by each request (default: session timeout)")
+	serveCmd.Flags().DurationVar(&serveMaxLifetime, "max-lifetime", 0, "Expire sessions this long after unlock, even if in use (default: session max lifetime)")
 	rootCmd.AddCommand(serveCmd)
 }
 
@@ -36,9 +39,21 @@ func runServe(cmd *cobra.Command, args []string) error {
 	vaultPath := getVaultPath()
 	address := fmt.Sprintf("%s:%d", serveBind, servePort)
 
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if serveTimeout > 0 {
+		cfg.SessionTimeout = serveTimeout
+	}
+	if serveMaxLifetime > 0 {
+		cfg.SessionMaxLifetime = serveMaxLifetime
+	}
+
 	// Create server
 	srv := server.NewServer(address, vaultPath)
-	srv.SetSessionTimeout(serveTimeout)
+	srv.SetSessionTimeout(cfg.SessionTimeout)
+	srv.SetSessionMaxLifetime(cfg.SessionMaxLifetime)
 
 	// Start server in goroutine
 	errs := make(chan error, 1)
@@ -13,24 +13,40 @@ import (
 	"github.com/TheEditor/keyp/internal/ui"
 )
 
-var setStdin bool
+var (
+	setStdin   bool
+	setExpires string
+	setRotate  string
+)
 
 var setCmdObj = &cobra.Command{
 	Use:   "set <name> [value]",
 	Short: "Set a secret value",
-	Long:  "Create or update a secret. Value can be provided as argument or via stdin.",
-	Args:  cobra.RangeArgs(1, 2),
-	RunE:  runSet,
+	Long: `Create or update a secret. Value can be provided as argument or via stdin.
+
+--expires sets the date the secret stops working, such as 2027-01-01, and
+--rotate how often its value should change, such as 90d; either can be
+cleared with never. Updating a secret keeps both unless they are given.
+See 'keyp expiring'.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runSet,
 }
 
 func init() {
 	setCmdObj.Flags().BoolVar(&setStdin, "stdin", false, "Read value from stdin")
+	setCmdObj.Flags().StringVar(&setExpires, "expires", "", "Date the secret expires, such as 2027-01-01, or never")
+	setCmdObj.Flags().StringVar(&setRotate, "rotate", "", "How often the value should be rotated, such as 90d, or never")
 	rootCmd.AddCommand(setCmdObj)
 }
 
 func runSet(cmd *cobra.Command, args []string) error {
 	name := args[0]
 
+	secret := model.NewSecretObject(name)
+	if err := applyLifecycle(secret, setExpires, setRotate); err != nil {
+		return err
+	}
+
 	// Get value
 	var value string
 	if setStdin {
@@ -56,22 +72,24 @@ func runSet(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	// Create secret with single field
-	secret := model.NewSecretObject(name)
+	// Give the secret a single field
 	field := model.NewField("value", value)
 	field.Sensitive = true
 	secret.AddField(field)
 
 	// Try create, if exists then update
-	if err := handle.Store().Create(cmd.Context(), secret); err != nil {
+	if err := handle.Create(cmd.Context(), secret); err != nil {
 		if errors.Is(err, store.ErrAlreadyExists) {
 			// Update existing
-			existing, err := handle.Store().GetByName(cmd.Context(), name)
+			existing, err := handle.GetByName(cmd.Context(), name)
 			if err != nil {
 				return fmt.Errorf("failed to get existing secret: %w", err)
 			}
 			existing.Fields = secret.Fields
-			if err := handle.Store().Update(cmd.Context(), existing); err != nil {
+			if err := applyLifecycle(existing, setExpires, setRotate); err != nil {
+				return err
+			}
+			if err := handle.Update(cmd.Context(), existing); err != nil {
 				return fmt.Errorf("failed to update secret: %w", err)
 			}
 		} else {
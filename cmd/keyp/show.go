@@ -6,9 +6,13 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/model"
 )
 
-var showReveal bool
+var (
+	showReveal  bool
+	showVersion int
+)
 
 var showCmd = &cobra.Command{
 	Use:   "show <name>",
@@ -20,6 +24,7 @@ var showCmd = &cobra.Command{
 
 func init() {
 	showCmd.Flags().BoolVar(&showReveal, "reveal", false, "Show sensitive values (default: masked)")
+	showCmd.Flags().IntVar(&showVersion, "version", 0, "Show an earlier version (see 'keyp history')")
 	rootCmd.AddCommand(showCmd)
 }
 
@@ -32,8 +37,13 @@ func runShow(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	// Get secret
-	secret, err := handle.Store().GetByName(cmd.Context(), name)
+	// Get secret, or one of its versions
+	var secret *model.SecretObject
+	if showVersion != 0 {
+		secret, err = handle.GetVersion(cmd.Context(), name, showVersion)
+	} else {
+		secret, err = handle.GetByName(cmd.Context(), name)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to get secret: %w", err)
 	}
@@ -53,9 +63,18 @@ func runShow(cmd *cobra.Command, args []string) error {
 
 	// Display secret details
 	fmt.Printf("Name: %s\n", secret.Name)
+	if showVersion != 0 {
+		fmt.Printf("Version: %d\n", showVersion)
+	}
 	fmt.Printf("Tags: %v\n", secret.Tags)
 	fmt.Printf("Created: %s\n", secret.CreatedAt.Format("2006-01-02 15:04"))
 	fmt.Printf("Updated: %s\n", secret.UpdatedAt.Format("2006-01-02 15:04"))
+	if !secret.ExpiresAt.IsZero() {
+		fmt.Printf("Expires: %s\n", secret.ExpiresAt.Local().Format("2006-01-02 15:04"))
+	}
+	if secret.RotateEvery > 0 {
+		fmt.Printf("Rotate: every %s, next by %s\n", secret.RotateEvery, secret.RotationDue().Local().Format("2006-01-02"))
+	}
 	if secret.Notes != "" {
 		fmt.Printf("Notes: %s\n", secret.Notes)
 	}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+	"github.com/TheEditor/keyp/internal/ui"
+	"github.com/TheEditor/keyp/internal/vault"
+)
+
+var (
+	slotAddKeyFile  string
+	slotRemoveForce bool
+)
+
+var slotCmd = &cobra.Command{
+	Use:   "slot",
+	Short: "Manage key slots",
+	Long: `Manage the key slots of the vault. Each slot has a label and its own password,
+so several people can unlock the same vault without sharing a password.`,
+}
+
+var slotAddCmd = &cobra.Command{
+	Use:   "add <label>",
+	Short: "Add a key slot with a new password",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runSlotAdd,
+}
+
+var slotListCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "List key slots",
+	Aliases: []string{"ls"},
+	Args:    cobra.NoArgs,
+	RunE:    runSlotList,
+}
+
+var slotRemoveCmd = &cobra.Command{
+	Use:   "remove <label>",
+	Short: "Remove a key slot",
+	Long: `Remove a key slot so its password no longer unlocks the vault.
+The last remaining slot cannot be removed.
+
+The vault moves to a new data key: every secret, its history and its
+attachments are re-encrypted, and the new key is given to the remaining
+slots. Neither the removed password nor the old data key opens the vault
+from then on. Copies of the vault taken before still open with the removed
+password, so change any secret values its holder should lose.
+
+Slots from older versions of keyp can only be given the new key after they
+have been used to unlock once; until then removal lists them and stops.
+Every session of the vault is ended.`,
+	Aliases: []string{"rm"},
+	Args:    cobra.ExactArgs(1),
+	RunE:    runSlotRemove,
+}
+
+func init() {
+	slotAddCmd.Flags().StringVar(&slotAddKeyFile, "slot-keyfile", "", "Also require this key file to unlock with the new slot")
+	slotRemoveCmd.Flags().BoolVarP(&slotRemoveForce, "force", "f", false, "Skip confirmation prompt")
+	slotCmd.AddCommand(slotAddCmd)
+	slotCmd.AddCommand(slotListCmd)
+	slotCmd.AddCommand(slotRemoveCmd)
+	rootCmd.AddCommand(slotCmd)
+}
+
+func runSlotAdd(cmd *cobra.Command, args []string) error {
+	label := args[0]
+
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	password, err := ui.PromptConfirmPassword(
+		fmt.Sprintf("Password for slot '%s': ", label),
+		"Confirm password: ",
+	)
+	if err != nil {
+		return err
+	}
+
+	// Validate length
+	if len(password) < 8 {
+		return fmt.Errorf("password must be at least 8 characters")
+	}
+
+	if err := handle.AddSlot(label, password, slotAddKeyFile); err != nil {
+		return fmt.Errorf("failed to add key slot: %w", err)
+	}
+
+	fmt.Println(color.Success(fmt.Sprintf("Key slot '%s' added", label)))
+	return nil
+}
+
+// slotInfo is the JSON form of a key slot, without its wrapped key
+type slotInfo struct {
+	Label     string    `json:"label"`
+	Kind      string    `json:"kind"`
+	KDF       string    `json:"kdf"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func runSlotList(cmd *cobra.Command, args []string) error {
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	slots, err := handle.Slots()
+	if err != nil {
+		return fmt.Errorf("failed to list key slots: %w", err)
+	}
+
+	if jsonOutput {
+		infos := make([]slotInfo, len(slots))
+		for i, s := range slots {
+			infos[i] = slotInfo{Label: s.Label, Kind: slotKind(s), KDF: s.KDF.Algorithm, CreatedAt: s.CreatedAt}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(infos)
+	}
+
+	header := fmt.Sprintf("%-20s %-18s %-15s %s", "LABEL", "KIND", "KDF", "CREATED")
+	fmt.Println(color.Header(header))
+	for _, s := range slots {
+		fmt.Printf("%-20s %-18s %-15s %s\n", s.Label, slotKind(s), s.KDF.Algorithm, s.CreatedAt.Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+// slotKind describes what opens a key slot
+func slotKind(s vault.KeySlot) string {
+	switch {
+	case s.Kind == vault.SlotRecovery:
+		return "recovery"
+	case s.KeyFile:
+		return "password+keyfile"
+	default:
+		return "password"
+	}
+}
+
+func runSlotRemove(cmd *cobra.Command, args []string) error {
+	label := args[0]
+
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	// Confirm removal
+	if !slotRemoveForce {
+		confirm, err := ui.PromptVisible(fmt.Sprintf("Type '%s' to confirm removing the key slot: ", label))
+		if err != nil {
+			return err
+		}
+		if confirm != label {
+			return fmt.Errorf("removal cancelled")
+		}
+	}
+
+	if err := handle.RemoveSlot(cmd.Context(), label); err != nil {
+		return fmt.Errorf("failed to remove key slot: %w", err)
+	}
+
+	// Sessions hold the old data key
+	clearVaultHandle()
+
+	fmt.Println(color.Success(fmt.Sprintf("Key slot '%s' removed", label)))
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+	"github.com/TheEditor/keyp/internal/core"
+	"github.com/TheEditor/keyp/internal/sync"
+	"github.com/TheEditor/keyp/internal/vault"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether the vault is unlocked, and for how long",
+	Long: `Show the vault path, whether the vault is unlocked and until when, its
+secret and field counts, the KDF of each key slot, and its sync state.
+
+Never prompts for a password, and does not count as using the vault, so it
+can run from a shell prompt without keeping the vault unlocked. Exits 0 if
+the vault is unlocked, 4 if it is locked, and 2 if there is no vault at
+the path.`,
+	Args: cobra.NoArgs,
+	RunE: runStatus,
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
+
+// statusSlot is the KDF of a key slot, without its wrapped key
+type statusSlot struct {
+	Label string         `json:"label"`
+	KDF   core.KDFParams `json:"kdf"`
+}
+
+// statusSync is the JSON form of the sync state
+type statusSync struct {
+	Initialized      bool   `json:"initialized"`
+	RemoteConfigured bool   `json:"remote_configured"`
+	Clean            bool   `json:"clean"`
+	UnpushedCommits  int    `json:"unpushed_commits"`
+	UnpulledCommits  int    `json:"unpulled_commits"`
+	Error            string `json:"error,omitempty"`
+}
+
+// vaultStatus is what keyp status reports
+type vaultStatus struct {
+	Path             string       `json:"path"`
+	Exists           bool         `json:"exists"`
+	ID               string       `json:"id,omitempty"`
+	Unlocked         bool         `json:"unlocked"`
+	Session          string       `json:"session,omitempty"`
+	ExpiresInSeconds int64        `json:"expires_in_seconds,omitempty"`
+	Secrets          *int         `json:"secrets,omitempty"`
+	Fields           *int         `json:"fields,omitempty"`
+	Slots            []statusSlot `json:"slots,omitempty"`
+	Sync             *statusSync  `json:"sync,omitempty"`
+}
+
+func runStatus(cmd *cobra.Command, args []string) error {
+	status := &vaultStatus{Path: getVaultPath()}
+	status.Exists = vault.Exists(status.Path)
+
+	if status.Exists {
+		if err := collectStatus(cmd, status); err != nil {
+			return err
+		}
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(status); err != nil {
+			return err
+		}
+	} else {
+		printStatus(status)
+	}
+
+	switch {
+	case !status.Exists:
+		return vault.ErrNotExists
+	case !status.Unlocked:
+		return vault.ErrLocked
+	}
+	return nil
+}
+
+// collectStatus fills in what can be learned about an existing vault
+// without prompting for its password
+func collectStatus(cmd *cobra.Command, status *vaultStatus) error {
+	slots, err := vault.ReadSlots(status.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read vault: %w", err)
+	}
+	for _, s := range slots {
+		status.Slots = append(status.Slots, statusSlot{Label: s.Label, KDF: s.KDF})
+	}
+	status.ID, _ = vault.ReadID(status.Path)
+
+	// Peek, so that a shell prompt running this keeps no vault unlocked
+	if handle, source, err := peekSession(status.Path); err == nil {
+		defer handle.Lock()
+		status.Unlocked = true
+		status.Session = source
+		status.ExpiresInSeconds = int64(handle.TimeUntilExpire() / time.Second)
+
+		secrets, fields, err := handle.Counts(cmd.Context())
+		if err != nil {
+			return fmt.Errorf("failed to count secrets: %w", err)
+		}
+		status.Secrets, status.Fields = &secrets, &fields
+	}
+
+	syncStatus, err := sync.NewGitExecSyncer(status.Path).Status()
+	status.Sync = &statusSync{
+		Initialized:      syncStatus.Initialized,
+		RemoteConfigured: syncStatus.RemoteConfigured,
+		Clean:            syncStatus.Clean,
+		UnpushedCommits:  syncStatus.UnpushedCommits,
+		UnpulledCommits:  syncStatus.UnpulledCommits,
+	}
+	if err != nil {
+		status.Sync.Error = err.Error()
+	}
+	return nil
+}
+
+// printStatus writes the human-readable form of a status
+func printStatus(status *vaultStatus) {
+	fmt.Printf("Vault:    %s\n", status.Path)
+	if !status.Exists {
+		fmt.Println(color.Warning("No vault at this path (run 'keyp init')"))
+		return
+	}
+
+	if status.Unlocked {
+		expiresIn := time.Duration(status.ExpiresInSeconds) * time.Second
+		fmt.Printf("State:    %s (%s, locks in %s)\n", color.Success("unlocked"), status.Session, expiresIn)
+		fmt.Printf("Secrets:  %d (%d fields)\n", *status.Secrets, *status.Fields)
+	} else {
+		fmt.Printf("State:    %s\n", color.Warning("locked"))
+	}
+
+	for _, s := range status.Slots {
+		fmt.Printf("KDF:      %s: %s\n", s.Label, formatKDF(s.KDF))
+	}
+
+	syncState := status.Sync
+	switch {
+	case syncState.Error != "":
+		fmt.Printf("Sync:     unavailable (%s)\n", syncState.Error)
+	case !syncState.Initialized:
+		fmt.Println("Sync:     not initialized")
+	case !syncState.RemoteConfigured:
+		fmt.Printf("Sync:     no remote (clean: %v)\n", syncState.Clean)
+	default:
+		fmt.Printf("Sync:     clean: %v, %d unpushed, %d unpulled\n", syncState.Clean, syncState.UnpushedCommits, syncState.UnpulledCommits)
+	}
+}
+
+// formatKDF describes KDF parameters on one line
+func formatKDF(p core.KDFParams) string {
+	if p.Algorithm == core.KDFArgon2id {
+		return fmt.Sprintf("%s (time=%d, memory=%d KiB, threads=%d)", p.Algorithm, p.Time, p.Memory, p.Threads)
+	}
+	return fmt.Sprintf("%s (iterations=%d)", p.Algorithm, p.Iterations)
+}
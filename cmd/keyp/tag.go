@@ -52,7 +52,7 @@ func runTagAdd(cmd *cobra.Command, args []string) error {
 	}
 
 	// Get secret
-	secret, err := handle.Store().GetByName(cmd.Context(), secretName)
+	secret, err := handle.GetByName(cmd.Context(), secretName)
 	if err != nil {
 		return fmt.Errorf("failed to get secret: %w", err)
 	}
@@ -78,7 +78,7 @@ func runTagAdd(cmd *cobra.Command, args []string) error {
 
 	// Update secret if any tags were added
 	if len(addedTags) > 0 {
-		if err := handle.Store().Update(cmd.Context(), secret); err != nil {
+		if err := handle.Update(cmd.Context(), secret); err != nil {
 			return fmt.Errorf("failed to update secret: %w", err)
 		}
 
@@ -103,7 +103,7 @@ func runTagRm(cmd *cobra.Command, args []string) error {
 	}
 
 	// Get secret
-	secret, err := handle.Store().GetByName(cmd.Context(), secretName)
+	secret, err := handle.GetByName(cmd.Context(), secretName)
 	if err != nil {
 		return fmt.Errorf("failed to get secret: %w", err)
 	}
@@ -127,7 +127,7 @@ func runTagRm(cmd *cobra.Command, args []string) error {
 	secret.Tags = newTags
 
 	// Update secret
-	if err := handle.Store().Update(cmd.Context(), secret); err != nil {
+	if err := handle.Update(cmd.Context(), secret); err != nil {
 		return fmt.Errorf("failed to update secret: %w", err)
 	}
 
@@ -144,7 +144,7 @@ func runTagList(cmd *cobra.Command, args []string) error {
 
 	if len(args) == 0 {
 		// List all tags across all secrets
-		secrets, err := handle.Store().List(cmd.Context(), nil)
+		secrets, err := handle.List(cmd.Context(), nil)
 		if err != nil {
 			return fmt.Errorf("failed to list secrets: %w", err)
 		}
@@ -168,7 +168,7 @@ func runTagList(cmd *cobra.Command, args []string) error {
 	} else {
 		// List tags for specific secret
 		secretName := args[0]
-		secret, err := handle.Store().GetByName(cmd.Context(), secretName)
+		secret, err := handle.GetByName(cmd.Context(), secretName)
 		if err != nil {
 			return fmt.Errorf("failed to get secret: %w", err)
 		}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+	"github.com/TheEditor/keyp/internal/core"
+	"github.com/TheEditor/keyp/internal/model"
+	"github.com/TheEditor/keyp/internal/store"
+	"github.com/TheEditor/keyp/internal/ui"
+	"github.com/TheEditor/keyp/internal/vault"
+)
+
+var (
+	totpField string
+	totpCopy  bool
+	totpWatch bool
+	totpSet   bool
+)
+
+var totpCmd = &cobra.Command{
+	Use:   "totp <name>",
+	Short: "Show the current two-factor code of a secret",
+	Long: `Generate the current TOTP code (RFC 6238) from a secret's TOTP field and
+print it with the seconds it remains valid. The seed itself is never shown.
+
+With --watch, the code is shown live and replaced as each one expires,
+until interrupted.
+
+With --set, prompts for a base32 seed or an otpauth:// URI, as shown by the
+site's QR code, and stores it in the secret's TOTP field, creating the
+secret if needed.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runTOTP,
+}
+
+func init() {
+	totpCmd.Flags().StringVar(&totpField, "field", "", "TOTP field to use (default: the first one)")
+	totpCmd.Flags().BoolVarP(&totpCopy, "copy", "c", false, "Copy the code to the clipboard")
+	totpCmd.Flags().BoolVarP(&totpWatch, "watch", "w", false, "Keep showing the current code")
+	totpCmd.Flags().BoolVar(&totpSet, "set", false, "Store a TOTP seed or otpauth:// URI in the secret")
+	rootCmd.AddCommand(totpCmd)
+}
+
+func runTOTP(cmd *cobra.Command, args []string) error {
+	name := args[0]
+
+	if totpSet {
+		return setTOTP(cmd, name)
+	}
+
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	code, err := currentTOTP(cmd, handle, name)
+	if err != nil {
+		return err
+	}
+
+	if totpWatch {
+		return watchTOTP(cmd, handle, name, code)
+	}
+
+	remaining := time.Until(code.ExpiresAt).Round(time.Second)
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(map[string]any{
+			"code":              code.Code,
+			"expires_at":        code.ExpiresAt,
+			"remaining_seconds": int(remaining / time.Second),
+		})
+	}
+
+	if totpCopy {
+		if err := ui.CopyWithAutoClear(code.Code, ui.DefaultClearDuration); err != nil {
+			return fmt.Errorf("failed to copy to clipboard: %w", err)
+		}
+		fmt.Println(color.Success(fmt.Sprintf("Copied to clipboard (valid for %s)", remaining)))
+		return nil
+	}
+	fmt.Printf("%s (valid for %s)\n", code.Code, remaining)
+	return nil
+}
+
+// currentTOTP generates the current code of the secret's TOTP field
+func currentTOTP(cmd *cobra.Command, handle *vault.VaultHandle, name string) (core.TOTPCode, error) {
+	code, err := handle.TOTP(cmd.Context(), name, totpField, time.Now())
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return code, fmt.Errorf("secret '%s' not found: %w", name, err)
+	case errors.Is(err, vault.ErrFieldNotFound) && totpField != "":
+		return code, fmt.Errorf("secret '%s' has no TOTP field '%s'", name, totpField)
+	case errors.Is(err, vault.ErrFieldNotFound):
+		return code, fmt.Errorf("secret '%s' has no TOTP field (add one with 'keyp totp %s --set')", name, name)
+	case err != nil:
+		return code, fmt.Errorf("failed to generate code: %w", err)
+	}
+	return code, nil
+}
+
+// watchTOTP redraws the current code and its remaining time every second
+// until interrupted, copying each new code if asked to
+func watchTOTP(cmd *cobra.Command, handle *vault.VaultHandle, name string, code core.TOTPCode) error {
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+	defer stop()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	copied := ""
+	for {
+		if !time.Now().Before(code.ExpiresAt) {
+			next, err := currentTOTP(cmd, handle, name)
+			if err != nil {
+				fmt.Println()
+				return err
+			}
+			code = next
+		}
+		if totpCopy && code.Code != copied {
+			if err := ui.CopyWithAutoClear(code.Code, ui.DefaultClearDuration); err != nil {
+				fmt.Println()
+				return fmt.Errorf("failed to copy to clipboard: %w", err)
+			}
+			copied = code.Code
+		}
+		fmt.Printf("\r%s (valid for %2ds)", code.Code, int(time.Until(code.ExpiresAt).Round(time.Second)/time.Second))
+
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// setTOTP prompts for a seed and stores it in the secret's TOTP field,
+// replacing the one selected by --field or else the first one
+func setTOTP(cmd *cobra.Command, name string) error {
+	value, err := ui.PromptPassword("Enter TOTP seed or otpauth:// URI: ")
+	if err != nil {
+		return err
+	}
+	if _, err := core.ParseTOTP(value); err != nil {
+		return err
+	}
+
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	label := totpField
+	if label == "" {
+		label = "totp"
+	}
+	field := model.NewField(label, value)
+	field.Type = model.FieldTypeTOTP
+
+	secret, err := handle.GetByName(cmd.Context(), name)
+	if errors.Is(err, store.ErrNotFound) {
+		secret = model.NewSecretObject(name)
+		secret.AddField(field)
+		if err := handle.Create(cmd.Context(), secret); err != nil {
+			return fmt.Errorf("failed to create secret: %w", err)
+		}
+		fmt.Println(color.Success(fmt.Sprintf("Secret '%s' created with a TOTP field", name)))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get secret: %w", err)
+	}
+
+	replaced := false
+	for i, f := range secret.Fields {
+		if f.Type == model.FieldTypeTOTP && (totpField == "" || f.Label == totpField) {
+			secret.Fields[i].Value = value
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		secret.AddField(field)
+	}
+	if err := handle.Update(cmd.Context(), secret); err != nil {
+		return fmt.Errorf("failed to update secret: %w", err)
+	}
+	fmt.Println(color.Success(fmt.Sprintf("TOTP field of '%s' saved", name)))
+	return nil
+}
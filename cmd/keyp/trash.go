@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+	"github.com/TheEditor/keyp/internal/config"
+	"github.com/TheEditor/keyp/internal/model"
+	"github.com/TheEditor/keyp/internal/ui"
+)
+
+var (
+	trashPurgeOlderThan string
+	trashPurgeForce     bool
+)
+
+var trashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "Manage deleted secrets",
+	Long: `Manage the trash. 'keyp delete' moves secrets here instead of removing them,
+so they can be brought back with 'keyp restore <name>' until they are purged.`,
+}
+
+var trashListCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "List secrets in the trash",
+	Aliases: []string{"ls"},
+	Args:    cobra.NoArgs,
+	RunE:    runTrashList,
+}
+
+var trashPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Permanently remove secrets from the trash",
+	Long: `Permanently remove secrets from the trash, with their fields, history and
+attachments. Without --older-than the whole trash is emptied;
+'--older-than 30d' keeps what was deleted in the last 30 days.
+
+Requires confirmation unless --force is used.`,
+	Args: cobra.NoArgs,
+	RunE: runTrashPurge,
+}
+
+func init() {
+	trashPurgeCmd.Flags().StringVar(&trashPurgeOlderThan, "older-than", "", "Only purge secrets deleted at least this long ago (e.g. 30d, 12h)")
+	trashPurgeCmd.Flags().BoolVarP(&trashPurgeForce, "force", "f", false, "Skip confirmation prompt")
+	trashCmd.AddCommand(trashListCmd)
+	trashCmd.AddCommand(trashPurgeCmd)
+	rootCmd.AddCommand(trashCmd)
+}
+
+func runTrashList(cmd *cobra.Command, args []string) error {
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	secrets, err := handle.Trash(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("failed to list trash: %w", err)
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		redacted := make([]*model.SecretObject, len(secrets))
+		for i, s := range secrets {
+			redacted[i] = s.Redacted()
+		}
+		return enc.Encode(redacted)
+	}
+
+	if len(secrets) == 0 {
+		fmt.Println("Trash is empty")
+		return nil
+	}
+
+	header := fmt.Sprintf("%-30s %-20s %s", "NAME", "TAGS", "DELETED")
+	fmt.Println(color.Header(header))
+	for _, s := range secrets {
+		tags := strings.Join(s.Tags, ", ")
+		fmt.Printf("%-30s %-20s %s\n", s.Name, tags, s.DeletedAt.Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+func runTrashPurge(cmd *cobra.Command, args []string) error {
+	var olderThan time.Duration
+	if trashPurgeOlderThan != "" {
+		var err error
+		olderThan, err = config.ParseDuration(trashPurgeOlderThan)
+		if err != nil || olderThan < 0 {
+			return fmt.Errorf("invalid --older-than: %q", trashPurgeOlderThan)
+		}
+	}
+
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	secrets, err := handle.Trash(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("failed to list trash: %w", err)
+	}
+	count := 0
+	for _, s := range secrets {
+		if time.Since(s.DeletedAt) >= olderThan {
+			count++
+		}
+	}
+	if count == 0 {
+		fmt.Println("Nothing to purge")
+		return nil
+	}
+
+	// Confirm purge
+	if !trashPurgeForce {
+		confirm, err := ui.PromptVisible(fmt.Sprintf("Permanently delete %d secret(s)? Type 'purge' to confirm: ", count))
+		if err != nil {
+			return err
+		}
+		if confirm != "purge" {
+			return fmt.Errorf("purge cancelled")
+		}
+	}
+
+	purged, err := handle.Purge(cmd.Context(), olderThan)
+	if err != nil {
+		return fmt.Errorf("failed to purge trash: %w", err)
+	}
+
+	fmt.Println(color.Success(fmt.Sprintf("Purged %d secret(s) from the trash", purged)))
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/color"
+	"github.com/TheEditor/keyp/internal/model"
+	"github.com/TheEditor/keyp/internal/store"
+)
+
+var treeCmd = &cobra.Command{
+	Use:   "tree [folder]",
+	Short: "Show secrets as a tree of folders",
+	Long: `Show the secrets in the vault, or in a folder such as work/aws, as a tree
+of the folders their names form.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runTree,
+}
+
+func init() {
+	rootCmd.AddCommand(treeCmd)
+}
+
+func runTree(cmd *cobra.Command, args []string) error {
+	folder := ""
+	if len(args) == 1 {
+		folder = strings.Trim(args[0], model.PathSeparator)
+	}
+
+	// Get or unlock vault
+	handle, err := getOrUnlockVault(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	secrets, err := handle.List(cmd.Context(), &store.SearchOptions{Prefix: model.FolderPrefix(folder)})
+	if err != nil {
+		return fmt.Errorf("failed to list secrets: %w", err)
+	}
+	if len(secrets) == 0 {
+		fmt.Println("No secrets found")
+		return nil
+	}
+
+	if folder == "" {
+		fmt.Println(color.Header("."))
+	} else {
+		fmt.Println(color.Header(folder + model.PathSeparator))
+	}
+	printTree(secrets, folder, "")
+	return nil
+}
+
+// printTree writes the contents of folder below it, each line prefixed by
+// indent and the branches of the folders above
+func printTree(secrets []*model.SecretObject, folder, indent string) {
+	entries := model.ListFolder(secrets, folder)
+	for i, e := range entries {
+		branch, next := "├── ", "│   "
+		if i == len(entries)-1 {
+			branch, next = "└── ", "    "
+		}
+		if !e.IsFolder() {
+			fmt.Println(indent + branch + e.Name)
+			continue
+		}
+
+		fmt.Println(indent + branch + e.Name + model.PathSeparator)
+		var inside []*model.SecretObject
+		for _, s := range secrets {
+			if strings.HasPrefix(s.Name, e.Path+model.PathSeparator) {
+				inside = append(inside, s)
+			}
+		}
+		printTree(inside, e.Path, indent+next)
+	}
+}
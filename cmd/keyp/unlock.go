@@ -37,7 +37,7 @@ func runUnlock(cmd *cobra.Command, args []string) error {
 
 	// Unlock
 	timeout := time.Duration(unlockTimeout) * time.Minute
-	if err := handle.Unlock(password, timeout); err != nil {
+	if err := handle.UnlockWithKeyFile(password, getKeyFilePath(), timeout); err != nil {
 		return fmt.Errorf("failed to unlock vault: %w", err)
 	}
 
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/TheEditor/keyp/internal/agent"
 	"github.com/TheEditor/keyp/internal/config"
 	"github.com/TheEditor/keyp/internal/session"
 	"github.com/TheEditor/keyp/internal/ui"
@@ -14,16 +17,21 @@ import (
 
 var globalHandle *vault.VaultHandle
 var sessionMgr *session.Manager
+var sessionCfg *config.Config
+var agentClient = agent.NewClient(agent.DefaultSocketPath())
 
 func init() {
-	// Load configuration for session timeout
+	// Load configuration for session timeouts
 	cfg, err := config.Load()
 	if err != nil {
-		log.Printf("warning: failed to load config: %v, using default session timeout", err)
-		sessionMgr = session.New(session.DefaultTimeout)
-	} else {
-		sessionMgr = session.New(cfg.SessionTimeout)
+		log.Printf("warning: failed to load config: %v, using default session timeouts", err)
+		cfg = &config.Config{
+			SessionTimeout:     config.DefaultSessionTimeout,
+			SessionMaxLifetime: config.DefaultSessionMaxLifetime,
+		}
 	}
+	sessionCfg = cfg
+	sessionMgr = session.New(cfg.SessionTimeout, cfg.SessionMaxLifetime)
 }
 
 // getOrUnlockVault returns the vault handle, unlocking if necessary
@@ -33,15 +41,14 @@ func getOrUnlockVault(cmd *cobra.Command, timeout time.Duration) (*vault.VaultHa
 		return globalHandle, nil
 	}
 
-	// Try to load session from disk
-	if derivedKey, err := sessionMgr.Load(); err == nil {
-		// Session is valid, use it to unlock
-		handle := vault.NewHandle(getVaultPath())
-		if err := handle.UnlockWithKey(derivedKey, timeout); err == nil {
-			globalHandle = handle
-			return handle, nil
-		}
-		// If unlock fails, session is invalid, continue to prompt
+	vaultPath := getVaultPath()
+
+	// Reuse a session of this vault, kept by the agent or, if session files
+	// are on, in a session file. If there is none or unlock fails, continue
+	// to prompt.
+	if handle, _, err := openSession(vaultPath, timeout); err == nil {
+		globalHandle = handle
+		return handle, nil
 	}
 
 	// Need to unlock - prompt for password
@@ -50,20 +57,110 @@ func getOrUnlockVault(cmd *cobra.Command, timeout time.Duration) (*vault.VaultHa
 		return nil, err
 	}
 
-	handle := vault.NewHandle(getVaultPath())
-	if err := handle.Unlock(password, timeout); err != nil {
-		return nil, fmt.Errorf("failed to unlock vault: %w", err)
+	if timeout <= 0 {
+		timeout = sessionCfg.SessionTimeout
 	}
-
-	// Save session for future use
-	if derivedKey := handle.GetDerivedKey(); derivedKey != nil {
-		_ = sessionMgr.Save(derivedKey)
+	handle := vault.NewHandle(vaultPath)
+	handle.SetMaxLifetime(sessionCfg.SessionMaxLifetime)
+	if err := handle.UnlockWithKeyFile(password, getKeyFilePath(), timeout); err != nil {
+		return nil, fmt.Errorf("failed to unlock vault: %w", err)
 	}
 
+	saveSession(handle)
 	globalHandle = handle
 	return handle, nil
 }
 
+// Where a session key was found
+const (
+	sessionSourceAgent = "agent"
+	sessionSourceFile  = "session"
+)
+
+// openSession unlocks the vault at vaultPath with a key kept by the agent,
+// or else saved in its session file if session files are on, and returns
+// where the key came from.
+// Either way the use slides the session's idle timeout. The handle idles
+// out after timeout, or the configured session timeout if not set, and
+// never outlives the session.
+func openSession(vaultPath string, timeout time.Duration) (*vault.VaultHandle, string, error) {
+	return unlockSession(vaultPath, timeout, true)
+}
+
+// peekSession unlocks the vault at vaultPath like openSession, but without
+// sliding the session's idle timeout, so that checking whether a vault is
+// unlocked does not keep it unlocked
+func peekSession(vaultPath string) (*vault.VaultHandle, string, error) {
+	return unlockSession(vaultPath, 0, false)
+}
+
+// unlockSession unlocks the vault at vaultPath with the key of its
+// session, sliding the session's idle timeout if use is set
+func unlockSession(vaultPath string, timeout time.Duration, use bool) (*vault.VaultHandle, string, error) {
+	vaultID, err := vault.ReadID(vaultPath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	source := sessionSourceAgent
+	fetch := agentClient.Key
+	if !use {
+		fetch = agentClient.Peek
+	}
+	derivedKey, expiresAt, err := fetch(vaultID)
+	if err != nil {
+		if !sessionCfg.SessionFile {
+			return nil, "", err
+		}
+		source = sessionSourceFile
+		if derivedKey, expiresAt, err = sessionMgr.Load(vaultID); err != nil {
+			return nil, "", err
+		}
+		if use {
+			if refreshed, err := sessionMgr.Refresh(vaultID); err == nil {
+				expiresAt = refreshed
+			}
+		}
+	}
+	defer derivedKey.Destroy()
+
+	if timeout <= 0 {
+		timeout = sessionCfg.SessionTimeout
+	}
+	remaining := time.Until(expiresAt)
+	if remaining <= 0 {
+		return nil, "", errors.New("session expired")
+	}
+	handle := vault.NewHandle(vaultPath)
+	handle.SetMaxLifetime(remaining)
+	if err := handle.UnlockWithKey(derivedKey, timeout); err != nil {
+		return nil, "", err
+	}
+	return handle, source, nil
+}
+
+// saveSession keeps the key of an unlocked vault for later commands in the
+// agent if one is running. Otherwise the key stays in this process, unless
+// session files are on: then it is written, unencrypted, to the vault's
+// session file.
+func saveSession(handle *vault.VaultHandle) {
+	vaultID := handle.ID()
+	derivedKey := handle.GetDerivedKey()
+	if vaultID == "" || derivedKey == nil {
+		return
+	}
+	defer derivedKey.Destroy()
+
+	if err := agentClient.Add(vaultID, handle.Path(), derivedKey); !errors.Is(err, agent.ErrNotRunning) {
+		return
+	}
+	if !sessionCfg.SessionFile {
+		return
+	}
+	fmt.Fprintln(os.Stderr, "warning: no agent is running; the vault key is saved unencrypted in a session file (session_file is on)")
+	_ = sessionMgr.Save(vaultID, derivedKey)
+}
+
 // setVaultHandle stores a vault handle globally
 func setVaultHandle(h *vault.VaultHandle) {
 	globalHandle = h
@@ -74,12 +171,32 @@ func getVaultHandle() *vault.VaultHandle {
 	return globalHandle
 }
 
-// clearVaultHandle removes the global vault handle
+// clearVaultHandle removes the global vault handle and the sessions of the
+// current vault
 func clearVaultHandle() {
+	var vaultID string
+	if globalHandle != nil {
+		vaultID = globalHandle.ID()
+		globalHandle.Lock()
+	}
+	globalHandle = nil
+	if vaultID == "" {
+		vaultID, _ = vault.ReadID(getVaultPath())
+	}
+	// Also clear the session file and the key held by the agent
+	if vaultID != "" {
+		_ = sessionMgr.Clear(vaultID)
+		_ = agentClient.Lock(vaultID)
+	}
+}
+
+// clearAllSessions removes the global vault handle and the sessions of
+// every vault
+func clearAllSessions() {
 	if globalHandle != nil {
 		globalHandle.Lock()
 	}
 	globalHandle = nil
-	// Also clear the session file
-	_ = sessionMgr.Clear()
+	_ = sessionMgr.ClearAll()
+	_ = agentClient.Lock("")
 }
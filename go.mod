@@ -3,16 +3,16 @@ module github.com/TheEditor/keyp
 go 1.25.4
 
 require (
+	github.com/atotto/clipboard v0.1.4
 	github.com/google/uuid v1.6.0
+	github.com/mattn/go-sqlite3 v1.14.32
 	github.com/spf13/cobra v1.8.0
 	golang.org/x/crypto v0.46.0
+	golang.org/x/term v0.38.0
 )
 
 require (
-	github.com/atotto/clipboard v0.1.4 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/mattn/go-sqlite3 v1.14.32 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	golang.org/x/sys v0.39.0 // indirect
-	golang.org/x/term v0.38.0 // indirect
 )
@@ -0,0 +1,373 @@
+// Package agent keeps unlocked vault keys in the memory of a long-running
+// process and hands them to keyp commands over a Unix socket, so the keys
+// never have to be written to disk between commands.
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/TheEditor/keyp/internal/core"
+	"github.com/TheEditor/keyp/internal/vault"
+)
+
+const (
+	// SocketFileName is the name of the agent socket in ~/.keyp
+	SocketFileName = "agent.sock"
+
+	// maxMessageSize bounds a single request or response
+	maxMessageSize = 64 * 1024
+	// connTimeout bounds how long a client may take to send its request
+	connTimeout = 10 * time.Second
+)
+
+// Operations understood by the agent
+const (
+	opPing   = "ping"
+	opAdd    = "add"
+	opKey    = "key"
+	opPeek   = "peek"
+	opLock   = "lock"
+	opStatus = "status"
+)
+
+var (
+	// ErrNotRunning is returned when no agent listens on the socket
+	ErrNotRunning = errors.New("agent is not running")
+	// ErrNotUnlocked is returned when the agent holds no key for a vault
+	ErrNotUnlocked = errors.New("vault is not unlocked in the agent")
+)
+
+// request is sent by a client, one per connection
+type request struct {
+	Op    string `json:"op"`
+	Vault string `json:"vault,omitempty"` // Vault ID
+	Path  string `json:"path,omitempty"`
+	Key   []byte `json:"key,omitempty"`
+}
+
+// response answers a request
+type response struct {
+	Error     string        `json:"error,omitempty"`
+	Key       []byte        `json:"key,omitempty"`
+	ExpiresAt time.Time     `json:"expires_at,omitzero"`
+	Vaults    []VaultStatus `json:"vaults,omitempty"`
+}
+
+// VaultStatus describes a vault whose key the agent holds
+type VaultStatus struct {
+	ID         string    `json:"id"`
+	Path       string    `json:"path"`
+	UnlockedAt time.Time `json:"unlocked_at"`
+	LastUsed   time.Time `json:"last_used"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// DefaultSocketPath returns the socket path from KEYP_AGENT_SOCK, or
+// ~/.keyp/agent.sock
+func DefaultSocketPath() string {
+	if path := os.Getenv("KEYP_AGENT_SOCK"); path != "" {
+		return path
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "."
+	}
+	return filepath.Join(homeDir, ".keyp", SocketFileName)
+}
+
+// entry is an unlocked vault held by the agent
+type entry struct {
+	path       string
+	handle     *vault.VaultHandle
+	unlockedAt time.Time
+	lastUsed   time.Time
+}
+
+// Agent holds unlocked vault handles, by vault ID, and forgets them once
+// they have been idle for the idle timeout or held for the maximum lifetime
+type Agent struct {
+	mu          sync.Mutex
+	vaults      map[string]*entry
+	idleTimeout time.Duration
+	maxLifetime time.Duration
+}
+
+// New creates an agent with the given idle timeout and maximum lifetime
+func New(idleTimeout, maxLifetime time.Duration) *Agent {
+	return &Agent{
+		vaults:      make(map[string]*entry),
+		idleTimeout: idleTimeout,
+		maxLifetime: maxLifetime,
+	}
+}
+
+// Listen creates the agent socket, readable and writable only by the
+// current user. A stale socket left by an agent that exited is replaced.
+func Listen(socketPath string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("an agent is already listening on %s", socketPath)
+	}
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+	return listener, nil
+}
+
+// Serve answers requests on listener until it is closed, then locks every
+// vault it holds
+func (a *Agent) Serve(listener net.Listener) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	defer a.lock("")
+	go a.expireLoop(stop)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go a.handleConn(conn)
+	}
+}
+
+// expireLoop forgets expired keys without waiting for the next request
+func (a *Agent) expireLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			a.expire(time.Now())
+		}
+	}
+}
+
+// expire locks every vault that is past its idle timeout or lifetime
+func (a *Agent) expire(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id, e := range a.vaults {
+		if a.expired(e, now) {
+			e.handle.Lock()
+			delete(a.vaults, id)
+		}
+	}
+}
+
+// expired reports whether an entry must be forgotten
+func (a *Agent) expired(e *entry, now time.Time) bool {
+	return now.After(a.expiresAt(e)) || e.handle.IsExpired()
+}
+
+// expiresAt returns when an entry expires if it is not used again. A
+// maximum lifetime of 0 is no limit.
+func (a *Agent) expiresAt(e *entry) time.Time {
+	idle := e.lastUsed.Add(a.idleTimeout)
+	if a.maxLifetime > 0 {
+		if hard := e.unlockedAt.Add(a.maxLifetime); hard.Before(idle) {
+			return hard
+		}
+	}
+	return idle
+}
+
+// handleConn answers the single request sent on a connection
+func (a *Agent) handleConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(connTimeout))
+
+	if err := checkPeer(conn); err != nil {
+		log.Printf("agent: rejected connection: %v", err)
+		return
+	}
+
+	data, err := readMessage(conn)
+	if err != nil {
+		log.Printf("agent: failed to read request: %v", err)
+		return
+	}
+	var req request
+	err = json.Unmarshal(data, &req)
+	core.Wipe(data)
+	if err != nil {
+		writeMessage(conn, response{Error: "invalid request"})
+		return
+	}
+
+	resp := a.handle(&req)
+	core.Wipe(req.Key)
+	writeMessage(conn, resp)
+	core.Wipe(resp.Key)
+}
+
+// handle dispatches a request
+func (a *Agent) handle(req *request) response {
+	switch req.Op {
+	case opPing:
+		return response{}
+	case opAdd:
+		if err := a.add(req.Vault, req.Path, req.Key); err != nil {
+			return response{Error: err.Error()}
+		}
+		return response{}
+	case opKey, opPeek:
+		key, expiresAt, err := a.key(req.Vault, req.Op == opKey)
+		if err != nil {
+			return response{Error: err.Error()}
+		}
+		return response{Key: key, ExpiresAt: expiresAt}
+	case opLock:
+		a.lock(req.Vault)
+		return response{}
+	case opStatus:
+		return response{Vaults: a.status()}
+	default:
+		return response{Error: fmt.Sprintf("unknown operation %q", req.Op)}
+	}
+}
+
+// add unlocks a vault with its key and holds it
+func (a *Agent) add(id, path string, key []byte) error {
+	if id == "" || path == "" || len(key) == 0 {
+		return errors.New("vault ID, path and key are required")
+	}
+	buf, err := core.SecretBufferFrom(key)
+	if err != nil {
+		return err
+	}
+	defer buf.Destroy()
+
+	handle := vault.NewHandle(path)
+	handle.SetMaxLifetime(a.maxLifetime)
+	if err := handle.UnlockWithKey(buf, a.idleTimeout); err != nil {
+		return fmt.Errorf("failed to unlock vault: %w", err)
+	}
+	if handle.ID() != id {
+		handle.Lock()
+		return errors.New("vault at path has a different ID")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if old, ok := a.vaults[id]; ok {
+		old.handle.Lock()
+	}
+	now := time.Now()
+	a.vaults[id] = &entry{path: path, handle: handle, unlockedAt: now, lastUsed: now}
+	return nil
+}
+
+// key returns a copy of the key of an unlocked vault and when it expires,
+// marking it used first if use is set
+func (a *Agent) key(id string, use bool) ([]byte, time.Time, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e, ok := a.vaults[id]
+	if !ok {
+		return nil, time.Time{}, ErrNotUnlocked
+	}
+	now := time.Now()
+	if a.expired(e, now) {
+		e.handle.Lock()
+		delete(a.vaults, id)
+		return nil, time.Time{}, ErrNotUnlocked
+	}
+
+	derivedKey := e.handle.GetDerivedKey()
+	if derivedKey == nil {
+		return nil, time.Time{}, ErrNotUnlocked
+	}
+	defer derivedKey.Destroy()
+	if use {
+		e.lastUsed = now
+	}
+	return append([]byte(nil), derivedKey.Bytes()...), a.expiresAt(e), nil
+}
+
+// lock forgets the key of a vault, or of every vault if id is empty
+func (a *Agent) lock(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for vaultID, e := range a.vaults {
+		if id == "" || vaultID == id {
+			e.handle.Lock()
+			delete(a.vaults, vaultID)
+		}
+	}
+}
+
+// status describes the vaults the agent holds
+func (a *Agent) status() []VaultStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	vaults := make([]VaultStatus, 0, len(a.vaults))
+	for id, e := range a.vaults {
+		vaults = append(vaults, VaultStatus{
+			ID:         id,
+			Path:       e.path,
+			UnlockedAt: e.unlockedAt,
+			LastUsed:   e.lastUsed,
+			ExpiresAt:  a.expiresAt(e),
+		})
+	}
+	return vaults
+}
+
+// readMessage reads one message up to end of stream into a fixed buffer,
+// so that key material is never copied into buffers that cannot be wiped
+func readMessage(r io.Reader) ([]byte, error) {
+	buf := make([]byte, maxMessageSize)
+	n := 0
+	for n < len(buf) {
+		m, err := r.Read(buf[n:])
+		n += m
+		if err == io.EOF {
+			return buf[:n], nil
+		}
+		if err != nil {
+			core.Wipe(buf)
+			return nil, err
+		}
+	}
+	core.Wipe(buf)
+	return nil, errors.New("message too large")
+}
+
+// writeMessage encodes and writes one message, wiping the encoding after
+func writeMessage(conn net.Conn, msg any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	defer core.Wipe(data)
+	_, err = conn.Write(data)
+	return err
+}
@@ -0,0 +1,213 @@
+//go:build cgo
+
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TheEditor/keyp/internal/core"
+	"github.com/TheEditor/keyp/internal/vault"
+)
+
+// startAgent serves an agent on a temporary socket until the test ends
+func startAgent(t *testing.T, a *Agent) *Client {
+	t.Helper()
+	socketPath := filepath.Join(t.TempDir(), SocketFileName)
+	listener, err := Listen(socketPath)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- a.Serve(listener) }()
+	t.Cleanup(func() {
+		listener.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve failed: %v", err)
+		}
+	})
+	return NewClient(socketPath)
+}
+
+// unlockedVault creates a vault and returns its ID, path and data key
+func unlockedVault(t *testing.T) (string, string, *core.SecretBuffer) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "vault.db")
+	v, err := vault.Init(path, "testpassword123")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	v.Close()
+
+	handle := vault.NewHandle(path)
+	if err := handle.Unlock("testpassword123", 0); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	defer handle.Lock()
+	key := handle.GetDerivedKey()
+	t.Cleanup(key.Destroy)
+	return handle.ID(), path, key
+}
+
+func TestAgentHoldsKeys(t *testing.T) {
+	client := startAgent(t, New(time.Hour, time.Hour))
+	id, path, key := unlockedVault(t)
+
+	if err := client.Ping(); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	if _, _, err := client.Key(id); !errors.Is(err, ErrNotUnlocked) {
+		t.Fatalf("Expected ErrNotUnlocked before Add, got %v", err)
+	}
+	if err := client.Add(id, path, key); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	got, expiresAt, err := client.Key(id)
+	if err != nil {
+		t.Fatalf("Key failed: %v", err)
+	}
+	defer got.Destroy()
+	if !bytes.Equal(got.Bytes(), key.Bytes()) {
+		t.Error("Agent returned a different key")
+	}
+	if until := time.Until(expiresAt); until <= 0 || until > time.Hour {
+		t.Errorf("Key expires in %v, want within an hour", until)
+	}
+
+	vaults, err := client.Status()
+	if err != nil || len(vaults) != 1 || vaults[0].ID != id || vaults[0].Path != path {
+		t.Fatalf("Status = %+v, %v", vaults, err)
+	}
+
+	if err := client.Lock(""); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	if _, _, err := client.Key(id); !errors.Is(err, ErrNotUnlocked) {
+		t.Errorf("Expected ErrNotUnlocked after Lock, got %v", err)
+	}
+}
+
+func TestAgentRejectsWrongKey(t *testing.T) {
+	client := startAgent(t, New(time.Hour, time.Hour))
+	id, path, _ := unlockedVault(t)
+
+	wrong, err := core.NewSecretBuffer(core.KeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wrong.Destroy()
+	if err := client.Add(id, path, wrong); err == nil {
+		t.Error("Expected Add with a wrong key to fail")
+	}
+}
+
+func TestAgentExpiresKeys(t *testing.T) {
+	a := New(time.Minute, time.Hour)
+	client := startAgent(t, a)
+	id, path, key := unlockedVault(t)
+
+	if err := client.Add(id, path, key); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	a.expire(time.Now().Add(30 * time.Second))
+	if _, _, err := client.Key(id); err != nil {
+		t.Fatalf("Key expired before the idle timeout: %v", err)
+	}
+	a.expire(time.Now().Add(2 * time.Minute))
+	if _, _, err := client.Key(id); !errors.Is(err, ErrNotUnlocked) {
+		t.Errorf("Expected idle key to be forgotten, got %v", err)
+	}
+
+	// Use does not extend a key past its maximum lifetime
+	start := time.Now()
+	e := &entry{unlockedAt: start, lastUsed: start.Add(59*time.Minute + 30*time.Second)}
+	if got := a.expiresAt(e); !got.Equal(start.Add(time.Hour)) {
+		t.Errorf("expiresAt = %v, want %v", got, start.Add(time.Hour))
+	}
+}
+
+func TestAgentPeekLeavesIdleTimeout(t *testing.T) {
+	a := New(time.Minute, time.Hour)
+	client := startAgent(t, a)
+	id, path, key := unlockedVault(t)
+
+	if err := client.Add(id, path, key); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	a.mu.Lock()
+	lastUsed := a.vaults[id].lastUsed.Add(-30 * time.Second)
+	a.vaults[id].lastUsed = lastUsed
+	a.mu.Unlock()
+
+	got, expiresAt, err := client.Peek(id)
+	if err != nil {
+		t.Fatalf("Peek failed: %v", err)
+	}
+	defer got.Destroy()
+	if !bytes.Equal(got.Bytes(), key.Bytes()) {
+		t.Error("Peek returned a different key")
+	}
+	if !expiresAt.Equal(lastUsed.Add(time.Minute)) {
+		t.Errorf("Peek expiresAt = %v, want %v", expiresAt, lastUsed.Add(time.Minute))
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.vaults[id].lastUsed.Equal(lastUsed) {
+		t.Errorf("Peek moved lastUsed to %v", a.vaults[id].lastUsed)
+	}
+}
+
+func TestAgentWithoutMaxLifetime(t *testing.T) {
+	a := New(time.Minute, 0)
+	client := startAgent(t, a)
+	id, path, key := unlockedVault(t)
+
+	// A maximum lifetime of 0 is no limit, not an immediate expiry
+	if err := client.Add(id, path, key); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	a.expire(time.Now().Add(30 * time.Second))
+	got, expiresAt, err := client.Key(id)
+	if err != nil {
+		t.Fatalf("Key failed without a maximum lifetime: %v", err)
+	}
+	got.Destroy()
+	if until := time.Until(expiresAt); until <= 0 || until > time.Minute {
+		t.Errorf("Key expires in %v, want the idle timeout", until)
+	}
+
+	start := time.Now()
+	e := &entry{unlockedAt: start.Add(-24 * time.Hour), lastUsed: start}
+	if got := a.expiresAt(e); !got.Equal(start.Add(time.Minute)) {
+		t.Errorf("expiresAt = %v, want %v", got, start.Add(time.Minute))
+	}
+}
+
+func TestAgentSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), SocketFileName)
+	if err := NewClient(socketPath).Ping(); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Expected ErrNotRunning, got %v", err)
+	}
+
+	listener, err := Listen(socketPath)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer listener.Close()
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Socket permissions = %o, want 600", perm)
+	}
+	if _, err := Listen(socketPath); err == nil {
+		t.Error("Expected a second agent on the same socket to fail")
+	}
+}
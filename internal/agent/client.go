@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/TheEditor/keyp/internal/core"
+)
+
+// Client talks to an agent over its socket
+type Client struct {
+	socketPath string
+}
+
+// NewClient creates a client for the agent listening on socketPath
+func NewClient(socketPath string) *Client {
+	return &Client{socketPath: socketPath}
+}
+
+// Ping checks that an agent is listening
+func (c *Client) Ping() error {
+	_, err := c.call(&request{Op: opPing})
+	return err
+}
+
+// Add hands the key of an unlocked vault to the agent, which verifies it
+// against the vault at vaultPath before holding it
+func (c *Client) Add(vaultID, vaultPath string, key *core.SecretBuffer) error {
+	path, err := filepath.Abs(vaultPath)
+	if err != nil {
+		return err
+	}
+	req := &request{Op: opAdd, Vault: vaultID, Path: path, Key: key.Bytes()}
+	_, err = c.call(req)
+	return err
+}
+
+// Key returns the key the agent holds for a vault, and when the agent will
+// forget it unless it is used again. The caller must Destroy the key when
+// done with it.
+func (c *Client) Key(vaultID string) (*core.SecretBuffer, time.Time, error) {
+	resp, err := c.call(&request{Op: opKey, Vault: vaultID})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	key, err := core.SecretBufferFrom(resp.Key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return key, resp.ExpiresAt, nil
+}
+
+// Peek returns the key the agent holds for a vault like Key, but without
+// counting as a use, so the idle timeout is not slid. The caller must
+// Destroy the key when done with it.
+func (c *Client) Peek(vaultID string) (*core.SecretBuffer, time.Time, error) {
+	resp, err := c.call(&request{Op: opPeek, Vault: vaultID})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	key, err := core.SecretBufferFrom(resp.Key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return key, resp.ExpiresAt, nil
+}
+
+// Lock makes the agent forget the key of a vault, or every key if vaultID
+// is empty
+func (c *Client) Lock(vaultID string) error {
+	_, err := c.call(&request{Op: opLock, Vault: vaultID})
+	return err
+}
+
+// Status lists the vaults the agent holds keys for
+func (c *Client) Status() ([]VaultStatus, error) {
+	resp, err := c.call(&request{Op: opStatus})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Vaults, nil
+}
+
+// call sends one request and reads its response. ErrNotRunning is
+// returned if nothing listens on the socket.
+func (c *Client) call(req *request) (*response, error) {
+	conn, err := net.DialTimeout("unix", c.socketPath, time.Second)
+	if err != nil {
+		return nil, ErrNotRunning
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(connTimeout))
+
+	if err := writeMessage(conn, req); err != nil {
+		return nil, err
+	}
+	if err := conn.(*net.UnixConn).CloseWrite(); err != nil {
+		return nil, err
+	}
+
+	data, err := readMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	defer core.Wipe(data)
+	var resp response
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, errors.New("invalid response from agent")
+	}
+	if resp.Error != "" {
+		core.Wipe(resp.Key)
+		if resp.Error == ErrNotUnlocked.Error() {
+			return nil, ErrNotUnlocked
+		}
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
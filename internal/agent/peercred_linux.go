@@ -0,0 +1,38 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// checkPeer rejects connections from processes running as another user,
+// using the credentials the kernel recorded for the peer
+func checkPeer(conn net.Conn) error {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return errors.New("not a unix socket connection")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return err
+	}
+	if credErr != nil {
+		return fmt.Errorf("failed to read peer credentials: %w", credErr)
+	}
+	if cred.Uid != uint32(os.Getuid()) {
+		return fmt.Errorf("peer uid %d (pid %d) is not the agent owner", cred.Uid, cred.Pid)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package agent
+
+import "net"
+
+// checkPeer relies on the socket being accessible only to its owner, as
+// peer credentials are only read on Linux
+func checkPeer(conn net.Conn) error {
+	return nil
+}
@@ -6,4 +6,7 @@ const (
 	ExitNotFound    = 2
 	ExitAuthFailed  = 3
 	ExitVaultLocked = 4
+	ExitKeyFile     = 5 // Key file required but not given, or not found
+	ExitDue         = 6 // A secret expires or is due for rotation soon
+	ExitOverdue     = 7 // A secret has expired or is overdue for rotation
 )
@@ -4,65 +4,140 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
 
+const (
+	// DefaultSessionTimeout locks a session left unused for this long
+	DefaultSessionTimeout = 15 * time.Minute
+	// DefaultSessionMaxLifetime locks a session this long after unlock,
+	// however often it is used
+	DefaultSessionMaxLifetime = 8 * time.Hour
+)
+
 // Config holds the keyp configuration
 type Config struct {
-	SessionTimeout time.Duration
+	SessionTimeout     time.Duration // Idle timeout, extended on each use
+	SessionMaxLifetime time.Duration // Hard limit from unlock
+	SessionFile        bool          // Save unlocked keys unencrypted in session files when no agent runs
+}
+
+// sessionFileKey and sessionFileEnv turn on session files
+const (
+	sessionFileKey = "session_file"
+	sessionFileEnv = "KEYP_SESSION_FILE"
+)
+
+// settings maps each config file key and environment variable to the
+// setting it overrides
+var settings = []struct {
+	key    string
+	envVar string
+	field  func(*Config) *time.Duration
+}{
+	{"session_timeout", "KEYP_SESSION_TIMEOUT", func(c *Config) *time.Duration { return &c.SessionTimeout }},
+	{"session_max_lifetime", "KEYP_SESSION_MAX_LIFETIME", func(c *Config) *time.Duration { return &c.SessionMaxLifetime }},
 }
 
 // Load loads the configuration from ~/.keyp/config.yaml
-// Environment variable KEYP_SESSION_TIMEOUT overrides config file
+// Environment variables KEYP_SESSION_TIMEOUT, KEYP_SESSION_MAX_LIFETIME and
+// KEYP_SESSION_FILE override the config file
 func Load() (*Config, error) {
 	cfg := &Config{
-		SessionTimeout: 15 * time.Minute, // Default
+		SessionTimeout:     DefaultSessionTimeout,
+		SessionMaxLifetime: DefaultSessionMaxLifetime,
+	}
+
+	if err := cfg.loadFile(); err != nil {
+		return nil, err
+	}
+
+	for _, s := range settings {
+		value := os.Getenv(s.envVar)
+		if value == "" {
+			continue
+		}
+		duration, err := ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", s.envVar, err)
+		}
+		if duration < 0 {
+			return nil, fmt.Errorf("invalid %s: %q is negative", s.envVar, value)
+		}
+		*s.field(cfg) = duration
 	}
 
-	// Check environment variable first
-	if envTimeout := os.Getenv("KEYP_SESSION_TIMEOUT"); envTimeout != "" {
-		duration, err := time.ParseDuration(envTimeout)
+	if value := os.Getenv(sessionFileEnv); value != "" {
+		enabled, err := strconv.ParseBool(value)
 		if err != nil {
-			return nil, fmt.Errorf("invalid KEYP_SESSION_TIMEOUT: %w", err)
+			return nil, fmt.Errorf("invalid %s: %q is not true or false", sessionFileEnv, value)
 		}
-		cfg.SessionTimeout = duration
-		return cfg, nil
+		cfg.SessionFile = enabled
 	}
 
-	// Load from config file
+	return cfg, nil
+}
+
+// loadFile applies the settings found in the config file, if there is one
+func (cfg *Config) loadFile() error {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return cfg, nil // Use defaults
+		return nil // Use defaults
 	}
 
 	configPath := filepath.Join(homeDir, ".keyp", "config.yaml")
 	data, err := os.ReadFile(configPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return cfg, nil // Use defaults if file doesn't exist
+			return nil // Use defaults if file doesn't exist
 		}
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		return fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	// Simple YAML-like parsing for session_timeout
+	// Simple YAML-like parsing of "key: value" lines
 	lines := strings.Split(string(data), "\n")
 	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "session_timeout:") {
-			// Extract the value after the colon
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				timeoutStr := strings.TrimSpace(parts[1])
-				duration, err := time.ParseDuration(timeoutStr)
-				if err != nil {
-					return nil, fmt.Errorf("invalid session_timeout in config: %w", err)
-				}
-				cfg.SessionTimeout = duration
-				break
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		if strings.TrimSpace(key) == sessionFileKey {
+			enabled, err := strconv.ParseBool(strings.TrimSpace(value))
+			if err != nil {
+				return fmt.Errorf("invalid %s in config: %q is not true or false", sessionFileKey, strings.TrimSpace(value))
+			}
+			cfg.SessionFile = enabled
+			continue
+		}
+		for _, s := range settings {
+			if strings.TrimSpace(key) != s.key {
+				continue
+			}
+			duration, err := ParseDuration(strings.TrimSpace(value))
+			if err != nil {
+				return fmt.Errorf("invalid %s in config: %w", s.key, err)
 			}
+			if duration < 0 {
+				return fmt.Errorf("invalid %s in config: %q is negative", s.key, strings.TrimSpace(value))
+			}
+			*s.field(cfg) = duration
 		}
 	}
 
-	return cfg, nil
+	return nil
+}
+
+// ParseDuration parses a duration such as "90m" or "12h", also accepting
+// whole days such as "30d"
+func ParseDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
 }
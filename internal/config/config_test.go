@@ -0,0 +1,123 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a config file under a temporary home directory
+func writeConfig(t *testing.T, content string) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("KEYP_SESSION_TIMEOUT", "")
+	t.Setenv("KEYP_SESSION_MAX_LIFETIME", "")
+	t.Setenv("KEYP_SESSION_FILE", "")
+	if content == "" {
+		return
+	}
+	dir := filepath.Join(home, ".keyp")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	writeConfig(t, "")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.SessionTimeout != DefaultSessionTimeout || cfg.SessionMaxLifetime != DefaultSessionMaxLifetime || cfg.SessionFile {
+		t.Errorf("Load = %+v, want defaults", cfg)
+	}
+}
+
+func TestLoadSessionFile(t *testing.T) {
+	writeConfig(t, "session_file: true\n")
+	cfg, err := Load()
+	if err != nil || !cfg.SessionFile {
+		t.Errorf("Load = %+v, %v, want session files on", cfg, err)
+	}
+
+	t.Setenv("KEYP_SESSION_FILE", "false")
+	if cfg, err := Load(); err != nil || cfg.SessionFile {
+		t.Errorf("Load = %+v, %v, want session files off from env", cfg, err)
+	}
+
+	writeConfig(t, "session_file: sometimes\n")
+	if _, err := Load(); err == nil {
+		t.Error("Expected invalid session_file to be rejected")
+	}
+}
+
+func TestLoadFileAndEnv(t *testing.T) {
+	writeConfig(t, "session_timeout: 5m\nsession_max_lifetime: 2h\n")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.SessionTimeout != 5*time.Minute || cfg.SessionMaxLifetime != 2*time.Hour {
+		t.Errorf("Load = %+v, want values from config file", cfg)
+	}
+
+	// The environment overrides the file, one setting at a time
+	t.Setenv("KEYP_SESSION_MAX_LIFETIME", "1h")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.SessionTimeout != 5*time.Minute || cfg.SessionMaxLifetime != time.Hour {
+		t.Errorf("Load = %+v, want timeout from file and lifetime from env", cfg)
+	}
+}
+
+func TestLoadRejectsInvalidDurations(t *testing.T) {
+	writeConfig(t, "session_max_lifetime: forever\n")
+	if _, err := Load(); err == nil {
+		t.Error("Expected invalid config value to be rejected")
+	}
+
+	writeConfig(t, "")
+	t.Setenv("KEYP_SESSION_TIMEOUT", "soon")
+	if _, err := Load(); err == nil {
+		t.Error("Expected invalid environment value to be rejected")
+	}
+
+	writeConfig(t, "session_timeout: -5m\n")
+	if _, err := Load(); err == nil {
+		t.Error("Expected negative config value to be rejected")
+	}
+
+	writeConfig(t, "")
+	t.Setenv("KEYP_SESSION_MAX_LIFETIME", "-1d")
+	if _, err := Load(); err == nil {
+		t.Error("Expected negative environment value to be rejected")
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"90m": 90 * time.Minute,
+		"12h": 12 * time.Hour,
+		"30d": 30 * 24 * time.Hour,
+		"0d":  0,
+	}
+	for value, want := range tests {
+		got, err := ParseDuration(value)
+		if err != nil || got != want {
+			t.Errorf("ParseDuration(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+
+	for _, value := range []string{"", "d", "1.5d", "soon"} {
+		if _, err := ParseDuration(value); err == nil {
+			t.Errorf("ParseDuration(%q) succeeded, want error", value)
+		}
+	}
+}
@@ -1,12 +1,13 @@
 package core
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
 	"golang.org/x/crypto/pbkdf2"
 )
 
@@ -19,10 +20,13 @@ const (
 
 // EncryptionResult contains all values needed for decryption
 type EncryptionResult struct {
-	Ciphertext string `json:"ciphertext"`  // base64
-	AuthTag    string `json:"authTag"`     // base64
-	IV         string `json:"iv"`          // base64
-	Salt       string `json:"salt"`        // base64
+	Envelope string `json:"envelope,omitempty"` // See Seal
+	Salt     string `json:"salt"`               // base64
+
+	// Results from before envelopes carry the AES-256-GCM parts separately
+	Ciphertext string `json:"ciphertext,omitempty"` // base64
+	AuthTag    string `json:"authTag,omitempty"`    // base64
+	IV         string `json:"iv,omitempty"`         // base64
 }
 
 // DeriveKey derives a 256-bit key from password using PBKDF2-SHA256
@@ -37,7 +41,20 @@ func DeriveKey(password string, salt []byte, iterations int) ([]byte, error) {
 	return key, nil
 }
 
-// Encrypt encrypts plaintext using AES-256-GCM with PBKDF2-derived key
+// DeriveSubkey derives an independent 256-bit key for a specific purpose
+// from a vault key using HKDF-SHA256
+func DeriveSubkey(key []byte, purpose string) ([]byte, error) {
+	if len(key) != KeySize {
+		return nil, errors.New("key must be 32 bytes")
+	}
+	subkey := make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(purpose)), subkey); err != nil {
+		return nil, err
+	}
+	return subkey, nil
+}
+
+// Encrypt encrypts plaintext with the default cipher under a PBKDF2-derived key
 func Encrypt(plaintext, password string, iterations int) (*EncryptionResult, error) {
 	// Generate random salt
 	salt := make([]byte, SaltSize)
@@ -51,54 +68,19 @@ func Encrypt(plaintext, password string, iterations int) (*EncryptionResult, err
 		return nil, err
 	}
 
-	// Create cipher
-	block, err := aes.NewCipher(key)
+	envelope, err := Seal(DefaultCipher, key, []byte(plaintext), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
-
-	// Generate random IV
-	iv := make([]byte, IVSize)
-	if _, err := rand.Read(iv); err != nil {
-		return nil, err
-	}
-
-	// Encrypt (GCM appends auth tag to ciphertext)
-	sealed := gcm.Seal(nil, iv, []byte(plaintext), nil)
-
-	// Split ciphertext and auth tag
-	tagStart := len(sealed) - gcm.Overhead()
-	ciphertext := sealed[:tagStart]
-	authTag := sealed[tagStart:]
-
 	return &EncryptionResult{
-		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
-		AuthTag:    base64.StdEncoding.EncodeToString(authTag),
-		IV:         base64.StdEncoding.EncodeToString(iv),
-		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Envelope: envelope,
+		Salt:     base64.StdEncoding.EncodeToString(salt),
 	}, nil
 }
 
-// Decrypt decrypts ciphertext using AES-256-GCM with PBKDF2-derived key
+// Decrypt decrypts a result of Encrypt with a PBKDF2-derived key
 func Decrypt(result *EncryptionResult, password string, iterations int) (string, error) {
-	// Decode base64 values
-	ciphertext, err := base64.StdEncoding.DecodeString(result.Ciphertext)
-	if err != nil {
-		return "", errors.New("invalid ciphertext encoding")
-	}
-	authTag, err := base64.StdEncoding.DecodeString(result.AuthTag)
-	if err != nil {
-		return "", errors.New("invalid authTag encoding")
-	}
-	iv, err := base64.StdEncoding.DecodeString(result.IV)
-	if err != nil {
-		return "", errors.New("invalid IV encoding")
-	}
 	salt, err := base64.StdEncoding.DecodeString(result.Salt)
 	if err != nil {
 		return "", errors.New("invalid salt encoding")
@@ -110,25 +92,14 @@ func Decrypt(result *EncryptionResult, password string, iterations int) (string,
 		return "", err
 	}
 
-	// Create cipher
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return "", err
+	envelope := result.Envelope
+	if envelope == "" {
+		envelope = result.IV + ":" + result.Ciphertext + ":" + result.AuthTag
 	}
-
-	gcm, err := cipher.NewGCM(block)
+	plaintext, err := Open(key, envelope, nil)
 	if err != nil {
 		return "", err
 	}
 
-	// Reconstruct sealed message (ciphertext + authTag)
-	sealed := append(ciphertext, authTag...)
-
-	// Decrypt
-	plaintext, err := gcm.Open(nil, iv, sealed, nil)
-	if err != nil {
-		return "", errors.New("decryption failed: invalid password or corrupted data")
-	}
-
 	return string(plaintext), nil
 }
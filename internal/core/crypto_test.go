@@ -113,14 +113,9 @@ func TestEncryptProducesUniqueOutput(t *testing.T) {
 		t.Error("Expected different salts")
 	}
 
-	// IV should differ
-	if result1.IV == result2.IV {
-		t.Error("Expected different IVs")
-	}
-
-	// Ciphertext should differ
-	if result1.Ciphertext == result2.Ciphertext {
-		t.Error("Expected different ciphertexts")
+	// Nonce and ciphertext should differ
+	if result1.Envelope == result2.Envelope {
+		t.Error("Expected different envelopes")
 	}
 
 	// But both should decrypt to same plaintext
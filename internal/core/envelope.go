@@ -0,0 +1,169 @@
+package core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Supported ciphers
+const (
+	CipherAES256GCM         = "aes-256-gcm"
+	CipherXChaCha20Poly1305 = "xchacha20-poly1305"
+)
+
+// DefaultCipher is used unless a vault selects another
+const DefaultCipher = CipherAES256GCM
+
+// envelopeVersion tags the current envelope layout:
+// "keyp1:<cipher>:<nonce>:<ciphertext with tag>", both parts base64
+const envelopeVersion = "keyp1"
+
+// ErrDecrypt is returned when a value does not authenticate under the key
+// and associated data
+var ErrDecrypt = errors.New("decryption failed: invalid key or corrupted data")
+
+// Ciphers returns the names of the supported ciphers
+func Ciphers() []string {
+	return []string{CipherAES256GCM, CipherXChaCha20Poly1305}
+}
+
+// ValidateCipher checks that name is a supported cipher
+func ValidateCipher(name string) error {
+	for _, c := range Ciphers() {
+		if c == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported cipher %q", name)
+}
+
+// newAEAD returns the AEAD for a cipher name
+func newAEAD(name string, key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, errors.New("key must be 32 bytes")
+	}
+	switch name {
+	case CipherAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("unsupported cipher %q", name)
+	}
+}
+
+// Seal encrypts plaintext with the named cipher and a random nonce,
+// authenticating the optional associated data alongside it, and returns
+// the envelope
+func Seal(cipherName string, key, plaintext, aad []byte) (string, error) {
+	aead, err := newAEAD(cipherName, key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nil, nonce, plaintext, aad)
+
+	return strings.Join([]string{
+		envelopeVersion,
+		cipherName,
+		base64.StdEncoding.EncodeToString(nonce),
+		base64.StdEncoding.EncodeToString(sealed),
+	}, ":"), nil
+}
+
+// Open decrypts an envelope produced by Seal with the same associated data.
+// Values from before envelopes, "iv:ciphertext:authTag" under AES-256-GCM,
+// are accepted too.
+func Open(key []byte, envelope string, aad []byte) ([]byte, error) {
+	cipherName, nonce, sealed, err := parseEnvelope(envelope)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(cipherName, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce size")
+	}
+
+	plaintext, err := aead.Open(nil, nonce, sealed, aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// EnvelopeCipher returns the cipher an encrypted value was sealed with
+func EnvelopeCipher(envelope string) (string, error) {
+	cipherName, _, _, err := parseEnvelope(envelope)
+	return cipherName, err
+}
+
+// IsEnvelope reports whether s is laid out as an encrypted value, in the
+// current or the older format
+func IsEnvelope(s string) bool {
+	cipherName, nonce, sealed, err := parseEnvelope(s)
+	if err != nil {
+		return false
+	}
+	aead, err := newAEAD(cipherName, make([]byte, KeySize))
+	if err != nil {
+		return false
+	}
+	return len(nonce) == aead.NonceSize() && len(sealed) >= aead.Overhead()
+}
+
+// parseEnvelope splits an encrypted value into cipher, nonce and
+// ciphertext with the tag appended
+func parseEnvelope(envelope string) (string, []byte, []byte, error) {
+	parts := strings.Split(envelope, ":")
+	switch {
+	case len(parts) == 4 && parts[0] == envelopeVersion:
+		nonce, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return "", nil, nil, errors.New("invalid nonce encoding")
+		}
+		sealed, err := base64.StdEncoding.DecodeString(parts[3])
+		if err != nil {
+			return "", nil, nil, errors.New("invalid ciphertext encoding")
+		}
+		return parts[1], nonce, sealed, nil
+
+	case len(parts) == 3:
+		iv, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil {
+			return "", nil, nil, errors.New("invalid IV encoding")
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return "", nil, nil, errors.New("invalid ciphertext encoding")
+		}
+		authTag, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return "", nil, nil, errors.New("invalid authTag encoding")
+		}
+		return CipherAES256GCM, iv, append(ciphertext, authTag...), nil
+
+	case strings.HasPrefix(parts[0], "keyp"):
+		return "", nil, nil, fmt.Errorf("unsupported envelope version %q", parts[0])
+
+	default:
+		return "", nil, nil, errors.New("invalid encrypted value format")
+	}
+}
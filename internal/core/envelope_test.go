@@ -0,0 +1,111 @@
+package core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	key := make([]byte, KeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	aad := []byte("context")
+
+	for _, name := range Ciphers() {
+		t.Run(name, func(t *testing.T) {
+			envelope, err := Seal(name, key, []byte("hello world"), aad)
+			if err != nil {
+				t.Fatalf("Seal failed: %v", err)
+			}
+			if got, err := EnvelopeCipher(envelope); err != nil || got != name {
+				t.Errorf("EnvelopeCipher = %q, %v", got, err)
+			}
+			if !IsEnvelope(envelope) {
+				t.Error("Expected IsEnvelope to recognize the envelope")
+			}
+
+			plaintext, err := Open(key, envelope, aad)
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			if string(plaintext) != "hello world" {
+				t.Errorf("Round trip failed: got %q", plaintext)
+			}
+
+			if _, err := Open(key, envelope, []byte("other")); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("Expected ErrDecrypt for wrong associated data, got %v", err)
+			}
+			wrongKey := make([]byte, KeySize)
+			if _, err := Open(wrongKey, envelope, aad); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("Expected ErrDecrypt for wrong key, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenLegacyFormat(t *testing.T) {
+	key := make([]byte, KeySize)
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	iv := make([]byte, IVSize)
+	sealed := gcm.Seal(nil, iv, []byte("old value"), nil)
+	tagStart := len(sealed) - gcm.Overhead()
+	legacy := strings.Join([]string{
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(sealed[:tagStart]),
+		base64.StdEncoding.EncodeToString(sealed[tagStart:]),
+	}, ":")
+
+	if !IsEnvelope(legacy) {
+		t.Error("Expected IsEnvelope to recognize the legacy format")
+	}
+	if got, _ := EnvelopeCipher(legacy); got != CipherAES256GCM {
+		t.Errorf("EnvelopeCipher = %q, want %q", got, CipherAES256GCM)
+	}
+	plaintext, err := Open(key, legacy, nil)
+	if err != nil || string(plaintext) != "old value" {
+		t.Errorf("Open legacy = %q, %v", plaintext, err)
+	}
+}
+
+func TestOpenRejectsUnknownFormats(t *testing.T) {
+	key := make([]byte, KeySize)
+	for _, value := range []string{"plaintext", "keyp9:aes-256-gcm:AAAA:AAAA", "keyp1:rot13:AAAA:AAAA"} {
+		if _, err := Open(key, value, nil); err == nil {
+			t.Errorf("Expected error opening %q", value)
+		}
+		if IsEnvelope(value) {
+			t.Errorf("IsEnvelope(%q) = true", value)
+		}
+	}
+	if _, err := Seal("rot13", key, nil, nil); err == nil {
+		t.Error("Expected error sealing with unknown cipher")
+	}
+}
+
+func TestDecryptLegacyResult(t *testing.T) {
+	result, err := Encrypt("secret data", "password", MinIterations)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+
+	// Split the envelope into the separate fields older results carried
+	parts := strings.Split(result.Envelope, ":")
+	sealed, _ := base64.StdEncoding.DecodeString(parts[3])
+	legacy := &EncryptionResult{
+		Salt:       result.Salt,
+		IV:         parts[2],
+		Ciphertext: base64.StdEncoding.EncodeToString(sealed[:len(sealed)-16]),
+		AuthTag:    base64.StdEncoding.EncodeToString(sealed[len(sealed)-16:]),
+	}
+
+	decrypted, err := Decrypt(legacy, "password", MinIterations)
+	if err != nil || decrypted != "secret data" {
+		t.Errorf("Decrypt legacy = %q, %v", decrypted, err)
+	}
+}
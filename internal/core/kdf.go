@@ -0,0 +1,114 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Supported key derivation functions
+const (
+	KDFPBKDF2   = "pbkdf2-sha256"
+	KDFArgon2id = "argon2id"
+)
+
+// Default Argon2id parameters (RFC 9106, second recommended option)
+const (
+	Argon2Time    = 3
+	Argon2Memory  = 64 * 1024 // KiB
+	Argon2Threads = 4
+)
+
+// KDFParams describes a password key derivation function and its parameters
+type KDFParams struct {
+	Algorithm  string `json:"alg"`
+	Iterations int    `json:"iterations,omitempty"` // PBKDF2 only
+	Time       uint32 `json:"time,omitempty"`       // Argon2id passes
+	Memory     uint32 `json:"memory,omitempty"`     // Argon2id memory in KiB
+	Threads    uint8  `json:"threads,omitempty"`    // Argon2id parallelism
+}
+
+// DefaultKDF returns the parameters used for new and upgraded vaults
+func DefaultKDF() KDFParams {
+	return KDFParams{
+		Algorithm: KDFArgon2id,
+		Time:      Argon2Time,
+		Memory:    Argon2Memory,
+		Threads:   Argon2Threads,
+	}
+}
+
+// PBKDF2Params returns PBKDF2-SHA256 parameters with the given iteration count
+func PBKDF2Params(iterations int) KDFParams {
+	return KDFParams{Algorithm: KDFPBKDF2, Iterations: iterations}
+}
+
+// ParseKDFParams decodes parameters stored with String
+func ParseKDFParams(s string) (KDFParams, error) {
+	var p KDFParams
+	if err := json.Unmarshal([]byte(s), &p); err != nil {
+		return KDFParams{}, fmt.Errorf("invalid KDF descriptor: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return KDFParams{}, err
+	}
+	return p, nil
+}
+
+// String encodes the parameters for storage
+func (p KDFParams) String() string {
+	data, _ := json.Marshal(p)
+	return string(data)
+}
+
+// Validate checks that the parameters are usable and not below the minimums
+func (p KDFParams) Validate() error {
+	switch p.Algorithm {
+	case KDFPBKDF2:
+		if p.Iterations < MinIterations {
+			return errors.New("iterations must be at least 100000")
+		}
+	case KDFArgon2id:
+		if p.Time < 1 || p.Threads < 1 {
+			return errors.New("argon2id time and threads must be at least 1")
+		}
+		if p.Memory < 8*uint32(p.Threads) {
+			return errors.New("argon2id memory must be at least 8 KiB per thread")
+		}
+	default:
+		return fmt.Errorf("unsupported KDF %q", p.Algorithm)
+	}
+	return nil
+}
+
+// Derive derives a 256-bit key from password and salt
+func (p KDFParams) Derive(password string, salt []byte) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if len(salt) != SaltSize {
+		return nil, errors.New("salt must be 32 bytes")
+	}
+
+	switch p.Algorithm {
+	case KDFArgon2id:
+		return argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, KeySize), nil
+	default:
+		return DeriveKey(password, salt, p.Iterations)
+	}
+}
+
+// Outdated reports whether p is weaker than target and should be upgraded
+func (p KDFParams) Outdated(target KDFParams) bool {
+	if p.Algorithm != target.Algorithm {
+		return p.Algorithm == KDFPBKDF2
+	}
+	switch p.Algorithm {
+	case KDFArgon2id:
+		return p.Time < target.Time || p.Memory < target.Memory || p.Threads < target.Threads
+	default:
+		return p.Iterations < target.Iterations
+	}
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKDFParamsDerive(t *testing.T) {
+	salt := make([]byte, SaltSize)
+	for i := range salt {
+		salt[i] = byte(i)
+	}
+
+	params := KDFParams{Algorithm: KDFArgon2id, Time: 1, Memory: 64, Threads: 1}
+	key, err := params.Derive("testpassword", salt)
+	if err != nil {
+		t.Fatalf("Derive failed: %v", err)
+	}
+	if len(key) != KeySize {
+		t.Errorf("Expected key length %d, got %d", KeySize, len(key))
+	}
+
+	key2, _ := params.Derive("testpassword", salt)
+	if !bytes.Equal(key, key2) {
+		t.Error("Same inputs produced different keys")
+	}
+
+	// PBKDF2 descriptors must match DeriveKey
+	pbkdf2Key, err := PBKDF2Params(MinIterations).Derive("testpassword", salt)
+	if err != nil {
+		t.Fatalf("Derive failed: %v", err)
+	}
+	legacy, _ := DeriveKey("testpassword", salt, MinIterations)
+	if !bytes.Equal(pbkdf2Key, legacy) {
+		t.Error("PBKDF2 descriptor does not match DeriveKey")
+	}
+	if bytes.Equal(key, pbkdf2Key) {
+		t.Error("Different KDFs produced the same key")
+	}
+}
+
+func TestKDFParamsValidate(t *testing.T) {
+	invalid := []KDFParams{
+		{Algorithm: "scrypt"},
+		PBKDF2Params(1000),
+		{Algorithm: KDFArgon2id, Time: 0, Memory: 64, Threads: 1},
+		{Algorithm: KDFArgon2id, Time: 1, Memory: 8, Threads: 4},
+	}
+	for _, p := range invalid {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Expected %s to be invalid", p)
+		}
+	}
+	if err := DefaultKDF().Validate(); err != nil {
+		t.Errorf("DefaultKDF invalid: %v", err)
+	}
+}
+
+func TestKDFParamsRoundTrip(t *testing.T) {
+	p := DefaultKDF()
+	parsed, err := ParseKDFParams(p.String())
+	if err != nil {
+		t.Fatalf("ParseKDFParams failed: %v", err)
+	}
+	if parsed != p {
+		t.Errorf("Expected %v, got %v", p, parsed)
+	}
+
+	if _, err := ParseKDFParams("not json"); err == nil {
+		t.Error("Expected error for malformed descriptor")
+	}
+}
+
+func TestKDFParamsOutdated(t *testing.T) {
+	target := DefaultKDF()
+
+	if !PBKDF2Params(1000000).Outdated(target) {
+		t.Error("PBKDF2 should be outdated against Argon2id")
+	}
+	if target.Outdated(target) {
+		t.Error("Target should not be outdated against itself")
+	}
+
+	weaker := target
+	weaker.Memory = target.Memory / 2
+	if !weaker.Outdated(target) {
+		t.Error("Lower memory should be outdated")
+	}
+
+	stronger := target
+	stronger.Time = target.Time + 1
+	if stronger.Outdated(target) {
+		t.Error("Stronger parameters should not be outdated")
+	}
+}
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"errors"
+	"sync"
+)
+
+// SecretBuffer holds a key, password or revealed secret value outside the
+// garbage-collected heap where the platform allows, locked against being
+// swapped to disk. Destroy zeroes the contents and releases the memory.
+type SecretBuffer struct {
+	mu        sync.Mutex
+	data      []byte
+	locked    bool // Pages are locked in memory
+	destroyed bool
+}
+
+// releaseMemory returns the memory of a buffer to the system. Tests replace
+// it to inspect the contents after Destroy.
+var releaseMemory = releasePages
+
+// NewSecretBuffer allocates a zeroed buffer of size bytes
+func NewSecretBuffer(size int) (*SecretBuffer, error) {
+	if size < 0 {
+		return nil, errors.New("secret buffer size must not be negative")
+	}
+	data, locked, err := allocPages(size)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBuffer{data: data, locked: locked}, nil
+}
+
+// SecretBufferFrom moves b into a new buffer and zeroes b
+func SecretBufferFrom(b []byte) (*SecretBuffer, error) {
+	buf, err := NewSecretBuffer(len(b))
+	if err != nil {
+		return nil, err
+	}
+	copy(buf.data, b)
+	Wipe(b)
+	return buf, nil
+}
+
+// SecretBufferFromString copies s into a new buffer. The string itself
+// cannot be wiped, so prefer SecretBufferFrom where the source is a slice.
+func SecretBufferFromString(s string) (*SecretBuffer, error) {
+	buf, err := NewSecretBuffer(len(s))
+	if err != nil {
+		return nil, err
+	}
+	copy(buf.data, s)
+	return buf, nil
+}
+
+// Bytes returns the contents, or nil once the buffer is destroyed. The
+// slice aliases the buffer and must not be used after Destroy.
+func (b *SecretBuffer) Bytes() []byte {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.destroyed {
+		return nil
+	}
+	return b.data
+}
+
+// Len returns the size of the contents
+func (b *SecretBuffer) Len() int {
+	return len(b.Bytes())
+}
+
+// Clone returns an independent copy that must be destroyed separately
+func (b *SecretBuffer) Clone() (*SecretBuffer, error) {
+	data := b.Bytes()
+	if data == nil {
+		return nil, errors.New("secret buffer is destroyed")
+	}
+	clone, err := NewSecretBuffer(len(data))
+	if err != nil {
+		return nil, err
+	}
+	copy(clone.data, data)
+	return clone, nil
+}
+
+// Locked reports whether the contents are locked in memory
+func (b *SecretBuffer) Locked() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.locked
+}
+
+// Destroyed reports whether Destroy has been called
+func (b *SecretBuffer) Destroyed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.destroyed
+}
+
+// Destroy zeroes the contents and releases the memory. It is safe to call
+// more than once and on a nil buffer.
+func (b *SecretBuffer) Destroy() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.destroyed {
+		return
+	}
+	Wipe(b.data)
+	releaseMemory(b.data, b.locked)
+	b.data = nil
+	b.destroyed = true
+}
+
+// Wipe overwrites b with zeros
+func Wipe(b []byte) {
+	clear(b)
+}
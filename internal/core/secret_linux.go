@@ -0,0 +1,41 @@
+//go:build linux
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// allocPages maps anonymous memory for a secret buffer, rounded up to whole
+// pages, and locks it so it is never written to swap. If the lock limit is
+// reached the pages are still used, unlocked.
+func allocPages(size int) ([]byte, bool, error) {
+	if size == 0 {
+		return []byte{}, false, nil
+	}
+	pageSize := os.Getpagesize()
+	length := (size + pageSize - 1) / pageSize * pageSize
+
+	mem, err := syscall.Mmap(-1, 0, length, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to allocate secret memory: %w", err)
+	}
+	// Keep secrets out of core dumps
+	syscall.Madvise(mem, 0x10) // MADV_DONTDUMP
+	locked := syscall.Mlock(mem) == nil
+	return mem[:size], locked, nil
+}
+
+// releasePages unlocks and unmaps memory from allocPages
+func releasePages(b []byte, locked bool) {
+	if cap(b) == 0 {
+		return
+	}
+	mem := b[:cap(b)]
+	if locked {
+		syscall.Munlock(mem)
+	}
+	syscall.Munmap(mem)
+}
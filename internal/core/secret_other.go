@@ -0,0 +1,12 @@
+//go:build !linux
+
+package core
+
+// allocPages allocates a secret buffer on the heap; memory locking is only
+// implemented on Linux
+func allocPages(size int) ([]byte, bool, error) {
+	return make([]byte, size), false, nil
+}
+
+// releasePages leaves heap memory to the garbage collector
+func releasePages(b []byte, locked bool) {}
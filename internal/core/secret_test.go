@@ -0,0 +1,72 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSecretBufferFromWipesSource(t *testing.T) {
+	src := []byte("correct horse battery staple")
+	buf, err := SecretBufferFrom(src)
+	if err != nil {
+		t.Fatalf("SecretBufferFrom failed: %v", err)
+	}
+	defer buf.Destroy()
+
+	if string(buf.Bytes()) != "correct horse battery staple" {
+		t.Errorf("Contents mismatch: %q", buf.Bytes())
+	}
+	if !bytes.Equal(src, make([]byte, len(src))) {
+		t.Errorf("Source not wiped: %q", src)
+	}
+}
+
+func TestSecretBufferDestroyWipes(t *testing.T) {
+	var released []byte
+	releaseMemory = func(b []byte, locked bool) { released = b }
+	defer func() { releaseMemory = releasePages }()
+
+	buf, err := SecretBufferFromString("hunter2")
+	if err != nil {
+		t.Fatalf("SecretBufferFromString failed: %v", err)
+	}
+	buf.Destroy()
+
+	if !bytes.Equal(released, make([]byte, len("hunter2"))) {
+		t.Errorf("Contents not wiped before release: %q", released)
+	}
+	if !buf.Destroyed() || buf.Bytes() != nil || buf.Len() != 0 {
+		t.Error("Expected destroyed buffer to expose nothing")
+	}
+	buf.Destroy() // Safe to repeat
+}
+
+func TestSecretBufferClone(t *testing.T) {
+	buf, _ := SecretBufferFromString("key material")
+	clone, err := buf.Clone()
+	if err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+	buf.Destroy()
+	defer clone.Destroy()
+
+	if string(clone.Bytes()) != "key material" {
+		t.Errorf("Clone did not survive original's destruction: %q", clone.Bytes())
+	}
+	if _, err := buf.Clone(); err == nil {
+		t.Error("Expected error cloning a destroyed buffer")
+	}
+}
+
+func TestSecretBufferEmpty(t *testing.T) {
+	buf, err := NewSecretBuffer(0)
+	if err != nil {
+		t.Fatalf("NewSecretBuffer failed: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Len = %d, want 0", buf.Len())
+	}
+	buf.Destroy()
+	var nilBuf *SecretBuffer
+	nilBuf.Destroy()
+}
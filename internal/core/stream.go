@@ -0,0 +1,106 @@
+package core
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+)
+
+// StreamChunkSize is the plaintext size of every chunk of a sealed stream
+// but the last, which may be shorter or empty
+const StreamChunkSize = 64 * 1024
+
+// ErrTruncated is returned when a sealed stream ends before its last chunk
+var ErrTruncated = errors.New("decryption failed: stream is truncated")
+
+// StreamSealer encrypts a stream as a sequence of chunks, each sealed on its
+// own with a random nonce. The associated data of a chunk binds it to the
+// stream, its position and whether it is the last, so chunks cannot be
+// reordered, moved between streams or dropped from the end undetected.
+type StreamSealer struct {
+	aead cipher.AEAD
+	aad  []byte
+	seq  uint64
+}
+
+// NewStreamSealer starts sealing a stream with the named cipher, bound to
+// the given associated data
+func NewStreamSealer(cipherName string, key, aad []byte) (*StreamSealer, error) {
+	aead, err := newAEAD(cipherName, key)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamSealer{aead: aead, aad: aad}, nil
+}
+
+// Seal encrypts the next chunk and returns the nonce followed by the
+// ciphertext with its tag
+func (s *StreamSealer) Seal(chunk []byte, last bool) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(chunk)+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := s.aead.Seal(nonce, nonce, chunk, chunkAAD(s.aad, s.seq, last))
+	s.seq++
+	return sealed, nil
+}
+
+// StreamOpener decrypts the chunks of a stream sealed by StreamSealer, in order
+type StreamOpener struct {
+	aead cipher.AEAD
+	aad  []byte
+	seq  uint64
+	done bool
+}
+
+// NewStreamOpener starts opening a stream sealed with the named cipher and
+// the same associated data
+func NewStreamOpener(cipherName string, key, aad []byte) (*StreamOpener, error) {
+	aead, err := newAEAD(cipherName, key)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamOpener{aead: aead, aad: aad}, nil
+}
+
+// Open decrypts the next chunk, which the caller says is the last one or
+// not. It fails with ErrDecrypt if the chunk was not sealed at this
+// position, in this stream, with the same last flag.
+func (o *StreamOpener) Open(sealed []byte, last bool) ([]byte, error) {
+	if o.done {
+		return nil, ErrDecrypt
+	}
+	nonceSize := o.aead.NonceSize()
+	if len(sealed) < nonceSize+o.aead.Overhead() {
+		return nil, ErrDecrypt
+	}
+	chunk, err := o.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], chunkAAD(o.aad, o.seq, last))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	o.seq++
+	o.done = last
+	return chunk, nil
+}
+
+// Close checks that the last chunk was opened, and fails with ErrTruncated
+// otherwise
+func (o *StreamOpener) Close() error {
+	if !o.done {
+		return ErrTruncated
+	}
+	return nil
+}
+
+// chunkAAD appends the position of a chunk and its last flag to the
+// associated data of its stream
+func chunkAAD(aad []byte, seq uint64, last bool) []byte {
+	out := make([]byte, len(aad), len(aad)+9)
+	copy(out, aad)
+	out = binary.BigEndian.AppendUint64(out, seq)
+	if last {
+		return append(out, 1)
+	}
+	return append(out, 0)
+}
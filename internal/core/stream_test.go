@@ -0,0 +1,72 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := make([]byte, KeySize)
+	aad := []byte("stream")
+	chunks := [][]byte{[]byte("first"), []byte("second"), []byte("third")}
+
+	for _, name := range Ciphers() {
+		t.Run(name, func(t *testing.T) {
+			sealer, err := NewStreamSealer(name, key, aad)
+			if err != nil {
+				t.Fatalf("NewStreamSealer failed: %v", err)
+			}
+			var sealed [][]byte
+			for i, chunk := range chunks {
+				s, err := sealer.Seal(chunk, i == len(chunks)-1)
+				if err != nil {
+					t.Fatalf("Seal failed: %v", err)
+				}
+				sealed = append(sealed, s)
+			}
+
+			open := func(sealed [][]byte, aad []byte) ([]byte, error) {
+				opener, err := NewStreamOpener(name, key, aad)
+				if err != nil {
+					return nil, err
+				}
+				var out []byte
+				for i, s := range sealed {
+					chunk, err := opener.Open(s, i == len(sealed)-1)
+					if err != nil {
+						return nil, err
+					}
+					out = append(out, chunk...)
+				}
+				return out, opener.Close()
+			}
+
+			got, err := open(sealed, aad)
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			if !bytes.Equal(got, []byte("firstsecondthird")) {
+				t.Errorf("Round trip failed: got %q", got)
+			}
+
+			if _, err := open([][]byte{sealed[1], sealed[0], sealed[2]}, aad); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("Expected ErrDecrypt for reordered chunks, got %v", err)
+			}
+			if _, err := open(sealed[:2], aad); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("Expected ErrDecrypt for a dropped last chunk, got %v", err)
+			}
+			if _, err := open(sealed, []byte("other")); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("Expected ErrDecrypt for another stream, got %v", err)
+			}
+
+			opener, _ := NewStreamOpener(name, key, aad)
+			if _, err := opener.Open(sealed[0], false); err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			if err := opener.Close(); !errors.Is(err, ErrTruncated) {
+				t.Errorf("Expected ErrTruncated closing before the last chunk, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTOTPGenerate(t *testing.T) {
+	// Test vectors from RFC 6238, appendix B; the seed is "12345678901234567890"
+	// repeated to the length of each hash
+	seeds := map[string]string{
+		"SHA1":   "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+		"SHA256": "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZA",
+		"SHA512": "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNA",
+	}
+	tests := []struct {
+		unix      int64
+		algorithm string
+		code      string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1234567890, "SHA256", "91819424"},
+		{20000000000, "SHA512", "47863826"},
+	}
+	for _, tt := range tests {
+		key, err := ParseTOTP("otpauth://totp/test?digits=8&algorithm=" + tt.algorithm + "&secret=" + seeds[tt.algorithm])
+		if err != nil {
+			t.Fatalf("ParseTOTP failed: %v", err)
+		}
+		code := key.Generate(time.Unix(tt.unix, 0))
+		if code.Code != tt.code {
+			t.Errorf("%s at %d: got %s, want %s", tt.algorithm, tt.unix, code.Code, tt.code)
+		}
+		if want := time.Unix((tt.unix/30+1)*30, 0); !code.ExpiresAt.Equal(want) {
+			t.Errorf("%s at %d: expires at %v, want %v", tt.algorithm, tt.unix, code.ExpiresAt, want)
+		}
+	}
+}
+
+func TestParseTOTP(t *testing.T) {
+	key, err := ParseTOTP("jbsw y3dp-ehpk 3pxp")
+	if err != nil {
+		t.Fatalf("ParseTOTP of a grouped lower-case seed failed: %v", err)
+	}
+	if string(key.Secret) != "Hello!\xde\xad\xbe\xef" || key.Digits != TOTPDefaultDigits || key.Period != TOTPDefaultPeriod || key.Algorithm != "SHA1" {
+		t.Errorf("ParseTOTP = %+v, want defaults", key)
+	}
+
+	key, err = ParseTOTP("otpauth://totp/ACME:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=ACME&period=60&digits=7")
+	if err != nil {
+		t.Fatalf("ParseTOTP of a URI failed: %v", err)
+	}
+	if key.Digits != 7 || key.Period != time.Minute {
+		t.Errorf("ParseTOTP = %+v, want 7 digits every minute", key)
+	}
+
+	for _, value := range []string{
+		"",
+		"not base32!",
+		"otpauth://hotp/ACME?secret=JBSWY3DPEHPK3PXP&counter=1",
+		"otpauth://totp/ACME?issuer=ACME",
+		"otpauth://totp/ACME?secret=JBSWY3DPEHPK3PXP&algorithm=MD5",
+		"otpauth://totp/ACME?secret=JBSWY3DPEHPK3PXP&digits=4",
+		"otpauth://totp/ACME?secret=JBSWY3DPEHPK3PXP&period=0",
+	} {
+		if _, err := ParseTOTP(value); !errors.Is(err, ErrInvalidTOTP) {
+			t.Errorf("ParseTOTP(%q) = %v, want ErrInvalidTOTP", value, err)
+		}
+	}
+}
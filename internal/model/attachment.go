@@ -0,0 +1,38 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Attachment is a file stored encrypted alongside a secret, such as a
+// private key or a recovery document. Its content is read and written as a
+// stream rather than held here.
+type Attachment struct {
+	ID        string    `json:"id"`
+	SecretID  string    `json:"-"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"` // Bytes of content
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ErrInvalidAttachmentName is returned for an attachment name that is not a
+// plain file name
+var ErrInvalidAttachmentName = errors.New("invalid attachment name")
+
+// ValidateAttachmentName checks that name is a plain file name, which can
+// be extracted into a directory as is: not empty, not . or .., and without
+// path separators
+func ValidateAttachmentName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("%w: name is empty", ErrInvalidAttachmentName)
+	case name == "." || name == "..":
+		return fmt.Errorf("%w: '%s'", ErrInvalidAttachmentName, name)
+	case strings.ContainsAny(name, `/\`):
+		return fmt.Errorf("%w: '%s' contains a path separator", ErrInvalidAttachmentName, name)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Period is how often something recurs. It is written as whole days, such
+// as 90d, when it is a multiple of one, and as a Go duration otherwise.
+type Period time.Duration
+
+// String returns the period as config.ParseDuration reads it
+func (p Period) String() string {
+	d := time.Duration(p)
+	if d != 0 && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
+
+// MarshalText writes the period as String does
+func (p Period) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText reads a period written by MarshalText
+func (p *Period) UnmarshalText(text []byte) error {
+	value := string(text)
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return fmt.Errorf("invalid period %q", value)
+		}
+		*p = Period(time.Duration(n) * 24 * time.Hour)
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid period %q", value)
+	}
+	*p = Period(d)
+	return nil
+}
+
+// Reasons a secret needs attention, from most to least urgent
+const (
+	DueExpired         = "expired"
+	DueRotationOverdue = "rotation overdue"
+	DueExpiring        = "expiring"
+	DueRotation        = "rotation due"
+)
+
+// RotationDue returns when the field values of the secret should next
+// change, or the zero time without a rotation period
+func (s *SecretObject) RotationDue() time.Time {
+	if s.RotateEvery <= 0 {
+		return time.Time{}
+	}
+	from := s.RotatedAt
+	if from.IsZero() {
+		from = s.CreatedAt
+	}
+	return from.Add(time.Duration(s.RotateEvery))
+}
+
+// Due reports whether the secret needs attention before the given time,
+// and if so why and by when. Having expired comes first, then an overdue
+// rotation, then whichever of expiry and rotation is sooner.
+func (s *SecretObject) Due(now, before time.Time) (string, time.Time, bool) {
+	expires, rotate := s.ExpiresAt, s.RotationDue()
+	switch {
+	case !expires.IsZero() && !expires.After(now):
+		return DueExpired, expires, true
+	case !rotate.IsZero() && !rotate.After(now):
+		return DueRotationOverdue, rotate, true
+	case !expires.IsZero() && expires.Before(before) && (rotate.IsZero() || !rotate.Before(expires)):
+		return DueExpiring, expires, true
+	case !rotate.IsZero() && rotate.Before(before):
+		return DueRotation, rotate, true
+	}
+	return "", time.Time{}, false
+}
@@ -0,0 +1,102 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PathSeparator separates the segments of a hierarchical secret name such
+// as work/aws/prod, whose folder is work/aws
+const PathSeparator = "/"
+
+// ErrInvalidName is returned for a secret name that cannot be used as a path
+var ErrInvalidName = errors.New("invalid secret name")
+
+// ValidateName checks that name is not empty and has no empty path
+// segments: it neither starts nor ends with a separator, nor has two in a row
+func ValidateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: name is empty", ErrInvalidName)
+	}
+	for _, segment := range strings.Split(name, PathSeparator) {
+		if segment == "" {
+			return fmt.Errorf("%w: '%s' has an empty path segment", ErrInvalidName, name)
+		}
+	}
+	return nil
+}
+
+// FolderPrefix returns what the names of the secrets inside folder start
+// with: the folder followed by a separator, or nothing for the top level
+func FolderPrefix(folder string) string {
+	folder = strings.Trim(folder, PathSeparator)
+	if folder == "" {
+		return ""
+	}
+	return folder + PathSeparator
+}
+
+// Dir returns the folder of the secret, empty at the top level
+func (s *SecretObject) Dir() string {
+	i := strings.LastIndex(s.Name, PathSeparator)
+	if i < 0 {
+		return ""
+	}
+	return s.Name[:i]
+}
+
+// Base returns the last segment of the secret's name
+func (s *SecretObject) Base() string {
+	return s.Name[strings.LastIndex(s.Name, PathSeparator)+1:]
+}
+
+// PathEntry is a direct child of a folder: a secret, or a subfolder
+// holding at least one
+type PathEntry struct {
+	Name    string        // Segment within the folder
+	Path    string        // Full name of the secret or subfolder
+	Secret  *SecretObject // Nil for a subfolder
+	Secrets int           // Number of secrets anywhere inside a subfolder
+}
+
+// IsFolder reports whether the entry is a subfolder
+func (e PathEntry) IsFolder() bool {
+	return e.Secret == nil
+}
+
+// ListFolder returns the direct children of folder among secrets, which
+// are expected to be inside it: subfolders first, then secrets, each
+// ordered by name. A secret may share its name with a subfolder.
+func ListFolder(secrets []*SecretObject, folder string) []PathEntry {
+	depth := 0
+	if prefix := FolderPrefix(folder); prefix != "" {
+		depth = strings.Count(prefix, PathSeparator)
+	}
+
+	var folders, leaves []PathEntry
+	index := make(map[string]int)
+	for _, s := range secrets {
+		segments := strings.Split(s.Name, PathSeparator)
+		if len(segments) <= depth {
+			continue // The folder itself
+		}
+		path := strings.Join(segments[:depth+1], PathSeparator)
+		if len(segments) == depth+1 {
+			leaves = append(leaves, PathEntry{Name: segments[depth], Path: path, Secret: s})
+			continue
+		}
+		i, ok := index[path]
+		if !ok {
+			i = len(folders)
+			index[path] = i
+			folders = append(folders, PathEntry{Name: segments[depth], Path: path})
+		}
+		folders[i].Secrets++
+	}
+
+	sort.Slice(folders, func(i, j int) bool { return folders[i].Name < folders[j].Name })
+	sort.Slice(leaves, func(i, j int) bool { return leaves[i].Name < leaves[j].Name })
+	return append(folders, leaves...)
+}
@@ -2,8 +2,11 @@ package model
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/TheEditor/keyp/internal/core"
 	"github.com/google/uuid"
 )
 
@@ -16,6 +19,17 @@ type SecretObject struct {
 	Notes     string    `json:"notes,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	DeletedAt time.Time `json:"deleted_at,omitzero"` // Set while the secret is in the trash
+
+	// Optional lifecycle tracking; the rotation period counts from RotatedAt,
+	// which changes whenever a field value does
+	ExpiresAt   time.Time `json:"expires_at,omitzero"`
+	RotateEvery Period    `json:"rotate_every,omitzero"`
+	RotatedAt   time.Time `json:"rotated_at,omitzero"`
+
+	// NameKey is what the store matches the name by, for lookups and
+	// uniqueness; the name itself when empty
+	NameKey string `json:"-"`
 }
 
 // Field represents a single named value within a secret
@@ -35,8 +49,22 @@ const (
 	FieldTypePIN      = "pin"
 	FieldTypeURL      = "url"
 	FieldTypeEmail    = "email"
+	FieldTypeTOTP     = "totp" // otpauth:// URI or base32 seed, validated on input
 )
 
+// ErrInvalidField is returned for a field whose value does not suit its type
+var ErrInvalidField = errors.New("invalid field")
+
+// Validate checks that the value of the field suits its type
+func (f Field) Validate() error {
+	if f.Type == FieldTypeTOTP {
+		if _, err := core.ParseTOTP(f.Value); err != nil {
+			return fmt.Errorf("%w '%s': %w", ErrInvalidField, f.Label, err)
+		}
+	}
+	return nil
+}
+
 // NewSecretObject creates a new secret with defaults
 func NewSecretObject(name string) *SecretObject {
 	now := time.Now()
@@ -70,6 +98,16 @@ func (s *SecretObject) AddField(f Field) {
 	s.UpdatedAt = time.Now()
 }
 
+// ValidateFields checks every field of the secret
+func (s *SecretObject) ValidateFields() error {
+	for _, f := range s.Fields {
+		if err := f.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // TagsJSON returns tags as JSON string for storage
 func (s *SecretObject) TagsJSON() string {
 	data, _ := json.Marshal(s.Tags)
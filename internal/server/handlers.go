@@ -3,8 +3,16 @@ package server
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"mime"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/TheEditor/keyp/internal/config"
+	"github.com/TheEditor/keyp/internal/core"
+	"github.com/TheEditor/keyp/internal/model"
 	"github.com/TheEditor/keyp/internal/store"
 	"github.com/TheEditor/keyp/internal/vault"
 )
@@ -25,23 +33,46 @@ func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
 
 // Auth endpoints
 
+// maxUnlockBody bounds an unlock request, which carries the key file
+// content base64-encoded
+const maxUnlockBody = 2 * vault.MaxKeyFileData
+
 // handleUnlock unlocks the vault and creates a session
 func (s *Server) handleUnlock(w http.ResponseWriter, r *http.Request) {
 	var req UnlockRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUnlockBody)).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, ErrorResponse(ErrCodePayloadTooLarge, vault.ErrKeyFileTooLarge.Error()))
+			return
+		}
 		writeJSON(w, http.StatusBadRequest, ErrorResponse(ErrCodeBadRequest, "Invalid JSON"))
 		return
 	}
+	defer core.Wipe(req.KeyFile)
 
 	// Create vault handle and unlock
 	handle := vault.NewHandle(s.vaultPath)
-	if err := handle.Unlock(req.Password, 0); err != nil {
+	handle.SetMaxLifetime(s.maxLifetime)
+	if err := handle.UnlockWithKeyFileData(req.Password, req.KeyFile, s.sessionTimeout); err != nil {
+		if errors.Is(err, vault.ErrKeyFileTooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, ErrorResponse(ErrCodePayloadTooLarge, err.Error()))
+			return
+		}
+		if errors.Is(err, vault.ErrKeyFileRequired) {
+			writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeKeyFileRequired, "Valid key file required"))
+			return
+		}
+		if errors.Is(err, store.ErrSchemaTooNew) {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse(ErrCodeInternalError, "Vault was written by a newer version of keyp"))
+			return
+		}
 		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Invalid password"))
 		return
 	}
 
 	// Create session
-	session, err := s.sessions.Create(handle, s.sessionTimeout)
+	session, err := s.sessions.Create(handle, s.sessionTimeout, s.maxLifetime)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, ErrorResponse(ErrCodeInternalError, "Failed to create session"))
 		return
@@ -50,6 +81,7 @@ func (s *Server) handleUnlock(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, SuccessResponse(UnlockResponse{
 		Token:     session.Token,
 		ExpiresAt: session.ExpiresAt,
+		Deadline:  session.Deadline,
 	}))
 }
 
@@ -90,6 +122,7 @@ func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, http.StatusOK, SuccessResponse(RefreshResponse{
 		ExpiresAt: session.ExpiresAt,
+		Deadline:  session.Deadline,
 	}))
 }
 
@@ -104,19 +137,38 @@ func (s *Server) handleListSecrets(w http.ResponseWriter, r *http.Request) {
 	}
 
 	handle := session.Handle.(*vault.VaultHandle)
-	st := handle.Store()
-	if st == nil {
+	if !handle.IsUnlocked() {
 		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
 		return
 	}
 
+	// With ?prefix=, list the direct children of that folder
+	query := r.URL.Query()
+	var opts *store.SearchOptions
+	if query.Has("prefix") {
+		opts = &store.SearchOptions{Prefix: model.FolderPrefix(query.Get("prefix"))}
+	}
+
 	// List secrets
-	secrets, err := st.List(r.Context(), nil)
+	secrets, err := handle.List(r.Context(), opts)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, ErrorResponse(ErrCodeInternalError, "Failed to list secrets"))
 		return
 	}
 
+	if opts != nil {
+		listing := FolderListing{Prefix: opts.Prefix, Folders: []FolderItem{}, Secrets: []SecretListItem{}}
+		for _, e := range model.ListFolder(secrets, opts.Prefix) {
+			if e.IsFolder() {
+				listing.Folders = append(listing.Folders, FolderItem{Name: e.Name, Path: e.Path, Secrets: e.Secrets})
+			} else {
+				listing.Secrets = append(listing.Secrets, ToSecretListItem(e.Secret))
+			}
+		}
+		writeJSON(w, http.StatusOK, SuccessResponse(listing))
+		return
+	}
+
 	// Convert to API types
 	items := make([]SecretListItem, len(secrets))
 	for i, sec := range secrets {
@@ -135,8 +187,7 @@ func (s *Server) handleCreateSecret(w http.ResponseWriter, r *http.Request) {
 	}
 
 	handle := session.Handle.(*vault.VaultHandle)
-	st := handle.Store()
-	if st == nil {
+	if !handle.IsUnlocked() {
 		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
 		return
 	}
@@ -159,11 +210,19 @@ func (s *Server) handleCreateSecret(w http.ResponseWriter, r *http.Request) {
 
 	// Convert and create
 	secret := req.ToSecretObject()
-	if err := st.Create(r.Context(), secret); err != nil {
+	if err := setLifecycle(secret, &req.ExpiresAt, &req.RotateEvery); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse(ErrCodeBadRequest, err.Error()))
+		return
+	}
+	if err := handle.Create(r.Context(), secret); err != nil {
 		if errors.Is(err, store.ErrAlreadyExists) {
 			writeJSON(w, http.StatusConflict, ErrorResponse(ErrCodeConflict, "Secret already exists"))
 			return
 		}
+		if errors.Is(err, model.ErrInvalidName) || errors.Is(err, model.ErrInvalidField) {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse(ErrCodeBadRequest, err.Error()))
+			return
+		}
 		writeJSON(w, http.StatusInternalServerError, ErrorResponse(ErrCodeInternalError, "Failed to create secret"))
 		return
 	}
@@ -181,15 +240,14 @@ func (s *Server) handleGetSecret(w http.ResponseWriter, r *http.Request) {
 	}
 
 	handle := session.Handle.(*vault.VaultHandle)
-	st := handle.Store()
-	if st == nil {
+	if !handle.IsUnlocked() {
 		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
 		return
 	}
 
 	name := r.PathValue("name")
 
-	secret, err := st.GetByName(r.Context(), name)
+	secret, err := handle.GetByName(r.Context(), name)
 	if err != nil {
 		if errors.Is(err, store.ErrNotFound) {
 			writeJSON(w, http.StatusNotFound, ErrorResponse(ErrCodeNotFound, "Secret not found"))
@@ -213,8 +271,7 @@ func (s *Server) handleUpdateSecret(w http.ResponseWriter, r *http.Request) {
 	}
 
 	handle := session.Handle.(*vault.VaultHandle)
-	st := handle.Store()
-	if st == nil {
+	if !handle.IsUnlocked() {
 		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
 		return
 	}
@@ -222,7 +279,7 @@ func (s *Server) handleUpdateSecret(w http.ResponseWriter, r *http.Request) {
 	name := r.PathValue("name")
 
 	// Get existing secret
-	secret, err := st.GetByName(r.Context(), name)
+	secret, err := handle.GetByName(r.Context(), name)
 	if err != nil {
 		if errors.Is(err, store.ErrNotFound) {
 			writeJSON(w, http.StatusNotFound, ErrorResponse(ErrCodeNotFound, "Secret not found"))
@@ -246,9 +303,18 @@ func (s *Server) handleUpdateSecret(w http.ResponseWriter, r *http.Request) {
 	if req.Notes != nil {
 		secret.Notes = *req.Notes
 	}
+	req.ApplyFields(secret)
+	if err := setLifecycle(secret, req.ExpiresAt, req.RotateEvery); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse(ErrCodeBadRequest, err.Error()))
+		return
+	}
 
 	// Update
-	if err := st.Update(r.Context(), secret); err != nil {
+	if err := handle.Update(r.Context(), secret); err != nil {
+		if errors.Is(err, model.ErrInvalidName) || errors.Is(err, model.ErrInvalidField) {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse(ErrCodeBadRequest, err.Error()))
+			return
+		}
 		writeJSON(w, http.StatusInternalServerError, ErrorResponse(ErrCodeInternalError, "Failed to update secret"))
 		return
 	}
@@ -257,6 +323,70 @@ func (s *Server) handleUpdateSecret(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, SuccessResponse(detail))
 }
 
+// setLifecycle applies the expiry and rotation period of a request to a
+// secret, leaving either as is when nil
+func setLifecycle(secret *model.SecretObject, expires, rotate *string) error {
+	if expires != nil {
+		t, err := parseExpiresAt(*expires)
+		if err != nil {
+			return fmt.Errorf("invalid expires_at: %q", *expires)
+		}
+		secret.ExpiresAt = t
+	}
+	if rotate != nil {
+		p, err := parseRotateEvery(*rotate)
+		if err != nil {
+			return fmt.Errorf("invalid rotate_every: %q", *rotate)
+		}
+		secret.RotateEvery = p
+	}
+	return nil
+}
+
+// handleRenameSecret gives a secret a new name
+func (s *Server) handleRenameSecret(w http.ResponseWriter, r *http.Request) {
+	session := getSessionFromContext(r.Context())
+	if session == nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Not authenticated"))
+		return
+	}
+
+	handle := session.Handle.(*vault.VaultHandle)
+	if !handle.IsUnlocked() {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
+		return
+	}
+
+	name := r.PathValue("name")
+
+	var req RenameSecretRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse(ErrCodeBadRequest, "Invalid JSON"))
+		return
+	}
+	if req.Name == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse(ErrCodeBadRequest, "Name is required"))
+		return
+	}
+
+	secret, err := handle.Rename(r.Context(), name, req.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			writeJSON(w, http.StatusNotFound, ErrorResponse(ErrCodeNotFound, "Secret not found"))
+		case errors.Is(err, store.ErrAlreadyExists):
+			writeJSON(w, http.StatusConflict, ErrorResponse(ErrCodeConflict, "Secret already exists"))
+		case errors.Is(err, model.ErrInvalidName):
+			writeJSON(w, http.StatusBadRequest, ErrorResponse(ErrCodeBadRequest, err.Error()))
+		default:
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse(ErrCodeInternalError, "Failed to rename secret"))
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SuccessResponse(ToSecretDetail(secret, true)))
+}
+
 // handleDeleteSecret deletes a secret
 func (s *Server) handleDeleteSecret(w http.ResponseWriter, r *http.Request) {
 	session := getSessionFromContext(r.Context())
@@ -266,15 +396,14 @@ func (s *Server) handleDeleteSecret(w http.ResponseWriter, r *http.Request) {
 	}
 
 	handle := session.Handle.(*vault.VaultHandle)
-	st := handle.Store()
-	if st == nil {
+	if !handle.IsUnlocked() {
 		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
 		return
 	}
 
 	name := r.PathValue("name")
 
-	if err := st.Delete(r.Context(), name); err != nil {
+	if err := handle.Delete(r.Context(), name); err != nil {
 		if errors.Is(err, store.ErrNotFound) {
 			writeJSON(w, http.StatusNotFound, ErrorResponse(ErrCodeNotFound, "Secret not found"))
 			return
@@ -286,6 +415,254 @@ func (s *Server) handleDeleteSecret(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// handleListVersions lists the versions of a secret, oldest first
+func (s *Server) handleListVersions(w http.ResponseWriter, r *http.Request) {
+	session := getSessionFromContext(r.Context())
+	if session == nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Not authenticated"))
+		return
+	}
+
+	handle := session.Handle.(*vault.VaultHandle)
+	if !handle.IsUnlocked() {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
+		return
+	}
+
+	versions, err := handle.History(r.Context(), r.PathValue("name"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSON(w, http.StatusNotFound, ErrorResponse(ErrCodeNotFound, "Secret not found"))
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse(ErrCodeInternalError, "Failed to get history"))
+		return
+	}
+
+	items := make([]VersionItem, len(versions))
+	for i, v := range versions {
+		items[i] = VersionItem{
+			Version:    v.Version,
+			UpdatedAt:  v.UpdatedAt,
+			ReplacedAt: v.ReplacedAt,
+			Current:    i == len(versions)-1,
+		}
+	}
+
+	writeJSON(w, http.StatusOK, SuccessResponse(items))
+}
+
+// handleGetVersion retrieves a version of a secret, redacted
+func (s *Server) handleGetVersion(w http.ResponseWriter, r *http.Request) {
+	session := getSessionFromContext(r.Context())
+	if session == nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Not authenticated"))
+		return
+	}
+
+	handle := session.Handle.(*vault.VaultHandle)
+	if !handle.IsUnlocked() {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
+		return
+	}
+
+	number, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil || number < 1 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse(ErrCodeBadRequest, "Invalid version"))
+		return
+	}
+
+	secret, err := handle.GetVersion(r.Context(), r.PathValue("name"), number)
+	if err != nil {
+		writeVersionError(w, err, "Failed to get version")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SuccessResponse(ToSecretDetail(secret, true)))
+}
+
+// handleRollback makes an earlier version of a secret current again
+func (s *Server) handleRollback(w http.ResponseWriter, r *http.Request) {
+	session := getSessionFromContext(r.Context())
+	if session == nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Not authenticated"))
+		return
+	}
+
+	handle := session.Handle.(*vault.VaultHandle)
+	if !handle.IsUnlocked() {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
+		return
+	}
+
+	number, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil || number < 1 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse(ErrCodeBadRequest, "Invalid version"))
+		return
+	}
+
+	secret, err := handle.Rollback(r.Context(), r.PathValue("name"), number)
+	if err != nil {
+		writeVersionError(w, err, "Failed to roll back secret")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SuccessResponse(ToSecretDetail(secret, true)))
+}
+
+// writeVersionError answers a failed version lookup
+func writeVersionError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeJSON(w, http.StatusNotFound, ErrorResponse(ErrCodeNotFound, "Secret not found"))
+	case errors.Is(err, store.ErrVersionNotFound):
+		writeJSON(w, http.StatusNotFound, ErrorResponse(ErrCodeNotFound, "Version not found"))
+	default:
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse(ErrCodeInternalError, message))
+	}
+}
+
+// handleListTrash lists the secrets in the trash, most recently deleted first
+func (s *Server) handleListTrash(w http.ResponseWriter, r *http.Request) {
+	session := getSessionFromContext(r.Context())
+	if session == nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Not authenticated"))
+		return
+	}
+
+	handle := session.Handle.(*vault.VaultHandle)
+	if !handle.IsUnlocked() {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
+		return
+	}
+
+	secrets, err := handle.Trash(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse(ErrCodeInternalError, "Failed to list trash"))
+		return
+	}
+
+	items := make([]SecretListItem, len(secrets))
+	for i, sec := range secrets {
+		items[i] = ToSecretListItem(sec)
+	}
+
+	writeJSON(w, http.StatusOK, SuccessResponse(items))
+}
+
+// handleRestoreSecret takes a secret out of the trash
+func (s *Server) handleRestoreSecret(w http.ResponseWriter, r *http.Request) {
+	session := getSessionFromContext(r.Context())
+	if session == nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Not authenticated"))
+		return
+	}
+
+	handle := session.Handle.(*vault.VaultHandle)
+	if !handle.IsUnlocked() {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
+		return
+	}
+
+	name := r.PathValue("name")
+
+	if err := handle.Restore(r.Context(), name); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			writeJSON(w, http.StatusNotFound, ErrorResponse(ErrCodeNotFound, "Secret not found in trash"))
+		case errors.Is(err, store.ErrAlreadyExists):
+			writeJSON(w, http.StatusConflict, ErrorResponse(ErrCodeConflict, "Secret already exists"))
+		default:
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse(ErrCodeInternalError, "Failed to restore secret"))
+		}
+		return
+	}
+
+	secret, err := handle.GetByName(r.Context(), name)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse(ErrCodeInternalError, "Failed to get secret"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SuccessResponse(ToSecretDetail(secret, true)))
+}
+
+// handlePurgeTrash permanently removes secrets from the trash, only those
+// deleted at least ?older_than= ago if given
+func (s *Server) handlePurgeTrash(w http.ResponseWriter, r *http.Request) {
+	session := getSessionFromContext(r.Context())
+	if session == nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Not authenticated"))
+		return
+	}
+
+	handle := session.Handle.(*vault.VaultHandle)
+	if !handle.IsUnlocked() {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
+		return
+	}
+
+	var olderThan time.Duration
+	if value := r.URL.Query().Get("older_than"); value != "" {
+		var err error
+		olderThan, err = config.ParseDuration(value)
+		if err != nil || olderThan < 0 {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse(ErrCodeBadRequest, "Invalid older_than"))
+			return
+		}
+	}
+
+	purged, err := handle.Purge(r.Context(), olderThan)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse(ErrCodeInternalError, "Failed to purge trash"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SuccessResponse(PurgeResponse{Purged: purged}))
+}
+
+// handleExpiring lists the secrets that have expired or are due for
+// rotation, or will be within ?within= (default 30d), soonest first
+func (s *Server) handleExpiring(w http.ResponseWriter, r *http.Request) {
+	session := getSessionFromContext(r.Context())
+	if session == nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Not authenticated"))
+		return
+	}
+
+	handle := session.Handle.(*vault.VaultHandle)
+	if !handle.IsUnlocked() {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
+		return
+	}
+
+	within := 30 * 24 * time.Hour
+	if value := r.URL.Query().Get("within"); value != "" {
+		var err error
+		within, err = config.ParseDuration(value)
+		if err != nil || within < 0 {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse(ErrCodeBadRequest, "Invalid within"))
+			return
+		}
+	}
+
+	secrets, err := handle.Expiring(r.Context(), within)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse(ErrCodeInternalError, "Failed to list expiring secrets"))
+		return
+	}
+
+	now := time.Now()
+	items := make([]ExpiringItem, 0, len(secrets))
+	for _, sec := range secrets {
+		if status, due, ok := sec.Due(now, now.Add(within)); ok {
+			items = append(items, ExpiringItem{Name: sec.Name, Status: status, Due: due, Lifecycle: ToLifecycle(sec)})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, SuccessResponse(items))
+}
+
 // handleSearch searches for secrets
 func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	session := getSessionFromContext(r.Context())
@@ -295,8 +672,7 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	handle := session.Handle.(*vault.VaultHandle)
-	st := handle.Store()
-	if st == nil {
+	if !handle.IsUnlocked() {
 		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
 		return
 	}
@@ -308,7 +684,7 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Search
-	results, err := st.Search(r.Context(), query, nil)
+	results, err := handle.Search(r.Context(), query, nil)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, ErrorResponse(ErrCodeInternalError, "Search failed"))
 		return
@@ -332,8 +708,7 @@ func (s *Server) handleClipboard(w http.ResponseWriter, r *http.Request) {
 	}
 
 	handle := session.Handle.(*vault.VaultHandle)
-	st := handle.Store()
-	if st == nil {
+	if !handle.IsUnlocked() {
 		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
 		return
 	}
@@ -341,7 +716,7 @@ func (s *Server) handleClipboard(w http.ResponseWriter, r *http.Request) {
 	name := r.PathValue("name")
 
 	// Get secret
-	secret, err := st.GetByName(r.Context(), name)
+	secret, err := handle.GetByName(r.Context(), name)
 	if err != nil {
 		if errors.Is(err, store.ErrNotFound) {
 			writeJSON(w, http.StatusNotFound, ErrorResponse(ErrCodeNotFound, "Secret not found"))
@@ -380,3 +755,182 @@ func (s *Server) handleClipboard(w http.ResponseWriter, r *http.Request) {
 	// For now, we acknowledge the request succeeded
 	writeJSON(w, http.StatusOK, SuccessResponse(nil))
 }
+
+// handleTOTP returns the current code of a secret's TOTP field, selected
+// by ?field= or else the first one; the seed is never returned
+func (s *Server) handleTOTP(w http.ResponseWriter, r *http.Request) {
+	session := getSessionFromContext(r.Context())
+	if session == nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Not authenticated"))
+		return
+	}
+
+	handle := session.Handle.(*vault.VaultHandle)
+	if !handle.IsUnlocked() {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
+		return
+	}
+
+	name := r.PathValue("name")
+	now := time.Now()
+
+	code, err := handle.TOTP(r.Context(), name, r.URL.Query().Get("field"), now)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			writeJSON(w, http.StatusNotFound, ErrorResponse(ErrCodeNotFound, "Secret not found"))
+		case errors.Is(err, vault.ErrFieldNotFound):
+			writeJSON(w, http.StatusNotFound, ErrorResponse(ErrCodeNotFound, "TOTP field not found"))
+		default:
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse(ErrCodeInternalError, "Failed to generate code"))
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SuccessResponse(TOTPResponse{
+		Code:             code.Code,
+		ExpiresAt:        code.ExpiresAt,
+		RemainingSeconds: int(code.ExpiresAt.Sub(now) / time.Second),
+	}))
+}
+
+// handleListAttachments lists the files attached to a secret, ordered by name
+func (s *Server) handleListAttachments(w http.ResponseWriter, r *http.Request) {
+	session := getSessionFromContext(r.Context())
+	if session == nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Not authenticated"))
+		return
+	}
+
+	handle := session.Handle.(*vault.VaultHandle)
+	if !handle.IsUnlocked() {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
+		return
+	}
+
+	attachments, err := handle.Attachments(r.Context(), r.PathValue("name"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSON(w, http.StatusNotFound, ErrorResponse(ErrCodeNotFound, "Secret not found"))
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse(ErrCodeInternalError, "Failed to list attachments"))
+		return
+	}
+
+	if attachments == nil {
+		attachments = []*model.Attachment{}
+	}
+	writeJSON(w, http.StatusOK, SuccessResponse(attachments))
+}
+
+// handleUploadAttachment stores the raw request body as an attachment,
+// encrypting it as it streams in
+func (s *Server) handleUploadAttachment(w http.ResponseWriter, r *http.Request) {
+	session := getSessionFromContext(r.Context())
+	if session == nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Not authenticated"))
+		return
+	}
+
+	handle := session.Handle.(*vault.VaultHandle)
+	if !handle.IsUnlocked() {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
+		return
+	}
+
+	if r.ContentLength > vault.MaxAttachmentSize {
+		writeJSON(w, http.StatusRequestEntityTooLarge, ErrorResponse(ErrCodePayloadTooLarge, vault.ErrAttachmentTooLarge.Error()))
+		return
+	}
+	body := http.MaxBytesReader(w, r.Body, vault.MaxAttachmentSize)
+
+	attachment, err := handle.Attach(r.Context(), r.PathValue("name"), r.PathValue("file"), body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			writeJSON(w, http.StatusNotFound, ErrorResponse(ErrCodeNotFound, "Secret not found"))
+		case errors.Is(err, vault.ErrAttachmentExists):
+			writeJSON(w, http.StatusConflict, ErrorResponse(ErrCodeConflict, "Attachment already exists"))
+		case errors.Is(err, model.ErrInvalidAttachmentName):
+			writeJSON(w, http.StatusBadRequest, ErrorResponse(ErrCodeBadRequest, err.Error()))
+		case errors.Is(err, vault.ErrAttachmentTooLarge), errors.As(err, &tooLarge):
+			writeJSON(w, http.StatusRequestEntityTooLarge, ErrorResponse(ErrCodePayloadTooLarge, vault.ErrAttachmentTooLarge.Error()))
+		default:
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse(ErrCodeInternalError, "Failed to store attachment"))
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, SuccessResponse(attachment))
+}
+
+// handleDownloadAttachment streams the decrypted content of an attachment.
+// A chunk found corrupted partway cuts the response short of its
+// Content-Length, since the status has been sent by then.
+func (s *Server) handleDownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	session := getSessionFromContext(r.Context())
+	if session == nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Not authenticated"))
+		return
+	}
+
+	handle := session.Handle.(*vault.VaultHandle)
+	if !handle.IsUnlocked() {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
+		return
+	}
+
+	name, file := r.PathValue("name"), r.PathValue("file")
+
+	attachment, err := handle.Attachment(r.Context(), name, file)
+	if err != nil {
+		writeAttachmentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := handle.Extract(r.Context(), name, file, w); err != nil {
+		log.Printf("failed to extract attachment: %v", err)
+	}
+}
+
+// handleDeleteAttachment removes an attachment from a secret
+func (s *Server) handleDeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	session := getSessionFromContext(r.Context())
+	if session == nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Not authenticated"))
+		return
+	}
+
+	handle := session.Handle.(*vault.VaultHandle)
+	if !handle.IsUnlocked() {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Vault locked"))
+		return
+	}
+
+	if err := handle.Detach(r.Context(), r.PathValue("name"), r.PathValue("file")); err != nil {
+		writeAttachmentError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeAttachmentError responds to a failed lookup of an attachment
+func writeAttachmentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeJSON(w, http.StatusNotFound, ErrorResponse(ErrCodeNotFound, "Secret not found"))
+	case errors.Is(err, vault.ErrAttachmentNotFound):
+		writeJSON(w, http.StatusNotFound, ErrorResponse(ErrCodeNotFound, "Attachment not found"))
+	default:
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse(ErrCodeInternalError, "Failed to get attachment"))
+	}
+}
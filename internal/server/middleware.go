@@ -35,6 +35,12 @@ func (s *Server) withAuth(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		// Each authenticated request slides the idle timeout
+		if err := s.sessions.Refresh(token, s.sessionTimeout); err != nil {
+			writeJSON(w, http.StatusUnauthorized, ErrorResponse(ErrCodeUnauthorized, "Session expired"))
+			return
+		}
+
 		// Add session to context
 		ctx := context.WithValue(r.Context(), sessionKey, session)
 		next.ServeHTTP(w, r.WithContext(ctx))
@@ -7,6 +7,17 @@ import (
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/TheEditor/keyp/internal/config"
+)
+
+// Connection timeouts, so a slow or stalled client cannot keep a request,
+// and the vault handle it uses, open indefinitely. transferTimeout leaves
+// room for the largest attachment on a slow link.
+const (
+	readHeaderTimeout = 10 * time.Second
+	transferTimeout   = 2 * time.Minute
+	idleTimeout       = time.Minute
 )
 
 // Server represents the HTTP API server
@@ -16,25 +27,35 @@ type Server struct {
 	address        string
 	vaultPath      string
 	sessions       SessionStore
-	sessionTimeout time.Duration
+	sessionTimeout time.Duration // Idle timeout, slid by each request
+	maxLifetime    time.Duration // Limit from unlock, however active the session
 }
 
 // NewServer creates a new server instance
 func NewServer(address, vaultPath string) *Server {
-	return &Server{
+	s := &Server{
 		address:        address,
 		vaultPath:      vaultPath,
 		mux:            http.NewServeMux(),
 		sessions:       NewSessionStore(),
-		sessionTimeout: 15 * time.Minute, // Default 15 min
+		sessionTimeout: config.DefaultSessionTimeout,
+		maxLifetime:    config.DefaultSessionMaxLifetime,
 	}
+	s.setupRoutes()
+	return s
 }
 
-// SetSessionTimeout sets the session expiry duration
+// SetSessionTimeout sets how long a session may go unused before it expires
 func (s *Server) SetSessionTimeout(timeout time.Duration) {
 	s.sessionTimeout = timeout
 }
 
+// SetSessionMaxLifetime sets how long a session lasts however often it is
+// used; 0 means no limit
+func (s *Server) SetSessionMaxLifetime(maxLifetime time.Duration) {
+	s.maxLifetime = maxLifetime
+}
+
 // Handler returns the HTTP handler for the server
 func (s *Server) Handler() http.Handler {
 	return s.mux
@@ -42,11 +63,13 @@ func (s *Server) Handler() http.Handler {
 
 // Start begins listening for HTTP requests
 func (s *Server) Start() error {
-	s.setupRoutes()
-
 	s.httpServer = &http.Server{
-		Addr:    s.address,
-		Handler: s.mux,
+		Addr:              s.address,
+		Handler:           s.mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       transferTimeout,
+		WriteTimeout:      transferTimeout,
+		IdleTimeout:       idleTimeout,
 	}
 
 	log.Printf("Starting server on %s", s.address)
@@ -113,11 +136,32 @@ func (s *Server) setupRoutes() {
 	s.mux.HandleFunc("POST /v1/secrets", s.withAuth(s.handleCreateSecret))
 	s.mux.HandleFunc("GET /v1/secrets/{name}", s.withAuth(s.handleGetSecret))
 	s.mux.HandleFunc("PUT /v1/secrets/{name}", s.withAuth(s.handleUpdateSecret))
+	s.mux.HandleFunc("PATCH /v1/secrets/{name}", s.withAuth(s.handleRenameSecret))
 	s.mux.HandleFunc("DELETE /v1/secrets/{name}", s.withAuth(s.handleDeleteSecret))
 
+	// Version routes (protected)
+	s.mux.HandleFunc("GET /v1/secrets/{name}/versions", s.withAuth(s.handleListVersions))
+	s.mux.HandleFunc("GET /v1/secrets/{name}/versions/{version}", s.withAuth(s.handleGetVersion))
+	s.mux.HandleFunc("POST /v1/secrets/{name}/versions/{version}/rollback", s.withAuth(s.handleRollback))
+
+	// Trash routes (protected)
+	s.mux.HandleFunc("GET /v1/trash", s.withAuth(s.handleListTrash))
+	s.mux.HandleFunc("POST /v1/trash/{name}/restore", s.withAuth(s.handleRestoreSecret))
+	s.mux.HandleFunc("DELETE /v1/trash", s.withAuth(s.handlePurgeTrash))
+
 	// Search route (protected)
 	s.mux.HandleFunc("GET /v1/search", s.withAuth(s.handleSearch))
 
+	// Expiry route (protected)
+	s.mux.HandleFunc("GET /v1/expiring", s.withAuth(s.handleExpiring))
+
 	// Clipboard route (protected)
 	s.mux.HandleFunc("POST /v1/secrets/{name}/clipboard", s.withAuth(s.handleClipboard))
+	s.mux.HandleFunc("GET /v1/secrets/{name}/totp", s.withAuth(s.handleTOTP))
+
+	// Attachment routes (protected); contents stream as raw bytes
+	s.mux.HandleFunc("GET /v1/secrets/{name}/attachments", s.withAuth(s.handleListAttachments))
+	s.mux.HandleFunc("PUT /v1/secrets/{name}/attachments/{file}", s.withAuth(s.handleUploadAttachment))
+	s.mux.HandleFunc("GET /v1/secrets/{name}/attachments/{file}", s.withAuth(s.handleDownloadAttachment))
+	s.mux.HandleFunc("DELETE /v1/secrets/{name}/attachments/{file}", s.withAuth(s.handleDeleteAttachment))
 }
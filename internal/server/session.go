@@ -14,7 +14,7 @@ const TokenBytes = 32 // 256 bits
 
 // SessionStore interface for session management
 type SessionStore interface {
-	Create(handle *vault.VaultHandle, expiry time.Duration) (*Session, error)
+	Create(handle *vault.VaultHandle, expiry, maxLifetime time.Duration) (*Session, error)
 	Get(token string) (*Session, error)
 	Delete(token string) error
 	Refresh(token string, expiry time.Duration) error
@@ -59,19 +59,25 @@ func generateToken() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
-// Create creates a new session
-func (m *MemorySessionStore) Create(handle *vault.VaultHandle, expiry time.Duration) (*Session, error) {
+// Create creates a new session that expires after expiry unless refreshed,
+// and after maxLifetime however often it is refreshed. A maxLifetime of 0
+// means no limit.
+func (m *MemorySessionStore) Create(handle *vault.VaultHandle, expiry, maxLifetime time.Duration) (*Session, error) {
 	token, err := generateToken()
 	if err != nil {
 		return nil, err
 	}
 
+	now := time.Now()
 	session := &Session{
 		Token:     token,
 		Handle:    handle,
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(expiry),
+		CreatedAt: now,
+	}
+	if maxLifetime > 0 {
+		session.Deadline = now.Add(maxLifetime)
 	}
+	session.ExpiresAt = session.capExpiry(now.Add(expiry))
 
 	m.mu.Lock()
 	m.sessions[token] = session
@@ -80,7 +86,7 @@ func (m *MemorySessionStore) Create(handle *vault.VaultHandle, expiry time.Durat
 	return session, nil
 }
 
-// Get retrieves a session by token
+// Get retrieves a copy of a session by token
 func (m *MemorySessionStore) Get(token string) (*Session, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -95,29 +101,29 @@ func (m *MemorySessionStore) Get(token string) (*Session, error) {
 		return nil, fmt.Errorf("session expired")
 	}
 
-	return session, nil
+	// Return a copy, as Refresh may update the session concurrently
+	snapshot := *session
+	return &snapshot, nil
 }
 
 // Delete removes a session
 func (m *MemorySessionStore) Delete(token string) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	session, ok := m.sessions[token]
 	if !ok {
+		m.mu.Unlock()
 		return nil // Already deleted or never existed
 	}
+	delete(m.sessions, token)
+	m.mu.Unlock()
 
 	// Lock the vault handle
-	if handle, ok := session.Handle.(*vault.VaultHandle); ok {
-		handle.Lock()
-	}
-
-	delete(m.sessions, token)
+	lockHandles([]*Session{session})
 	return nil
 }
 
-// Refresh extends a session's expiry
+// Refresh extends a session's expiry to expiry from now, but not past its
+// deadline. An expired session cannot be refreshed.
 func (m *MemorySessionStore) Refresh(token string, expiry time.Duration) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -127,33 +133,54 @@ func (m *MemorySessionStore) Refresh(token string, expiry time.Duration) error {
 		return fmt.Errorf("session not found")
 	}
 
-	session.ExpiresAt = time.Now().Add(expiry)
+	now := time.Now()
+	if now.After(session.ExpiresAt) {
+		return fmt.Errorf("session expired")
+	}
+	session.ExpiresAt = session.capExpiry(now.Add(expiry))
 	return nil
 }
 
+// capExpiry returns expiresAt, or the session deadline if that is earlier
+func (s *Session) capExpiry(expiresAt time.Time) time.Time {
+	if !s.Deadline.IsZero() && s.Deadline.Before(expiresAt) {
+		return s.Deadline
+	}
+	return expiresAt
+}
+
 // Cleanup removes expired sessions
 func (m *MemorySessionStore) Cleanup() {
+	var expired []*Session
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	now := time.Now()
 	for token, session := range m.sessions {
 		if now.After(session.ExpiresAt) {
-			// Lock the vault handle before deleting
-			if handle, ok := session.Handle.(*vault.VaultHandle); ok {
-				handle.Lock()
-			}
+			expired = append(expired, session)
 			delete(m.sessions, token)
 		}
 	}
+	m.mu.Unlock()
+
+	lockHandles(expired)
 }
 
 // LockAll locks all active sessions' vault handles
 func (m *MemorySessionStore) LockAll() {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
+	m.mu.RLock()
+	sessions := make([]*Session, 0, len(m.sessions))
 	for _, session := range m.sessions {
+		sessions = append(sessions, session)
+	}
+	m.mu.RUnlock()
+
+	lockHandles(sessions)
+}
+
+// lockHandles locks the vault handles of sessions. It is called without
+// holding the store lock, as Lock waits for requests using the handle.
+func lockHandles(sessions []*Session) {
+	for _, session := range sessions {
 		if handle, ok := session.Handle.(*vault.VaultHandle); ok {
 			handle.Lock()
 		}
@@ -2,8 +2,10 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 
+	"github.com/TheEditor/keyp/internal/config"
 	"github.com/TheEditor/keyp/internal/model"
 )
 
@@ -24,8 +26,10 @@ type ErrorDetail struct {
 const (
 	ErrCodeBadRequest      = "bad_request"
 	ErrCodeUnauthorized    = "unauthorized"
+	ErrCodeKeyFileRequired = "keyfile_required"
 	ErrCodeNotFound        = "not_found"
 	ErrCodeConflict        = "conflict"
+	ErrCodePayloadTooLarge = "payload_too_large"
 	ErrCodeInternalError   = "internal_error"
 )
 
@@ -54,23 +58,27 @@ type Session struct {
 	Token     string
 	Handle    interface{} // *vault.VaultHandle
 	CreatedAt time.Time
-	ExpiresAt time.Time
+	ExpiresAt time.Time // Slid forward by each authenticated request
+	Deadline  time.Time // Hard limit on ExpiresAt, zero for none
 }
 
 // UnlockRequest for POST /v1/unlock
 type UnlockRequest struct {
 	Password string `json:"password"`
+	KeyFile  []byte `json:"keyfile,omitempty"` // Key file content, base64 in JSON
 }
 
 // UnlockResponse for successful unlock
 type UnlockResponse struct {
 	Token     string    `json:"token"`
 	ExpiresAt time.Time `json:"expires_at"`
+	Deadline  time.Time `json:"deadline,omitzero"` // No refresh extends the session past this
 }
 
 // RefreshResponse for POST /v1/refresh
 type RefreshResponse struct {
 	ExpiresAt time.Time `json:"expires_at"`
+	Deadline  time.Time `json:"deadline,omitzero"` // No refresh extends the session past this
 }
 
 // SecretListItem for list responses (minimal info)
@@ -79,6 +87,39 @@ type SecretListItem struct {
 	Tags      []string  `json:"tags"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	DeletedAt time.Time `json:"deleted_at,omitzero"` // Only for secrets in the trash
+	Lifecycle
+}
+
+// Lifecycle is when a secret expires and should next be rotated, each
+// left out if not set
+type Lifecycle struct {
+	ExpiresAt   time.Time    `json:"expires_at,omitzero"`
+	RotateEvery model.Period `json:"rotate_every,omitzero"`
+	RotatedAt   time.Time    `json:"rotated_at,omitzero"`
+	RotationDue time.Time    `json:"rotation_due,omitzero"`
+}
+
+// ExpiringItem for GET /v1/expiring
+type ExpiringItem struct {
+	Name   string    `json:"name"`
+	Status string    `json:"status"` // expired, rotation overdue, expiring or rotation due
+	Due    time.Time `json:"due"`
+	Lifecycle
+}
+
+// FolderItem is a subfolder in a FolderListing
+type FolderItem struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Secrets int    `json:"secrets"` // Secrets anywhere inside it
+}
+
+// FolderListing for GET /v1/secrets?prefix= (direct children only)
+type FolderListing struct {
+	Prefix  string           `json:"prefix"`
+	Folders []FolderItem     `json:"folders"`
+	Secrets []SecretListItem `json:"secrets"`
 }
 
 // SecretDetail for get responses (full info, redacted by default)
@@ -90,6 +131,20 @@ type SecretDetail struct {
 	Notes     string     `json:"notes"`
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
+	Lifecycle
+}
+
+// VersionItem for GET /v1/secrets/{name}/versions
+type VersionItem struct {
+	Version    int       `json:"version"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	ReplacedAt time.Time `json:"replaced_at,omitzero"`
+	Current    bool      `json:"current"`
+}
+
+// PurgeResponse for DELETE /v1/trash
+type PurgeResponse struct {
+	Purged int `json:"purged"`
 }
 
 // Field in secret response
@@ -114,6 +169,11 @@ type CreateSecretRequest struct {
 	Tags   []string     `json:"tags,omitempty"`
 	Fields []FieldInput `json:"fields"`
 	Notes  string       `json:"notes,omitempty"`
+
+	// ExpiresAt is an RFC 3339 time or a date such as 2027-01-01, and
+	// RotateEvery a period such as 90d
+	ExpiresAt   string `json:"expires_at,omitempty"`
+	RotateEvery string `json:"rotate_every,omitempty"`
 }
 
 // UpdateSecretRequest for PUT /v1/secrets/:name (partial updates)
@@ -121,6 +181,15 @@ type UpdateSecretRequest struct {
 	Tags   *[]string    `json:"tags,omitempty"`
 	Fields *[]FieldInput `json:"fields,omitempty"`
 	Notes  *string      `json:"notes,omitempty"`
+
+	// As in CreateSecretRequest; an empty string clears either
+	ExpiresAt   *string `json:"expires_at,omitempty"`
+	RotateEvery *string `json:"rotate_every,omitempty"`
+}
+
+// RenameSecretRequest for PATCH /v1/secrets/:name
+type RenameSecretRequest struct {
+	Name string `json:"name"`
 }
 
 // ToSecretListItem converts model to API type
@@ -130,7 +199,47 @@ func ToSecretListItem(s *model.SecretObject) SecretListItem {
 		Tags:      s.Tags,
 		CreatedAt: s.CreatedAt,
 		UpdatedAt: s.UpdatedAt,
+		DeletedAt: s.DeletedAt,
+		Lifecycle: ToLifecycle(s),
+	}
+}
+
+// ToLifecycle returns the expiry and rotation of a secret
+func ToLifecycle(s *model.SecretObject) Lifecycle {
+	return Lifecycle{
+		ExpiresAt:   s.ExpiresAt,
+		RotateEvery: s.RotateEvery,
+		RotatedAt:   s.RotatedAt,
+		RotationDue: s.RotationDue(),
+	}
+}
+
+// parseExpiresAt parses the expiry of a request: an RFC 3339 time, or a
+// date taken as local midnight. An empty string is no expiry.
+func parseExpiresAt(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
 	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseRotateEvery parses the rotation period of a request, such as 90d.
+// An empty string is no rotation.
+func parseRotateEvery(value string) (model.Period, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := config.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return model.Period(d), nil
 }
 
 // ToSecretDetail converts model to API type with optional redaction
@@ -157,6 +266,7 @@ func ToSecretDetail(s *model.SecretObject, redact bool) SecretDetail {
 		Notes:     s.Notes,
 		CreatedAt: s.CreatedAt,
 		UpdatedAt: s.UpdatedAt,
+		Lifecycle: ToLifecycle(s),
 	}
 }
 
@@ -167,23 +277,56 @@ func (r *CreateSecretRequest) ToSecretObject() *model.SecretObject {
 	secret.Notes = r.Notes
 
 	for _, f := range r.Fields {
-		field := model.Field{
-			ID:        model.NewField(f.Label, f.Value).ID,
-			Label:     f.Label,
-			Value:     f.Value,
-			Sensitive: f.Sensitive,
-			Type:      f.Type,
-			SortOrder: len(secret.Fields),
-		}
-		if field.Type == "" {
-			field.Type = model.FieldTypeText
-		}
-		secret.Fields = append(secret.Fields, field)
+		secret.Fields = append(secret.Fields, f.toField(model.NewField(f.Label, f.Value).ID, len(secret.Fields)))
 	}
 
 	return secret
 }
 
+// ApplyFields replaces the fields of a secret with those of the request,
+// if it has any, keeping the ID of each field whose label is unchanged
+func (r *UpdateSecretRequest) ApplyFields(secret *model.SecretObject) {
+	if r.Fields == nil {
+		return
+	}
+	ids := make(map[string]string, len(secret.Fields))
+	for _, f := range secret.Fields {
+		ids[f.Label] = f.ID
+	}
+	fields := make([]model.Field, 0, len(*r.Fields))
+	for _, f := range *r.Fields {
+		id, ok := ids[f.Label]
+		if !ok {
+			id = model.NewField(f.Label, f.Value).ID
+		}
+		fields = append(fields, f.toField(id, len(fields)))
+	}
+	secret.Fields = fields
+}
+
+// toField converts a field of a request, defaulting to a text field
+func (f FieldInput) toField(id string, order int) model.Field {
+	field := model.Field{
+		ID:        id,
+		Label:     f.Label,
+		Value:     f.Value,
+		Sensitive: f.Sensitive,
+		Type:      f.Type,
+		SortOrder: order,
+	}
+	if field.Type == "" {
+		field.Type = model.FieldTypeText
+	}
+	return field
+}
+
+// TOTPResponse for GET /v1/secrets/:name/totp
+type TOTPResponse struct {
+	Code             string    `json:"code"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RemainingSeconds int       `json:"remaining_seconds"`
+}
+
 // HealthResponse for GET /health
 type HealthResponse struct {
 	Status string `json:"status"`
@@ -1,65 +1,151 @@
 package session
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/TheEditor/keyp/internal/core"
 )
 
 const (
 	// DefaultTimeout is the default session timeout (15 minutes)
 	DefaultTimeout = 15 * time.Minute
-	// SessionFileName is the name of the session file
-	SessionFileName = "session"
+	// SessionDirName is the directory holding one session file per vault
+	SessionDirName = "sessions"
+	// legacySessionFileName is the single session file of older versions
+	legacySessionFileName = "session"
 )
 
-// Manager handles session persistence
+// Manager handles session persistence. A session expires once it has gone
+// unused for the idle timeout, or has existed for the maximum lifetime,
+// whichever comes first.
 type Manager struct {
-	sessionDir string
-	timeout    time.Duration
+	sessionDir  string
+	timeout     time.Duration // Idle timeout, slid by Refresh
+	maxLifetime time.Duration // Limit from Save, 0 for none
 }
 
-// New creates a new session manager
-func New(timeout time.Duration) *Manager {
+// New creates a new session manager with the given idle timeout and
+// maximum lifetime
+func New(timeout, maxLifetime time.Duration) *Manager {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		homeDir = "."
 	}
 	sessionDir := filepath.Join(homeDir, ".keyp")
 	return &Manager{
-		sessionDir: sessionDir,
-		timeout:    timeout,
+		sessionDir:  sessionDir,
+		timeout:     timeout,
+		maxLifetime: maxLifetime,
+	}
+}
+
+// sessionPath returns the session file of a vault
+func (m *Manager) sessionPath(vaultID string) (string, error) {
+	if vaultID == "" || vaultID != filepath.Base(vaultID) || strings.HasPrefix(vaultID, ".") {
+		return "", fmt.Errorf("invalid vault ID %q", vaultID)
 	}
+	return filepath.Join(m.sessionDir, SessionDirName, vaultID), nil
 }
 
-// Save writes the derived key and expiry to the session file
-func (m *Manager) Save(derivedKey []byte) error {
+// Save writes the derived key and expiry to the session file of a vault
+func (m *Manager) Save(vaultID string, derivedKey *core.SecretBuffer) error {
+	sessionPath, err := m.sessionPath(vaultID)
+	if err != nil {
+		return err
+	}
+
 	// Ensure session directory exists
-	if err := os.MkdirAll(m.sessionDir, 0700); err != nil {
+	if err := os.MkdirAll(filepath.Dir(sessionPath), 0700); err != nil {
 		return fmt.Errorf("failed to create session directory: %w", err)
 	}
 
-	sessionPath := filepath.Join(m.sessionDir, SessionFileName)
+	// Encode the derived key in hex into a scratch buffer that is wiped
+	// once written
+	key := derivedKey.Bytes()
+	keyHex := make([]byte, hex.EncodedLen(len(key)))
+	defer core.Wipe(keyHex)
+	hex.Encode(keyHex, key)
+
+	now := time.Now()
+	var deadline time.Time
+	if m.maxLifetime > 0 {
+		deadline = now.Add(m.maxLifetime)
+	}
+	if err := writeSessionFile(sessionPath, keyHex, capExpiry(now.Add(m.timeout), deadline), deadline); err != nil {
+		return err
+	}
 
-	// Create the session file with the derived key in hex and expiry timestamp
-	keyHex := hex.EncodeToString(derivedKey)
-	expiry := time.Now().Add(m.timeout)
-	content := fmt.Sprintf("%s\n%d", keyHex, expiry.Unix())
+	// The single session file of older versions is no longer read
+	os.Remove(filepath.Join(m.sessionDir, legacySessionFileName))
 
-	// Write with restricted permissions (0600)
-	if err := os.WriteFile(sessionPath, []byte(content), 0600); err != nil {
-		return fmt.Errorf("failed to write session file: %w", err)
+	return nil
+}
+
+// Load reads the session file of a vault and returns the derived key and
+// when the session expires unless refreshed, if valid and not expired. The
+// caller must Destroy the key when done with it.
+func (m *Manager) Load(vaultID string) (*core.SecretBuffer, time.Time, error) {
+	f, err := m.read(vaultID)
+	if err != nil {
+		return nil, time.Time{}, err
 	}
+	defer core.Wipe(f.data)
 
-	return nil
+	// Decode the hex key straight into locked memory
+	derivedKey, err := core.NewSecretBuffer(hex.DecodedLen(len(f.keyHex)))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if _, err := hex.Decode(derivedKey.Bytes(), f.keyHex); err != nil {
+		derivedKey.Destroy()
+		return nil, time.Time{}, fmt.Errorf("failed to decode session key: %w", err)
+	}
+
+	return derivedKey, f.expiresAt, nil
+}
+
+// Refresh slides the idle timeout of a vault's session from now, without
+// extending it past its maximum lifetime, and returns when it now expires
+func (m *Manager) Refresh(vaultID string) (time.Time, error) {
+	f, err := m.read(vaultID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer core.Wipe(f.data)
+
+	// Session files hold whole seconds
+	expiresAt := time.Unix(capExpiry(time.Now().Add(m.timeout), f.deadline).Unix(), 0)
+	if err := writeSessionFile(f.path, f.keyHex, expiresAt, f.deadline); err != nil {
+		return time.Time{}, err
+	}
+	return expiresAt, nil
 }
 
-// Load reads the session file and returns the derived key if valid and not expired
-func (m *Manager) Load() ([]byte, error) {
-	sessionPath := filepath.Join(m.sessionDir, SessionFileName)
+// sessionFile is a parsed session file
+type sessionFile struct {
+	path      string
+	data      []byte    // Raw content; the caller must wipe it
+	keyHex    []byte    // Hex derived key, within data
+	expiresAt time.Time // Idle expiry
+	deadline  time.Time // Hard expiry, zero for none
+}
+
+// read reads and parses the session file of a vault, failing if the
+// session has expired. The file holds the hex key, the idle expiry and
+// the hard expiry as Unix times, one per line; files written by older
+// versions have no hard expiry and cannot be refreshed.
+func (m *Manager) read(vaultID string) (*sessionFile, error) {
+	sessionPath, err := m.sessionPath(vaultID)
+	if err != nil {
+		return nil, err
+	}
 
 	// Check if session file exists
 	data, err := os.ReadFile(sessionPath)
@@ -71,39 +157,92 @@ func (m *Manager) Load() ([]byte, error) {
 	}
 
 	// Parse the session file
-	lines := strings.Split(string(data), "\n")
+	lines := bytes.Split(data, []byte("\n"))
 	if len(lines) < 2 {
+		core.Wipe(data)
 		return nil, fmt.Errorf("invalid session file format")
 	}
 
-	keyHex := strings.TrimSpace(lines[0])
-	expiryStr := strings.TrimSpace(lines[1])
-
-	// Decode the hex key
-	derivedKey, err := hex.DecodeString(keyHex)
+	expiry, err := strconv.ParseInt(string(bytes.TrimSpace(lines[1])), 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode session key: %w", err)
+		core.Wipe(data)
+		return nil, fmt.Errorf("failed to parse session expiry: %w", err)
+	}
+	deadline := expiry
+	if len(lines) > 2 {
+		if deadline, err = strconv.ParseInt(string(bytes.TrimSpace(lines[2])), 10, 64); err != nil {
+			core.Wipe(data)
+			return nil, fmt.Errorf("failed to parse session deadline: %w", err)
+		}
 	}
 
-	// Parse the expiry timestamp
-	var expiry int64
-	_, err = fmt.Sscanf(expiryStr, "%d", &expiry)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse session expiry: %w", err)
+	f := &sessionFile{
+		path:      sessionPath,
+		data:      data,
+		keyHex:    bytes.TrimSpace(lines[0]),
+		expiresAt: time.Unix(expiry, 0),
+	}
+	if deadline != 0 {
+		f.deadline = time.Unix(deadline, 0)
 	}
 
 	// Check if session has expired
-	if time.Now().Unix() > expiry {
+	if time.Now().After(f.expiresAt) {
+		core.Wipe(data)
 		return nil, fmt.Errorf("session expired")
 	}
 
-	return derivedKey, nil
+	return f, nil
 }
 
-// Clear deletes the session file
-func (m *Manager) Clear() error {
-	sessionPath := filepath.Join(m.sessionDir, SessionFileName)
-	err := os.Remove(sessionPath)
+// writeSessionFile writes a session file with restricted permissions (0600)
+func writeSessionFile(path string, keyHex []byte, expiresAt, deadline time.Time) error {
+	content := make([]byte, 0, len(keyHex)+48)
+	content = append(content, keyHex...)
+	defer core.Wipe(content)
+	content = append(content, '\n')
+	content = strconv.AppendInt(content, expiresAt.Unix(), 10)
+	content = append(content, '\n')
+	if !deadline.IsZero() {
+		content = strconv.AppendInt(content, deadline.Unix(), 10)
+	} else {
+		content = append(content, '0')
+	}
+
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		return fmt.Errorf("failed to write session file: %w", err)
+	}
+	return nil
+}
+
+// capExpiry returns expiresAt, or deadline if that is earlier
+func capExpiry(expiresAt, deadline time.Time) time.Time {
+	if !deadline.IsZero() && deadline.Before(expiresAt) {
+		return deadline
+	}
+	return expiresAt
+}
+
+// Clear deletes the session file of a vault
+func (m *Manager) Clear(vaultID string) error {
+	sessionPath, err := m.sessionPath(vaultID)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(sessionPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to clear session: %w", err)
+	}
+	return nil
+}
+
+// ClearAll deletes the session files of every vault, and the single
+// session file left by older versions
+func (m *Manager) ClearAll() error {
+	if err := os.RemoveAll(filepath.Join(m.sessionDir, SessionDirName)); err != nil {
+		return fmt.Errorf("failed to clear sessions: %w", err)
+	}
+	err := os.Remove(filepath.Join(m.sessionDir, legacySessionFileName))
 	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to clear session: %w", err)
 	}
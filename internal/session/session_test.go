@@ -0,0 +1,167 @@
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TheEditor/keyp/internal/core"
+)
+
+func newTestManager(t *testing.T, timeout time.Duration) *Manager {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	return New(timeout, time.Hour)
+}
+
+func TestSessionsPerVault(t *testing.T) {
+	m := newTestManager(t, time.Minute)
+
+	key, err := core.SecretBufferFrom(bytes.Repeat([]byte{7}, core.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Destroy()
+
+	if err := m.Save("vault-a", key); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, expiresAt, err := m.Load("vault-a")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer loaded.Destroy()
+	if !bytes.Equal(loaded.Bytes(), key.Bytes()) {
+		t.Error("Loaded key differs from saved key")
+	}
+	if until := time.Until(expiresAt); until <= 0 || until > time.Minute {
+		t.Errorf("Session expires in %v, want within a minute", until)
+	}
+
+	if _, _, err := m.Load("vault-b"); err == nil {
+		t.Error("Expected no session for another vault")
+	}
+
+	if err := m.Clear("vault-a"); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if _, _, err := m.Load("vault-a"); err == nil {
+		t.Error("Expected session to be cleared")
+	}
+}
+
+func TestSessionExpires(t *testing.T) {
+	m := newTestManager(t, -time.Minute)
+
+	key, err := core.NewSecretBuffer(core.KeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Destroy()
+	if err := m.Save("vault-a", key); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if _, _, err := m.Load("vault-a"); err == nil {
+		t.Error("Expected expired session to be rejected")
+	}
+}
+
+func TestSessionRefresh(t *testing.T) {
+	m := newTestManager(t, time.Minute)
+
+	key, err := core.NewSecretBuffer(core.KeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Destroy()
+	if err := m.Save("vault-a", key); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// Refreshing slides the idle timeout from now
+	m.timeout = 10 * time.Minute
+	expiresAt, err := m.Refresh("vault-a")
+	if err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if until := time.Until(expiresAt); until <= 9*time.Minute || until > 10*time.Minute {
+		t.Errorf("Refreshed session expires in %v, want about 10m", until)
+	}
+	loaded, loadedExpiry, err := m.Load("vault-a")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	loaded.Destroy()
+	if !loadedExpiry.Equal(expiresAt) {
+		t.Errorf("Load expiry = %v, want %v", loadedExpiry, expiresAt)
+	}
+
+	// but never past the maximum lifetime set when the session was saved
+	m.timeout = 2 * time.Hour
+	if expiresAt, err = m.Refresh("vault-a"); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if until := time.Until(expiresAt); until > time.Hour {
+		t.Errorf("Refreshed session expires in %v, past its maximum lifetime", until)
+	}
+
+	// Sessions saved by older versions expire at their fixed time
+	path, err := m.sessionPath("vault-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiry := time.Now().Add(time.Minute).Unix()
+	legacy := fmt.Sprintf("%x\n%d", key.Bytes(), expiry)
+	if err := os.WriteFile(path, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if expiresAt, err = m.Refresh("vault-b"); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if expiresAt.Unix() != expiry {
+		t.Errorf("Refreshed legacy session expires at %v, want %v", expiresAt, time.Unix(expiry, 0))
+	}
+}
+
+func TestSessionRejectsInvalidIDs(t *testing.T) {
+	m := newTestManager(t, time.Minute)
+	for _, id := range []string{"", ".", "..", "../session", "a/b"} {
+		if _, err := m.sessionPath(id); err == nil {
+			t.Errorf("Expected vault ID %q to be rejected", id)
+		}
+	}
+}
+
+func TestClearAll(t *testing.T) {
+	m := newTestManager(t, time.Minute)
+
+	key, err := core.NewSecretBuffer(core.KeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Destroy()
+	for _, id := range []string{"vault-a", "vault-b"} {
+		if err := m.Save(id, key); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	legacy := filepath.Join(m.sessionDir, legacySessionFileName)
+	if err := os.WriteFile(legacy, []byte("00\n0"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.ClearAll(); err != nil {
+		t.Fatalf("ClearAll failed: %v", err)
+	}
+	for _, id := range []string{"vault-a", "vault-b"} {
+		if _, _, err := m.Load(id); err == nil {
+			t.Errorf("Expected session of %s to be cleared", id)
+		}
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Error("Expected legacy session file to be removed")
+	}
+}
@@ -0,0 +1,236 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"time"
+
+	"github.com/TheEditor/keyp/internal/model"
+)
+
+// StoredAttachment is an attachment as stored: its name sealed and matched
+// by NameKey, its content in chunks sealed with Cipher
+type StoredAttachment struct {
+	model.Attachment
+	NameKey string
+	Cipher  string
+}
+
+// CreateAttachment stores an attachment with the chunks returned by next,
+// which is called until it returns io.EOF. The attachment row is written
+// after the chunks, so its Size may be filled in while they stream. Either
+// the whole attachment lands or nothing does. It fails with ErrNotFound
+// unless the secret exists outside the trash, and with ErrAlreadyExists if
+// the secret has an attachment with the same name key.
+func (s *Store) CreateAttachment(ctx context.Context, a *StoredAttachment, next func() ([]byte, error)) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var live int
+	err = tx.QueryRowContext(ctx, "SELECT count(*) FROM secrets WHERE id = ? AND deleted_at IS NULL", a.SecretID).Scan(&live)
+	if err != nil {
+		return err
+	}
+	if live == 0 {
+		return ErrNotFound
+	}
+
+	for seq := 0; ; seq++ {
+		chunk, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO attachment_chunks (attachment_id, seq, data) VALUES (?, ?, ?)",
+			a.ID, seq, chunk,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO attachments (id, secret_id, name, name_key, size, cipher, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		a.ID, a.SecretID, a.Name, a.NameKey, a.Size, a.Cipher, a.CreatedAt.Format(time.RFC3339),
+	)
+	if err != nil {
+		return nameConflict(err)
+	}
+
+	return tx.Commit()
+}
+
+// Attachments returns the attachments of a secret in the order they were added
+func (s *Store) Attachments(ctx context.Context, secretID string) ([]*StoredAttachment, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, secret_id, name, name_key, size, cipher, created_at FROM attachments WHERE secret_id = ? ORDER BY rowid",
+		secretID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []*StoredAttachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+// GetAttachment returns the attachment of a secret with the given name key
+func (s *Store) GetAttachment(ctx context.Context, secretID, key string) (*StoredAttachment, error) {
+	row := s.db.QueryRowContext(ctx,
+		"SELECT id, secret_id, name, name_key, size, cipher, created_at FROM attachments WHERE secret_id = ? AND name_key = ?",
+		secretID, key,
+	)
+	a, err := scanAttachment(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return a, err
+}
+
+// ReadAttachment passes the stored chunks of an attachment to fn in order,
+// telling it which one is the last. Only one chunk is held at a time
+// besides the one read ahead to know that.
+func (s *Store) ReadAttachment(ctx context.Context, id string, fn func(chunk []byte, last bool) error) error {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT data FROM attachment_chunks WHERE attachment_id = ? ORDER BY seq",
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var pending []byte
+	have := false
+	for rows.Next() {
+		var chunk []byte
+		if err := rows.Scan(&chunk); err != nil {
+			return err
+		}
+		if have {
+			if err := fn(pending, false); err != nil {
+				return err
+			}
+		}
+		pending, have = chunk, true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if have {
+		return fn(pending, true)
+	}
+	return nil
+}
+
+// AttachmentRewrite replaces the sealed name, name key and cipher of a
+// stored attachment, and each of its chunks with what Reseal returns for it
+type AttachmentRewrite struct {
+	*StoredAttachment
+	Reseal func(chunk []byte, last bool) ([]byte, error)
+}
+
+// rewriteAttachment applies a rewrite within a transaction, holding one
+// chunk at a time
+func rewriteAttachment(ctx context.Context, tx *sql.Tx, a AttachmentRewrite) error {
+	_, err := tx.ExecContext(ctx,
+		"UPDATE attachments SET name = ?, name_key = ?, cipher = ? WHERE id = ?",
+		a.Name, a.NameKey, a.Cipher, a.ID,
+	)
+	if err != nil {
+		return nameConflict(err)
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT seq FROM attachment_chunks WHERE attachment_id = ? ORDER BY seq", a.ID)
+	if err != nil {
+		return err
+	}
+	var seqs []int
+	for rows.Next() {
+		var seq int
+		if err := rows.Scan(&seq); err != nil {
+			rows.Close()
+			return err
+		}
+		seqs = append(seqs, seq)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, seq := range seqs {
+		var chunk []byte
+		err := tx.QueryRowContext(ctx,
+			"SELECT data FROM attachment_chunks WHERE attachment_id = ? AND seq = ?",
+			a.ID, seq,
+		).Scan(&chunk)
+		if err != nil {
+			return err
+		}
+		if chunk, err = a.Reseal(chunk, i == len(seqs)-1); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			"UPDATE attachment_chunks SET data = ? WHERE attachment_id = ? AND seq = ?",
+			chunk, a.ID, seq,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteAttachment removes an attachment and its content
+func (s *Store) DeleteAttachment(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM attachments WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	// Foreign keys are not enforced, so the cascade has to be done by hand
+	if _, err := tx.ExecContext(ctx, "DELETE FROM attachment_chunks WHERE attachment_id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rowScanner is a single row or the current row of a result set
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAttachment(row rowScanner) (*StoredAttachment, error) {
+	var a StoredAttachment
+	var createdAt string
+	err := row.Scan(&a.ID, &a.SecretID, &a.Name, &a.NameKey, &a.Size, &a.Cipher, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	a.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	return &a, nil
+}
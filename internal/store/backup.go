@@ -0,0 +1,88 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Backup copies the database file at path next to itself and verifies the
+// copy byte-for-byte. It returns the path of the backup.
+func Backup(path string) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open vault for backup: %w", err)
+	}
+	defer src.Close()
+
+	backupPath, dst, err := createBackupFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup: %w", err)
+	}
+
+	srcHash := sha256.New()
+	if _, err := io.Copy(dst, io.TeeReader(src, srcHash)); err != nil {
+		dst.Close()
+		os.Remove(backupPath)
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		os.Remove(backupPath)
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(backupPath)
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	// Re-read the backup to make sure it landed on disk intact
+	written, err := fileHash(backupPath)
+	if err != nil {
+		os.Remove(backupPath)
+		return "", err
+	}
+	if !bytes.Equal(written, srcHash.Sum(nil)) {
+		os.Remove(backupPath)
+		return "", fmt.Errorf("backup verification failed for %s", backupPath)
+	}
+
+	return backupPath, nil
+}
+
+// createBackupFile creates a new backup file named after path and the
+// current time. Backups taken within the same second, such as the one a
+// migration takes on open followed by another before a later rewrite, get
+// a numbered suffix instead of failing.
+func createBackupFile(path string) (string, *os.File, error) {
+	base := fmt.Sprintf("%s.bak-%s", path, time.Now().Format("20060102-150405"))
+	backupPath := base
+	for n := 1; ; n++ {
+		f, err := os.OpenFile(backupPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			return backupPath, f, nil
+		}
+		if !os.IsExist(err) || n > 100 {
+			return "", nil, err
+		}
+		backupPath = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+// fileHash returns the SHA-256 digest of a file
+func fileHash(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// IntegrityCheck runs SQLite's integrity check and returns the problems it
+// reports, or nil if the database is intact
+func (s *Store) IntegrityCheck(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return nil, err
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	return problems, rows.Err()
+}
+
+// OrphanedFields returns field rows whose secret no longer exists
+func (s *Store) OrphanedFields(ctx context.Context) ([]FieldRow, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT secret_id, id, label, value, sensitive, type, sort_order FROM fields
+		 WHERE secret_id NOT IN (SELECT id FROM secrets)`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []FieldRow
+	for rows.Next() {
+		var r FieldRow
+		var sensitive int
+		err := rows.Scan(&r.SecretID, &r.ID, &r.Label, &r.Value, &sensitive, &r.Type, &r.SortOrder)
+		if err != nil {
+			return nil, err
+		}
+		r.Sensitive = sensitive == 1
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+// DeleteOrphanedFields removes field rows whose secret no longer exists and
+// returns how many were removed
+func (s *Store) DeleteOrphanedFields(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM fields WHERE secret_id NOT IN (SELECT id FROM secrets)")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MalformedTags returns the IDs of secrets whose tags column is not a JSON
+// array of strings
+func (s *Store) MalformedTags(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, tags FROM secrets")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id, tagsJSON string
+		if err := rows.Scan(&id, &tagsJSON); err != nil {
+			return nil, err
+		}
+		var tags []string
+		if tagsJSON != "" && (json.Unmarshal([]byte(tagsJSON), &tags) != nil || tags == nil) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, rows.Err()
+}
+
+// SetTags replaces the tags column of a secret without touching its timestamps
+func (s *Store) SetTags(ctx context.Context, id string, tags []string) error {
+	if tags == nil {
+		tags = []string{}
+	}
+	tagsJSON, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	result, err := s.db.ExecContext(ctx, "UPDATE secrets SET tags = ? WHERE id = ?", string(tagsJSON), id)
+	if err != nil {
+		return err
+	}
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
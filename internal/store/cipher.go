@@ -0,0 +1,149 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrSQLCipherUnavailable is returned when keyp was not linked against SQLCipher
+var ErrSQLCipherUnavailable = errors.New("SQLCipher is not available in this build")
+
+// sqliteMagic is the header of every unencrypted SQLite database file
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+// keyedConnector opens SQLite connections and keys each one before use
+type keyedConnector struct {
+	dsn    string
+	pragma string
+	driver *sqlite3.SQLiteDriver
+}
+
+// Connect opens a new connection and applies the SQLCipher key
+func (c *keyedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.(*sqlite3.SQLiteConn).Exec(c.pragma, nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Driver returns the underlying SQLite driver
+func (c *keyedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// keyLiteral formats a raw key as a SQLCipher key literal
+func keyLiteral(key []byte) string {
+	return fmt.Sprintf("x'%s'", hex.EncodeToString(key))
+}
+
+// OpenEncrypted opens or creates a SQLCipher database keyed with a raw 256-bit key
+func OpenEncrypted(path string, key []byte) (*Store, error) {
+	db := sql.OpenDB(&keyedConnector{
+		dsn:    path,
+		pragma: fmt.Sprintf("PRAGMA key = \"%s\"", keyLiteral(key)),
+		driver: &sqlite3.SQLiteDriver{},
+	})
+
+	if !cipherAvailable(db) {
+		db.Close()
+		return nil, ErrSQLCipherUnavailable
+	}
+
+	// A wrong key only surfaces on the first read of the file
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM sqlite_master").Scan(&count); err != nil {
+		db.Close()
+		return nil, ErrInvalidPassword
+	}
+
+	s := &Store{db: db}
+	if err := s.migrate(path); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// SQLCipherAvailable reports whether keyp is linked against SQLCipher
+func SQLCipherAvailable() bool {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return false
+	}
+	defer db.Close()
+	return cipherAvailable(db)
+}
+
+// cipherAvailable checks for SQLCipher by querying its version pragma,
+// which plain SQLite silently ignores
+func cipherAvailable(db *sql.DB) bool {
+	var version string
+	if err := db.QueryRow("PRAGMA cipher_version").Scan(&version); err != nil {
+		return false
+	}
+	return version != ""
+}
+
+// IsEncrypted reports whether the file at path is not a plain SQLite database.
+// Missing and empty files are treated as plain, since SQLite creates them.
+func IsEncrypted(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	header := make([]byte, len(sqliteMagic))
+	n, err := io.ReadFull(f, header)
+	if n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+		return false, nil
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return !bytes.Equal(header[:n], sqliteMagic), nil
+}
+
+// ExportEncrypted writes a SQLCipher-encrypted copy of the database to dst
+func (s *Store) ExportEncrypted(ctx context.Context, dst string, key []byte) error {
+	if !cipherAvailable(s.db) {
+		return ErrSQLCipherUnavailable
+	}
+
+	// ATTACH and sqlcipher_export must run on the same connection
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS encrypted KEY ?", dst, keyLiteral(key)); err != nil {
+		return fmt.Errorf("failed to attach encrypted database: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT sqlcipher_export('encrypted')"); err != nil {
+		conn.ExecContext(ctx, "DETACH DATABASE encrypted")
+		return fmt.Errorf("failed to export database: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "DETACH DATABASE encrypted"); err != nil {
+		return fmt.Errorf("failed to detach encrypted database: %w", err)
+	}
+	return nil
+}
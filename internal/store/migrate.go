@@ -0,0 +1,257 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// metaSchemaVersion is the vault_meta key holding the number of migrations
+// applied to the database
+const metaSchemaVersion = "schema_version"
+
+// ErrSchemaTooNew is returned when opening a vault whose schema was written
+// by a newer version of keyp
+var ErrSchemaTooNew = errors.New("vault was written by a newer version of keyp; upgrade keyp to open it")
+
+// migration changes the schema from the previous version to the next. Each
+// one runs in its own transaction together with the version bump.
+type migration struct {
+	description string
+	apply       func(ctx context.Context, tx *sql.Tx) error
+}
+
+// migrations upgrades the schema one version at a time; the version of a
+// database is the number of entries applied. Vaults from before schema
+// versioning report version 0 and already hold some of these tables, so
+// the early migrations must tolerate finding their changes in place.
+// Append new migrations; never edit or reorder released ones.
+var migrations = []migration{
+	{"create secrets, fields and metadata", migrateInitial},
+	{"keep secret history", migrateVersions},
+	{"add trash", migrateTrash},
+	{"enforce unique names", migrateUniqueNames},
+	{"add attachments", migrateAttachments},
+	{"track expiry and rotation", migrateLifecycle},
+}
+
+// migrate brings the database at path up to the latest schema version,
+// taking a verified backup of the file first if it already holds a vault
+func (s *Store) migrate(path string) error {
+	ctx := context.Background()
+	latest := len(migrations)
+	current, existing, err := s.schemaVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if current > latest {
+		return fmt.Errorf("%w (schema version %d, this keyp supports up to %d)", ErrSchemaTooNew, current, latest)
+	}
+	if current == latest {
+		return nil
+	}
+
+	if existing {
+		if _, err := Backup(path); err != nil {
+			return fmt.Errorf("failed to back up vault before migrating: %w", err)
+		}
+	}
+
+	for version := current; version < latest; version++ {
+		if err := s.applyMigration(ctx, version+1, migrations[version]); err != nil {
+			return fmt.Errorf("failed to migrate vault schema to version %d (%s): %w", version+1, migrations[version].description, err)
+		}
+	}
+	return nil
+}
+
+// schemaVersion returns the schema version of the database and whether it
+// holds any tables yet
+func (s *Store) schemaVersion(ctx context.Context) (int, bool, error) {
+	var tables int
+	if err := s.db.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master WHERE type = 'table'").Scan(&tables); err != nil {
+		return 0, false, err
+	}
+	if tables == 0 {
+		return 0, false, nil
+	}
+
+	if !s.hasTable(ctx, "vault_meta") {
+		return 0, true, nil
+	}
+	var value string
+	err := s.db.QueryRowContext(ctx, "SELECT value FROM vault_meta WHERE key = ?", metaSchemaVersion).Scan(&value)
+	if err == sql.ErrNoRows {
+		return 0, true, nil
+	}
+	if err != nil {
+		return 0, true, err
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, true, fmt.Errorf("invalid schema version %q", value)
+	}
+	return version, true, nil
+}
+
+// hasTable reports whether the database has a table with the given name
+func (s *Store) hasTable(ctx context.Context, name string) bool {
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count)
+	return err == nil && count > 0
+}
+
+// applyMigration runs one migration and records the version it reaches in
+// a single transaction
+func (s *Store) applyMigration(ctx context.Context, version int, m migration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.apply(ctx, tx); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT OR REPLACE INTO vault_meta (key, value) VALUES (?, ?)",
+		metaSchemaVersion, strconv.Itoa(version),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateInitial(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+    CREATE TABLE IF NOT EXISTS vault_meta (
+        key TEXT PRIMARY KEY,
+        value TEXT NOT NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS secrets (
+        id TEXT PRIMARY KEY,
+        name TEXT NOT NULL,
+        tags TEXT DEFAULT '[]',
+        notes TEXT DEFAULT '',
+        created_at TEXT NOT NULL,
+        updated_at TEXT NOT NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS fields (
+        id TEXT PRIMARY KEY,
+        secret_id TEXT NOT NULL,
+        label TEXT NOT NULL,
+        value TEXT NOT NULL,
+        sensitive INTEGER DEFAULT 1,
+        type TEXT DEFAULT 'text',
+        sort_order INTEGER DEFAULT 0,
+        FOREIGN KEY (secret_id) REFERENCES secrets(id) ON DELETE CASCADE,
+        UNIQUE(secret_id, label)
+    );
+    `)
+	return err
+}
+
+func migrateVersions(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+    CREATE TABLE IF NOT EXISTS secret_versions (
+        secret_id TEXT NOT NULL,
+        version INTEGER NOT NULL,
+        name TEXT NOT NULL,
+        tags TEXT DEFAULT '[]',
+        notes TEXT DEFAULT '',
+        fields TEXT NOT NULL DEFAULT '[]',
+        created_at TEXT NOT NULL,
+        updated_at TEXT NOT NULL,
+        replaced_at TEXT NOT NULL,
+        PRIMARY KEY (secret_id, version),
+        FOREIGN KEY (secret_id) REFERENCES secrets(id) ON DELETE CASCADE
+    );
+    `)
+	return err
+}
+
+func migrateTrash(ctx context.Context, tx *sql.Tx) error {
+	return addColumn(ctx, tx, "secrets", "deleted_at", "TEXT")
+}
+
+// migrateUniqueNames matches names by a separate key, which the vault
+// derives to fold case or hide the name, and makes it unique outside the
+// trash. Of secrets that already share a name, the first one created keeps
+// it; the others get a key no name produces, so they stay listed until
+// renamed, which 'keyp doctor --repair' does.
+func migrateUniqueNames(ctx context.Context, tx *sql.Tx) error {
+	if err := addColumn(ctx, tx, "secrets", "name_key", "TEXT"); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+    UPDATE secrets SET name_key = name WHERE name_key IS NULL;
+
+    UPDATE secrets SET name_key = name_key || '#' || id
+     WHERE deleted_at IS NULL
+       AND rowid NOT IN (SELECT min(rowid) FROM secrets WHERE deleted_at IS NULL GROUP BY name_key);
+
+    CREATE UNIQUE INDEX IF NOT EXISTS secrets_name_key ON secrets (name_key) WHERE deleted_at IS NULL;
+    `)
+	return err
+}
+
+// migrateAttachments adds encrypted files linked to secrets. An
+// attachment's content is split into sealed chunks, one row each, so it can
+// be written and read without holding it in memory.
+func migrateAttachments(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+    CREATE TABLE IF NOT EXISTS attachments (
+        id TEXT PRIMARY KEY,
+        secret_id TEXT NOT NULL,
+        name TEXT NOT NULL,
+        name_key TEXT NOT NULL,
+        size INTEGER NOT NULL,
+        cipher TEXT NOT NULL,
+        created_at TEXT NOT NULL,
+        FOREIGN KEY (secret_id) REFERENCES secrets(id) ON DELETE CASCADE,
+        UNIQUE(secret_id, name_key)
+    );
+
+    CREATE TABLE IF NOT EXISTS attachment_chunks (
+        attachment_id TEXT NOT NULL,
+        seq INTEGER NOT NULL,
+        data BLOB NOT NULL,
+        PRIMARY KEY (attachment_id, seq),
+        FOREIGN KEY (attachment_id) REFERENCES attachments(id) ON DELETE CASCADE
+    );
+    `)
+	return err
+}
+
+// migrateLifecycle adds optional expiry and rotation tracking to secrets.
+// The rotation period is held in seconds. When field values last changed
+// was not recorded before, so the last update stands in for it.
+func migrateLifecycle(ctx context.Context, tx *sql.Tx) error {
+	if err := addColumn(ctx, tx, "secrets", "expires_at", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumn(ctx, tx, "secrets", "rotate_every", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumn(ctx, tx, "secrets", "rotated_at", "TEXT"); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "UPDATE secrets SET rotated_at = updated_at WHERE rotated_at IS NULL")
+	return err
+}
+
+// addColumn adds a column to a table unless it already has it
+func addColumn(ctx context.Context, tx *sql.Tx, table, column, definition string) error {
+	var count int
+	err := tx.QueryRowContext(ctx, "SELECT count(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "ALTER TABLE "+table+" ADD COLUMN "+column+" "+definition)
+	return err
+}
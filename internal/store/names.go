@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/TheEditor/keyp/internal/model"
+	"github.com/mattn/go-sqlite3"
+)
+
+// nameKey returns the key a secret's name is matched by
+func nameKey(secret *model.SecretObject) string {
+	if secret.NameKey != "" {
+		return secret.NameKey
+	}
+	return secret.Name
+}
+
+// nameConflict turns a violation of the unique name index into
+// ErrAlreadyExists
+func nameConflict(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+// SetNameKeys replaces the name keys of the given secrets, by ID, and sets
+// the given metadata entries in a single transaction. It fails with
+// ErrAlreadyExists if two secrets outside the trash would share a key.
+func (s *Store) SetNameKeys(ctx context.Context, keys map[string]string, meta map[string]string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for id, key := range keys {
+		if _, err := tx.ExecContext(ctx, "UPDATE secrets SET name_key = ? WHERE id = ?", key, id); err != nil {
+			return nameConflict(err)
+		}
+	}
+
+	for key, value := range meta {
+		_, err = tx.ExecContext(ctx,
+			"INSERT OR REPLACE INTO vault_meta (key, value) VALUES (?, ?)",
+			key, value,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// buildPrefixFilter constructs a WHERE clause matching the values of column
+// that start with prefix. A range rather than LIKE, so an index on the
+// column serves it.
+func buildPrefixFilter(column, prefix string, args *[]interface{}) string {
+	*args = append(*args, prefix)
+	end := prefixEnd(prefix)
+	if end == "" {
+		return column + " >= ?"
+	}
+	*args = append(*args, end)
+	return "(" + column + " >= ? AND " + column + " < ?)"
+}
+
+// prefixEnd returns the least string greater than every string starting
+// with prefix, or "" if there is none
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
@@ -11,8 +11,18 @@ import (
 
 // SearchOptions holds filtering options for search queries
 type SearchOptions struct {
-	Tags  []string
-	Limit int
+	Tags   []string
+	Limit  int
+	Prefix string // Only secrets whose name key starts with it, found through the name index
+}
+
+// secretColumns are the columns of a secret that scanSecret reads
+const secretColumns = "id, name, name_key, tags, notes, created_at, updated_at, expires_at, rotate_every, rotated_at"
+
+// FieldRow is a stored field together with the secret it belongs to
+type FieldRow struct {
+	SecretID string
+	model.Field
 }
 
 // Store handles SQLite database operations
@@ -28,7 +38,7 @@ func Open(path string) (*Store, error) {
 	}
 
 	s := &Store{db: db}
-	if err := s.initSchema(); err != nil {
+	if err := s.migrate(path); err != nil {
 		db.Close()
 		return nil, err
 	}
@@ -60,39 +70,40 @@ func (s *Store) GetMeta(key string) (string, error) {
 	return value, err
 }
 
-func (s *Store) initSchema() error {
-	schema := `
-    CREATE TABLE IF NOT EXISTS vault_meta (
-        key TEXT PRIMARY KEY,
-        value TEXT NOT NULL
-    );
-
-    CREATE TABLE IF NOT EXISTS secrets (
-        id TEXT PRIMARY KEY,
-        name TEXT NOT NULL,
-        tags TEXT DEFAULT '[]',
-        notes TEXT DEFAULT '',
-        created_at TEXT NOT NULL,
-        updated_at TEXT NOT NULL
-    );
-
-    CREATE TABLE IF NOT EXISTS fields (
-        id TEXT PRIMARY KEY,
-        secret_id TEXT NOT NULL,
-        label TEXT NOT NULL,
-        value TEXT NOT NULL,
-        sensitive INTEGER DEFAULT 1,
-        type TEXT DEFAULT 'text',
-        sort_order INTEGER DEFAULT 0,
-        FOREIGN KEY (secret_id) REFERENCES secrets(id) ON DELETE CASCADE,
-        UNIQUE(secret_id, label)
-    );
-    `
-	_, err := s.db.Exec(schema)
+// SetMetaValues stores several metadata entries and removes others in a single transaction
+func (s *Store) SetMetaValues(values map[string]string, remove ...string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, key := range remove {
+		if _, err := tx.Exec("DELETE FROM vault_meta WHERE key = ?", key); err != nil {
+			return err
+		}
+	}
+	for key, value := range values {
+		_, err := tx.Exec(
+			"INSERT OR REPLACE INTO vault_meta (key, value) VALUES (?, ?)",
+			key, value,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteMeta removes a metadata entry
+func (s *Store) DeleteMeta(key string) error {
+	_, err := s.db.Exec("DELETE FROM vault_meta WHERE key = ?", key)
 	return err
 }
 
-// Create inserts a new secret with its fields
+// Create inserts a new secret with its fields. It fails with
+// ErrAlreadyExists if a secret outside the trash has the same name key.
 func (s *Store) Create(ctx context.Context, secret *model.SecretObject) error {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -101,13 +112,14 @@ func (s *Store) Create(ctx context.Context, secret *model.SecretObject) error {
 	defer tx.Rollback()
 
 	_, err = tx.ExecContext(ctx,
-		"INSERT INTO secrets (id, name, tags, notes, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
-		secret.ID, secret.Name, secret.TagsJSON(), secret.Notes,
+		"INSERT INTO secrets (id, name, name_key, tags, notes, created_at, updated_at, expires_at, rotate_every, rotated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		secret.ID, secret.Name, nameKey(secret), secret.TagsJSON(), secret.Notes,
 		secret.CreatedAt.Format(time.RFC3339),
 		secret.UpdatedAt.Format(time.RFC3339),
+		optionalTime(secret.ExpiresAt), rotateSeconds(secret), optionalTime(secret.RotatedAt),
 	)
 	if err != nil {
-		return err
+		return nameConflict(err)
 	}
 
 	for _, f := range secret.Fields {
@@ -123,14 +135,15 @@ func (s *Store) Create(ctx context.Context, secret *model.SecretObject) error {
 	return tx.Commit()
 }
 
-// GetByName retrieves a secret by name
-func (s *Store) GetByName(ctx context.Context, name string) (*model.SecretObject, error) {
+// GetByName retrieves a secret by the key its name is matched by. Secrets
+// in the trash are not found.
+func (s *Store) GetByName(ctx context.Context, key string) (*model.SecretObject, error) {
 	row := s.db.QueryRowContext(ctx,
-		"SELECT id, name, tags, notes, created_at, updated_at FROM secrets WHERE name = ?",
-		name,
+		"SELECT "+secretColumns+" FROM secrets WHERE name_key = ? AND deleted_at IS NULL",
+		key,
 	)
 
-	secret, err := s.scanSecret(row)
+	secret, err := scanSecret(row)
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
 	}
@@ -147,24 +160,30 @@ func (s *Store) GetByName(ctx context.Context, name string) (*model.SecretObject
 	return secret, nil
 }
 
-// List returns all secrets with optional filtering
-func (s *Store) List(ctx context.Context, opts *SearchOptions) ([]*model.SecretObject, error) {
-	query := "SELECT id, name, tags, notes, created_at, updated_at FROM secrets"
-	args := []interface{}{}
+// GetByID retrieves a secret by its ID, in the trash or not
+func (s *Store) GetByID(ctx context.Context, id string) (*model.SecretObject, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+secretColumns+" FROM secrets WHERE id = ?", id)
 
-	// Apply tag filtering if specified
-	if opts != nil && len(opts.Tags) > 0 {
-		query += " WHERE " + buildTagFilter(opts.Tags, &args)
+	secret, err := scanSecret(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	query += " ORDER BY name"
-
-	// Apply limit if specified
-	if opts != nil && opts.Limit > 0 {
-		query += " LIMIT ?"
-		args = append(args, opts.Limit)
+	fields, err := s.getFields(ctx, secret.ID)
+	if err != nil {
+		return nil, err
 	}
+	secret.Fields = fields
+
+	return secret, nil
+}
 
+// List returns all secrets outside the trash with optional filtering
+func (s *Store) List(ctx context.Context, opts *SearchOptions) ([]*model.SecretObject, error) {
+	query, args := listQuery(opts)
 	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
@@ -173,27 +192,64 @@ func (s *Store) List(ctx context.Context, opts *SearchOptions) ([]*model.SecretO
 
 	var secrets []*model.SecretObject
 	for rows.Next() {
-		secret, err := s.scanSecretRows(rows)
+		secret, err := scanSecret(rows)
 		if err != nil {
 			return nil, err
 		}
 		secrets = append(secrets, secret)
 	}
-	return secrets, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := s.loadFields(ctx, secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// listQuery builds the query List runs
+func listQuery(opts *SearchOptions) (string, []interface{}) {
+	query := "SELECT " + secretColumns + " FROM secrets WHERE deleted_at IS NULL"
+	args := []interface{}{}
+
+	// Apply prefix and tag filtering if specified
+	if opts != nil && opts.Prefix != "" {
+		query += " AND " + buildPrefixFilter("name_key", opts.Prefix, &args)
+	}
+	if opts != nil && len(opts.Tags) > 0 {
+		query += " AND " + buildTagFilter(opts.Tags, &args)
+	}
+
+	query += " ORDER BY name"
+
+	// Apply limit if specified
+	if opts != nil && opts.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, opts.Limit)
+	}
+	return query, args
 }
 
-// Search performs simple LIKE-based search across name, tags, notes, and field labels
+// Search performs simple LIKE-based search across name, tags, notes, and
+// field labels of the secrets outside the trash
 func (s *Store) Search(ctx context.Context, query string, opts *SearchOptions) ([]*model.SecretObject, error) {
 	pattern := "%" + query + "%"
 
 	sqlQuery := `
-        SELECT DISTINCT s.id, s.name, s.tags, s.notes, s.created_at, s.updated_at
+        SELECT DISTINCT s.id, s.name, s.name_key, s.tags, s.notes, s.created_at, s.updated_at,
+               s.expires_at, s.rotate_every, s.rotated_at
         FROM secrets s
         LEFT JOIN fields f ON s.id = f.secret_id
-        WHERE s.name LIKE ? OR s.tags LIKE ? OR s.notes LIKE ? OR f.label LIKE ?`
+        WHERE s.deleted_at IS NULL
+          AND (s.name LIKE ? OR s.tags LIKE ? OR s.notes LIKE ? OR f.label LIKE ?)`
 	args := []interface{}{pattern, pattern, pattern, pattern}
 
-	// Apply tag filtering if specified
+	// Apply prefix and tag filtering if specified
+	if opts != nil && opts.Prefix != "" {
+		sqlQuery += " AND " + buildPrefixFilter("s.name_key", opts.Prefix, &args)
+	}
 	if opts != nil && len(opts.Tags) > 0 {
 		sqlQuery += " AND " + buildTagFilter(opts.Tags, &args)
 	}
@@ -214,16 +270,26 @@ func (s *Store) Search(ctx context.Context, query string, opts *SearchOptions) (
 
 	var secrets []*model.SecretObject
 	for rows.Next() {
-		secret, err := s.scanSecretRows(rows)
+		secret, err := scanSecret(rows)
 		if err != nil {
 			return nil, err
 		}
 		secrets = append(secrets, secret)
 	}
-	return secrets, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := s.loadFields(ctx, secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
 }
 
-// Update modifies an existing secret
+// Update modifies an existing secret, keeping its previous state as a
+// version in its history. Renaming it onto the name key of another secret
+// fails with ErrAlreadyExists.
 func (s *Store) Update(ctx context.Context, secret *model.SecretObject) error {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -231,16 +297,52 @@ func (s *Store) Update(ctx context.Context, secret *model.SecretObject) error {
 	}
 	defer tx.Rollback()
 
+	if err := updateSecret(ctx, tx, secret); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateAll updates several secrets in a single transaction, each as
+// Update does. One may take the name key another gives up.
+func (s *Store) UpdateAll(ctx context.Context, secrets []*model.SecretObject) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Release their keys first; NULL keys never conflict
+	for _, secret := range secrets {
+		if _, err := tx.ExecContext(ctx, "UPDATE secrets SET name_key = NULL WHERE id = ?", secret.ID); err != nil {
+			return err
+		}
+	}
+	for _, secret := range secrets {
+		if err := updateSecret(ctx, tx, secret); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// updateSecret archives the stored state of a secret and replaces it
+func updateSecret(ctx context.Context, tx *sql.Tx, secret *model.SecretObject) error {
+	if err := archiveVersion(ctx, tx, secret.ID); err != nil {
+		return err
+	}
+
 	// Update the main secret record
 	secret.UpdatedAt = time.Now()
 	result, err := tx.ExecContext(ctx,
-		"UPDATE secrets SET name = ?, tags = ?, notes = ?, updated_at = ? WHERE id = ?",
-		secret.Name, secret.TagsJSON(), secret.Notes,
+		"UPDATE secrets SET name = ?, name_key = ?, tags = ?, notes = ?, updated_at = ?, expires_at = ?, rotate_every = ?, rotated_at = ? WHERE id = ?",
+		secret.Name, nameKey(secret), secret.TagsJSON(), secret.Notes,
 		secret.UpdatedAt.Format(time.RFC3339),
+		optionalTime(secret.ExpiresAt), rotateSeconds(secret), optionalTime(secret.RotatedAt),
 		secret.ID,
 	)
 	if err != nil {
-		return err
+		return nameConflict(err)
 	}
 
 	affected, _ := result.RowsAffected()
@@ -265,12 +367,16 @@ func (s *Store) Update(ctx context.Context, secret *model.SecretObject) error {
 		}
 	}
 
-	return tx.Commit()
+	return nil
 }
 
-// Delete removes a secret and its fields
-func (s *Store) Delete(ctx context.Context, name string) error {
-	result, err := s.db.ExecContext(ctx, "DELETE FROM secrets WHERE name = ?", name)
+// Delete moves the secret with the given name key to the trash. It keeps
+// its fields and history until it is restored or purged.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE secrets SET deleted_at = ? WHERE name_key = ? AND deleted_at IS NULL",
+		time.Now().Format(time.RFC3339), key,
+	)
 	if err != nil {
 		return err
 	}
@@ -281,8 +387,166 @@ func (s *Store) Delete(ctx context.Context, name string) error {
 	return nil
 }
 
-func (s *Store) getFields(ctx context.Context, secretID string) ([]model.Field, error) {
+// Counts returns the number of secrets and fields in the store, leaving
+// out the trash
+func (s *Store) Counts(ctx context.Context) (secrets int, fields int, err error) {
+	if err = s.db.QueryRowContext(ctx, "SELECT count(*) FROM secrets WHERE deleted_at IS NULL").Scan(&secrets); err != nil {
+		return 0, 0, err
+	}
+	err = s.db.QueryRowContext(ctx,
+		"SELECT count(*) FROM fields WHERE secret_id IN (SELECT id FROM secrets WHERE deleted_at IS NULL)",
+	).Scan(&fields)
+	if err != nil {
+		return 0, 0, err
+	}
+	return secrets, fields, nil
+}
+
+// SensitiveFields returns every sensitive field row across all secrets
+func (s *Store) SensitiveFields(ctx context.Context) ([]FieldRow, error) {
 	rows, err := s.db.QueryContext(ctx,
+		"SELECT secret_id, id, label, value, sensitive, type, sort_order FROM fields WHERE sensitive = 1",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []FieldRow
+	for rows.Next() {
+		var r FieldRow
+		var sensitive int
+		err := rows.Scan(&r.SecretID, &r.ID, &r.Label, &r.Value, &sensitive, &r.Type, &r.SortOrder)
+		if err != nil {
+			return nil, err
+		}
+		r.Sensitive = sensitive == 1
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+// UpdateFieldValues rewrites the stored values of the given fields and sets
+// the given metadata entries in a single transaction
+func (s *Store) UpdateFieldValues(ctx context.Context, fields []FieldRow, meta map[string]string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, f := range fields {
+		_, err = tx.ExecContext(ctx,
+			"UPDATE fields SET value = ? WHERE id = ? AND secret_id = ?",
+			f.Value, f.ID, f.SecretID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for key, value := range meta {
+		_, err = tx.ExecContext(ctx,
+			"INSERT OR REPLACE INTO vault_meta (key, value) VALUES (?, ?)",
+			key, value,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RewriteSecrets replaces the stored name, tags, notes and fields of the
+// given secrets and versions, rewrites the given attachments and sets the
+// given metadata entries in a single transaction. Unlike Update,
+// timestamps are left as they are and no history is kept.
+func (s *Store) RewriteSecrets(ctx context.Context, secrets []*model.SecretObject, versions []*Version, attachments []AttachmentRewrite, meta map[string]string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, secret := range secrets {
+		_, err = tx.ExecContext(ctx,
+			"UPDATE secrets SET name = ?, name_key = ?, tags = ?, notes = ? WHERE id = ?",
+			secret.Name, nameKey(secret), secret.TagsJSON(), secret.Notes, secret.ID,
+		)
+		if err != nil {
+			return nameConflict(err)
+		}
+		if _, err = tx.ExecContext(ctx, "DELETE FROM fields WHERE secret_id = ?", secret.ID); err != nil {
+			return err
+		}
+		for _, f := range secret.Fields {
+			_, err = tx.ExecContext(ctx,
+				"INSERT INTO fields (id, secret_id, label, value, sensitive, type, sort_order) VALUES (?, ?, ?, ?, ?, ?, ?)",
+				f.ID, secret.ID, f.Label, f.Value, boolToInt(f.Sensitive), f.Type, f.SortOrder,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, v := range versions {
+		if err := rewriteVersion(ctx, tx, v); err != nil {
+			return err
+		}
+	}
+
+	for _, a := range attachments {
+		if err := rewriteAttachment(ctx, tx, a); err != nil {
+			return err
+		}
+	}
+
+	for key, value := range meta {
+		_, err = tx.ExecContext(ctx,
+			"INSERT OR REPLACE INTO vault_meta (key, value) VALUES (?, ?)",
+			key, value,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Vacuum rebuilds the database file so that deleted and overwritten
+// content no longer lingers in free pages
+func (s *Store) Vacuum(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, "VACUUM")
+	return err
+}
+
+// loadFields populates the fields of each secret
+func (s *Store) loadFields(ctx context.Context, secrets []*model.SecretObject) error {
+	for _, secret := range secrets {
+		fields, err := s.getFields(ctx, secret.ID)
+		if err != nil {
+			return err
+		}
+		secret.Fields = fields
+	}
+	return nil
+}
+
+func (s *Store) getFields(ctx context.Context, secretID string) ([]model.Field, error) {
+	return queryFields(ctx, s.db, secretID)
+}
+
+// queryer runs queries on the database or within a transaction
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// queryFields returns the fields of a secret in order
+func queryFields(ctx context.Context, q queryer, secretID string) ([]model.Field, error) {
+	rows, err := q.QueryContext(ctx,
 		"SELECT id, label, value, sensitive, type, sort_order FROM fields WHERE secret_id = ? ORDER BY sort_order",
 		secretID,
 	)
@@ -305,36 +569,41 @@ func (s *Store) getFields(ctx context.Context, secretID string) ([]model.Field,
 	return fields, rows.Err()
 }
 
-func (s *Store) scanSecret(row *sql.Row) (*model.SecretObject, error) {
+// scanSecret reads a secret selected as secretColumns, followed by any
+// extra columns
+func scanSecret(row rowScanner, extra ...any) (*model.SecretObject, error) {
 	var secret model.SecretObject
 	var tagsJSON, createdAt, updatedAt string
+	var expiresAt, rotatedAt sql.NullString
+	var rotateEvery int64
 
-	err := row.Scan(&secret.ID, &secret.Name, &tagsJSON, &secret.Notes, &createdAt, &updatedAt)
-	if err != nil {
+	dest := []any{&secret.ID, &secret.Name, &secret.NameKey, &tagsJSON, &secret.Notes, &createdAt, &updatedAt, &expiresAt, &rotateEvery, &rotatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
 		return nil, err
 	}
 
 	secret.Tags = model.ParseTags(tagsJSON)
 	secret.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
 	secret.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+	secret.ExpiresAt, _ = time.Parse(time.RFC3339, expiresAt.String)
+	secret.RotateEvery = model.Period(time.Duration(rotateEvery) * time.Second)
+	secret.RotatedAt, _ = time.Parse(time.RFC3339, rotatedAt.String)
 
 	return &secret, nil
 }
 
-func (s *Store) scanSecretRows(rows *sql.Rows) (*model.SecretObject, error) {
-	var secret model.SecretObject
-	var tagsJSON, createdAt, updatedAt string
-
-	err := rows.Scan(&secret.ID, &secret.Name, &tagsJSON, &secret.Notes, &createdAt, &updatedAt)
-	if err != nil {
-		return nil, err
+// optionalTime formats a time for a nullable column, NULL for the zero time
+func optionalTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
 	}
+	return t.Format(time.RFC3339)
+}
 
-	secret.Tags = model.ParseTags(tagsJSON)
-	secret.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
-	secret.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
-
-	return &secret, nil
+// rotateSeconds returns the rotation period of a secret in whole seconds,
+// as the rotate_every column holds it
+func rotateSeconds(secret *model.SecretObject) int64 {
+	return int64(time.Duration(secret.RotateEvery) / time.Second)
 }
 
 func boolToInt(b bool) int {
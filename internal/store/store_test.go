@@ -4,8 +4,15 @@ package store
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/TheEditor/keyp/internal/model"
 )
@@ -132,6 +139,97 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestUniqueNames(t *testing.T) {
+	s := setupTestStore(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	first := model.NewSecretObject("github")
+	second := model.NewSecretObject("gitlab")
+	for _, secret := range []*model.SecretObject{first, second} {
+		if err := s.Create(ctx, secret); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+
+	if err := s.Create(ctx, model.NewSecretObject("github")); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("Expected ErrAlreadyExists for a duplicate name, got %v", err)
+	}
+	second.Name = "github"
+	if err := s.Update(ctx, second); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("Expected ErrAlreadyExists renaming onto a taken name, got %v", err)
+	}
+
+	// Names are matched by key when one is given
+	folded := model.NewSecretObject("GitHub")
+	folded.NameKey = "github"
+	if err := s.Create(ctx, folded); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("Expected ErrAlreadyExists for a duplicate key, got %v", err)
+	}
+
+	// A trashed secret does not hold on to its name
+	if err := s.Delete(ctx, "github"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := s.Create(ctx, model.NewSecretObject("github")); err != nil {
+		t.Errorf("Create over a trashed name failed: %v", err)
+	}
+}
+
+func TestListPrefix(t *testing.T) {
+	s := setupTestStore(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	for _, name := range []string{"work/aws/prod", "work/aws/staging", "work/aws", "work/awsx", "work/github", "personal"} {
+		if err := s.Create(ctx, model.NewSecretObject(name)); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+
+	secrets, err := s.List(ctx, &SearchOptions{Prefix: "work/aws/"})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(secrets) != 2 || secrets[0].Name != "work/aws/prod" || secrets[1].Name != "work/aws/staging" {
+		t.Errorf("List with prefix = %v, want the two secrets inside work/aws", secrets)
+	}
+
+	// The prefix is served by the name index rather than a table scan
+	query, args := listQuery(&SearchOptions{Prefix: "work/aws/"})
+	rows, err := s.db.QueryContext(ctx, "EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		t.Fatalf("EXPLAIN failed: %v", err)
+	}
+	defer rows.Close()
+	var plan []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			t.Fatal(err)
+		}
+		plan = append(plan, detail)
+	}
+	if len(plan) == 0 || !strings.Contains(plan[0], "secrets_name_key") {
+		t.Errorf("Expected the prefix to use the name index, got plan %q", plan)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := map[string]string{
+		"work/":    "work0",
+		"a":        "b",
+		"a\xff":    "b",
+		"\xff\xff": "",
+	}
+	for prefix, want := range tests {
+		if got := prefixEnd(prefix); got != want {
+			t.Errorf("prefixEnd(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+}
+
 func TestDeleteNotFound(t *testing.T) {
 	s := setupTestStore(t)
 	defer s.Close()
@@ -162,3 +260,616 @@ func setupTestStore(t *testing.T) *Store {
 
 	return s
 }
+
+func TestIsEncrypted(t *testing.T) {
+	s := setupTestStore(t)
+	defer s.Close()
+
+	secret := model.NewSecretObject("plain")
+	s.Create(context.Background(), secret)
+
+	var path string
+	s.db.QueryRow("SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&path)
+
+	encrypted, err := IsEncrypted(path)
+	if err != nil {
+		t.Fatalf("IsEncrypted failed: %v", err)
+	}
+	if encrypted {
+		t.Error("Plain SQLite database reported as encrypted")
+	}
+
+	// Anything without the SQLite header is treated as encrypted
+	other := filepath.Join(t.TempDir(), "random.db")
+	os.WriteFile(other, []byte("definitely not sqlite data"), 0600)
+	encrypted, err = IsEncrypted(other)
+	if err != nil {
+		t.Fatalf("IsEncrypted failed: %v", err)
+	}
+	if !encrypted {
+		t.Error("Non-SQLite file not reported as encrypted")
+	}
+}
+
+func TestOpenEncryptedWithoutSQLCipher(t *testing.T) {
+	if SQLCipherAvailable() {
+		t.Skip("built against SQLCipher")
+	}
+
+	key := make([]byte, 32)
+	_, err := OpenEncrypted(filepath.Join(t.TempDir(), "vault.db"), key)
+	if err != ErrSQLCipherUnavailable {
+		t.Errorf("Expected ErrSQLCipherUnavailable, got %v", err)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault.db")
+	if err := os.WriteFile(path, []byte("vault contents"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	backupPath, err := Backup(path)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	data, err := os.ReadFile(backupPath)
+	if err != nil {
+		t.Fatalf("failed to read backup: %v", err)
+	}
+	if string(data) != "vault contents" {
+		t.Errorf("Backup content mismatch: %q", data)
+	}
+
+	// A second backup within the same second gets its own file
+	second, err := Backup(path)
+	if err != nil {
+		t.Fatalf("Second Backup failed: %v", err)
+	}
+	if second == backupPath {
+		t.Errorf("Expected a distinct backup path, got %s twice", second)
+	}
+	if data, err := os.ReadFile(second); err != nil || string(data) != "vault contents" {
+		t.Errorf("Second backup = %q, %v", data, err)
+	}
+}
+
+func TestRewriteSecrets(t *testing.T) {
+	s := setupTestStore(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	secret := model.NewSecretObject("before")
+	secret.AddField(model.NewField("old", "value"))
+	if err := s.Create(ctx, secret); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	updatedAt := secret.UpdatedAt
+
+	secret.Name = "after"
+	secret.Tags = []string{"moved"}
+	secret.Fields = []model.Field{model.NewField("new", "value")}
+	if err := s.RewriteSecrets(ctx, []*model.SecretObject{secret}, nil, nil, map[string]string{"rewritten": "1"}); err != nil {
+		t.Fatalf("RewriteSecrets failed: %v", err)
+	}
+
+	got, err := s.GetByName(ctx, "after")
+	if err != nil {
+		t.Fatalf("GetByName failed: %v", err)
+	}
+	if len(got.Tags) != 1 || len(got.Fields) != 1 || got.Fields[0].Label != "new" {
+		t.Errorf("Rewrite not applied: %+v", got)
+	}
+	if got.UpdatedAt.Unix() != updatedAt.Unix() {
+		t.Errorf("UpdatedAt changed: %v != %v", got.UpdatedAt, updatedAt)
+	}
+	if value, err := s.GetMeta("rewritten"); err != nil || value != "1" {
+		t.Errorf("Metadata not set: %q, %v", value, err)
+	}
+	if err := s.Vacuum(ctx); err != nil {
+		t.Errorf("Vacuum failed: %v", err)
+	}
+}
+
+func TestUpdateKeepsVersions(t *testing.T) {
+	s := setupTestStore(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	secret := model.NewSecretObject("versioned")
+	secret.AddField(model.NewField("password", "first"))
+	if err := s.Create(ctx, secret); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	for _, value := range []string{"second", "third"} {
+		secret.Fields[0].Value = value
+		if err := s.Update(ctx, secret); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+	}
+
+	versions, err := s.Versions(ctx, secret.ID)
+	if err != nil {
+		t.Fatalf("Versions failed: %v", err)
+	}
+	if len(versions) != 3 || versions[2].Version != 3 || !versions[2].ReplacedAt.IsZero() {
+		t.Fatalf("Expected two earlier versions and the current one, got %+v", versions)
+	}
+	if versions[0].ReplacedAt.IsZero() {
+		t.Error("Expected earlier versions to record when they were replaced")
+	}
+
+	first, err := s.GetVersion(ctx, secret.ID, 1)
+	if err != nil {
+		t.Fatalf("GetVersion failed: %v", err)
+	}
+	if first.Secret.Name != "versioned" || len(first.Secret.Fields) != 1 || first.Secret.Fields[0].Value != "first" {
+		t.Errorf("Version 1 = %+v, want the state at creation", first.Secret)
+	}
+	if _, err := s.GetVersion(ctx, secret.ID, 3); err != ErrVersionNotFound {
+		t.Errorf("Expected ErrVersionNotFound for the current version, got %v", err)
+	}
+
+	if err := s.Delete(ctx, "versioned"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := s.Purge(ctx, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	all, err := s.AllVersions(ctx)
+	if err != nil {
+		t.Fatalf("AllVersions failed: %v", err)
+	}
+	if len(all) != 0 {
+		t.Errorf("Purge left %d versions behind", len(all))
+	}
+}
+
+func TestPurgeRemovesFields(t *testing.T) {
+	s := setupTestStore(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	secret := model.NewSecretObject("with-fields")
+	secret.AddField(model.NewField("data", "value"))
+	if err := s.Create(ctx, secret); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := s.Delete(ctx, "with-fields"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := s.Purge(ctx, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+
+	orphans, err := s.OrphanedFields(ctx)
+	if err != nil {
+		t.Fatalf("OrphanedFields failed: %v", err)
+	}
+	if len(orphans) != 0 {
+		t.Errorf("Purge left %d field rows behind", len(orphans))
+	}
+	var fields int
+	s.db.QueryRow("SELECT count(*) FROM fields").Scan(&fields)
+	if fields != 0 {
+		t.Errorf("Purge left %d field rows behind", fields)
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	s := setupTestStore(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	secret := model.NewSecretObject("healthy")
+	secret.AddField(model.NewField("data", "value"))
+	if err := s.Create(ctx, secret); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if problems, err := s.IntegrityCheck(ctx); err != nil || problems != nil {
+		t.Fatalf("IntegrityCheck = %v, %v", problems, err)
+	}
+
+	// Leave a field behind the way a delete used to
+	if _, err := s.db.Exec("DELETE FROM secrets WHERE id = ?", secret.ID); err != nil {
+		t.Fatal(err)
+	}
+	broken := model.NewSecretObject("broken-tags")
+	if err := s.Create(ctx, broken); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := s.db.Exec("UPDATE secrets SET tags = 'not json' WHERE id = ?", broken.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	orphans, err := s.OrphanedFields(ctx)
+	if err != nil || len(orphans) != 1 || orphans[0].SecretID != secret.ID {
+		t.Fatalf("OrphanedFields = %+v, %v", orphans, err)
+	}
+	if removed, err := s.DeleteOrphanedFields(ctx); err != nil || removed != 1 {
+		t.Errorf("DeleteOrphanedFields = %d, %v", removed, err)
+	}
+
+	ids, err := s.MalformedTags(ctx)
+	if err != nil || len(ids) != 1 || ids[0] != broken.ID {
+		t.Fatalf("MalformedTags = %v, %v", ids, err)
+	}
+	if err := s.SetTags(ctx, broken.ID, nil); err != nil {
+		t.Fatalf("SetTags failed: %v", err)
+	}
+	if ids, err := s.MalformedTags(ctx); err != nil || len(ids) != 0 {
+		t.Errorf("MalformedTags after repair = %v, %v", ids, err)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	s := setupTestStore(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	secret := model.NewSecretObject("trashed")
+	secret.AddField(model.NewField("password", "hunter2"))
+	if err := s.Create(ctx, secret); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := s.Delete(ctx, "trashed"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	// Hidden from lookups, listings, searches and counts
+	if _, err := s.GetByName(ctx, "trashed"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a trashed secret, got %v", err)
+	}
+	if list, _ := s.List(ctx, nil); len(list) != 0 {
+		t.Errorf("List returned %d trashed secrets", len(list))
+	}
+	if found, _ := s.Search(ctx, "trash", nil); len(found) != 0 {
+		t.Errorf("Search returned %d trashed secrets", len(found))
+	}
+	if secrets, fields, _ := s.Counts(ctx); secrets != 0 || fields != 0 {
+		t.Errorf("Counts = %d, %d; want the trash left out", secrets, fields)
+	}
+	if err := s.Delete(ctx, "trashed"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting twice, got %v", err)
+	}
+
+	trash, err := s.Trash(ctx)
+	if err != nil {
+		t.Fatalf("Trash failed: %v", err)
+	}
+	if len(trash) != 1 || trash[0].ID != secret.ID || trash[0].DeletedAt.IsZero() || len(trash[0].Fields) != 1 {
+		t.Fatalf("Trash = %+v, want the deleted secret with its fields", trash)
+	}
+
+	// Restoring brings it back with its fields
+	if err := s.Restore(ctx, "trashed"); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	restored, err := s.GetByName(ctx, "trashed")
+	if err != nil || len(restored.Fields) != 1 || restored.Fields[0].Value != "hunter2" {
+		t.Errorf("Restored secret = %+v, %v", restored, err)
+	}
+	if err := s.Restore(ctx, "trashed"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound restoring a live secret, got %v", err)
+	}
+
+	// A secret cannot be restored over a live one of the same name
+	if err := s.Delete(ctx, "trashed"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := s.Create(ctx, model.NewSecretObject("trashed")); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := s.Restore(ctx, "trashed"); err != ErrAlreadyExists {
+		t.Errorf("Expected ErrAlreadyExists, got %v", err)
+	}
+
+	// Purge only removes what was deleted before the cutoff
+	if n, err := s.Purge(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("Purge of old secrets = %d, %v; want 0", n, err)
+	}
+	if n, err := s.Purge(ctx, time.Now().Add(time.Minute)); err != nil || n != 1 {
+		t.Errorf("Purge = %d, %v; want 1", n, err)
+	}
+	if trash, _ := s.Trash(ctx); len(trash) != 0 {
+		t.Errorf("Purge left %d secrets in the trash", len(trash))
+	}
+	if _, err := s.GetByName(ctx, "trashed"); err != nil {
+		t.Errorf("Purge removed the live secret: %v", err)
+	}
+}
+
+func TestMigrateLegacyVault(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+
+	// A vault from before schema versioning and the trash
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE vault_meta (key TEXT PRIMARY KEY, value TEXT NOT NULL);
+    CREATE TABLE secrets (
+        id TEXT PRIMARY KEY,
+        name TEXT NOT NULL,
+        tags TEXT DEFAULT '[]',
+        notes TEXT DEFAULT '',
+        created_at TEXT NOT NULL,
+        updated_at TEXT NOT NULL
+    );
+    INSERT INTO secrets (id, name, created_at, updated_at)
+    VALUES ('1', 'old', '2024-01-01T00:00:00Z', '2024-01-01T00:00:00Z'),
+           ('2', 'old', '2024-01-02T00:00:00Z', '2024-01-02T00:00:00Z')`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	if version, _ := s.GetMeta(metaSchemaVersion); version != strconv.Itoa(len(migrations)) {
+		t.Errorf("schema_version = %q, want %d", version, len(migrations))
+	}
+	backups, _ := filepath.Glob(path + ".bak-*")
+	if len(backups) != 1 {
+		t.Errorf("Expected one backup before migrating, found %v", backups)
+	}
+
+	// Of the duplicates, the first keeps the name; the other stays listed
+	if list, err := s.List(ctx, nil); err != nil || len(list) != 2 {
+		t.Fatalf("List = %v, %v; want the existing secrets", list, err)
+	}
+	got, err := s.GetByName(ctx, "old")
+	if err != nil || got.ID != "1" {
+		t.Fatalf("GetByName = %+v, %v; want the first secret", got, err)
+	}
+	// Rotation is counted from the last update of existing secrets
+	if !got.RotatedAt.Equal(got.UpdatedAt) || got.RotateEvery != 0 || !got.ExpiresAt.IsZero() {
+		t.Errorf("Expected rotated_at to default to updated_at and no expiry, got %+v", got)
+	}
+	if err := s.Create(ctx, model.NewSecretObject("old")); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("Expected ErrAlreadyExists after migrating, got %v", err)
+	}
+	if err := s.Delete(ctx, "old"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if trash, err := s.Trash(ctx); err != nil || len(trash) != 1 {
+		t.Errorf("Trash = %v, %v; want the deleted secret", trash, err)
+	}
+}
+
+func TestMigrateNewVaultWithoutBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "new.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	s.Close()
+
+	// Reopening an up-to-date vault migrates nothing
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer s.Close()
+
+	if backups, _ := filepath.Glob(path + ".bak-*"); len(backups) != 0 {
+		t.Errorf("Expected no backup of a new vault, found %v", backups)
+	}
+}
+
+func TestOpenRejectsNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "future.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if err := s.SetMeta(metaSchemaVersion, strconv.Itoa(len(migrations)+1)); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if _, err := Open(path); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Expected ErrSchemaTooNew, got %v", err)
+	}
+}
+
+func TestMigrationRollsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	s.Close()
+
+	saved := migrations
+	t.Cleanup(func() { migrations = saved })
+	migrations = append(migrations[:len(migrations):len(migrations)], migration{"broken", func(ctx context.Context, tx *sql.Tx) error {
+		if err := addColumn(ctx, tx, "secrets", "broken", "TEXT"); err != nil {
+			return err
+		}
+		return errors.New("migration failed")
+	}})
+
+	if _, err := Open(path); err == nil {
+		t.Fatal("Expected the failing migration to fail Open")
+	}
+
+	// Neither the column nor the version bump was kept
+	migrations = saved
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer s.Close()
+	var count int
+	s.db.QueryRow("SELECT count(*) FROM pragma_table_info('secrets') WHERE name = 'broken'").Scan(&count)
+	if count != 0 {
+		t.Error("Failed migration left its column behind")
+	}
+	if version, _ := s.GetMeta(metaSchemaVersion); version != strconv.Itoa(len(saved)) {
+		t.Errorf("schema_version = %q, want %d", version, len(saved))
+	}
+}
+
+func TestAttachments(t *testing.T) {
+	s := setupTestStore(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	secret := model.NewSecretObject("server")
+	if err := s.Create(ctx, secret); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	chunks := func(data ...string) func() ([]byte, error) {
+		return func() ([]byte, error) {
+			if len(data) == 0 {
+				return nil, io.EOF
+			}
+			chunk := []byte(data[0])
+			data = data[1:]
+			return chunk, nil
+		}
+	}
+	a := &StoredAttachment{
+		Attachment: model.Attachment{ID: "a1", SecretID: secret.ID, Name: "id_rsa", Size: 6, CreatedAt: time.Now()},
+		NameKey:    "key-1",
+		Cipher:     "aes-256-gcm",
+	}
+	if err := s.CreateAttachment(ctx, a, chunks("ab", "cd", "ef")); err != nil {
+		t.Fatalf("CreateAttachment failed: %v", err)
+	}
+
+	got, err := s.GetAttachment(ctx, secret.ID, "key-1")
+	if err != nil {
+		t.Fatalf("GetAttachment failed: %v", err)
+	}
+	if got.Name != "id_rsa" || got.Size != 6 || got.Cipher != "aes-256-gcm" {
+		t.Errorf("GetAttachment = %+v", got)
+	}
+
+	var content []string
+	var lasts []bool
+	err = s.ReadAttachment(ctx, "a1", func(chunk []byte, last bool) error {
+		content = append(content, string(chunk))
+		lasts = append(lasts, last)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadAttachment failed: %v", err)
+	}
+	if strings.Join(content, ",") != "ab,cd,ef" || lasts[0] || lasts[1] || !lasts[2] {
+		t.Errorf("ReadAttachment = %q, last flags %v", content, lasts)
+	}
+
+	// A second attachment with the same key rolls back, chunks included
+	dup := &StoredAttachment{Attachment: model.Attachment{ID: "a2", SecretID: secret.ID}, NameKey: "key-1"}
+	if err := s.CreateAttachment(ctx, dup, chunks("x")); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("Expected ErrAlreadyExists for a duplicate name key, got %v", err)
+	}
+	// A failing source stores nothing either
+	failing := &StoredAttachment{Attachment: model.Attachment{ID: "a3", SecretID: secret.ID}, NameKey: "key-3"}
+	err = s.CreateAttachment(ctx, failing, func() ([]byte, error) { return nil, errors.New("read failed") })
+	if err == nil {
+		t.Error("Expected the error of the chunk source")
+	}
+	var chunkRows int
+	s.db.QueryRow("SELECT count(*) FROM attachment_chunks WHERE attachment_id != 'a1'").Scan(&chunkRows)
+	if chunkRows != 0 {
+		t.Errorf("Failed attachments left %d chunk rows behind", chunkRows)
+	}
+
+	orphan := &StoredAttachment{Attachment: model.Attachment{ID: "a4", SecretID: "missing"}, NameKey: "key-4"}
+	if err := s.CreateAttachment(ctx, orphan, chunks("x")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing secret, got %v", err)
+	}
+
+	if err := s.Delete(ctx, "server"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if list, _ := s.Attachments(ctx, secret.ID); len(list) != 1 {
+		t.Errorf("Expected the attachment to stay with the trashed secret, got %d", len(list))
+	}
+	if _, err := s.Purge(ctx, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	var attachments int
+	s.db.QueryRow("SELECT count(*) FROM attachments").Scan(&attachments)
+	s.db.QueryRow("SELECT count(*) FROM attachment_chunks").Scan(&chunkRows)
+	if attachments != 0 || chunkRows != 0 {
+		t.Errorf("Purge left %d attachments and %d chunk rows behind", attachments, chunkRows)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	s := setupTestStore(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	expires := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	rotated := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	secret := model.NewSecretObject("api-key")
+	secret.ExpiresAt = expires
+	secret.RotateEvery = model.Period(90 * 24 * time.Hour)
+	secret.RotatedAt = rotated
+	if err := s.Create(ctx, secret); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := s.Create(ctx, model.NewSecretObject("plain")); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	check := func(what string, got *model.SecretObject) {
+		t.Helper()
+		if !got.ExpiresAt.Equal(expires) || got.RotateEvery != secret.RotateEvery || !got.RotatedAt.Equal(rotated) {
+			t.Errorf("%s: expires %v, rotate %v, rotated %v", what, got.ExpiresAt, got.RotateEvery, got.RotatedAt)
+		}
+	}
+	got, err := s.GetByName(ctx, "api-key")
+	if err != nil {
+		t.Fatalf("GetByName failed: %v", err)
+	}
+	check("GetByName", got)
+	if got, err = s.GetByID(ctx, secret.ID); err != nil {
+		t.Fatalf("GetByID failed: %v", err)
+	}
+	check("GetByID", got)
+	list, err := s.List(ctx, nil)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("List = %v, %v", list, err)
+	}
+	for _, l := range list {
+		if l.Name == "plain" && (!l.ExpiresAt.IsZero() || l.RotateEvery != 0 || !l.RotatedAt.IsZero()) {
+			t.Errorf("Expected no lifecycle on plain, got %+v", l)
+		}
+	}
+
+	// Clearing both is stored as such
+	got.ExpiresAt, got.RotateEvery = time.Time{}, 0
+	if err := s.Update(ctx, got); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if got, err = s.GetByName(ctx, "api-key"); err != nil {
+		t.Fatalf("GetByName failed: %v", err)
+	}
+	if !got.ExpiresAt.IsZero() || got.RotateEvery != 0 || !got.RotatedAt.Equal(rotated) {
+		t.Errorf("Expected expiry and rotation cleared, got %+v", got)
+	}
+
+	if err := s.Delete(ctx, "api-key"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := s.GetByID(ctx, secret.ID); err != nil {
+		t.Errorf("Expected GetByID to find a secret in the trash, got %v", err)
+	}
+	if _, err := s.GetByID(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/TheEditor/keyp/internal/model"
+)
+
+// Trash returns the secrets in the trash with their fields, most recently
+// deleted first
+func (s *Store) Trash(ctx context.Context) ([]*model.SecretObject, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+secretColumns+", deleted_at FROM secrets WHERE deleted_at IS NOT NULL",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var secrets []*model.SecretObject
+	for rows.Next() {
+		var deletedAt string
+		secret, err := scanSecret(rows, &deletedAt)
+		if err != nil {
+			return nil, err
+		}
+		secret.DeletedAt, _ = time.Parse(time.RFC3339, deletedAt)
+		secrets = append(secrets, secret)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Timestamps carry their zone offset, so they are ordered here rather
+	// than as text
+	sort.SliceStable(secrets, func(i, j int) bool {
+		return secrets[i].DeletedAt.After(secrets[j].DeletedAt)
+	})
+	if err := s.loadFields(ctx, secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// Restore takes the most recently deleted secret with the given name key out
+// of the trash. It fails with ErrAlreadyExists if a secret outside the trash
+// has taken the name since.
+func (s *Store) Restore(ctx context.Context, key string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var live int
+	err = tx.QueryRowContext(ctx, "SELECT count(*) FROM secrets WHERE name_key = ? AND deleted_at IS NULL", key).Scan(&live)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, deleted_at FROM secrets WHERE name_key = ? AND deleted_at IS NOT NULL", key)
+	if err != nil {
+		return err
+	}
+	var id string
+	var latest time.Time
+	for rows.Next() {
+		var candidate, deletedAt string
+		if err := rows.Scan(&candidate, &deletedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		deleted, _ := time.Parse(time.RFC3339, deletedAt)
+		if id == "" || deleted.After(latest) {
+			id, latest = candidate, deleted
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if id == "" {
+		return ErrNotFound
+	}
+	if live > 0 {
+		return ErrAlreadyExists
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE secrets SET deleted_at = NULL WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Purge permanently removes the secrets moved to the trash before the given
+// time, with their fields, history and attachments, and returns how many
+// were removed
+func (s *Store) Purge(ctx context.Context, before time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, deleted_at FROM secrets WHERE deleted_at IS NOT NULL")
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id, deletedAt string
+		if err := rows.Scan(&id, &deletedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		deleted, _ := time.Parse(time.RFC3339, deletedAt)
+		if deleted.Before(before) {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		// Foreign keys are not enforced, so the cascade has to be done by hand
+		_, err := tx.ExecContext(ctx,
+			"DELETE FROM attachment_chunks WHERE attachment_id IN (SELECT id FROM attachments WHERE secret_id = ?)", id,
+		)
+		if err != nil {
+			return 0, err
+		}
+		for _, table := range []string{"fields", "secret_versions", "attachments"} {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE secret_id = ?", id); err != nil {
+				return 0, err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM secrets WHERE id = ?", id); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
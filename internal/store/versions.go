@@ -0,0 +1,185 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/TheEditor/keyp/internal/model"
+)
+
+// ErrVersionNotFound is returned when a secret has no version with the
+// requested number
+var ErrVersionNotFound = errors.New("version not found")
+
+// VersionInfo describes a state of a secret: an earlier one kept in its
+// history, or the current one, which has no ReplacedAt
+type VersionInfo struct {
+	Version    int       `json:"version"`
+	UpdatedAt  time.Time `json:"updated_at"`           // When the state was saved
+	ReplacedAt time.Time `json:"replaced_at,omitzero"` // When a later state replaced it
+}
+
+// Version is an earlier state of a secret, exactly as it was stored
+type Version struct {
+	VersionInfo
+	Secret *model.SecretObject
+}
+
+// archiveVersion copies the stored state of a secret, fields included,
+// into its history as the next version number
+func archiveVersion(ctx context.Context, tx *sql.Tx, secretID string) error {
+	row := tx.QueryRowContext(ctx,
+		"SELECT id, name, tags, notes, created_at, updated_at FROM secrets WHERE id = ?",
+		secretID,
+	)
+	var secret model.SecretObject
+	var tags, createdAt, updatedAt string
+	err := row.Scan(&secret.ID, &secret.Name, &tags, &secret.Notes, &createdAt, &updatedAt)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	fields, err := queryFields(ctx, tx, secretID)
+	if err != nil {
+		return err
+	}
+	fieldsJSON, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	var number int
+	err = tx.QueryRowContext(ctx,
+		"SELECT COALESCE(MAX(version), 0) + 1 FROM secret_versions WHERE secret_id = ?",
+		secretID,
+	).Scan(&number)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO secret_versions (secret_id, version, name, tags, notes, fields, created_at, updated_at, replaced_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		secretID, number, secret.Name, tags, secret.Notes, string(fieldsJSON),
+		createdAt, updatedAt, time.Now().Format(time.RFC3339),
+	)
+	return err
+}
+
+// Versions lists the history of a secret, oldest first, followed by its
+// current state
+func (s *Store) Versions(ctx context.Context, secretID string) ([]VersionInfo, error) {
+	var updatedAt string
+	err := s.db.QueryRowContext(ctx, "SELECT updated_at FROM secrets WHERE id = ?", secretID).Scan(&updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT version, updated_at, replaced_at FROM secret_versions WHERE secret_id = ? ORDER BY version",
+		secretID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []VersionInfo
+	for rows.Next() {
+		var v VersionInfo
+		var updated, replaced string
+		if err := rows.Scan(&v.Version, &updated, &replaced); err != nil {
+			return nil, err
+		}
+		v.UpdatedAt, _ = time.Parse(time.RFC3339, updated)
+		v.ReplacedAt, _ = time.Parse(time.RFC3339, replaced)
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	current := VersionInfo{Version: 1}
+	if len(versions) > 0 {
+		current.Version = versions[len(versions)-1].Version + 1
+	}
+	current.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+	return append(versions, current), nil
+}
+
+// GetVersion returns an earlier state of a secret as it was stored
+func (s *Store) GetVersion(ctx context.Context, secretID string, number int) (*Version, error) {
+	row := s.db.QueryRowContext(ctx,
+		"SELECT secret_id, version, name, tags, notes, fields, created_at, updated_at, replaced_at FROM secret_versions WHERE secret_id = ? AND version = ?",
+		secretID, number,
+	)
+	v, err := scanVersion(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrVersionNotFound
+	}
+	return v, err
+}
+
+// AllVersions returns the history of every secret as it is stored
+func (s *Store) AllVersions(ctx context.Context) ([]*Version, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT secret_id, version, name, tags, notes, fields, created_at, updated_at, replaced_at FROM secret_versions ORDER BY secret_id, version",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []*Version
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// scanVersion reads a secret_versions row
+func scanVersion(row interface{ Scan(...any) error }) (*Version, error) {
+	secret := &model.SecretObject{}
+	v := &Version{Secret: secret}
+	var tags, fields, createdAt, updatedAt, replacedAt string
+	err := row.Scan(&secret.ID, &v.Version, &secret.Name, &tags, &secret.Notes, &fields, &createdAt, &updatedAt, &replacedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(fields), &secret.Fields); err != nil {
+		return nil, err
+	}
+
+	secret.Tags = model.ParseTags(tags)
+	secret.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	secret.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+	v.UpdatedAt = secret.UpdatedAt
+	v.ReplacedAt, _ = time.Parse(time.RFC3339, replacedAt)
+	return v, nil
+}
+
+// rewriteVersion replaces the stored name, tags, notes and fields of a
+// version, leaving its timestamps as they are
+func rewriteVersion(ctx context.Context, tx *sql.Tx, v *Version) error {
+	fields, err := json.Marshal(v.Secret.Fields)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE secret_versions SET name = ?, tags = ?, notes = ?, fields = ? WHERE secret_id = ? AND version = ?",
+		v.Secret.Name, v.Secret.TagsJSON(), v.Secret.Notes, string(fields), v.Secret.ID, v.Version,
+	)
+	return err
+}
@@ -0,0 +1,276 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/TheEditor/keyp/internal/core"
+	"github.com/TheEditor/keyp/internal/model"
+	"github.com/TheEditor/keyp/internal/store"
+	"github.com/google/uuid"
+)
+
+// MaxAttachmentSize limits the content of a single attachment. Attachments
+// are meant for keys, certificates and recovery documents, not backups.
+const MaxAttachmentSize = 16 << 20
+
+var (
+	// ErrAttachmentNotFound is returned when a secret has no attachment with the requested name
+	ErrAttachmentNotFound = errors.New("attachment not found")
+
+	// ErrAttachmentExists is returned when a secret already has an attachment with the name
+	ErrAttachmentExists = errors.New("attachment already exists")
+
+	// ErrAttachmentTooLarge is returned for content over MaxAttachmentSize
+	ErrAttachmentTooLarge = fmt.Errorf("attachment is larger than %d MiB", MaxAttachmentSize>>20)
+)
+
+// attachmentNameAAD binds a sealed attachment name to its secret and attachment
+func attachmentNameAAD(secretID, attachmentID string) []byte {
+	return []byte("keyp-attachment-name-v1\x00" + secretID + "\x00" + attachmentID)
+}
+
+// attachmentAAD binds the content chunks of an attachment to its secret and
+// attachment; core.StreamSealer adds the position of each chunk
+func attachmentAAD(secretID, attachmentID string) []byte {
+	return []byte("keyp-attachment-v1\x00" + secretID + "\x00" + attachmentID)
+}
+
+// attachmentKey returns the key an attachment name is matched by within its
+// secret. Names are always sealed, with or without encrypted metadata, so
+// turning that on has no attachments to rewrite.
+func (v *Vault) attachmentKey(secretID, name string) (string, error) {
+	return v.blindIndex(indexAttachment, secretID+"\x00"+name)
+}
+
+// Attach stores the content read from r as an attachment of the secret
+// with the given name. The content is sealed in chunks as it is read, so
+// it is never held in memory whole; nothing is stored if reading fails or
+// the content is over MaxAttachmentSize.
+func (v *Vault) Attach(ctx context.Context, name, file string, r io.Reader) (*model.Attachment, error) {
+	if v.IsLocked() {
+		return nil, ErrLocked
+	}
+	if err := model.ValidateAttachmentName(file); err != nil {
+		return nil, err
+	}
+	secret, err := v.storedSecret(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &store.StoredAttachment{
+		Attachment: model.Attachment{
+			ID:        uuid.New().String(),
+			SecretID:  secret.ID,
+			CreatedAt: time.Now(),
+		},
+		Cipher: v.cipherName(),
+	}
+	if a.NameKey, err = v.attachmentKey(secret.ID, file); err != nil {
+		return nil, err
+	}
+	if a.Name, err = v.encrypt(file, attachmentNameAAD(secret.ID, a.ID)); err != nil {
+		return nil, err
+	}
+	sealer, err := core.NewStreamSealer(a.Cipher, v.key.Bytes(), attachmentAAD(secret.ID, a.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	// Reading one byte past the limit tells content at the limit from more
+	chunks := &chunkReader{r: io.LimitReader(r, MaxAttachmentSize+1)}
+	done := false
+	next := func() ([]byte, error) {
+		if done {
+			return nil, io.EOF
+		}
+		chunk, last, err := chunks.next()
+		if err != nil {
+			return nil, err
+		}
+		defer core.Wipe(chunk)
+		if a.Size += int64(len(chunk)); a.Size > MaxAttachmentSize {
+			return nil, ErrAttachmentTooLarge
+		}
+		done = last
+		return sealer.Seal(chunk, last)
+	}
+
+	err = v.store.CreateAttachment(ctx, a, next)
+	if errors.Is(err, store.ErrAlreadyExists) {
+		return nil, fmt.Errorf("%w: '%s'", ErrAttachmentExists, file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	attachment := a.Attachment
+	attachment.Name = file
+	return &attachment, nil
+}
+
+// Attachments returns the attachments of the secret with the given name,
+// ordered by name
+func (v *Vault) Attachments(ctx context.Context, name string) ([]*model.Attachment, error) {
+	if v.IsLocked() {
+		return nil, ErrLocked
+	}
+	secret, err := v.storedSecret(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := v.store.Attachments(ctx, secret.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	attachments := make([]*model.Attachment, len(stored))
+	for i, a := range stored {
+		if attachments[i], err = v.openAttachment(a); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(attachments, func(i, j int) bool { return attachments[i].Name < attachments[j].Name })
+	return attachments, nil
+}
+
+// Attachment returns an attachment of the secret with the given name,
+// without its content
+func (v *Vault) Attachment(ctx context.Context, name, file string) (*model.Attachment, error) {
+	if v.IsLocked() {
+		return nil, ErrLocked
+	}
+	stored, err := v.storedAttachment(ctx, name, file)
+	if err != nil {
+		return nil, err
+	}
+	return v.openAttachment(stored)
+}
+
+// Extract decrypts the content of an attachment to w one chunk at a time,
+// each checked before it is written. If the content turns out to be
+// corrupted, what was written before is intact but incomplete.
+func (v *Vault) Extract(ctx context.Context, name, file string, w io.Writer) (*model.Attachment, error) {
+	if v.IsLocked() {
+		return nil, ErrLocked
+	}
+	stored, err := v.storedAttachment(ctx, name, file)
+	if err != nil {
+		return nil, err
+	}
+	attachment, err := v.openAttachment(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	opener, err := core.NewStreamOpener(stored.Cipher, v.key.Bytes(), attachmentAAD(stored.SecretID, stored.ID))
+	if err != nil {
+		return nil, err
+	}
+	err = v.store.ReadAttachment(ctx, stored.ID, func(chunk []byte, last bool) error {
+		plaintext, err := opener.Open(chunk, last)
+		if err != nil {
+			return fmt.Errorf("attachment '%s' is corrupted: %w", file, err)
+		}
+		defer core.Wipe(plaintext)
+		_, err = w.Write(plaintext)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := opener.Close(); err != nil {
+		return nil, fmt.Errorf("attachment '%s' is corrupted: %w", file, err)
+	}
+	return attachment, nil
+}
+
+// Detach removes an attachment from the secret with the given name
+func (v *Vault) Detach(ctx context.Context, name, file string) error {
+	if v.IsLocked() {
+		return ErrLocked
+	}
+	stored, err := v.storedAttachment(ctx, name, file)
+	if err != nil {
+		return err
+	}
+	return v.store.DeleteAttachment(ctx, stored.ID)
+}
+
+// storedAttachment looks up an attachment by the names of its secret and
+// itself
+func (v *Vault) storedAttachment(ctx context.Context, name, file string) (*store.StoredAttachment, error) {
+	secret, err := v.storedSecret(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	key, err := v.attachmentKey(secret.ID, file)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := v.store.GetAttachment(ctx, secret.ID, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrAttachmentNotFound
+	}
+	return stored, err
+}
+
+// openAttachment decrypts the name of a stored attachment
+func (v *Vault) openAttachment(stored *store.StoredAttachment) (*model.Attachment, error) {
+	name, err := decryptWithKey(v.key.Bytes(), stored.Name, attachmentNameAAD(stored.SecretID, stored.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt attachment name: %w", err)
+	}
+	attachment := stored.Attachment
+	attachment.Name = name
+	return &attachment, nil
+}
+
+// chunkReader splits a stream into chunks of core.StreamChunkSize, reading
+// one chunk ahead to tell which is the last
+type chunkReader struct {
+	r       io.Reader
+	ahead   []byte
+	eof     bool
+	started bool
+}
+
+// next returns the next chunk and whether it is the last. Empty content
+// is a single empty chunk.
+func (c *chunkReader) next() ([]byte, bool, error) {
+	var err error
+	if !c.started {
+		c.started = true
+		if c.ahead, c.eof, err = readChunk(c.r); err != nil {
+			return nil, false, err
+		}
+	}
+	chunk := c.ahead
+	if c.eof {
+		return chunk, true, nil
+	}
+	if c.ahead, c.eof, err = readChunk(c.r); err != nil {
+		return nil, false, err
+	}
+	// A full chunk followed by nothing is the last one
+	return chunk, c.eof && len(c.ahead) == 0, nil
+}
+
+// readChunk reads up to a chunk from r and reports whether r is exhausted
+func readChunk(r io.Reader) ([]byte, bool, error) {
+	buf := make([]byte, core.StreamChunkSize)
+	n, err := io.ReadFull(r, buf)
+	switch err {
+	case nil:
+		return buf, false, nil
+	case io.EOF, io.ErrUnexpectedEOF:
+		return buf[:n], true, nil
+	default:
+		return nil, false, err
+	}
+}
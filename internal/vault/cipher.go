@@ -0,0 +1,37 @@
+package vault
+
+import (
+	"github.com/TheEditor/keyp/internal/core"
+)
+
+// metaCipher holds the cipher used for new writes
+const metaCipher = "cipher"
+
+// cipherName returns the cipher used for new writes
+func (v *Vault) cipherName() string {
+	if v.cipher == "" {
+		return core.DefaultCipher
+	}
+	return v.cipher
+}
+
+// Cipher returns the cipher used to encrypt new and updated values
+func (v *Vault) Cipher() string {
+	return v.cipherName()
+}
+
+// SetCipher selects the cipher for values written from now on. Existing
+// values keep the cipher they were written with and remain readable.
+func (v *Vault) SetCipher(name string) error {
+	if v.IsLocked() {
+		return ErrLocked
+	}
+	if err := core.ValidateCipher(name); err != nil {
+		return err
+	}
+	if err := v.store.SetMeta(metaCipher, name); err != nil {
+		return err
+	}
+	v.cipher = name
+	return nil
+}
@@ -0,0 +1,424 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/TheEditor/keyp/internal/core"
+	"github.com/TheEditor/keyp/internal/model"
+	"github.com/TheEditor/keyp/internal/store"
+)
+
+// Checks run by Doctor
+const (
+	CheckIntegrity      = "integrity"
+	CheckVerify         = "verify"
+	CheckDecrypt        = "decrypt"
+	CheckOrphanedFields = "orphaned_fields"
+	CheckDuplicateNames = "duplicate_names"
+	CheckTags           = "tags"
+)
+
+// errAttachmentCorrupted is reported for attachment content that does not decrypt
+var errAttachmentCorrupted = errors.New("attachment content does not decrypt with the vault key")
+
+// Problem is a single finding of Doctor
+type Problem struct {
+	Check      string `json:"check"`
+	Message    string `json:"message"`
+	SecretID   string `json:"secret_id,omitempty"`
+	Secret     string `json:"secret,omitempty"`
+	FieldID    string `json:"field_id,omitempty"`
+	Field      string `json:"field,omitempty"`
+	Version    int    `json:"version,omitempty"`    // Past version of the secret the problem is in
+	Attachment string `json:"attachment,omitempty"` // Name, or ID if the name does not decrypt
+	Repairable bool   `json:"repairable"`
+	Repaired   bool   `json:"repaired"`
+}
+
+// DoctorReport lists the checks Doctor ran and the problems it found
+type DoctorReport struct {
+	Checks   []string  `json:"checks"`
+	Problems []Problem `json:"problems"`
+	Backup   string    `json:"backup,omitempty"`
+}
+
+// Healthy reports whether every problem found was repaired
+func (r *DoctorReport) Healthy() bool {
+	for _, p := range r.Problems {
+		if !p.Repaired {
+			return false
+		}
+	}
+	return true
+}
+
+// Doctor checks the integrity of the vault: the SQLite file itself, the
+// verification value, every encrypted field of the secrets, the trash and
+// their history, every attachment, and rows left inconsistent by older
+// versions. With repair, a verified backup is taken first and the
+// problems marked repairable are fixed; the backup path is in the report.
+func (v *Vault) Doctor(ctx context.Context, repair bool) (*DoctorReport, error) {
+	if v.IsLocked() {
+		return nil, ErrLocked
+	}
+	report := &DoctorReport{
+		Checks:   []string{CheckIntegrity, CheckVerify, CheckDecrypt, CheckDuplicateNames, CheckTags, CheckOrphanedFields},
+		Problems: []Problem{},
+	}
+
+	issues, err := v.store.IntegrityCheck(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run integrity check: %w", err)
+	}
+	for _, issue := range issues {
+		report.Problems = append(report.Problems, Problem{Check: CheckIntegrity, Message: issue})
+	}
+
+	if err := v.verifyKey(); err != nil {
+		report.Problems = append(report.Problems, Problem{
+			Check:   CheckVerify,
+			Message: "verification value does not decrypt with the vault key",
+		})
+	}
+
+	secrets, err := v.store.List(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets: %w", err)
+	}
+	trashed, err := v.store.Trash(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trash: %w", err)
+	}
+
+	// Tags revealed from sealed metadata, to rebuild a broken tag index
+	sealedTags := make(map[string][]string)
+	names := make(map[string]string, len(secrets)+len(trashed))
+
+	// Secrets in the trash must still decrypt to be restored, but may share
+	// a name with a live secret
+	for _, s := range append(secrets, trashed...) {
+		problems, tags := v.checkSecret(s)
+		report.Problems = append(report.Problems, problems...)
+		names[s.ID] = s.Name
+		if tags != nil {
+			sealedTags[s.ID] = tags
+		}
+
+		problems, err := v.checkAttachments(ctx, s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read attachments: %w", err)
+		}
+		report.Problems = append(report.Problems, problems...)
+	}
+
+	versions, err := v.store.AllVersions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	for _, version := range versions {
+		problems, _ := v.checkSecret(version.Secret)
+		for i := range problems {
+			problems[i].Version = version.Version
+			if problems[i].Secret == "" {
+				problems[i].Secret = names[version.Secret.ID]
+			}
+		}
+		report.Problems = append(report.Problems, problems...)
+	}
+
+	// Live secrets by the key their name should be found by. Vaults from
+	// before names were unique may hold several under one name, of which
+	// only one can be found; the others have their key suffixed.
+	var keys []string
+	byKey := make(map[string][]*model.SecretObject)
+	for _, s := range secrets {
+		if s.Name == "" {
+			continue // Metadata did not decrypt
+		}
+		key, err := v.nameKey(s.Name)
+		if err != nil {
+			return nil, err
+		}
+		if byKey[key] == nil {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], s)
+	}
+	duplicates := make(map[string][]*model.SecretObject)
+	for _, key := range keys {
+		group := byKey[key]
+		if len(group) == 1 && group[0].NameKey == key {
+			continue
+		}
+		p := Problem{Check: CheckDuplicateNames, Secret: group[0].Name, Repairable: true}
+		if len(group) == 1 {
+			p.Message = "secret cannot be found by its name"
+			p.SecretID = group[0].ID
+		} else {
+			ids := make([]string, len(group))
+			for i, s := range group {
+				ids[i] = s.ID
+			}
+			p.Message = fmt.Sprintf("%d secrets share this name: %s", len(ids), strings.Join(ids, ", "))
+		}
+		duplicates[key] = group
+		report.Problems = append(report.Problems, p)
+	}
+
+	malformed, err := v.store.MalformedTags(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check tags: %w", err)
+	}
+	for _, id := range malformed {
+		report.Problems = append(report.Problems, Problem{
+			Check:      CheckTags,
+			Message:    "tags are not a JSON array of strings",
+			SecretID:   id,
+			Secret:     names[id],
+			Repairable: true,
+		})
+	}
+
+	orphans, err := v.store.OrphanedFields(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check fields: %w", err)
+	}
+	for _, f := range orphans {
+		p := Problem{
+			Check:      CheckOrphanedFields,
+			Message:    "field belongs to a secret that no longer exists",
+			SecretID:   f.SecretID,
+			FieldID:    f.ID,
+			Repairable: true,
+		}
+		if !v.metadataEncrypted {
+			p.Field = f.Label
+		}
+		report.Problems = append(report.Problems, p)
+	}
+
+	if repair {
+		if err := v.repair(ctx, report, sealedTags, duplicates); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// checkSecret tries to decrypt the metadata and every encrypted field of a
+// stored secret, replacing its name with the plaintext one when it can. With
+// encrypted metadata it also returns the sealed tags.
+func (v *Vault) checkSecret(s *model.SecretObject) ([]Problem, []string) {
+	var tags []string
+	if v.metadataEncrypted {
+		if err := v.revealMetadata(s); err != nil {
+			s.Name = ""
+			return []Problem{{Check: CheckDecrypt, Message: err.Error(), SecretID: s.ID}}, nil
+		}
+		tags = s.Tags
+	}
+
+	var problems []Problem
+	for _, f := range s.Fields {
+		if !f.Sensitive && !v.metadataEncrypted {
+			continue
+		}
+		if err := v.checkField(s.ID, f); err != nil {
+			problems = append(problems, Problem{
+				Check:    CheckDecrypt,
+				Message:  err.Error(),
+				SecretID: s.ID,
+				Secret:   s.Name,
+				FieldID:  f.ID,
+				Field:    f.Label,
+			})
+		}
+	}
+	return problems, tags
+}
+
+// checkField decrypts a field value and discards the plaintext
+func (v *Vault) checkField(secretID string, f model.Field) error {
+	encrypted, ok := strings.CutPrefix(f.Value, fieldCipherPrefix)
+	if !ok {
+		return errUnboundField
+	}
+	plaintext, err := core.Open(v.key.Bytes(), encrypted, fieldAAD(secretID, f))
+	if errors.Is(err, core.ErrDecrypt) {
+		return errors.New("field value does not decrypt with the vault key")
+	}
+	if err != nil {
+		return err
+	}
+	core.Wipe(plaintext)
+	return nil
+}
+
+// checkAttachments decrypts the name and every chunk of each attachment of
+// a stored secret, discarding the plaintext
+func (v *Vault) checkAttachments(ctx context.Context, s *model.SecretObject) ([]Problem, error) {
+	stored, err := v.store.Attachments(ctx, s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []Problem
+	for _, a := range stored {
+		p := Problem{Check: CheckDecrypt, SecretID: s.ID, Secret: s.Name, Attachment: a.ID}
+		attachment, err := v.openAttachment(a)
+		if err != nil {
+			p.Message = "attachment name does not decrypt with the vault key"
+			problems = append(problems, p)
+			continue
+		}
+		p.Attachment = attachment.Name
+
+		opener, err := core.NewStreamOpener(a.Cipher, v.key.Bytes(), attachmentAAD(a.SecretID, a.ID))
+		if err != nil {
+			p.Message = err.Error()
+			problems = append(problems, p)
+			continue
+		}
+		err = v.store.ReadAttachment(ctx, a.ID, func(chunk []byte, last bool) error {
+			plaintext, err := opener.Open(chunk, last)
+			if err != nil {
+				return errAttachmentCorrupted
+			}
+			core.Wipe(plaintext)
+			return nil
+		})
+		if err != nil && err != errAttachmentCorrupted {
+			return nil, err
+		}
+		if err == nil && opener.Close() != nil {
+			err = errors.New("attachment content is truncated")
+		}
+		if err != nil {
+			p.Message = err.Error()
+			problems = append(problems, p)
+		}
+	}
+	return problems, nil
+}
+
+// repair backs up the vault and fixes the repairable problems in report
+func (v *Vault) repair(ctx context.Context, report *DoctorReport, sealedTags map[string][]string, duplicates map[string][]*model.SecretObject) error {
+	needed := false
+	for _, p := range report.Problems {
+		needed = needed || p.Repairable
+	}
+	if !needed {
+		return nil
+	}
+
+	backupPath, err := store.Backup(v.path)
+	if err != nil {
+		return err
+	}
+	report.Backup = backupPath
+
+	for i := range report.Problems {
+		p := &report.Problems[i]
+		if p.Check != CheckTags {
+			continue
+		}
+		// Tags in a broken column are lost unless they were also sealed
+		tags, err := v.tagIndexes(sealedTags[p.SecretID])
+		if err != nil {
+			return err
+		}
+		if err := v.store.SetTags(ctx, p.SecretID, tags); err != nil {
+			return fmt.Errorf("failed to repair tags: %w", err)
+		}
+		p.Repaired = true
+	}
+
+	if err := v.repairNames(ctx, duplicates); err != nil {
+		return fmt.Errorf("failed to repair names: %w", err)
+	}
+	for i := range report.Problems {
+		if report.Problems[i].Check == CheckDuplicateNames {
+			report.Problems[i].Repaired = true
+		}
+	}
+
+	if _, err := v.store.DeleteOrphanedFields(ctx); err != nil {
+		return fmt.Errorf("failed to remove orphaned fields: %w", err)
+	}
+	for i := range report.Problems {
+		if report.Problems[i].Check == CheckOrphanedFields {
+			report.Problems[i].Repaired = true
+		}
+	}
+	return nil
+}
+
+// repairNames makes every secret in duplicates findable by name. In each
+// group the secret that holds the key keeps its name, or else the first one
+// does; the others are renamed with a numeric suffix.
+func (v *Vault) repairNames(ctx context.Context, duplicates map[string][]*model.SecretObject) error {
+	for key, group := range duplicates {
+		keeper := 0
+		for i, s := range group {
+			if s.NameKey == key {
+				keeper = i
+				break
+			}
+		}
+		if group[keeper].NameKey != key {
+			if err := v.store.SetNameKeys(ctx, map[string]string{group[keeper].ID: key}, nil); err != nil {
+				return err
+			}
+		}
+
+		n := 2
+		for i, s := range group {
+			if i == keeper {
+				continue
+			}
+			name, err := v.freeName(ctx, s.Name, &n)
+			if err != nil {
+				return err
+			}
+			if err := v.renameStored(ctx, s, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// freeName returns the first of name-n, name-(n+1), ... that no secret
+// outside the trash is using, and advances n past it
+func (v *Vault) freeName(ctx context.Context, name string, n *int) (string, error) {
+	for ; ; *n++ {
+		candidate := fmt.Sprintf("%s-%d", name, *n)
+		_, err := v.storedSecret(ctx, candidate)
+		if errors.Is(err, store.ErrNotFound) {
+			*n++
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// tagIndexes returns what the tags column holds for tags
+func (v *Vault) tagIndexes(tags []string) ([]string, error) {
+	if !v.metadataEncrypted {
+		return tags, nil
+	}
+	indexes := make([]string, len(tags))
+	for i, tag := range tags {
+		index, err := v.blindIndex(indexTag, tag)
+		if err != nil {
+			return nil, err
+		}
+		indexes[i] = index
+	}
+	return indexes, nil
+}
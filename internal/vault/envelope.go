@@ -0,0 +1,64 @@
+package vault
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/TheEditor/keyp/internal/core"
+	"github.com/TheEditor/keyp/internal/store"
+)
+
+// metaWrappedKey holds the data key encrypted with the password key
+const metaWrappedKey = "wrapped_key"
+
+// newDataKey generates a random data-encryption key in locked memory
+func newDataKey() (*core.SecretBuffer, error) {
+	key, err := core.NewSecretBuffer(core.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(key.Bytes()); err != nil {
+		key.Destroy()
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	return key, nil
+}
+
+// wrapKey encrypts a data key with a key-encryption key
+func wrapKey(kek, key []byte) (string, error) {
+	wrapped, err := core.Seal(core.DefaultCipher, kek, key, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	return wrapped, nil
+}
+
+// unwrapKey decrypts a data key into locked memory; a wrong key-encryption
+// key yields ErrInvalidPassword
+func unwrapKey(kek []byte, wrapped string) (*core.SecretBuffer, error) {
+	key, err := core.Open(kek, wrapped, nil)
+	if err != nil {
+		return nil, store.ErrInvalidPassword
+	}
+	if len(key) != core.KeySize {
+		core.Wipe(key)
+		return nil, fmt.Errorf("corrupted vault metadata: wrapped key has wrong size")
+	}
+	return core.SecretBufferFrom(key)
+}
+
+// convertToEnvelope moves a vault whose fields are encrypted with the
+// password key onto a random data key wrapped by that password key
+func (v *Vault) convertToEnvelope(ctx context.Context) error {
+	key, err := newDataKey()
+	if err != nil {
+		return err
+	}
+	wrapped, err := wrapKey(v.key.Bytes(), key.Bytes())
+	if err != nil {
+		key.Destroy()
+		return err
+	}
+	return v.replaceKey(ctx, key, map[string]string{metaWrappedKey: wrapped})
+}
@@ -0,0 +1,61 @@
+package vault
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/TheEditor/keyp/internal/model"
+)
+
+// markRotation sets when the field values of a secret about to be updated
+// last changed: now if any value differs from the stored one under the
+// same label, or has no stored counterpart, and as stored otherwise
+func (v *Vault) markRotation(ctx context.Context, secret *model.SecretObject) error {
+	stored, err := v.store.GetByID(ctx, secret.ID)
+	if err != nil {
+		return err
+	}
+	previous, err := v.decryptSecret(stored)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]string, len(previous.Fields))
+	for _, f := range previous.Fields {
+		values[f.Label] = f.Value
+	}
+	secret.RotatedAt = previous.RotatedAt
+	for _, f := range secret.Fields {
+		if value, ok := values[f.Label]; !ok || value != f.Value {
+			secret.RotatedAt = time.Now()
+			break
+		}
+	}
+	return nil
+}
+
+// Expiring returns the secrets that have expired or are due for rotation,
+// or will be within the given time, soonest first
+func (v *Vault) Expiring(ctx context.Context, within time.Duration) ([]*model.SecretObject, error) {
+	if v.IsLocked() {
+		return nil, ErrLocked
+	}
+	secrets, err := v.List(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	before := now.Add(within)
+	var due []*model.SecretObject
+	dueAt := make(map[*model.SecretObject]time.Time)
+	for _, s := range secrets {
+		if _, at, ok := s.Due(now, before); ok {
+			due = append(due, s)
+			dueAt[s] = at
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return dueAt[due[i]].Before(dueAt[due[j]]) })
+	return due, nil
+}
@@ -0,0 +1,122 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TheEditor/keyp/internal/core"
+	"github.com/TheEditor/keyp/internal/model"
+	"github.com/TheEditor/keyp/internal/store"
+)
+
+// fieldCipherPrefix marks field values sealed with their secret and field
+// identity as associated data
+const fieldCipherPrefix = "v2:"
+
+// errUnboundField is returned for field values sealed without associated data
+var errUnboundField = errors.New("field value is not bound to its secret")
+
+// fieldAAD returns the associated data binding a field value to its secret,
+// field ID and label, so values cannot be moved between fields undetected
+func fieldAAD(secretID string, f model.Field) []byte {
+	return []byte("keyp-field-v2\x00" + secretID + "\x00" + f.ID + "\x00" + f.Label)
+}
+
+// sealField encrypts the value of a field bound to its identity
+func (v *Vault) sealField(secretID string, f model.Field) (string, error) {
+	encrypted, err := v.encrypt(f.Value, fieldAAD(secretID, f))
+	if err != nil {
+		return "", err
+	}
+	return fieldCipherPrefix + encrypted, nil
+}
+
+// openField decrypts a field value sealed by sealField. Values in the older
+// unbound format are rejected; migrate re-seals them when the vault opens.
+func (v *Vault) openField(secretID string, f model.Field) (string, error) {
+	encrypted, ok := strings.CutPrefix(f.Value, fieldCipherPrefix)
+	if !ok {
+		return "", errUnboundField
+	}
+	return decryptWithKey(v.key.Bytes(), encrypted, fieldAAD(secretID, f))
+}
+
+// ErrFieldNotFound is returned when a secret has no field with the requested label
+var ErrFieldNotFound = errors.New("field not found")
+
+// RevealField decrypts a single field value into locked memory, without
+// decrypting the rest of the secret. An empty label selects the first
+// field. The caller must Destroy the buffer when done with it.
+func (v *Vault) RevealField(ctx context.Context, name, label string) (*core.SecretBuffer, error) {
+	if v.IsLocked() {
+		return nil, ErrLocked
+	}
+	return v.revealField(ctx, name, func(f model.Field) bool {
+		return label == "" || f.Label == label
+	})
+}
+
+// TOTP generates the current code of a TOTP field, without the seed leaving
+// the vault. An empty label selects the first TOTP field.
+func (v *Vault) TOTP(ctx context.Context, name, label string, at time.Time) (core.TOTPCode, error) {
+	if v.IsLocked() {
+		return core.TOTPCode{}, ErrLocked
+	}
+	seed, err := v.revealField(ctx, name, func(f model.Field) bool {
+		return f.Type == model.FieldTypeTOTP && (label == "" || f.Label == label)
+	})
+	if err != nil {
+		return core.TOTPCode{}, err
+	}
+	defer seed.Destroy()
+
+	key, err := core.ParseTOTP(string(seed.Bytes()))
+	if err != nil {
+		return core.TOTPCode{}, err
+	}
+	defer core.Wipe(key.Secret)
+	return key.Generate(at), nil
+}
+
+// revealField decrypts the value of the first field of a secret that
+// matches into locked memory
+func (v *Vault) revealField(ctx context.Context, name string, match func(model.Field) bool) (*core.SecretBuffer, error) {
+	key, err := v.nameKey(name)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := v.store.GetByName(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if v.metadataEncrypted {
+		if err := v.revealMetadata(secret); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range secret.Fields {
+		if !match(f) {
+			continue
+		}
+		if !f.Sensitive && !v.metadataEncrypted {
+			return core.SecretBufferFromString(f.Value)
+		}
+		encrypted, ok := strings.CutPrefix(f.Value, fieldCipherPrefix)
+		if !ok {
+			return nil, errUnboundField
+		}
+		plaintext, err := core.Open(v.key.Bytes(), encrypted, fieldAAD(secret.ID, f))
+		if errors.Is(err, core.ErrDecrypt) {
+			return nil, fmt.Errorf("failed to decrypt field %q: %w", f.Label, store.ErrInvalidPassword)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return core.SecretBufferFrom(plaintext)
+	}
+	return nil, ErrFieldNotFound
+}
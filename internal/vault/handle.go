@@ -1,22 +1,30 @@
 package vault
 
 import (
+	"bytes"
+	"context"
+	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/TheEditor/keyp/internal/core"
+	"github.com/TheEditor/keyp/internal/model"
 	"github.com/TheEditor/keyp/internal/store"
 )
 
 // VaultHandle represents an unlocked vault that can be reused
-// without re-entering the password repeatedly
+// without re-entering the password repeatedly. It expires once it has gone
+// unused for its idle timeout, or has been unlocked for its maximum
+// lifetime, whichever comes first.
 type VaultHandle struct {
-	mu         sync.RWMutex
-	store      *store.Store
-	key        []byte        // Derived encryption key
-	unlockedAt time.Time
-	timeout    time.Duration
-	path       string
-	password   string // Keep password for re-unlocking after auto-lock
+	mu          sync.RWMutex
+	vault       *Vault // Unlocked vault, nil while locked
+	unlockedAt  time.Time
+	lastUsed    atomic.Int64  // Unix nanoseconds of the last access, updated under the read lock
+	timeout     time.Duration // Idle timeout, slid by each access
+	maxLifetime time.Duration // Limit from unlockedAt, 0 for none
+	path        string
 }
 
 // NewHandle creates a new vault handle (initially locked)
@@ -32,46 +40,111 @@ func (h *VaultHandle) Path() string {
 	return h.path
 }
 
-// Store returns the underlying store if unlocked, nil if locked
-func (h *VaultHandle) Store() *store.Store {
+// ID returns the identity of the unlocked vault, or "" if locked
+func (h *VaultHandle) ID() string {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	return h.store
+	if h.vault == nil {
+		return ""
+	}
+	return h.vault.ID()
 }
 
 // IsUnlocked returns true if vault is currently unlocked
 func (h *VaultHandle) IsUnlocked() bool {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	return h.store != nil
+	return h.vault != nil
 }
 
-// IsExpired returns true if unlock timeout has elapsed
+// IsExpired returns true if the idle timeout or the maximum lifetime has
+// elapsed
 func (h *VaultHandle) IsExpired() bool {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	if h.store == nil {
+	if h.vault == nil {
 		return true // Already locked
 	}
-	return time.Since(h.unlockedAt) > h.timeout
+	return time.Now().After(h.expiresAt())
+}
+
+// ExpiresAt returns when the handle expires unless it is used again, or
+// the zero time if locked
+func (h *VaultHandle) ExpiresAt() time.Time {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return time.Time{}
+	}
+	return h.expiresAt()
+}
+
+// expiresAt returns the earlier of the idle and lifetime deadlines. The
+// caller must hold the lock.
+func (h *VaultHandle) expiresAt() time.Time {
+	expires := time.Unix(0, h.lastUsed.Load()).Add(h.timeout)
+	if h.maxLifetime > 0 {
+		if hard := h.unlockedAt.Add(h.maxLifetime); hard.Before(expires) {
+			return hard
+		}
+	}
+	return expires
+}
+
+// touch records an access, sliding the idle timeout. An expired handle
+// stays expired. The caller must hold the lock.
+func (h *VaultHandle) touch() {
+	now := time.Now()
+	if !now.After(h.expiresAt()) {
+		h.lastUsed.Store(now.UnixNano())
+	}
+}
+
+// unlocked marks the handle unlocked as of now. The caller must hold the
+// write lock.
+func (h *VaultHandle) unlocked() {
+	h.unlockedAt = time.Now()
+	h.lastUsed.Store(h.unlockedAt.UnixNano())
 }
 
 // Unlock opens the vault and keeps it open in the handle
 func (h *VaultHandle) Unlock(password string, timeout time.Duration) error {
+	return h.UnlockWithKeyFile(password, "", timeout)
+}
+
+// UnlockWithKeyFile opens the vault with a password and key file and keeps
+// it open in the handle. An empty keyFilePath means password only.
+func (h *VaultHandle) UnlockWithKeyFile(password string, keyFilePath string, timeout time.Duration) error {
+	return h.unlock(timeout, func() (*Vault, error) {
+		return OpenWithKeyFile(h.path, password, keyFilePath)
+	})
+}
+
+// UnlockWithKeyFileData is UnlockWithKeyFile with the key file content
+// rather than its path. Empty content means password only.
+func (h *VaultHandle) UnlockWithKeyFileData(password string, keyFileData []byte, timeout time.Duration) error {
+	return h.unlock(timeout, func() (*Vault, error) {
+		return OpenWithKeyFileData(h.path, password, keyFileData)
+	})
+}
+
+// unlock opens the vault with openVault and keeps it open in the handle
+func (h *VaultHandle) unlock(timeout time.Duration, openVault func() (*Vault, error)) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
 	// Open the vault
-	v, err := Open(h.path, password)
+	v, err := openVault()
 	if err != nil {
 		return err
 	}
 
-	// Extract store from vault (close vault, keep store)
-	h.store = v.store
-	h.key = v.key
-	h.password = password
-	h.unlockedAt = time.Now()
+	// Replace any previously unlocked vault
+	if h.vault != nil {
+		h.vault.Close()
+	}
+	h.vault = v
+	h.unlocked()
 
 	if timeout > 0 {
 		h.timeout = timeout
@@ -85,14 +158,13 @@ func (h *VaultHandle) Lock() {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	if h.store != nil {
-		h.store.Close()
+	if h.vault != nil {
+		h.vault.Close()
 	}
 
-	h.store = nil
-	h.key = nil
-	h.password = ""
+	h.vault = nil
 	h.unlockedAt = time.Time{}
+	h.lastUsed.Store(0)
 }
 
 // UnlockedTime returns when the vault was unlocked
@@ -102,66 +174,88 @@ func (h *VaultHandle) UnlockedTime() time.Time {
 	return h.unlockedAt
 }
 
-// TimeUntilExpire returns time remaining until auto-lock
+// TimeUntilExpire returns time remaining until auto-lock, if the handle is
+// not used again
 func (h *VaultHandle) TimeUntilExpire() time.Duration {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	if h.store == nil {
+	if h.vault == nil {
 		return 0
 	}
 
-	elapsed := time.Since(h.unlockedAt)
-	if elapsed >= h.timeout {
-		return 0
-	}
-
-	return h.timeout - elapsed
+	return max(time.Until(h.expiresAt()), 0)
 }
 
-// Timeout returns the current timeout setting
+// Timeout returns the current idle timeout setting
 func (h *VaultHandle) Timeout() time.Duration {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 	return h.timeout
 }
 
-// SetTimeout updates the timeout duration
+// SetTimeout updates the idle timeout duration
 func (h *VaultHandle) SetTimeout(timeout time.Duration) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	h.timeout = timeout
 }
 
-// GetDerivedKey returns the derived encryption key if unlocked, nil otherwise
-func (h *VaultHandle) GetDerivedKey() []byte {
+// MaxLifetime returns how long the handle stays unlocked however often it
+// is used, or 0 for no limit
+func (h *VaultHandle) MaxLifetime() time.Duration {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.maxLifetime
+}
+
+// SetMaxLifetime limits how long the handle stays unlocked, counted from
+// unlock; 0 removes the limit
+func (h *VaultHandle) SetMaxLifetime(maxLifetime time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.maxLifetime = maxLifetime
+}
+
+// GetDerivedKey returns a copy of the vault data key if unlocked, nil
+// otherwise. The caller must Destroy the copy when done with it.
+func (h *VaultHandle) GetDerivedKey() *core.SecretBuffer {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	if h.key == nil {
+	if h.vault == nil {
+		return nil
+	}
+	h.touch()
+	keyCopy, err := h.vault.key.Clone()
+	if err != nil {
 		return nil
 	}
-	// Return a copy to prevent external modification
-	keyCopy := make([]byte, len(h.key))
-	copy(keyCopy, h.key)
 	return keyCopy
 }
 
-// UnlockWithKey unlocks the vault using a pre-derived key instead of a password
-func (h *VaultHandle) UnlockWithKey(derivedKey []byte, timeout time.Duration) error {
+// UnlockWithKey unlocks the vault using a pre-derived key instead of a
+// password. The handle keeps its own copy of the key.
+func (h *VaultHandle) UnlockWithKey(derivedKey *core.SecretBuffer, timeout time.Duration) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	// Open the vault store directly with the derived key
-	st, err := store.Open(h.path)
+	key, err := derivedKey.Clone()
 	if err != nil {
 		return err
 	}
 
-	// Store the derived key and update state
-	h.store = st
-	h.key = derivedKey
-	h.password = "" // No password when unlocking with key
-	h.unlockedAt = time.Now()
+	// Open the vault directly with the derived key
+	v, err := openWithKey(h.path, key)
+	if err != nil {
+		return err
+	}
+
+	// Replace any previously unlocked vault
+	if h.vault != nil {
+		h.vault.Close()
+	}
+	h.vault = v
+	h.unlocked()
 
 	if timeout > 0 {
 		h.timeout = timeout
@@ -169,3 +263,396 @@ func (h *VaultHandle) UnlockWithKey(derivedKey []byte, timeout time.Duration) er
 
 	return nil
 }
+
+// Create adds a new secret, encrypting its sensitive fields
+func (h *VaultHandle) Create(ctx context.Context, secret *model.SecretObject) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return ErrLocked
+	}
+	h.touch()
+	return h.vault.Create(ctx, secret)
+}
+
+// GetByName retrieves and decrypts a secret by name
+func (h *VaultHandle) GetByName(ctx context.Context, name string) (*model.SecretObject, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return nil, ErrLocked
+	}
+	h.touch()
+	return h.vault.GetByName(ctx, name)
+}
+
+// RevealField decrypts one field value into locked memory; the caller must
+// Destroy the buffer
+func (h *VaultHandle) RevealField(ctx context.Context, name, label string) (*core.SecretBuffer, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return nil, ErrLocked
+	}
+	h.touch()
+	return h.vault.RevealField(ctx, name, label)
+}
+
+// TOTP generates the current code of a TOTP field
+func (h *VaultHandle) TOTP(ctx context.Context, name, label string, at time.Time) (core.TOTPCode, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return core.TOTPCode{}, ErrLocked
+	}
+	h.touch()
+	return h.vault.TOTP(ctx, name, label, at)
+}
+
+// List returns all secrets with their sensitive fields decrypted
+func (h *VaultHandle) List(ctx context.Context, opts *store.SearchOptions) ([]*model.SecretObject, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return nil, ErrLocked
+	}
+	h.touch()
+	return h.vault.List(ctx, opts)
+}
+
+// Search performs full-text search and decrypts the matching secrets
+func (h *VaultHandle) Search(ctx context.Context, query string, opts *store.SearchOptions) ([]*model.SecretObject, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return nil, ErrLocked
+	}
+	h.touch()
+	return h.vault.Search(ctx, query, opts)
+}
+
+// Update updates an existing secret, encrypting its sensitive fields
+func (h *VaultHandle) Update(ctx context.Context, secret *model.SecretObject) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return ErrLocked
+	}
+	h.touch()
+	return h.vault.Update(ctx, secret)
+}
+
+// Delete moves a secret to the trash
+func (h *VaultHandle) Delete(ctx context.Context, name string) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return ErrLocked
+	}
+	h.touch()
+	return h.vault.Delete(ctx, name)
+}
+
+// History lists the versions of a secret, oldest first; the last one is current
+func (h *VaultHandle) History(ctx context.Context, name string) ([]store.VersionInfo, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return nil, ErrLocked
+	}
+	h.touch()
+	return h.vault.History(ctx, name)
+}
+
+// GetVersion retrieves and decrypts a version of a secret
+func (h *VaultHandle) GetVersion(ctx context.Context, name string, number int) (*model.SecretObject, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return nil, ErrLocked
+	}
+	h.touch()
+	return h.vault.GetVersion(ctx, name, number)
+}
+
+// Rollback makes an earlier version of a secret current again
+func (h *VaultHandle) Rollback(ctx context.Context, name string, number int) (*model.SecretObject, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return nil, ErrLocked
+	}
+	h.touch()
+	return h.vault.Rollback(ctx, name, number)
+}
+
+// Trash returns the decrypted secrets in the trash, most recently deleted first
+func (h *VaultHandle) Trash(ctx context.Context) ([]*model.SecretObject, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return nil, ErrLocked
+	}
+	h.touch()
+	return h.vault.Trash(ctx)
+}
+
+// Restore takes a secret out of the trash
+func (h *VaultHandle) Restore(ctx context.Context, name string) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return ErrLocked
+	}
+	h.touch()
+	return h.vault.Restore(ctx, name)
+}
+
+// Purge permanently removes the secrets that have been in the trash for at
+// least olderThan and returns how many were removed
+func (h *VaultHandle) Purge(ctx context.Context, olderThan time.Duration) (int, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return 0, ErrLocked
+	}
+	h.touch()
+	return h.vault.Purge(ctx, olderThan)
+}
+
+// Rename gives a secret a new name, keeping its fields
+func (h *VaultHandle) Rename(ctx context.Context, oldName, newName string) (*model.SecretObject, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return nil, ErrLocked
+	}
+	h.touch()
+	return h.vault.Rename(ctx, oldName, newName)
+}
+
+// Move renames a secret or a whole folder and returns how many secrets moved
+func (h *VaultHandle) Move(ctx context.Context, from, to string) (int, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return 0, ErrLocked
+	}
+	h.touch()
+	return h.vault.Move(ctx, from, to)
+}
+
+// Expiring returns the secrets that have expired or are due for rotation,
+// or will be within the given time
+func (h *VaultHandle) Expiring(ctx context.Context, within time.Duration) ([]*model.SecretObject, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return nil, ErrLocked
+	}
+	h.touch()
+	return h.vault.Expiring(ctx, within)
+}
+
+// Attach stores the content read from r as an attachment of a secret. The
+// content is read in full before taking the lock, so a slow reader cannot
+// hold off Lock.
+func (h *VaultHandle) Attach(ctx context.Context, name, file string, r io.Reader) (*model.Attachment, error) {
+	content, err := io.ReadAll(io.LimitReader(r, MaxAttachmentSize+1))
+	defer core.Wipe(content)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return nil, ErrLocked
+	}
+	h.touch()
+	return h.vault.Attach(ctx, name, file, bytes.NewReader(content))
+}
+
+// Attachments returns the attachments of a secret
+func (h *VaultHandle) Attachments(ctx context.Context, name string) ([]*model.Attachment, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return nil, ErrLocked
+	}
+	h.touch()
+	return h.vault.Attachments(ctx, name)
+}
+
+// Attachment returns an attachment of a secret without its content
+func (h *VaultHandle) Attachment(ctx context.Context, name, file string) (*model.Attachment, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return nil, ErrLocked
+	}
+	h.touch()
+	return h.vault.Attachment(ctx, name, file)
+}
+
+// Extract decrypts the content of an attachment to w. The content is
+// decrypted in full before writing to w, outside the lock, so a slow writer
+// cannot hold off Lock.
+func (h *VaultHandle) Extract(ctx context.Context, name, file string, w io.Writer) (*model.Attachment, error) {
+	var content bytes.Buffer
+	defer func() { core.Wipe(content.Bytes()) }()
+	attachment, err := h.extract(ctx, name, file, &content)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(content.Bytes()); err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// extract decrypts the content of an attachment to w under the read lock
+func (h *VaultHandle) extract(ctx context.Context, name, file string, w io.Writer) (*model.Attachment, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return nil, ErrLocked
+	}
+	h.touch()
+	return h.vault.Extract(ctx, name, file, w)
+}
+
+// Detach removes an attachment from a secret
+func (h *VaultHandle) Detach(ctx context.Context, name, file string) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return ErrLocked
+	}
+	h.touch()
+	return h.vault.Detach(ctx, name, file)
+}
+
+// MigrateToSQLCipher converts the vault to whole-database encryption and
+// returns the path of the backup taken beforehand
+func (h *VaultHandle) MigrateToSQLCipher(ctx context.Context) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.vault == nil {
+		return "", ErrLocked
+	}
+	return h.vault.MigrateToSQLCipher(ctx)
+}
+
+// EncryptMetadata switches the vault to encrypted metadata and returns the
+// path of the backup taken beforehand, if any
+func (h *VaultHandle) EncryptMetadata(ctx context.Context) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.vault == nil {
+		return "", ErrLocked
+	}
+	return h.vault.EncryptMetadata(ctx)
+}
+
+// Doctor checks the integrity of the vault, repairing what it can if asked
+func (h *VaultHandle) Doctor(ctx context.Context, repair bool) (*DoctorReport, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.vault == nil {
+		return nil, ErrLocked
+	}
+	h.touch()
+	return h.vault.Doctor(ctx, repair)
+}
+
+// Cipher returns the cipher used for new writes
+func (h *VaultHandle) Cipher() (string, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return "", ErrLocked
+	}
+	return h.vault.Cipher(), nil
+}
+
+// SetCipher selects the cipher for new writes
+func (h *VaultHandle) SetCipher(name string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.vault == nil {
+		return ErrLocked
+	}
+	return h.vault.SetCipher(name)
+}
+
+// CaseInsensitiveNames reports whether secret names match regardless of case
+func (h *VaultHandle) CaseInsensitiveNames() (bool, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return false, ErrLocked
+	}
+	return h.vault.CaseInsensitiveNames(), nil
+}
+
+// SetCaseInsensitiveNames selects whether secret names match regardless of case
+func (h *VaultHandle) SetCaseInsensitiveNames(ctx context.Context, on bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.vault == nil {
+		return ErrLocked
+	}
+	return h.vault.SetCaseInsensitiveNames(ctx, on)
+}
+
+// ChangePassword re-keys the vault under a new password
+func (h *VaultHandle) ChangePassword(ctx context.Context, oldPassword, newPassword string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.vault == nil {
+		return ErrLocked
+	}
+	return h.vault.ChangePassword(ctx, oldPassword, newPassword)
+}
+
+// Counts returns the number of secrets and fields in the vault
+func (h *VaultHandle) Counts(ctx context.Context) (int, int, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return 0, 0, ErrLocked
+	}
+	h.touch()
+	return h.vault.Counts(ctx)
+}
+
+// Slots returns the key slots of the vault
+func (h *VaultHandle) Slots() ([]KeySlot, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.vault == nil {
+		return nil, ErrLocked
+	}
+	return h.vault.Slots()
+}
+
+// AddSlot adds a key slot that unlocks the vault with password and an optional key file
+func (h *VaultHandle) AddSlot(label, password, keyFilePath string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.vault == nil {
+		return ErrLocked
+	}
+	return h.vault.AddSlot(label, password, keyFilePath)
+}
+
+// RemoveSlot removes a key slot and moves the vault to a new data key
+func (h *VaultHandle) RemoveSlot(ctx context.Context, label string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.vault == nil {
+		return ErrLocked
+	}
+	return h.vault.RemoveSlot(ctx, label)
+}
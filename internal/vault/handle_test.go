@@ -1,9 +1,17 @@
 package vault
 
 import (
+	"context"
+	"errors"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/TheEditor/keyp/internal/model"
 )
 
 // setupTestVault creates a temporary vault for testing
@@ -15,18 +23,19 @@ func setupTestVault(t *testing.T) (string, string) {
 
 	// Initialize vault
 	password := "testpassword"
-	v, err := Open(tmpDir, password)
+	vaultPath := filepath.Join(tmpDir, "vault.db")
+	v, err := Init(vaultPath, password)
 	if err != nil {
-		t.Fatalf("failed to open vault: %v", err)
+		t.Fatalf("failed to init vault: %v", err)
 	}
 	defer v.Close()
 
-	return tmpDir, password
+	return vaultPath, password
 }
 
 // cleanupTestVault removes the test vault
-func cleanupTestVault(t *testing.T, tmpDir string) {
-	if err := os.RemoveAll(tmpDir); err != nil {
+func cleanupTestVault(t *testing.T, vaultPath string) {
+	if err := os.RemoveAll(filepath.Dir(vaultPath)); err != nil {
 		t.Logf("warning: failed to cleanup temp dir: %v", err)
 	}
 }
@@ -77,10 +86,9 @@ func TestUnlock(t *testing.T) {
 		t.Errorf("expected unlocked handle to not be expired immediately")
 	}
 
-	// Check store is accessible
-	store := handle.Store()
-	if store == nil {
-		t.Errorf("expected store to be non-nil after unlock")
+	// Check vault is accessible
+	if _, err := handle.List(context.Background(), nil); err != nil {
+		t.Errorf("expected list to succeed after unlock: %v", err)
 	}
 
 	// Check unlock time is set
@@ -139,9 +147,9 @@ func TestLock(t *testing.T) {
 		t.Errorf("expected locked handle to be expired")
 	}
 
-	// Check store is nil
-	if handle.Store() != nil {
-		t.Errorf("expected store to be nil after lock")
+	// Check vault is no longer accessible
+	if _, err := handle.List(context.Background(), nil); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected ErrLocked after lock, got %v", err)
 	}
 
 	// Check unlock time is cleared
@@ -198,6 +206,59 @@ func TestIsExpired(t *testing.T) {
 	}
 }
 
+// TestSlidingExpiry tests that use slides the idle timeout, but never past
+// the maximum lifetime
+func TestSlidingExpiry(t *testing.T) {
+	tmpDir, password := setupTestVault(t)
+	defer cleanupTestVault(t, tmpDir)
+	ctx := context.Background()
+
+	handle := NewHandle(tmpDir)
+	handle.SetMaxLifetime(time.Hour)
+	if err := handle.Unlock(password, time.Minute); err != nil {
+		t.Fatalf("failed to unlock: %v", err)
+	}
+
+	// Unlocked half an hour ago, last used 50 seconds ago
+	now := time.Now()
+	handle.unlockedAt = now.Add(-30 * time.Minute)
+	handle.lastUsed.Store(now.Add(-50 * time.Second).UnixNano())
+	if remaining := handle.TimeUntilExpire(); remaining <= 0 || remaining > 10*time.Second {
+		t.Errorf("expected about 10s remaining, got %v", remaining)
+	}
+
+	// Use restarts the idle timeout
+	if _, _, err := handle.Counts(ctx); err != nil {
+		t.Fatalf("Counts failed: %v", err)
+	}
+	if remaining := handle.TimeUntilExpire(); remaining <= 50*time.Second {
+		t.Errorf("expected use to slide the idle timeout, got %v remaining", remaining)
+	}
+
+	// but not past the maximum lifetime
+	handle.unlockedAt = now.Add(-59*time.Minute - 30*time.Second)
+	if _, _, err := handle.Counts(ctx); err != nil {
+		t.Fatalf("Counts failed: %v", err)
+	}
+	if remaining := handle.TimeUntilExpire(); remaining > 30*time.Second {
+		t.Errorf("expected the maximum lifetime to cap expiry, got %v remaining", remaining)
+	}
+	handle.unlockedAt = now.Add(-2 * time.Hour)
+	if !handle.IsExpired() {
+		t.Errorf("expected handle to be expired after its maximum lifetime")
+	}
+
+	// Use does not revive an idle handle
+	handle.unlockedAt = now
+	handle.lastUsed.Store(now.Add(-2 * time.Minute).UnixNano())
+	if _, _, err := handle.Counts(ctx); err != nil {
+		t.Fatalf("Counts failed: %v", err)
+	}
+	if !handle.IsExpired() {
+		t.Errorf("expected idle handle to stay expired after use")
+	}
+}
+
 // TestTimeUntilExpire tests TimeUntilExpire method
 func TestTimeUntilExpire(t *testing.T) {
 	tmpDir, password := setupTestVault(t)
@@ -317,7 +378,223 @@ func TestKeyZeroed(t *testing.T) {
 		t.Errorf("expected handle to be locked after Lock()")
 	}
 
-	if handle.Store() != nil {
-		t.Errorf("expected store to be nil after lock")
+	if handle.GetDerivedKey() != nil {
+		t.Errorf("expected derived key to be nil after lock")
+	}
+}
+
+// TestHandleEncryptsFields tests that handle CRUD goes through field encryption
+func TestHandleEncryptsFields(t *testing.T) {
+	tmpDir, password := setupTestVault(t)
+	defer cleanupTestVault(t, tmpDir)
+
+	handle := NewHandle(tmpDir)
+	if err := handle.Unlock(password, 30*time.Minute); err != nil {
+		t.Fatalf("failed to unlock: %v", err)
+	}
+	defer handle.Lock()
+
+	ctx := context.Background()
+	secret := model.NewSecretObject("github")
+	secret.AddField(model.NewField("token", "ghp_abc123"))
+	if err := handle.Create(ctx, secret); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// Raw store value must be ciphertext
+	raw, err := handle.vault.store.GetByName(ctx, "github")
+	if err != nil {
+		t.Fatalf("store GetByName failed: %v", err)
+	}
+	if raw.Fields[0].Value == "ghp_abc123" {
+		t.Errorf("expected sensitive field to be encrypted in store")
+	}
+
+	// Handle returns plaintext
+	got, err := handle.GetByName(ctx, "github")
+	if err != nil {
+		t.Fatalf("GetByName failed: %v", err)
+	}
+	if got.Fields[0].Value != "ghp_abc123" {
+		t.Errorf("expected decrypted value, got %q", got.Fields[0].Value)
+	}
+}
+
+// TestLockWipesSecrets tests that the data key is wiped on lock
+func TestLockWipesSecrets(t *testing.T) {
+	tmpDir, password := setupTestVault(t)
+	defer cleanupTestVault(t, tmpDir)
+
+	handle := NewHandle(tmpDir)
+	if err := handle.Unlock(password, 30*time.Minute); err != nil {
+		t.Fatalf("failed to unlock: %v", err)
+	}
+
+	key := handle.vault.key
+	if key.Destroyed() {
+		t.Fatal("expected key to be live while unlocked")
+	}
+	derived := handle.GetDerivedKey()
+	defer derived.Destroy()
+
+	handle.Lock()
+
+	if !key.Destroyed() || key.Bytes() != nil {
+		t.Errorf("expected data key to be wiped after Lock()")
+	}
+	if derived.Destroyed() || derived.Len() != 32 {
+		t.Errorf("expected caller's copy of the key to outlive Lock()")
+	}
+
+	// The copy unlocks the vault again and is not consumed
+	if err := handle.UnlockWithKey(derived, 30*time.Minute); err != nil {
+		t.Fatalf("failed to unlock with key: %v", err)
+	}
+	if derived.Destroyed() {
+		t.Errorf("expected UnlockWithKey to keep its own copy")
+	}
+	handle.Lock()
+}
+
+// TestRevealField tests that a single field is revealed into a wiped buffer
+func TestRevealField(t *testing.T) {
+	tmpDir, password := setupTestVault(t)
+	defer cleanupTestVault(t, tmpDir)
+
+	handle := NewHandle(tmpDir)
+	if err := handle.Unlock(password, 30*time.Minute); err != nil {
+		t.Fatalf("failed to unlock: %v", err)
+	}
+	defer handle.Lock()
+
+	ctx := context.Background()
+	secret := model.NewSecretObject("email")
+	secret.AddField(model.NewField("password", "hunter2"))
+	secret.AddField(model.NewField("username", "alice"))
+	secret.Fields[1].Sensitive = false
+	if err := handle.Create(ctx, secret); err != nil {
+		t.Fatalf("failed to create secret: %v", err)
+	}
+
+	for label, want := range map[string]string{"": "hunter2", "password": "hunter2", "username": "alice"} {
+		value, err := handle.RevealField(ctx, "email", label)
+		if err != nil {
+			t.Fatalf("RevealField(%q) failed: %v", label, err)
+		}
+		if string(value.Bytes()) != want {
+			t.Errorf("RevealField(%q) = %q, want %q", label, value.Bytes(), want)
+		}
+		value.Destroy()
+	}
+
+	if _, err := handle.RevealField(ctx, "email", "pin"); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("expected ErrFieldNotFound, got %v", err)
+	}
+}
+
+// TestUnlockWithKeyRejectsOtherVault tests that a key saved for one vault
+// does not open another
+func TestUnlockWithKeyRejectsOtherVault(t *testing.T) {
+	first, password := setupTestVault(t)
+	defer cleanupTestVault(t, first)
+	second, _ := setupTestVault(t)
+	defer cleanupTestVault(t, second)
+
+	handle := NewHandle(first)
+	if err := handle.Unlock(password, 30*time.Minute); err != nil {
+		t.Fatalf("failed to unlock: %v", err)
+	}
+	key := handle.GetDerivedKey()
+	defer key.Destroy()
+	firstID := handle.ID()
+	handle.Lock()
+
+	other := NewHandle(second)
+	if err := other.UnlockWithKey(key, 0); err == nil {
+		t.Fatal("expected key of another vault to be rejected")
+	}
+	if other.IsUnlocked() {
+		t.Error("expected handle to stay locked")
+	}
+
+	if err := handle.UnlockWithKey(key, 0); err != nil {
+		t.Fatalf("failed to unlock with key: %v", err)
+	}
+	if handle.ID() != firstID || firstID == "" {
+		t.Errorf("expected vault ID %q, got %q", firstID, handle.ID())
+	}
+}
+
+// stalledPipe is a reader and writer that block, like a client that stops
+// sending or reading, until released
+type stalledPipe struct {
+	entered chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func newStalledPipe() *stalledPipe {
+	return &stalledPipe{entered: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (p *stalledPipe) stall() {
+	p.once.Do(func() { close(p.entered) })
+	<-p.release
+}
+
+func (p *stalledPipe) Read([]byte) (int, error) {
+	p.stall()
+	return 0, io.EOF
+}
+
+func (p *stalledPipe) Write(b []byte) (int, error) {
+	p.stall()
+	return len(b), nil
+}
+
+// TestLockDuringAttachmentTransfer tests that a stalled upload or download
+// does not hold off Lock
+func TestLockDuringAttachmentTransfer(t *testing.T) {
+	tmpDir, password := setupTestVault(t)
+	defer cleanupTestVault(t, tmpDir)
+
+	ctx := context.Background()
+	handle := NewHandle(tmpDir)
+	if err := handle.Unlock(password, 30*time.Minute); err != nil {
+		t.Fatalf("failed to unlock: %v", err)
+	}
+	if err := handle.Create(ctx, model.NewSecretObject("server")); err != nil {
+		t.Fatalf("failed to create secret: %v", err)
+	}
+	if _, err := handle.Attach(ctx, "server", "id_ed25519", strings.NewReader("private key")); err != nil {
+		t.Fatalf("failed to attach: %v", err)
+	}
+
+	download, upload := newStalledPipe(), newStalledPipe()
+	downloaded := make(chan error, 1)
+	go func() {
+		_, err := handle.Extract(ctx, "server", "id_ed25519", download)
+		downloaded <- err
+	}()
+	go handle.Attach(ctx, "server", "notes.txt", upload)
+	<-download.entered
+	<-upload.entered
+
+	locked := make(chan struct{})
+	go func() {
+		handle.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Lock waited for a stalled attachment transfer")
+	}
+
+	// The download still completes with what was decrypted before the lock
+	close(download.release)
+	close(upload.release)
+	if err := <-downloaded; err != nil {
+		t.Errorf("expected the download to finish, got %v", err)
 	}
 }
@@ -0,0 +1,191 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/TheEditor/keyp/internal/store"
+)
+
+// HeaderSuffix names the sidecar file that holds the unlock metadata of a
+// SQLCipher vault, whose vault_meta table cannot be read until it is keyed
+const HeaderSuffix = ".header"
+
+// pendingSuffix names a header written ahead of a re-key; it replaces the
+// header once the re-keyed database is in place
+const pendingSuffix = ".pending"
+
+// unlockMetaKeys lists the vault_meta entries needed before the database is open
+var unlockMetaKeys = []string{"salt", "iterations", metaKDF, metaWrappedKey, metaSlots, "verify", metaVaultID}
+
+// metaStore reads and writes vault metadata
+type metaStore interface {
+	GetMeta(key string) (string, error)
+	SetMeta(key, value string) error
+	SetMetaValues(values map[string]string, remove ...string) error
+	DeleteMeta(key string) error
+}
+
+// header is a metaStore backed by a JSON sidecar file next to the vault
+type header struct {
+	path   string
+	values map[string]string
+}
+
+// headerPath returns the sidecar header path for a vault
+func headerPath(vaultPath string) string {
+	return vaultPath + HeaderSuffix
+}
+
+// loadHeader reads the sidecar header of a SQLCipher vault
+func loadHeader(vaultPath string) (*header, error) {
+	path := headerPath(vaultPath)
+	h, err := readHeader(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("vault header %s is missing", path)
+	}
+	return h, err
+}
+
+// loadPendingHeader reads the pending header of a vault, or returns nil if there is none
+func loadPendingHeader(vaultPath string) (*header, error) {
+	h, err := readHeader(headerPath(vaultPath) + pendingSuffix)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return h, err
+}
+
+// readHeader parses a header file; a missing file is returned as is
+func readHeader(path string) (*header, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to read vault header: %w", err)
+	}
+
+	h := &header{path: path}
+	if err := json.Unmarshal(data, &h.values); err != nil {
+		return nil, fmt.Errorf("corrupted vault header: %w", err)
+	}
+	if h.values == nil {
+		h.values = make(map[string]string)
+	}
+	return h, nil
+}
+
+// newHeader creates an empty header for a vault without writing it
+func newHeader(vaultPath string) *header {
+	return &header{
+		path:   headerPath(vaultPath),
+		values: make(map[string]string),
+	}
+}
+
+// GetMeta retrieves a header value by key
+func (h *header) GetMeta(key string) (string, error) {
+	value, ok := h.values[key]
+	if !ok {
+		return "", store.ErrNotFound
+	}
+	return value, nil
+}
+
+// SetMeta stores a header value and rewrites the file
+func (h *header) SetMeta(key, value string) error {
+	h.values[key] = value
+	return h.save()
+}
+
+// SetMetaValues stores several header values and removes others with a single rewrite
+func (h *header) SetMetaValues(values map[string]string, remove ...string) error {
+	for _, k := range remove {
+		delete(h.values, k)
+	}
+	for k, v := range values {
+		h.values[k] = v
+	}
+	return h.save()
+}
+
+// DeleteMeta removes a header value and rewrites the file
+func (h *header) DeleteMeta(key string) error {
+	delete(h.values, key)
+	return h.save()
+}
+
+// save atomically replaces the header file
+func (h *header) save() error {
+	data, err := json.MarshalIndent(h.values, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write vault header: %w", err)
+	}
+	if err := os.Rename(tmp, h.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write vault header: %w", err)
+	}
+	return nil
+}
+
+// stage writes a pending header holding the current values with changes applied
+func (h *header) stage(changes map[string]string) (*header, error) {
+	pending := &header{
+		path:   h.path + pendingSuffix,
+		values: make(map[string]string, len(h.values)+len(changes)),
+	}
+	for k, v := range h.values {
+		pending.values[k] = v
+	}
+	for k, v := range changes {
+		pending.values[k] = v
+	}
+	if err := pending.save(); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// promote replaces the vault header with this pending header
+func (h *header) promote() error {
+	target := strings.TrimSuffix(h.path, pendingSuffix)
+	if err := os.Rename(h.path, target); err != nil {
+		return fmt.Errorf("failed to replace vault header: %w", err)
+	}
+	h.path = target
+	return nil
+}
+
+// withUnlockMeta calls fn with the unlock metadata of the vault at path,
+// which is readable without the vault key: the store of a plain vault, or
+// the header of a SQLCipher vault
+func withUnlockMeta(path string, fn func(metaStore) error) error {
+	if !Exists(path) {
+		return ErrNotExists
+	}
+	encrypted, err := store.IsEncrypted(path)
+	if err != nil {
+		return err
+	}
+	if encrypted {
+		h, err := loadHeader(path)
+		if err != nil {
+			return err
+		}
+		return fn(h)
+	}
+	s, err := store.Open(path)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return fn(s)
+}
@@ -0,0 +1,51 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/TheEditor/keyp/internal/store"
+	"github.com/google/uuid"
+)
+
+// metaVaultID holds the random identity of a vault, readable without
+// unlocking it, so that sessions for different vaults stay apart
+const metaVaultID = "vault_id"
+
+// ReadID returns the identity of the vault at path without unlocking it.
+// Vaults from before vault IDs have none until they are next unlocked with
+// a password, and return store.ErrNotFound.
+func ReadID(path string) (string, error) {
+	var id string
+	err := withUnlockMeta(path, func(meta metaStore) error {
+		var err error
+		id, err = meta.GetMeta(metaVaultID)
+		return err
+	})
+	return id, err
+}
+
+// ID returns the identity of the vault
+func (v *Vault) ID() string {
+	return v.id
+}
+
+// loadID reads the vault identity from the unlock metadata
+func (v *Vault) loadID() error {
+	id, err := v.meta.GetMeta(metaVaultID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("failed to read vault ID: %w", err)
+	}
+	v.id = id
+	return nil
+}
+
+// assignID gives a vault from before vault IDs its identity
+func (v *Vault) assignID() error {
+	id := uuid.New().String()
+	if err := v.meta.SetMeta(metaVaultID, id); err != nil {
+		return err
+	}
+	v.id = id
+	return nil
+}
@@ -0,0 +1,97 @@
+package vault
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/TheEditor/keyp/internal/core"
+)
+
+var (
+	ErrKeyFileRequired = errors.New("vault requires a key file")
+	ErrKeyFileMissing  = errors.New("key file not found")
+	ErrKeyFileTooLarge = errors.New("key file too large")
+)
+
+// keyFileSize is the number of random bytes in a generated key file
+const keyFileSize = 64
+
+// MaxKeyFileData is the largest key file content accepted from a caller
+// rather than read from a path
+const MaxKeyFileData = 64 << 10
+
+// GenerateKeyFile writes a new random key file, refusing to overwrite an existing one
+func GenerateKeyFile(path string) error {
+	data := make([]byte, keyFileSize)
+	if _, err := rand.Read(data); err != nil {
+		return fmt.Errorf("failed to generate key file: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0400)
+	if err != nil {
+		return fmt.Errorf("failed to create key file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	return f.Close()
+}
+
+// readKeyFile returns the SHA-256 digest of a key file, or nil if path is empty
+func readKeyFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrKeyFileMissing, path)
+		}
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	defer core.Wipe(data)
+	if len(data) == 0 {
+		return nil, fmt.Errorf("key file %s is empty", path)
+	}
+
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
+
+// keyFileDigest returns the SHA-256 digest of key file content passed in
+// directly, or nil if there is none. The caller wipes data.
+func keyFileDigest(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if len(data) > MaxKeyFileData {
+		return nil, ErrKeyFileTooLarge
+	}
+
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
+
+// keyFileBuffer copies a key file digest into locked memory for an open
+// vault to keep; nil stays nil
+func keyFileBuffer(keyFile []byte) (*core.SecretBuffer, error) {
+	if keyFile == nil {
+		return nil, nil
+	}
+	return core.SecretBufferFrom(append([]byte(nil), keyFile...))
+}
+
+// compositeSecret combines a password with a key file digest into the KDF input
+func compositeSecret(password string, keyFile []byte) string {
+	pw := sha256.Sum256([]byte(password))
+	h := sha256.New()
+	h.Write(pw[:])
+	h.Write(keyFile)
+	return string(h.Sum(nil))
+}
@@ -0,0 +1,292 @@
+package vault
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/TheEditor/keyp/internal/core"
+	"github.com/TheEditor/keyp/internal/model"
+	"github.com/TheEditor/keyp/internal/store"
+)
+
+// metaMetadataEncrypted marks vaults whose secret names, tags, notes and
+// field labels are encrypted
+const metaMetadataEncrypted = "metadata_encrypted"
+
+// blindIndexPurpose separates the blind index key from the field key
+const blindIndexPurpose = "keyp-blind-index-v1"
+
+// Blind index kinds, so a name and a tag with the same text index differently
+const (
+	indexName       = "name"
+	indexFoldedName = "name-folded" // Lower-cased, for case-insensitive name keys
+	indexTag        = "tag"
+	indexAttachment = "attachment" // Attachment names, within their secret
+)
+
+// ErrMetadataEncrypted is returned when encrypting metadata that already is
+var ErrMetadataEncrypted = errors.New("vault metadata is already encrypted")
+
+// errIndexMismatch is returned when a row's blind index does not match its sealed name
+var errIndexMismatch = errors.New("secret name does not match its index")
+
+// sealedMetadata is what the notes column holds when metadata is encrypted.
+// The name and tags columns then hold blind indexes, so exact name lookup
+// and tag filtering still run in SQL.
+type sealedMetadata struct {
+	Name  string   `json:"name"`
+	Tags  []string `json:"tags"`
+	Notes string   `json:"notes,omitempty"`
+}
+
+// MetadataEncrypted reports whether secret names, tags, notes and field
+// labels are stored encrypted
+func (v *Vault) MetadataEncrypted() bool {
+	return v.metadataEncrypted
+}
+
+// metadataAAD binds sealed metadata to its secret
+func metadataAAD(secretID string) []byte {
+	return []byte("keyp-meta-v2\x00" + secretID)
+}
+
+// labelAAD binds a sealed field label to its secret and field
+func labelAAD(secretID, fieldID string) []byte {
+	return []byte("keyp-label-v2\x00" + secretID + "\x00" + fieldID)
+}
+
+// blindIndex returns a keyed hash of value that can be matched exactly in
+// SQL without revealing value
+func (v *Vault) blindIndex(kind, value string) (string, error) {
+	key, err := core.DeriveSubkey(v.key.Bytes(), blindIndexPurpose)
+	if err != nil {
+		return "", err
+	}
+	defer core.Wipe(key)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(kind + "\x00" + value))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// storeOptions translates search options for the store. A prefix is matched
+// against name keys, so it is folded like them. With encrypted metadata,
+// tags are matched by blind index, and the prefix and limit are applied
+// after decryption, since the store cannot see or order by names.
+func (v *Vault) storeOptions(opts *store.SearchOptions) (*store.SearchOptions, error) {
+	if opts == nil {
+		return nil, nil
+	}
+	if !v.metadataEncrypted {
+		if !v.caseInsensitiveNames || opts.Prefix == "" {
+			return opts, nil
+		}
+		translated := *opts
+		translated.Prefix = strings.ToLower(opts.Prefix)
+		return &translated, nil
+	}
+	translated := &store.SearchOptions{Tags: make([]string, len(opts.Tags))}
+	for i, tag := range opts.Tags {
+		index, err := v.blindIndex(indexTag, tag)
+		if err != nil {
+			return nil, err
+		}
+		translated.Tags[i] = index
+	}
+	return translated, nil
+}
+
+// hideMetadata replaces the name, tags, notes and field labels of a secret
+// about to be stored with their blind indexes and ciphertexts
+func (v *Vault) hideMetadata(secret *model.SecretObject) error {
+	data, err := json.Marshal(sealedMetadata{Name: secret.Name, Tags: secret.Tags, Notes: secret.Notes})
+	if err != nil {
+		return err
+	}
+	notes, err := v.encrypt(string(data), metadataAAD(secret.ID))
+	if err != nil {
+		return err
+	}
+	name, err := v.blindIndex(indexName, secret.Name)
+	if err != nil {
+		return err
+	}
+	tags := make([]string, len(secret.Tags))
+	for i, tag := range secret.Tags {
+		if tags[i], err = v.blindIndex(indexTag, tag); err != nil {
+			return err
+		}
+	}
+	for i := range secret.Fields {
+		f := &secret.Fields[i]
+		if f.Label, err = v.encrypt(f.Label, labelAAD(secret.ID, f.ID)); err != nil {
+			return err
+		}
+	}
+
+	secret.Name, secret.Tags, secret.Notes = name, tags, notes
+	return nil
+}
+
+// revealMetadata reverses hideMetadata on a stored secret and checks that
+// the row's name index belongs to the sealed name
+func (v *Vault) revealMetadata(secret *model.SecretObject) error {
+	data, err := decryptWithKey(v.key.Bytes(), secret.Notes, metadataAAD(secret.ID))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt secret metadata: %w", err)
+	}
+	var meta sealedMetadata
+	if err := json.Unmarshal([]byte(data), &meta); err != nil {
+		return fmt.Errorf("corrupted secret metadata: %w", err)
+	}
+	index, err := v.blindIndex(indexName, meta.Name)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(index), []byte(secret.Name)) {
+		return errIndexMismatch
+	}
+	for i := range secret.Fields {
+		f := &secret.Fields[i]
+		if f.Label, err = decryptWithKey(v.key.Bytes(), f.Label, labelAAD(secret.ID, f.ID)); err != nil {
+			return fmt.Errorf("failed to decrypt field label: %w", err)
+		}
+	}
+
+	if meta.Tags == nil {
+		meta.Tags = []string{}
+	}
+	secret.Name, secret.Tags, secret.Notes = meta.Name, meta.Tags, meta.Notes
+	return nil
+}
+
+// finishListing filters decrypted secrets by prefix, orders them by name
+// and applies the limit, which the store cannot do over blind indexes
+func (v *Vault) finishListing(secrets []*model.SecretObject, opts *store.SearchOptions) []*model.SecretObject {
+	if !v.metadataEncrypted {
+		return secrets
+	}
+	if opts != nil && opts.Prefix != "" {
+		var matched []*model.SecretObject
+		for _, s := range secrets {
+			if v.hasPrefix(s.Name, opts.Prefix) {
+				matched = append(matched, s)
+			}
+		}
+		secrets = matched
+	}
+	sort.Slice(secrets, func(i, j int) bool { return secrets[i].Name < secrets[j].Name })
+	if opts != nil && opts.Limit > 0 && len(secrets) > opts.Limit {
+		secrets = secrets[:opts.Limit]
+	}
+	return secrets
+}
+
+// searchDecrypted searches a vault with encrypted metadata by decrypting
+// every secret that passes the tag filter and matching in memory
+func (v *Vault) searchDecrypted(ctx context.Context, query string, opts *store.SearchOptions) ([]*model.SecretObject, error) {
+	var filter *store.SearchOptions
+	if opts != nil {
+		filter = &store.SearchOptions{Tags: opts.Tags, Prefix: opts.Prefix}
+	}
+	secrets, err := v.List(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []*model.SecretObject
+	for _, s := range secrets {
+		if matchesQuery(s, query) {
+			matched = append(matched, s)
+		}
+	}
+	return v.finishListing(matched, opts), nil
+}
+
+// matchesQuery reports whether query appears in the name, tags, notes or a
+// field label of secret, ignoring case like the store's LIKE search
+func matchesQuery(secret *model.SecretObject, query string) bool {
+	query = strings.ToLower(query)
+	contains := func(s string) bool { return strings.Contains(strings.ToLower(s), query) }
+
+	if contains(secret.Name) || contains(secret.Notes) {
+		return true
+	}
+	for _, tag := range secret.Tags {
+		if contains(tag) {
+			return true
+		}
+	}
+	for _, f := range secret.Fields {
+		if contains(f.Label) {
+			return true
+		}
+	}
+	return false
+}
+
+// EncryptMetadata switches the vault to encrypted metadata: secret names,
+// tags, notes, field labels and all field values are encrypted, in the
+// current secrets, the trash and their history alike, and names and tags
+// are indexed with keyed hashes. A verified backup of the vault is taken first if it
+// holds any secrets; its path is returned.
+func (v *Vault) EncryptMetadata(ctx context.Context) (string, error) {
+	if v.IsLocked() {
+		return "", ErrLocked
+	}
+	if v.metadataEncrypted {
+		return "", ErrMetadataEncrypted
+	}
+
+	secrets, err := v.List(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	trashed, err := v.Trash(ctx)
+	if err != nil {
+		return "", err
+	}
+	secrets = append(secrets, trashed...)
+	versions, err := v.decryptVersions(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var backupPath string
+	if len(secrets) > 0 {
+		if backupPath, err = store.Backup(v.path); err != nil {
+			return "", err
+		}
+	}
+
+	v.metadataEncrypted = true
+	sealed := make([]*model.SecretObject, len(secrets))
+	for i, s := range secrets {
+		if sealed[i], err = v.encryptSecret(s); err != nil {
+			v.metadataEncrypted = false
+			return backupPath, err
+		}
+	}
+	for _, version := range versions {
+		if version.Secret, err = v.encryptSecret(version.Secret); err != nil {
+			v.metadataEncrypted = false
+			return backupPath, err
+		}
+	}
+	if err := v.store.RewriteSecrets(ctx, sealed, versions, nil, map[string]string{metaMetadataEncrypted: "1"}); err != nil {
+		v.metadataEncrypted = false
+		return backupPath, err
+	}
+
+	// The overwritten plaintext would otherwise remain in free pages
+	if err := v.store.Vacuum(ctx); err != nil {
+		return backupPath, fmt.Errorf("failed to compact vault: %w", err)
+	}
+	return backupPath, nil
+}
@@ -0,0 +1,93 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/TheEditor/keyp/internal/core"
+	"github.com/TheEditor/keyp/internal/store"
+)
+
+// metaFieldsEncrypted marks vaults whose sensitive fields are known to be encrypted
+const metaFieldsEncrypted = "fields_encrypted"
+
+// metaFieldFormat records the format of encrypted field values
+const metaFieldFormat = "field_format"
+
+// fieldFormatBound is the format whose values are sealed with associated data
+const fieldFormatBound = "2"
+
+// migrate runs one-time upgrades on an unlocked vault
+func (v *Vault) migrate(ctx context.Context) error {
+	if _, err := v.store.GetMeta(metaFieldsEncrypted); err != nil {
+		if err := v.encryptPlaintextFields(ctx); err != nil {
+			return err
+		}
+	}
+	if format, _ := v.store.GetMeta(metaFieldFormat); format != fieldFormatBound {
+		return v.resealFields(ctx)
+	}
+	return nil
+}
+
+// encryptPlaintextFields encrypts sensitive field values that were written
+// directly to the store without going through the vault
+func (v *Vault) encryptPlaintextFields(ctx context.Context) error {
+	rows, err := v.store.SensitiveFields(ctx)
+	if err != nil {
+		return err
+	}
+
+	var updated []store.FieldRow
+	for _, row := range rows {
+		if isEncryptedValue(row.Value) {
+			continue
+		}
+		encrypted, err := v.sealField(row.SecretID, row.Field)
+		if err != nil {
+			return err
+		}
+		row.Value = encrypted
+		updated = append(updated, row)
+	}
+
+	return v.store.UpdateFieldValues(ctx, updated, map[string]string{
+		metaFieldsEncrypted: "1",
+	})
+}
+
+// resealFields re-encrypts field values written before associated data was
+// used, binding each to its secret and field identity
+func (v *Vault) resealFields(ctx context.Context) error {
+	rows, err := v.store.SensitiveFields(ctx)
+	if err != nil {
+		return err
+	}
+
+	var updated []store.FieldRow
+	for _, row := range rows {
+		if strings.HasPrefix(row.Value, fieldCipherPrefix) {
+			continue
+		}
+		plaintext, err := decryptWithKey(v.key.Bytes(), row.Value, nil)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt field %q: %w", row.Label, err)
+		}
+		row.Value = plaintext
+		if row.Value, err = v.sealField(row.SecretID, row.Field); err != nil {
+			return err
+		}
+		updated = append(updated, row)
+	}
+
+	return v.store.UpdateFieldValues(ctx, updated, map[string]string{
+		metaFieldFormat: fieldFormatBound,
+	})
+}
+
+// isEncryptedValue reports whether a stored value is an encrypted
+// envelope, in any format, with or without the bound-format prefix
+func isEncryptedValue(value string) bool {
+	return core.IsEnvelope(strings.TrimPrefix(value, fieldCipherPrefix))
+}
@@ -0,0 +1,148 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/TheEditor/keyp/internal/model"
+	"github.com/TheEditor/keyp/internal/store"
+)
+
+// metaCaseInsensitiveNames marks vaults whose secret names match regardless
+// of case
+const metaCaseInsensitiveNames = "case_insensitive_names"
+
+// nameKey returns the key the store matches name by, for lookups and
+// uniqueness: the name itself, folded to lower case if names are
+// case-insensitive, and with encrypted metadata a blind index of that
+func (v *Vault) nameKey(name string) (string, error) {
+	kind := indexName
+	if v.caseInsensitiveNames {
+		name = strings.ToLower(name)
+		kind = indexFoldedName
+	}
+	if !v.metadataEncrypted {
+		return name, nil
+	}
+	return v.blindIndex(kind, name)
+}
+
+// hasPrefix reports whether name starts with prefix, ignoring case if names
+// are case-insensitive
+func (v *Vault) hasPrefix(name, prefix string) bool {
+	if v.caseInsensitiveNames {
+		name, prefix = strings.ToLower(name), strings.ToLower(prefix)
+	}
+	return strings.HasPrefix(name, prefix)
+}
+
+// CaseInsensitiveNames reports whether secret names match regardless of case
+func (v *Vault) CaseInsensitiveNames() bool {
+	return v.caseInsensitiveNames
+}
+
+// SetCaseInsensitiveNames selects whether secret names match regardless of
+// case, in lookups and for uniqueness. Turning it on fails with
+// store.ErrAlreadyExists if two secrets have names that differ only in case.
+func (v *Vault) SetCaseInsensitiveNames(ctx context.Context, on bool) error {
+	if v.IsLocked() {
+		return ErrLocked
+	}
+	if on == v.caseInsensitiveNames {
+		return nil
+	}
+
+	secrets, err := v.List(ctx, nil)
+	if err != nil {
+		return err
+	}
+	trashed, err := v.Trash(ctx)
+	if err != nil {
+		return err
+	}
+
+	previous := v.caseInsensitiveNames
+	v.caseInsensitiveNames = on
+	keys, err := v.nameKeys(secrets, trashed)
+	if err != nil {
+		v.caseInsensitiveNames = previous
+		return err
+	}
+
+	setting := "0"
+	if on {
+		setting = "1"
+	}
+	if err := v.store.SetNameKeys(ctx, keys, map[string]string{metaCaseInsensitiveNames: setting}); err != nil {
+		v.caseInsensitiveNames = previous
+		return err
+	}
+	return nil
+}
+
+// nameKeys returns the name key of each secret by ID, checking that no two
+// secrets outside the trash share one
+func (v *Vault) nameKeys(secrets, trashed []*model.SecretObject) (map[string]string, error) {
+	keys := make(map[string]string, len(secrets)+len(trashed))
+	owners := make(map[string]string, len(secrets))
+	for _, s := range secrets {
+		key, err := v.nameKey(s.Name)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := owners[key]; ok {
+			return nil, fmt.Errorf("%w: '%s' and '%s' differ only in case", store.ErrAlreadyExists, other, s.Name)
+		}
+		owners[key] = s.Name
+		keys[s.ID] = key
+	}
+	for _, s := range trashed {
+		key, err := v.nameKey(s.Name)
+		if err != nil {
+			return nil, err
+		}
+		keys[s.ID] = key
+	}
+	return keys, nil
+}
+
+// Rename gives a secret a new name, keeping its fields. Like any update, the
+// state under the old name is kept in its history. Fails with
+// store.ErrAlreadyExists if the new name is taken.
+func (v *Vault) Rename(ctx context.Context, oldName, newName string) (*model.SecretObject, error) {
+	if v.IsLocked() {
+		return nil, ErrLocked
+	}
+	if err := model.ValidateName(newName); err != nil {
+		return nil, err
+	}
+	secret, err := v.GetByName(ctx, oldName)
+	if err != nil {
+		return nil, err
+	}
+	secret.Name = newName
+	if err := v.Update(ctx, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// renameStored renames a stored secret whose metadata has been revealed,
+// without decrypting its field values or adding to its history
+func (v *Vault) renameStored(ctx context.Context, s *model.SecretObject, name string) error {
+	renamed := *s
+	renamed.Name = name
+	renamed.Fields = append([]model.Field(nil), s.Fields...)
+	key, err := v.nameKey(name)
+	if err != nil {
+		return err
+	}
+	renamed.NameKey = key
+	if v.metadataEncrypted {
+		if err := v.hideMetadata(&renamed); err != nil {
+			return err
+		}
+	}
+	return v.store.RewriteSecrets(ctx, []*model.SecretObject{&renamed}, nil, nil, nil)
+}
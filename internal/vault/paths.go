@@ -0,0 +1,76 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/TheEditor/keyp/internal/model"
+	"github.com/TheEditor/keyp/internal/store"
+)
+
+// Move renames a secret or a whole folder, and returns how many secrets
+// moved: a secret named from becomes to, and one named from/rest becomes
+// to/rest. Each keeps its old name in its history. Nothing moves if any
+// new name is taken, which fails with store.ErrAlreadyExists.
+func (v *Vault) Move(ctx context.Context, from, to string) (int, error) {
+	if v.IsLocked() {
+		return 0, ErrLocked
+	}
+	from = strings.Trim(from, model.PathSeparator)
+	to = strings.Trim(to, model.PathSeparator)
+	if err := model.ValidateName(from); err != nil {
+		return 0, err
+	}
+	if err := model.ValidateName(to); err != nil {
+		return 0, err
+	}
+	if v.hasPrefix(to+model.PathSeparator, from+model.PathSeparator) {
+		return 0, fmt.Errorf("cannot move '%s' into itself", from)
+	}
+
+	secrets, err := v.List(ctx, &store.SearchOptions{Prefix: model.FolderPrefix(from)})
+	if err != nil {
+		return 0, err
+	}
+	secret, err := v.GetByName(ctx, from)
+	switch {
+	case err == nil:
+		secrets = append(secrets, secret)
+	case !errors.Is(err, store.ErrNotFound):
+		return 0, err
+	}
+	if len(secrets) == 0 {
+		return 0, store.ErrNotFound
+	}
+
+	moving := make(map[string]bool, len(secrets))
+	for _, s := range secrets {
+		moving[s.ID] = true
+	}
+
+	depth := strings.Count(from, model.PathSeparator) + 1
+	encrypted := make([]*model.SecretObject, len(secrets))
+	for i, s := range secrets {
+		segments := strings.Split(s.Name, model.PathSeparator)
+		s.Name = strings.Join(append([]string{to}, segments[depth:]...), model.PathSeparator)
+
+		existing, err := v.storedSecret(ctx, s.Name)
+		if err == nil && !moving[existing.ID] {
+			return 0, fmt.Errorf("%w: '%s'", store.ErrAlreadyExists, s.Name)
+		}
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return 0, err
+		}
+
+		if encrypted[i], err = v.encryptSecret(s); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := v.store.UpdateAll(ctx, encrypted); err != nil {
+		return 0, err
+	}
+	return len(secrets), nil
+}
@@ -0,0 +1,134 @@
+package vault
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TheEditor/keyp/internal/core"
+	"github.com/TheEditor/keyp/internal/store"
+)
+
+// recoverySlotLabel names the key slot opened by the recovery key
+const recoverySlotLabel = "recovery"
+
+// recoveryKeySize is the number of random bytes in a recovery key
+const recoveryKeySize = 32
+
+// ErrInvalidRecoveryKey is returned for malformed or wrong recovery keys
+var ErrInvalidRecoveryKey = errors.New("invalid recovery key")
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// formatRecoveryKey encodes a recovery key as dash-separated groups of four characters
+func formatRecoveryKey(raw []byte) string {
+	encoded := recoveryEncoding.EncodeToString(raw)
+	var groups []string
+	for len(encoded) > 4 {
+		groups = append(groups, encoded[:4])
+		encoded = encoded[4:]
+	}
+	groups = append(groups, encoded)
+	return strings.Join(groups, "-")
+}
+
+// parseRecoveryKey decodes a recovery key, ignoring case, dashes and spaces
+func parseRecoveryKey(s string) ([]byte, error) {
+	s = strings.ToUpper(s)
+	s = strings.NewReplacer("-", "", " ", "").Replace(s)
+	raw, err := recoveryEncoding.DecodeString(s)
+	if err != nil || len(raw) != recoveryKeySize {
+		return nil, ErrInvalidRecoveryKey
+	}
+	return raw, nil
+}
+
+// AddRecoveryKey generates a recovery key, stores a key slot for it and
+// returns the formatted key. The key cannot be shown again.
+func (v *Vault) AddRecoveryKey() (string, error) {
+	if v.IsLocked() {
+		return "", ErrLocked
+	}
+
+	slots, err := loadSlots(v.meta)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range slots {
+		if s.Kind == SlotRecovery || s.Label == recoverySlotLabel {
+			return "", ErrSlotExists
+		}
+	}
+
+	raw := make([]byte, recoveryKeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate recovery key: %w", err)
+	}
+	slot, err := newSlot(recoverySlotLabel, string(raw), nil, core.DefaultKDF(), v.key.Bytes())
+	if err != nil {
+		return "", err
+	}
+	slot.Kind = SlotRecovery
+
+	if err := saveSlots(v.meta, append(slots, *slot)); err != nil {
+		return "", err
+	}
+	return formatRecoveryKey(raw), nil
+}
+
+// OpenWithRecoveryKey opens an existing vault with its recovery key
+func OpenWithRecoveryKey(path string, recoveryKey string) (*Vault, error) {
+	if !Exists(path) {
+		return nil, ErrNotExists
+	}
+
+	raw, err := parseRecoveryKey(recoveryKey)
+	if err != nil {
+		return nil, err
+	}
+	v, err := open(path, credentials{recoveryKey: raw})
+	if errors.Is(err, store.ErrInvalidPassword) {
+		return nil, ErrInvalidRecoveryKey
+	}
+	return v, err
+}
+
+// ResetPassword sets the password of the named password slot without
+// knowing the old one, creating the slot if needed. It is meant for vaults
+// opened with the recovery key. An empty keyFilePath means password only.
+func (v *Vault) ResetPassword(label, password, keyFilePath string) error {
+	if v.IsLocked() {
+		return ErrLocked
+	}
+
+	keyFile, err := readKeyFile(keyFilePath)
+	if err != nil {
+		return err
+	}
+	slot, err := newSlot(label, password, keyFile, core.DefaultKDF(), v.key.Bytes())
+	if err != nil {
+		return err
+	}
+
+	slots, err := loadSlots(v.meta)
+	if err != nil {
+		return err
+	}
+	for i := range slots {
+		if slots[i].Label != label {
+			continue
+		}
+		if slots[i].Kind != SlotPassword {
+			return fmt.Errorf("key slot '%s' is not a password slot", label)
+		}
+		slot.CreatedAt = slots[i].CreatedAt
+		slots[i] = *slot
+		return saveSlots(v.meta, slots)
+	}
+
+	slot.CreatedAt = time.Now()
+	return saveSlots(v.meta, append(slots, *slot))
+}
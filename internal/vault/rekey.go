@@ -0,0 +1,232 @@
+package vault
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"os"
+
+	"github.com/TheEditor/keyp/internal/core"
+	"github.com/TheEditor/keyp/internal/model"
+	"github.com/TheEditor/keyp/internal/store"
+)
+
+// ChangePassword re-wraps the data key in the key slot that oldPassword
+// opens under newPassword. On failure the vault remains readable with the
+// old password.
+func (v *Vault) ChangePassword(ctx context.Context, oldPassword, newPassword string) error {
+	if v.IsLocked() {
+		return ErrLocked
+	}
+
+	key, slot, err := unlockKey(v.meta, credentials{password: oldPassword, keyFile: v.keyFile.Bytes()})
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+	if subtle.ConstantTimeCompare(key.Bytes(), v.key.Bytes()) != 1 {
+		return store.ErrInvalidPassword
+	}
+
+	return v.setPassword(slot.Label, newPassword, core.DefaultKDF())
+}
+
+// replaceKey re-encrypts everything in the vault under a new data key:
+// the secrets, the trash, their history and attachments, with their
+// metadata and name indexes, and the verification value. The given unlock
+// metadata is stored alongside. Either the whole change lands or the vault
+// keeps working with the old key. The vault takes ownership of newKey.
+func (v *Vault) replaceKey(ctx context.Context, newKey *core.SecretBuffer, unlockMeta map[string]string) error {
+	err := v.rewriteUnderKey(ctx, newKey, unlockMeta)
+	if v.key == newKey {
+		// A SQLCipher vault already switched when its database was swapped
+		return err
+	}
+	if err != nil {
+		newKey.Destroy()
+		return err
+	}
+	v.key.Destroy()
+	v.key = newKey
+	return nil
+}
+
+// rewriteUnderKey does the work of replaceKey
+func (v *Vault) rewriteUnderKey(ctx context.Context, newKey *core.SecretBuffer, unlockMeta map[string]string) error {
+	secrets, err := v.List(ctx, nil)
+	if err != nil {
+		return err
+	}
+	trashed, err := v.Trash(ctx)
+	if err != nil {
+		return err
+	}
+	secrets = append(secrets, trashed...)
+	versions, err := v.decryptVersions(ctx)
+	if err != nil {
+		return err
+	}
+
+	next := &Vault{
+		key:                  newKey,
+		cipher:               v.cipher,
+		metadataEncrypted:    v.metadataEncrypted,
+		caseInsensitiveNames: v.caseInsensitiveNames,
+	}
+	sealed := make([]*model.SecretObject, len(secrets))
+	for i, secret := range secrets {
+		if sealed[i], err = next.encryptSecret(secret); err != nil {
+			return err
+		}
+	}
+	for _, version := range versions {
+		if version.Secret, err = next.encryptSecret(version.Secret); err != nil {
+			return err
+		}
+	}
+	attachments, err := v.resealAttachments(ctx, next, secrets)
+	if err != nil {
+		return err
+	}
+
+	verify, err := next.encryptValue(verificationPlaintext)
+	if err != nil {
+		return fmt.Errorf("failed to create verification value: %w", err)
+	}
+	unlockMeta["verify"] = verify
+
+	if v.IsDatabaseEncrypted() {
+		return v.replaceDatabaseKey(ctx, newKey, func(s *store.Store) error {
+			return s.RewriteSecrets(ctx, sealed, versions, attachments, nil)
+		}, unlockMeta)
+	}
+
+	// Secrets and unlock metadata share the database, so one transaction covers both
+	return v.store.RewriteSecrets(ctx, sealed, versions, attachments, unlockMeta)
+}
+
+// resealAttachments prepares the attachments of the given secrets to be
+// rewritten under the key of next. Their content is re-sealed chunk by
+// chunk as the rewrite reads it.
+func (v *Vault) resealAttachments(ctx context.Context, next *Vault, secrets []*model.SecretObject) ([]store.AttachmentRewrite, error) {
+	var rewrites []store.AttachmentRewrite
+	for _, secret := range secrets {
+		stored, err := v.store.Attachments(ctx, secret.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range stored {
+			attachment, err := v.openAttachment(a)
+			if err != nil {
+				return nil, err
+			}
+			aad := attachmentAAD(a.SecretID, a.ID)
+			opener, err := core.NewStreamOpener(a.Cipher, v.key.Bytes(), aad)
+			if err != nil {
+				return nil, err
+			}
+
+			rewritten := *a
+			rewritten.Cipher = next.cipherName()
+			if rewritten.NameKey, err = next.attachmentKey(a.SecretID, attachment.Name); err != nil {
+				return nil, err
+			}
+			if rewritten.Name, err = next.encrypt(attachment.Name, attachmentNameAAD(a.SecretID, a.ID)); err != nil {
+				return nil, err
+			}
+			sealer, err := core.NewStreamSealer(rewritten.Cipher, next.key.Bytes(), aad)
+			if err != nil {
+				return nil, err
+			}
+
+			name := attachment.Name
+			rewrites = append(rewrites, store.AttachmentRewrite{
+				StoredAttachment: &rewritten,
+				Reseal: func(chunk []byte, last bool) ([]byte, error) {
+					plaintext, err := opener.Open(chunk, last)
+					if err != nil {
+						return nil, fmt.Errorf("attachment '%s' of '%s' is corrupted: %w", name, secret.Name, err)
+					}
+					defer core.Wipe(plaintext)
+					return sealer.Seal(plaintext, last)
+				},
+			})
+		}
+	}
+	return rewrites, nil
+}
+
+// replaceDatabaseKey re-keys a SQLCipher vault. The database is copied to a
+// temporary file under the new key and rewrite is applied to the copy. The
+// new unlock metadata is staged in a pending header before the copy is
+// swapped in, so Open can finish the switch if it is interrupted.
+func (v *Vault) replaceDatabaseKey(ctx context.Context, newKey *core.SecretBuffer, rewrite func(*store.Store) error, unlockMeta map[string]string) error {
+	h := v.meta.(*header)
+
+	dbKey, err := core.DeriveSubkey(newKey.Bytes(), sqlcipherKeyPurpose)
+	if err != nil {
+		return err
+	}
+	defer core.Wipe(dbKey)
+
+	tmp := v.path + ".rekey.tmp"
+	os.Remove(tmp)
+	if err := v.store.ExportEncrypted(ctx, tmp, dbKey); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := verifyEncryptedCopy(ctx, v.store, tmp, dbKey); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	copyStore, err := store.OpenEncrypted(tmp, dbKey)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	err = rewrite(copyStore)
+	copyStore.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	pending, err := h.stage(unlockMeta)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// Swap the re-keyed copy into place
+	v.store.Close()
+	if err := os.Rename(tmp, v.path); err != nil {
+		os.Remove(tmp)
+		os.Remove(pending.path)
+		s, openErr := openEncryptedStore(v.path, v.key.Bytes())
+		if openErr != nil {
+			v.store = nil
+			v.locked = true
+		} else {
+			v.store = s
+		}
+		return fmt.Errorf("failed to replace vault: %w", err)
+	}
+
+	// The database now needs the new key
+	v.key.Destroy()
+	v.key = newKey
+
+	// If this fails the next Open promotes the pending header instead
+	promoteErr := pending.promote()
+
+	s, err := store.OpenEncrypted(v.path, dbKey)
+	if err != nil {
+		v.store = nil
+		v.locked = true
+		return fmt.Errorf("failed to reopen re-keyed vault: %w", err)
+	}
+	v.store = s
+	v.meta = pending
+	return promoteErr
+}
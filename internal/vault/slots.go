@@ -0,0 +1,492 @@
+package vault
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TheEditor/keyp/internal/core"
+	"github.com/TheEditor/keyp/internal/store"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// metaSlots holds the JSON list of key slots
+const metaSlots = "slots"
+
+// slotKeyPurpose separates the X25519 private key of a slot from its key-encryption key
+const slotKeyPurpose = "keyp-slot-x25519-v1"
+
+// defaultSlotLabel names the slot created by Init and by conversion of older vaults
+const defaultSlotLabel = "default"
+
+// legacyUnlockKeys are replaced by the slot list when an older vault is converted
+var legacyUnlockKeys = []string{"salt", "iterations", metaKDF, metaWrappedKey}
+
+var (
+	ErrSlotExists   = errors.New("key slot already exists")
+	ErrSlotNotFound = errors.New("key slot not found")
+	ErrLastSlot     = errors.New("cannot remove the last password key slot")
+
+	// ErrSlotNotSealed is returned when removing a slot would leave a slot
+	// from before sealed slots, which cannot receive the new data key
+	ErrSlotNotSealed = errors.New("key slot must be unlocked once before a slot can be removed")
+)
+
+// Key slot kinds
+const (
+	SlotPassword = ""         // Opened with a password, and a key file if required
+	SlotRecovery = "recovery" // Opened with a generated recovery key
+)
+
+// credentials are what the caller presents to open a key slot. Password
+// slots are tried unless a recovery key is given.
+type credentials struct {
+	password    string
+	keyFile     []byte
+	recoveryKey []byte
+}
+
+// KeySlot is one way to unlock the vault: a password-derived key with its
+// own salt and KDF, holding a copy of the vault data key. The copy is
+// sealed to an X25519 key pair derived from the password key, so a new
+// data key can be sealed to the slot without its password. Slots from
+// before that have no public key and wrap the data key with the password
+// key itself until they are next unlocked.
+type KeySlot struct {
+	Label      string         `json:"label"`
+	Kind       string         `json:"kind,omitempty"`
+	KDF        core.KDFParams `json:"kdf"`
+	Salt       string         `json:"salt"`
+	WrappedKey string         `json:"wrapped_key"`
+	PublicKey  string         `json:"public_key,omitempty"` // X25519 key the data key is sealed to
+	KeyFile    bool           `json:"keyfile,omitempty"`    // Key file required alongside the password
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+// newSlot derives a key from password, and the key file digest if given,
+// with a fresh salt and seals the data key to the key pair derived from it
+func newSlot(label, password string, keyFile []byte, params core.KDFParams, key []byte) (*KeySlot, error) {
+	salt := make([]byte, core.SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	secret := password
+	if keyFile != nil {
+		secret = compositeSecret(password, keyFile)
+	}
+	kek, err := params.Derive(secret, salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	priv, err := slotPrivateKey(kek)
+	core.Wipe(kek)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+	core.Wipe(priv[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive slot key: %w", err)
+	}
+
+	slot := &KeySlot{
+		Label:     label,
+		KDF:       params,
+		Salt:      base64.StdEncoding.EncodeToString(salt),
+		PublicKey: base64.StdEncoding.EncodeToString(pub),
+		KeyFile:   keyFile != nil,
+		CreatedAt: time.Now(),
+	}
+	if err := slot.seal(key); err != nil {
+		return nil, err
+	}
+	return slot, nil
+}
+
+// slotPrivateKey derives the X25519 private key of a slot from its
+// key-encryption key
+func slotPrivateKey(kek []byte) (*[32]byte, error) {
+	derived, err := core.DeriveSubkey(kek, slotKeyPurpose)
+	if err != nil {
+		return nil, err
+	}
+	defer core.Wipe(derived)
+	var priv [32]byte
+	copy(priv[:], derived)
+	return &priv, nil
+}
+
+// publicKey decodes the X25519 public key of the slot
+func (s *KeySlot) publicKey() (*[32]byte, error) {
+	pub, err := base64.StdEncoding.DecodeString(s.PublicKey)
+	if err != nil || len(pub) != 32 {
+		return nil, fmt.Errorf("corrupted key slot '%s': invalid public key", s.Label)
+	}
+	return (*[32]byte)(pub), nil
+}
+
+// seal replaces the data key held by the slot, which needs only its public key
+func (s *KeySlot) seal(key []byte) error {
+	pub, err := s.publicKey()
+	if err != nil {
+		return err
+	}
+	sealed, err := box.SealAnonymous(nil, key, pub, rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to seal data key: %w", err)
+	}
+	s.WrappedKey = base64.StdEncoding.EncodeToString(sealed)
+	return nil
+}
+
+// open decrypts the data key sealed to the slot with the private key
+// derived from kek; a wrong key yields ErrInvalidPassword
+func (s *KeySlot) open(kek []byte) (*core.SecretBuffer, error) {
+	pub, err := s.publicKey()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(s.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("corrupted vault metadata: %w", err)
+	}
+	priv, err := slotPrivateKey(kek)
+	if err != nil {
+		return nil, err
+	}
+	defer core.Wipe(priv[:])
+
+	key, ok := box.OpenAnonymous(nil, sealed, pub, priv)
+	if !ok {
+		return nil, store.ErrInvalidPassword
+	}
+	if len(key) != core.KeySize {
+		core.Wipe(key)
+		return nil, fmt.Errorf("corrupted vault metadata: sealed key has wrong size")
+	}
+	return core.SecretBufferFrom(key)
+}
+
+// unwrap returns the data key if the credentials open this slot. Slots of
+// vaults from before envelope encryption have no wrapped key; the password
+// key is the data key.
+func (s *KeySlot) unwrap(creds credentials) (*core.SecretBuffer, error) {
+	secret := creds.password
+	if s.Kind == SlotRecovery {
+		secret = string(creds.recoveryKey)
+	} else if s.KeyFile {
+		if creds.keyFile == nil {
+			return nil, ErrKeyFileRequired
+		}
+		secret = compositeSecret(creds.password, creds.keyFile)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(s.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("corrupted vault metadata: %w", err)
+	}
+	kek, err := s.KDF.Derive(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	if s.WrappedKey == "" {
+		return core.SecretBufferFrom(kek)
+	}
+	defer core.Wipe(kek)
+	if s.PublicKey != "" {
+		return s.open(kek)
+	}
+	return unwrapKey(kek, s.WrappedKey)
+}
+
+// unlockKey tries the credentials against every key slot of the matching
+// kind and returns the data key with the slot that opened it
+func unlockKey(meta metaStore, creds credentials) (*core.SecretBuffer, *KeySlot, error) {
+	slots, err := loadSlots(meta)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kind := SlotPassword
+	if creds.recoveryKey != nil {
+		kind = SlotRecovery
+	}
+
+	needKeyFile := false
+	for i := range slots {
+		if slots[i].Kind != kind {
+			continue
+		}
+		key, err := slots[i].unwrap(creds)
+		if errors.Is(err, ErrKeyFileRequired) {
+			needKeyFile = true
+			continue
+		}
+		if errors.Is(err, store.ErrInvalidPassword) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, &slots[i], nil
+	}
+	if needKeyFile {
+		return nil, nil, ErrKeyFileRequired
+	}
+	return nil, nil, store.ErrInvalidPassword
+}
+
+// loadSlots reads the key slots. Vaults from before key slots are presented
+// as a single slot built from their unlock parameters.
+func loadSlots(meta metaStore) ([]KeySlot, error) {
+	data, err := meta.GetMeta(metaSlots)
+	if errors.Is(err, store.ErrNotFound) {
+		slot, err := legacySlot(meta)
+		if err != nil {
+			return nil, err
+		}
+		return []KeySlot{slot}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault metadata: %w", err)
+	}
+
+	var slots []KeySlot
+	if err := json.Unmarshal([]byte(data), &slots); err != nil {
+		return nil, fmt.Errorf("corrupted key slots: %w", err)
+	}
+	if len(slots) == 0 {
+		return nil, errors.New("corrupted key slots: vault has no key slots")
+	}
+	return slots, nil
+}
+
+// saveSlots writes the key slots, removing any other listed metadata in the same write
+func saveSlots(meta metaStore, slots []KeySlot, remove ...string) error {
+	data, err := json.Marshal(slots)
+	if err != nil {
+		return err
+	}
+	return meta.SetMetaValues(map[string]string{metaSlots: string(data)}, remove...)
+}
+
+// legacySlot builds a slot from the salt, KDF and wrapped key of a vault without key slots
+func legacySlot(meta metaStore) (KeySlot, error) {
+	salt, err := meta.GetMeta("salt")
+	if err != nil {
+		return KeySlot{}, fmt.Errorf("failed to read vault metadata: %w", err)
+	}
+	params, err := legacyKDFParams(meta)
+	if err != nil {
+		return KeySlot{}, err
+	}
+	wrapped, err := meta.GetMeta(metaWrappedKey)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return KeySlot{}, fmt.Errorf("failed to read vault metadata: %w", err)
+	}
+
+	return KeySlot{
+		Label:      defaultSlotLabel,
+		KDF:        params,
+		Salt:       salt,
+		WrappedKey: wrapped,
+	}, nil
+}
+
+// legacyKDFParams reads the KDF descriptor. Vaults created before
+// descriptors existed only record a PBKDF2 iteration count.
+func legacyKDFParams(meta metaStore) (core.KDFParams, error) {
+	descriptor, err := meta.GetMeta(metaKDF)
+	if err == nil {
+		params, err := core.ParseKDFParams(descriptor)
+		if err != nil {
+			return core.KDFParams{}, fmt.Errorf("corrupted vault metadata: %w", err)
+		}
+		return params, nil
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return core.KDFParams{}, fmt.Errorf("failed to read vault metadata: %w", err)
+	}
+
+	iterStr, err := meta.GetMeta("iterations")
+	if err != nil {
+		return core.KDFParams{}, fmt.Errorf("failed to read vault metadata: %w", err)
+	}
+	iterations, err := strconv.Atoi(iterStr)
+	if err != nil {
+		return core.KDFParams{}, fmt.Errorf("corrupted vault metadata: %w", err)
+	}
+	return core.PBKDF2Params(iterations), nil
+}
+
+// convertToSlots replaces the single set of unlock parameters of an older
+// vault with an equivalent "default" key slot
+func (v *Vault) convertToSlots() error {
+	slot, err := legacySlot(v.meta)
+	if err != nil {
+		return err
+	}
+	slot.CreatedAt = time.Now()
+	return saveSlots(v.meta, []KeySlot{slot}, legacyUnlockKeys...)
+}
+
+// Slots returns the key slots of the vault
+func (v *Vault) Slots() ([]KeySlot, error) {
+	if v.IsLocked() {
+		return nil, ErrLocked
+	}
+	return loadSlots(v.meta)
+}
+
+// ReadSlots returns the key slots of the vault at path without unlocking it
+func ReadSlots(path string) ([]KeySlot, error) {
+	var slots []KeySlot
+	err := withUnlockMeta(path, func(meta metaStore) error {
+		var err error
+		slots, err = loadSlots(meta)
+		return err
+	})
+	return slots, err
+}
+
+// AddSlot adds a key slot that unlocks the vault with password and, if
+// keyFilePath is not empty, the key file at that path
+func (v *Vault) AddSlot(label, password, keyFilePath string) error {
+	if v.IsLocked() {
+		return ErrLocked
+	}
+	if label == "" {
+		return errors.New("key slot label is required")
+	}
+
+	slots, err := loadSlots(v.meta)
+	if err != nil {
+		return err
+	}
+	for _, s := range slots {
+		if s.Label == label {
+			return ErrSlotExists
+		}
+	}
+
+	keyFile, err := readKeyFile(keyFilePath)
+	if err != nil {
+		return err
+	}
+	slot, err := newSlot(label, password, keyFile, core.DefaultKDF(), v.key.Bytes())
+	if err != nil {
+		return err
+	}
+	return saveSlots(v.meta, append(slots, *slot))
+}
+
+// RemoveSlot removes a key slot and revokes it: a new data key is
+// generated, everything in the vault is re-encrypted under it and it is
+// sealed to the remaining slots, so neither the removed password nor a
+// data key taken from an old copy of the vault opens anything written from
+// now on. The last password slot cannot be removed, and every remaining
+// slot must have been unlocked since slots were sealed (ErrSlotNotSealed).
+func (v *Vault) RemoveSlot(ctx context.Context, label string) error {
+	if v.IsLocked() {
+		return ErrLocked
+	}
+
+	slots, err := loadSlots(v.meta)
+	if err != nil {
+		return err
+	}
+	passwordSlots := 0
+	index := -1
+	for i, s := range slots {
+		if s.Kind == SlotPassword {
+			passwordSlots++
+		}
+		if s.Label == label {
+			index = i
+		}
+	}
+	if index < 0 {
+		return ErrSlotNotFound
+	}
+	if slots[index].Kind == SlotPassword && passwordSlots == 1 {
+		return ErrLastSlot
+	}
+
+	remaining := append(slots[:index:index], slots[index+1:]...)
+	var unsealed []string
+	for _, s := range remaining {
+		if s.PublicKey == "" {
+			unsealed = append(unsealed, s.Label)
+		}
+	}
+	if len(unsealed) > 0 {
+		return fmt.Errorf("%w: %s", ErrSlotNotSealed, strings.Join(unsealed, ", "))
+	}
+
+	key, err := newDataKey()
+	if err != nil {
+		return err
+	}
+	for i := range remaining {
+		if err := remaining[i].seal(key.Bytes()); err != nil {
+			key.Destroy()
+			return err
+		}
+	}
+	data, err := json.Marshal(remaining)
+	if err != nil {
+		key.Destroy()
+		return err
+	}
+	if err := v.replaceKey(ctx, key, map[string]string{metaSlots: string(data)}); err != nil {
+		return err
+	}
+
+	// The old ciphertexts would otherwise remain in free pages
+	if err := v.store.Vacuum(ctx); err != nil {
+		return fmt.Errorf("failed to compact vault: %w", err)
+	}
+	return nil
+}
+
+// setPassword re-seals the data key in the named slot under a key derived
+// from password, or the recovery key of a recovery slot, with a fresh salt.
+// Slots that need a key file keep using the one the vault was unlocked
+// with. The slot list is replaced in a single write.
+func (v *Vault) setPassword(label, password string, params core.KDFParams) error {
+	if v.IsLocked() {
+		return ErrLocked
+	}
+
+	slots, err := loadSlots(v.meta)
+	if err != nil {
+		return err
+	}
+	for i := range slots {
+		if slots[i].Label != label {
+			continue
+		}
+		var keyFile []byte
+		if slots[i].KeyFile {
+			if keyFile = v.keyFile.Bytes(); keyFile == nil {
+				return ErrKeyFileRequired
+			}
+		}
+		slot, err := newSlot(label, password, keyFile, params, v.key.Bytes())
+		if err != nil {
+			return err
+		}
+		slot.Kind = slots[i].Kind
+		slot.CreatedAt = slots[i].CreatedAt
+		slots[i] = *slot
+		return saveSlots(v.meta, slots)
+	}
+	return ErrSlotNotFound
+}
@@ -0,0 +1,128 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/TheEditor/keyp/internal/core"
+	"github.com/TheEditor/keyp/internal/store"
+)
+
+// ErrAlreadyEncrypted is returned when migrating a vault that already uses SQLCipher
+var ErrAlreadyEncrypted = errors.New("vault database is already encrypted")
+
+// IsDatabaseEncrypted reports whether the vault uses whole-database encryption
+func (v *Vault) IsDatabaseEncrypted() bool {
+	_, ok := v.meta.(*header)
+	return ok
+}
+
+// MigrateToSQLCipher converts a plain vault into a SQLCipher-encrypted database
+// in place. A verified backup of the original file is kept; its path is returned.
+func (v *Vault) MigrateToSQLCipher(ctx context.Context) (string, error) {
+	if v.IsLocked() {
+		return "", ErrLocked
+	}
+	if v.IsDatabaseEncrypted() {
+		return "", ErrAlreadyEncrypted
+	}
+	if !store.SQLCipherAvailable() {
+		return "", store.ErrSQLCipherUnavailable
+	}
+
+	backupPath, err := store.Backup(v.path)
+	if err != nil {
+		return "", err
+	}
+
+	dbKey, err := core.DeriveSubkey(v.key.Bytes(), sqlcipherKeyPurpose)
+	if err != nil {
+		return backupPath, err
+	}
+	defer core.Wipe(dbKey)
+
+	// Export into a temporary file next to the vault
+	tmp := v.path + ".sqlcipher.tmp"
+	os.Remove(tmp)
+	if err := v.store.ExportEncrypted(ctx, tmp, dbKey); err != nil {
+		os.Remove(tmp)
+		return backupPath, err
+	}
+
+	// Verify the encrypted copy before it replaces the original
+	if err := verifyEncryptedCopy(ctx, v.store, tmp, dbKey); err != nil {
+		os.Remove(tmp)
+		return backupPath, err
+	}
+
+	// Move unlock metadata into the sidecar header
+	h := newHeader(v.path)
+	for _, key := range unlockMetaKeys {
+		value, err := v.meta.GetMeta(key)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			os.Remove(tmp)
+			return backupPath, err
+		}
+		h.values[key] = value
+	}
+	if err := h.save(); err != nil {
+		os.Remove(tmp)
+		return backupPath, err
+	}
+
+	// Swap the encrypted copy into place
+	v.store.Close()
+	if err := os.Rename(tmp, v.path); err != nil {
+		os.Remove(tmp)
+		os.Remove(h.path)
+		s, openErr := store.Open(v.path)
+		if openErr != nil {
+			v.store = nil
+			v.locked = true
+			return backupPath, fmt.Errorf("failed to replace vault: %w", err)
+		}
+		v.store = s
+		return backupPath, fmt.Errorf("failed to replace vault: %w", err)
+	}
+
+	s, err := store.OpenEncrypted(v.path, dbKey)
+	if err != nil {
+		v.store = nil
+		v.locked = true
+		return backupPath, fmt.Errorf("failed to reopen migrated vault: %w", err)
+	}
+	v.store = s
+	v.meta = h
+
+	return backupPath, nil
+}
+
+// verifyEncryptedCopy checks that an exported database opens with the key
+// and holds the same number of secrets and fields as the source
+func verifyEncryptedCopy(ctx context.Context, src *store.Store, path string, key []byte) error {
+	wantSecrets, wantFields, err := src.Counts(ctx)
+	if err != nil {
+		return err
+	}
+
+	enc, err := store.OpenEncrypted(path, key)
+	if err != nil {
+		return fmt.Errorf("failed to open encrypted copy: %w", err)
+	}
+	defer enc.Close()
+
+	gotSecrets, gotFields, err := enc.Counts(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read encrypted copy: %w", err)
+	}
+	if gotSecrets != wantSecrets || gotFields != wantFields {
+		return fmt.Errorf("encrypted copy does not match vault (%d/%d secrets, %d/%d fields)",
+			gotSecrets, wantSecrets, gotFields, wantFields)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package vault
+
+import (
+	"context"
+	"time"
+
+	"github.com/TheEditor/keyp/internal/model"
+)
+
+// Trash returns the decrypted secrets in the trash, most recently deleted
+// first
+func (v *Vault) Trash(ctx context.Context) ([]*model.SecretObject, error) {
+	if v.IsLocked() {
+		return nil, ErrLocked
+	}
+	secrets, err := v.store.Trash(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var decrypted []*model.SecretObject
+	for _, s := range secrets {
+		d, err := v.decryptSecret(s)
+		if err != nil {
+			return nil, err
+		}
+		decrypted = append(decrypted, d)
+	}
+	return decrypted, nil
+}
+
+// Restore takes a secret out of the trash. If several deleted secrets had
+// the name, the most recently deleted one is restored.
+func (v *Vault) Restore(ctx context.Context, name string) error {
+	if v.IsLocked() {
+		return ErrLocked
+	}
+	key, err := v.nameKey(name)
+	if err != nil {
+		return err
+	}
+	return v.store.Restore(ctx, key)
+}
+
+// Purge permanently removes the secrets that have been in the trash for at
+// least olderThan, and returns how many were removed. Zero empties the trash.
+func (v *Vault) Purge(ctx context.Context, olderThan time.Duration) (int, error) {
+	if v.IsLocked() {
+		return 0, ErrLocked
+	}
+	n, err := v.store.Purge(ctx, time.Now().Add(-olderThan))
+	if err != nil || n == 0 {
+		return n, err
+	}
+	// Purged content would otherwise linger in free pages
+	return n, v.store.Vacuum(ctx)
+}
@@ -2,24 +2,25 @@ package vault
 
 import (
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
 
 	"github.com/TheEditor/keyp/internal/core"
 	"github.com/TheEditor/keyp/internal/model"
 	"github.com/TheEditor/keyp/internal/store"
+	"github.com/google/uuid"
 )
 
 const verificationPlaintext = "keyp-vault-v1"
 
+// metaKDF holds the JSON descriptor of the password KDF in vaults without key slots
+const metaKDF = "kdf"
+
+// sqlcipherKeyPurpose separates the SQLCipher database key from the field key
+const sqlcipherKeyPurpose = "keyp-sqlcipher-v1"
+
 var (
 	ErrLocked        = errors.New("vault is locked")
 	ErrAlreadyExists = errors.New("vault already exists")
@@ -28,10 +29,18 @@ var (
 
 // Vault manages the secret store lifecycle
 type Vault struct {
-	path   string
-	store  *store.Store
-	key    []byte
-	locked bool
+	id      string // Random vault identity; empty for older vaults until assigned
+	path    string
+	store   *store.Store
+	meta    metaStore          // Unlock metadata (the store, or a header for SQLCipher vaults)
+	key     *core.SecretBuffer // Data key, in locked memory
+	slot    string             // Key slot used to unlock; empty when opened with a key
+	keyFile *core.SecretBuffer // Digest of the key file used to unlock, if any
+	locked  bool
+
+	cipher               string // Cipher for new writes; empty means core.DefaultCipher
+	metadataEncrypted    bool   // Names, tags, notes and labels are sealed
+	caseInsensitiveNames bool   // Names match regardless of case
 }
 
 // DefaultPath returns the default vault path (~/.keyp/vault.db)
@@ -48,6 +57,25 @@ func Exists(path string) bool {
 
 // Init creates a new vault at the specified path with password protection
 func Init(path string, password string) (*Vault, error) {
+	return InitWithKeyFile(path, password, "")
+}
+
+// InitWithKeyFile creates a new vault that needs both password and the key
+// file at keyFilePath to unlock. An empty keyFilePath means password only.
+func InitWithKeyFile(path string, password string, keyFilePath string) (*Vault, error) {
+	if Exists(path) {
+		return nil, ErrAlreadyExists
+	}
+	keyFile, err := readKeyFile(keyFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer core.Wipe(keyFile)
+	return initVault(path, password, keyFile, core.DefaultKDF())
+}
+
+// initVault creates a new vault whose key is derived with the given KDF
+func initVault(path string, password string, keyFile []byte, params core.KDFParams) (*Vault, error) {
 	if Exists(path) {
 		return nil, ErrAlreadyExists
 	}
@@ -64,44 +92,54 @@ func Init(path string, password string) (*Vault, error) {
 		return nil, err
 	}
 
-	// Generate random salt
-	salt := make([]byte, core.SaltSize)
-	if _, err := rand.Read(salt); err != nil {
+	// Fields are encrypted with a random data key wrapped by the password key
+	key, err := newDataKey()
+	if err != nil {
 		s.Close()
-		return nil, fmt.Errorf("failed to generate salt: %w", err)
+		return nil, err
 	}
-
-	// Derive encryption key
-	key, err := core.DeriveKey(password, salt, core.MinIterations)
+	keyFileCopy, err := keyFileBuffer(keyFile)
 	if err != nil {
+		key.Destroy()
 		s.Close()
-		return nil, fmt.Errorf("failed to derive key: %w", err)
+		return nil, err
 	}
-
-	// Store encryption metadata
-	if err := s.SetMeta("salt", base64.StdEncoding.EncodeToString(salt)); err != nil {
-		s.Close()
+	v := &Vault{
+		path:    path,
+		store:   s,
+		meta:    s,
+		key:     key,
+		keyFile: keyFileCopy,
+		locked:  false,
+	}
+	slot, err := newSlot(defaultSlotLabel, password, keyFile, params, key.Bytes())
+	if err != nil {
+		v.Close()
 		return nil, err
 	}
-	if err := s.SetMeta("iterations", fmt.Sprintf("%d", core.MinIterations)); err != nil {
-		s.Close()
+	if err := saveSlots(s, []KeySlot{*slot}); err != nil {
+		v.Close()
 		return nil, err
 	}
+	v.slot = slot.Label
 
-	// Create and store verification value (encrypted with the derived key)
-	v := &Vault{
-		path:   path,
-		store:  s,
-		key:    key,
-		locked: false,
-	}
+	// Create and store verification value (encrypted with the data key)
 	verifyEncrypted, err := v.encryptValue(verificationPlaintext)
 	if err != nil {
-		s.Close()
+		v.Close()
 		return nil, fmt.Errorf("failed to create verification value: %w", err)
 	}
 	if err := s.SetMeta("verify", verifyEncrypted); err != nil {
-		s.Close()
+		v.Close()
+		return nil, err
+	}
+	v.id = uuid.New().String()
+	if err := s.SetMetaValues(map[string]string{
+		metaFieldsEncrypted: "1",
+		metaFieldFormat:     fieldFormatBound,
+		metaVaultID:         v.id,
+	}); err != nil {
+		v.Close()
 		return nil, err
 	}
 
@@ -110,71 +148,290 @@ func Init(path string, password string) (*Vault, error) {
 
 // Open opens an existing vault with password
 func Open(path string, password string) (*Vault, error) {
+	return OpenWithKeyFile(path, password, "")
+}
+
+// OpenWithKeyFile opens an existing vault with password and the key file at
+// keyFilePath. An empty keyFilePath means password only.
+func OpenWithKeyFile(path string, password string, keyFilePath string) (*Vault, error) {
 	if !Exists(path) {
 		return nil, ErrNotExists
 	}
 
-	s, err := store.Open(path)
+	keyFile, err := readKeyFile(keyFilePath)
 	if err != nil {
 		return nil, err
 	}
+	defer core.Wipe(keyFile)
+	return open(path, credentials{password: password, keyFile: keyFile})
+}
 
-	// Read encryption parameters
-	saltB64, err := s.GetMeta("salt")
+// OpenWithKeyFileData opens an existing vault with password and the given
+// key file content, for callers that cannot hand over a path. Empty
+// content means password only.
+func OpenWithKeyFileData(path string, password string, keyFileData []byte) (*Vault, error) {
+	if !Exists(path) {
+		return nil, ErrNotExists
+	}
+
+	keyFile, err := keyFileDigest(keyFileData)
 	if err != nil {
-		s.Close()
-		return nil, fmt.Errorf("failed to read vault metadata: %w", err)
+		return nil, err
 	}
-	iterStr, err := s.GetMeta("iterations")
+	defer core.Wipe(keyFile)
+	return open(path, credentials{password: password, keyFile: keyFile})
+}
+
+// open unlocks an existing vault with the given credentials and brings its
+// format up to date
+func open(path string, creds credentials) (*Vault, error) {
+	encrypted, err := store.IsEncrypted(path)
 	if err != nil {
-		s.Close()
-		return nil, fmt.Errorf("failed to read vault metadata: %w", err)
+		return nil, err
+	}
+
+	// Plain vaults keep unlock metadata in the store itself; SQLCipher
+	// vaults keep it in a sidecar header until the database is keyed
+	var v *Vault
+	var slot *KeySlot
+	if encrypted {
+		v, slot, err = openEncrypted(path, creds)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		s, err := store.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		v, slot, err = unlock(path, s, s, creds)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
 	}
 
-	salt, err := base64.StdEncoding.DecodeString(saltB64)
+	ctx := context.Background()
+
+	// Encrypt any sensitive values written in plaintext by older versions
+	if err := v.migrate(ctx); err != nil {
+		v.Close()
+		return nil, fmt.Errorf("failed to migrate vault: %w", err)
+	}
+
+	// Vaults from before envelope encryption use the password key directly
+	if slot.WrappedKey == "" {
+		if err := v.convertToEnvelope(ctx); err != nil {
+			v.Close()
+			return nil, fmt.Errorf("failed to convert vault to envelope encryption: %w", err)
+		}
+	}
+
+	// Vaults from before key slots keep a single set of unlock parameters
+	if _, err := v.meta.GetMeta(metaSlots); errors.Is(err, store.ErrNotFound) {
+		if err := v.convertToSlots(); err != nil {
+			v.Close()
+			return nil, fmt.Errorf("failed to convert vault to key slots: %w", err)
+		}
+	}
+
+	// Vaults from before vault IDs get one, so their sessions stay apart
+	if v.id == "" {
+		if err := v.assignID(); err != nil {
+			v.Close()
+			return nil, fmt.Errorf("failed to assign vault ID: %w", err)
+		}
+	}
+
+	// Move slots created with weaker KDF settings to the current defaults,
+	// and seal the data key in slots from before sealed slots, so a later
+	// slot removal can hand them the new data key. This only re-seals the
+	// data key; a failed upgrade leaves the old slot in place and is
+	// retried on the next unlock.
+	if slot.KDF.Outdated(core.DefaultKDF()) || slot.PublicKey == "" {
+		secret := creds.password
+		if slot.Kind == SlotRecovery {
+			secret = string(creds.recoveryKey)
+		}
+		v.setPassword(slot.Label, secret, core.DefaultKDF())
+	}
+
+	return v, nil
+}
+
+// openEncrypted unlocks a SQLCipher vault, finishing an interrupted re-key
+// if the database has already moved to the key in a pending header
+func openEncrypted(path string, creds credentials) (*Vault, *KeySlot, error) {
+	h, err := loadHeader(path)
 	if err != nil {
-		s.Close()
-		return nil, fmt.Errorf("corrupted vault metadata: %w", err)
+		return nil, nil, err
 	}
-	iterations, err := strconv.Atoi(iterStr)
+	v, slot, err := unlockEncrypted(path, h, creds)
+
+	pending, pendingErr := loadPendingHeader(path)
+	if pendingErr != nil || pending == nil {
+		return v, slot, err
+	}
+	if err == nil {
+		// The re-key never replaced the database; drop its header
+		os.Remove(pending.path)
+		return v, slot, nil
+	}
+	if !errors.Is(err, store.ErrInvalidPassword) {
+		return nil, nil, err
+	}
+
+	v, slot, err = unlockEncrypted(path, pending, creds)
 	if err != nil {
-		s.Close()
-		return nil, fmt.Errorf("corrupted vault metadata: %w", err)
+		return nil, nil, err
+	}
+	if err := pending.promote(); err != nil {
+		v.Close()
+		return nil, nil, err
 	}
+	return v, slot, nil
+}
 
-	// Derive key from password
-	key, err := core.DeriveKey(password, salt, iterations)
+// unlockEncrypted unwraps the data key using a header and opens the SQLCipher database with it
+func unlockEncrypted(path string, h *header, creds credentials) (*Vault, *KeySlot, error) {
+	key, slot, err := unlockKey(h, creds)
 	if err != nil {
-		s.Close()
-		return nil, err
+		return nil, nil, err
+	}
+	keyFile, err := keyFileBuffer(creds.keyFile)
+	if err != nil {
+		key.Destroy()
+		return nil, nil, err
+	}
+	s, err := openEncryptedStore(path, key.Bytes())
+	if err != nil {
+		key.Destroy()
+		keyFile.Destroy()
+		return nil, nil, err
 	}
+	v := &Vault{path: path, store: s, meta: h, key: key, slot: slot.Label, keyFile: keyFile}
+	if err := v.verifyKey(); err != nil {
+		v.Close()
+		return nil, nil, err
+	}
+	if err := v.loadSettings(); err != nil {
+		v.Close()
+		return nil, nil, err
+	}
+	return v, slot, nil
+}
 
-	// Create temporary vault to verify password by decrypting verification value
-	v := &Vault{
-		path:   path,
-		store:  s,
-		key:    key,
-		locked: false,
+// unlock unwraps the data key of a plain vault and verifies it
+func unlock(path string, s *store.Store, meta metaStore, creds credentials) (*Vault, *KeySlot, error) {
+	key, slot, err := unlockKey(meta, creds)
+	if err != nil {
+		return nil, nil, err
 	}
+	keyFile, err := keyFileBuffer(creds.keyFile)
+	if err != nil {
+		key.Destroy()
+		return nil, nil, err
+	}
+	v := &Vault{path: path, store: s, meta: meta, key: key, slot: slot.Label, keyFile: keyFile}
+	if err := v.verifyKey(); err != nil {
+		key.Destroy()
+		keyFile.Destroy()
+		return nil, nil, err
+	}
+	if err := v.loadSettings(); err != nil {
+		key.Destroy()
+		keyFile.Destroy()
+		return nil, nil, err
+	}
+	return v, slot, nil
+}
 
-	// Read and decrypt verification value
-	verifyEncrypted, err := s.GetMeta("verify")
+// verifyKey checks the vault key by decrypting the verification value
+func (v *Vault) verifyKey() error {
+	verifyEncrypted, err := v.meta.GetMeta("verify")
 	if err != nil {
-		s.Close()
-		return nil, fmt.Errorf("failed to read verification value: %w", err)
+		return fmt.Errorf("failed to read verification value: %w", err)
 	}
 
 	decrypted, err := v.decryptValue(verifyEncrypted)
 	if err != nil || decrypted != verificationPlaintext {
-		s.Close()
-		return nil, store.ErrInvalidPassword
+		return store.ErrInvalidPassword
 	}
+	return nil
+}
 
+// loadSettings reads the vault settings kept in the store
+func (v *Vault) loadSettings() error {
+	cipherName, err := v.store.GetMeta(metaCipher)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("failed to read vault metadata: %w", err)
+	}
+	metadataEncrypted, err := v.store.GetMeta(metaMetadataEncrypted)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("failed to read vault metadata: %w", err)
+	}
+	caseInsensitiveNames, err := v.store.GetMeta(metaCaseInsensitiveNames)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("failed to read vault metadata: %w", err)
+	}
+	v.cipher = cipherName
+	v.metadataEncrypted = metadataEncrypted == "1"
+	v.caseInsensitiveNames = caseInsensitiveNames == "1"
+	return v.loadID()
+}
+
+// openWithKey opens an existing vault with an already derived key. The
+// vault takes ownership of key and destroys it when closed or on failure.
+func openWithKey(path string, key *core.SecretBuffer) (*Vault, error) {
+	encrypted, err := store.IsEncrypted(path)
+	if err != nil {
+		key.Destroy()
+		return nil, err
+	}
+
+	var meta metaStore
+	var s *store.Store
+	if !encrypted {
+		s, err = store.Open(path)
+		meta = s
+	} else {
+		var h *header
+		if h, err = loadHeader(path); err == nil {
+			s, err = openEncryptedStore(path, key.Bytes())
+			meta = h
+		}
+	}
+	if err != nil {
+		key.Destroy()
+		return nil, err
+	}
+
+	v := &Vault{path: path, store: s, meta: meta, key: key}
+	if err := v.verifyKey(); err != nil {
+		v.Close()
+		return nil, err
+	}
+	if err := v.loadSettings(); err != nil {
+		v.Close()
+		return nil, err
+	}
 	return v, nil
 }
 
-// Close closes the vault
+// openEncryptedStore opens a SQLCipher vault database using a subkey of the vault key
+func openEncryptedStore(path string, key []byte) (*store.Store, error) {
+	dbKey, err := core.DeriveSubkey(key, sqlcipherKeyPurpose)
+	if err != nil {
+		return nil, err
+	}
+	defer core.Wipe(dbKey)
+	return store.OpenEncrypted(path, dbKey)
+}
+
+// Close closes the vault and wipes its data key and key file digest
 func (v *Vault) Close() error {
+	v.key.Destroy()
+	v.keyFile.Destroy()
 	if v.store != nil {
 		err := v.store.Close()
 		v.store = nil
@@ -199,8 +456,20 @@ func (v *Vault) Create(ctx context.Context, secret *model.SecretObject) error {
 	if v.IsLocked() {
 		return ErrLocked
 	}
+	if err := model.ValidateName(secret.Name); err != nil {
+		return err
+	}
+	if err := secret.ValidateFields(); err != nil {
+		return err
+	}
+	if secret.RotatedAt.IsZero() {
+		secret.RotatedAt = secret.CreatedAt
+	}
 	// Encrypt sensitive field values before storage
-	encrypted := v.encryptSecret(secret)
+	encrypted, err := v.encryptSecret(secret)
+	if err != nil {
+		return err
+	}
 	return v.store.Create(ctx, encrypted)
 }
 
@@ -209,7 +478,11 @@ func (v *Vault) GetByName(ctx context.Context, name string) (*model.SecretObject
 	if v.IsLocked() {
 		return nil, ErrLocked
 	}
-	secret, err := v.store.GetByName(ctx, name)
+	key, err := v.nameKey(name)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := v.store.GetByName(ctx, key)
 	if err != nil {
 		return nil, err
 	}
@@ -222,7 +495,11 @@ func (v *Vault) List(ctx context.Context, opts *store.SearchOptions) ([]*model.S
 	if v.IsLocked() {
 		return nil, ErrLocked
 	}
-	secrets, err := v.store.List(ctx, opts)
+	storeOpts, err := v.storeOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := v.store.List(ctx, storeOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -235,7 +512,7 @@ func (v *Vault) List(ctx context.Context, opts *store.SearchOptions) ([]*model.S
 		}
 		decrypted = append(decrypted, d)
 	}
-	return decrypted, nil
+	return v.finishListing(decrypted, opts), nil
 }
 
 // Search performs full-text search
@@ -243,7 +520,14 @@ func (v *Vault) Search(ctx context.Context, query string, opts *store.SearchOpti
 	if v.IsLocked() {
 		return nil, ErrLocked
 	}
-	secrets, err := v.store.Search(ctx, query, opts)
+	if v.metadataEncrypted {
+		return v.searchDecrypted(ctx, query, opts)
+	}
+	storeOpts, err := v.storeOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := v.store.Search(ctx, query, storeOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -259,22 +543,40 @@ func (v *Vault) Search(ctx context.Context, query string, opts *store.SearchOpti
 	return decrypted, nil
 }
 
-// Update updates an existing secret
+// Update updates an existing secret. Its RotatedAt moves to now if any
+// field value changed.
 func (v *Vault) Update(ctx context.Context, secret *model.SecretObject) error {
 	if v.IsLocked() {
 		return ErrLocked
 	}
+	if err := secret.ValidateFields(); err != nil {
+		return err
+	}
+	if err := v.markRotation(ctx, secret); err != nil {
+		return err
+	}
 	// Encrypt sensitive field values before storage
-	encrypted := v.encryptSecret(secret)
-	return v.store.Update(ctx, encrypted)
+	encrypted, err := v.encryptSecret(secret)
+	if err != nil {
+		return err
+	}
+	if err := v.store.Update(ctx, encrypted); err != nil {
+		return err
+	}
+	secret.UpdatedAt = encrypted.UpdatedAt
+	return nil
 }
 
-// Delete removes a secret
+// Delete moves a secret to the trash
 func (v *Vault) Delete(ctx context.Context, name string) error {
 	if v.IsLocked() {
 		return ErrLocked
 	}
-	return v.store.Delete(ctx, name)
+	key, err := v.nameKey(name)
+	if err != nil {
+		return err
+	}
+	return v.store.Delete(ctx, key)
 }
 
 // Path returns the vault file path
@@ -282,34 +584,66 @@ func (v *Vault) Path() string {
 	return v.path
 }
 
-// encryptSecret encrypts sensitive field values in a secret
-func (v *Vault) encryptSecret(secret *model.SecretObject) *model.SecretObject {
+// Counts returns the number of secrets and fields in the vault
+func (v *Vault) Counts(ctx context.Context) (secrets int, fields int, err error) {
+	if v.IsLocked() {
+		return 0, 0, ErrLocked
+	}
+	return v.store.Counts(ctx)
+}
+
+// encryptSecret encrypts sensitive field values in a secret, and with
+// encrypted metadata every field value and the metadata as well
+func (v *Vault) encryptSecret(secret *model.SecretObject) (*model.SecretObject, error) {
 	copy := *secret
+	key, err := v.nameKey(secret.Name)
+	if err != nil {
+		return nil, err
+	}
+	copy.NameKey = key
 	copy.Fields = make([]model.Field, len(secret.Fields))
 	for i, f := range secret.Fields {
+		// The field ID is part of the associated data, so it must be fixed before sealing
+		if f.ID == "" {
+			f.ID = uuid.New().String()
+			secret.Fields[i].ID = f.ID
+		}
 		copy.Fields[i] = f
-		if f.Sensitive {
-			// Encrypt using the derived vault key
-			encrypted, err := v.encryptValue(f.Value)
+		if f.Sensitive || v.metadataEncrypted {
+			// Encrypt bound to the secret and field identity
+			encrypted, err := v.sealField(secret.ID, f)
 			if err != nil {
-				// If encryption fails, keep original (shouldn't happen in normal operation)
-				continue
+				return nil, fmt.Errorf("failed to encrypt field %q: %w", f.Label, err)
 			}
 			copy.Fields[i].Value = encrypted
 		}
 	}
-	return &copy
+	if v.metadataEncrypted {
+		if err := v.hideMetadata(&copy); err != nil {
+			return nil, err
+		}
+	}
+	return &copy, nil
 }
 
-// decryptSecret decrypts sensitive field values in a secret
+// decryptSecret decrypts sensitive field values in a secret, and with
+// encrypted metadata every field value and the metadata as well
 func (v *Vault) decryptSecret(secret *model.SecretObject) (*model.SecretObject, error) {
 	copy := *secret
 	copy.Fields = make([]model.Field, len(secret.Fields))
 	for i, f := range secret.Fields {
 		copy.Fields[i] = f
-		if f.Sensitive {
-			// Decrypt using the derived vault key
-			decrypted, err := v.decryptValue(f.Value)
+	}
+	// Field values are bound to the plaintext label
+	if v.metadataEncrypted {
+		if err := v.revealMetadata(&copy); err != nil {
+			return nil, err
+		}
+	}
+	for i, f := range copy.Fields {
+		if f.Sensitive || v.metadataEncrypted {
+			// Decrypt and check the secret and field identity
+			decrypted, err := v.openField(secret.ID, f)
 			if err != nil {
 				return nil, fmt.Errorf("failed to decrypt field %q: %w", f.Label, err)
 			}
@@ -319,82 +653,37 @@ func (v *Vault) decryptSecret(secret *model.SecretObject) (*model.SecretObject,
 	return &copy, nil
 }
 
-// encryptValue encrypts a single value using the vault's derived key
+// encryptValue encrypts a single value using the vault's data key
 func (v *Vault) encryptValue(plaintext string) (string, error) {
-	// Create a cipher block from the derived key
-	block, err := aes.NewCipher(v.key)
-	if err != nil {
-		return "", err
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", err
-	}
-
-	// Generate random IV
-	iv := make([]byte, core.IVSize)
-	if _, err := rand.Read(iv); err != nil {
-		return "", err
-	}
-
-	// Encrypt (GCM appends auth tag to ciphertext)
-	sealed := gcm.Seal(nil, iv, []byte(plaintext), nil)
-
-	// Split ciphertext and auth tag
-	tagStart := len(sealed) - gcm.Overhead()
-	ciphertext := sealed[:tagStart]
-	authTag := sealed[tagStart:]
-
-	// Return as JSON-like string: "iv:ciphertext:authTag" (all base64)
-	result := fmt.Sprintf("%s:%s:%s",
-		base64.StdEncoding.EncodeToString(iv),
-		base64.StdEncoding.EncodeToString(ciphertext),
-		base64.StdEncoding.EncodeToString(authTag),
-	)
-	return result, nil
+	return v.encrypt(plaintext, nil)
 }
 
-// decryptValue decrypts a single value using the vault's derived key
+// decryptValue decrypts a single value using the vault's data key
 func (v *Vault) decryptValue(encrypted string) (string, error) {
-	// Parse the format: "iv:ciphertext:authTag"
-	parts := strings.Split(encrypted, ":")
-	if len(parts) != 3 {
-		return "", errors.New("invalid encrypted value format")
-	}
+	return decryptWithKey(v.key.Bytes(), encrypted, nil)
+}
 
-	iv, err := base64.StdEncoding.DecodeString(parts[0])
-	if err != nil {
-		return "", errors.New("invalid IV encoding")
-	}
-	ciphertext, err := base64.StdEncoding.DecodeString(parts[1])
-	if err != nil {
-		return "", errors.New("invalid ciphertext encoding")
-	}
-	authTag, err := base64.StdEncoding.DecodeString(parts[2])
-	if err != nil {
-		return "", errors.New("invalid authTag encoding")
-	}
+// encrypt encrypts a value with the data key and the vault's cipher,
+// authenticating the optional associated data alongside it
+func (v *Vault) encrypt(plaintext string, aad []byte) (string, error) {
+	return encryptWithKey(v.cipherName(), v.key.Bytes(), plaintext, aad)
+}
 
-	// Create a cipher block from the derived key
-	block, err := aes.NewCipher(v.key)
-	if err != nil {
-		return "", err
-	}
+// encryptWithKey seals a value in the core envelope format
+func encryptWithKey(cipherName string, key []byte, plaintext string, aad []byte) (string, error) {
+	return core.Seal(cipherName, key, []byte(plaintext), aad)
+}
 
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", err
+// decryptWithKey decrypts a value sealed with any supported cipher, or in
+// the older "iv:ciphertext:authTag" format, with the same associated data
+func decryptWithKey(key []byte, encrypted string, aad []byte) (string, error) {
+	plaintext, err := core.Open(key, encrypted, aad)
+	if errors.Is(err, core.ErrDecrypt) {
+		return "", store.ErrInvalidPassword
 	}
-
-	// Reconstruct sealed message (ciphertext + authTag)
-	sealed := append(ciphertext, authTag...)
-
-	// Decrypt
-	plaintext, err := gcm.Open(nil, iv, sealed, nil)
 	if err != nil {
-		return "", store.ErrInvalidPassword
+		return "", err
 	}
-
+	defer core.Wipe(plaintext)
 	return string(plaintext), nil
 }
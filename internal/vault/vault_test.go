@@ -3,11 +3,18 @@
 package vault
 
 import (
+	"bytes"
 	"context"
-	"os"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"io"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/TheEditor/keyp/internal/core"
 	"github.com/TheEditor/keyp/internal/model"
 	"github.com/TheEditor/keyp/internal/store"
 )
@@ -328,23 +335,1944 @@ func TestVaultAlreadyExists(t *testing.T) {
 	}
 }
 
-func setupTestVault(t *testing.T, password string) (*Vault, string) {
+func TestVaultMigratesPlaintextFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	password := "testpassword123"
+	ctx := context.Background()
+
+	v, err := Init(path, password)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	v.Close()
+
+	// Simulate an older client writing straight to the store
+	s, err := store.Open(path)
+	if err != nil {
+		t.Fatalf("store.Open failed: %v", err)
+	}
+	secret := model.NewSecretObject("legacy")
+	secret.AddField(model.NewField("password", "plaintext-secret"))
+	if err := s.Create(ctx, secret); err != nil {
+		t.Fatalf("store Create failed: %v", err)
+	}
+	if err := s.DeleteMeta(metaFieldsEncrypted); err != nil {
+		t.Fatalf("DeleteMeta failed: %v", err)
+	}
+	s.Close()
+
+	v2, err := Open(path, password)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer v2.Close()
+
+	raw, err := v2.store.GetByName(ctx, "legacy")
+	if err != nil {
+		t.Fatalf("store GetByName failed: %v", err)
+	}
+	if raw.Fields[0].Value == "plaintext-secret" {
+		t.Errorf("expected plaintext field to be encrypted by migration")
+	}
+
+	got, err := v2.GetByName(ctx, "legacy")
+	if err != nil {
+		t.Fatalf("GetByName failed: %v", err)
+	}
+	if got.Fields[0].Value != "plaintext-secret" {
+		t.Errorf("expected 'plaintext-secret', got %q", got.Fields[0].Value)
+	}
+}
+
+func TestVaultInitUsesDefaultKDF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	v, err := Init(path, "testpassword123")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	defer v.Close()
+
+	slots, err := loadSlots(v.meta)
+	if err != nil {
+		t.Fatalf("loadSlots failed: %v", err)
+	}
+	if len(slots) != 1 || slots[0].Label != defaultSlotLabel {
+		t.Fatalf("Expected a single %q slot, got %+v", defaultSlotLabel, slots)
+	}
+	if slots[0].KDF != core.DefaultKDF() {
+		t.Errorf("Expected %s, got %s", core.DefaultKDF(), slots[0].KDF)
+	}
+}
+
+func TestVaultUpgradesLegacyKDF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	password := "testpassword123"
+	ctx := context.Background()
+
+	// Build a vault the way older versions did: PBKDF2 with an iteration count only
+	v, err := initVault(path, password, nil, core.PBKDF2Params(core.MinIterations))
+	if err != nil {
+		t.Fatalf("initVault failed: %v", err)
+	}
+	secret := model.NewSecretObject("github")
+	secret.AddField(model.NewField("token", "ghp_legacy"))
+	if err := v.Create(ctx, secret); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	slot := slotByLabel(t, v, defaultSlotLabel)
+	writeLegacyUnlockMeta(t, v.store, map[string]string{
+		"salt":         slot.Salt,
+		"iterations":   "100000",
+		metaWrappedKey: legacyWrap(t, v, slot, password),
+	})
+	v.Close()
+
+	v2, err := Open(path, password)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	slots, err := loadSlots(v2.meta)
+	if err != nil {
+		t.Fatalf("loadSlots failed: %v", err)
+	}
+	if _, err := v2.meta.GetMeta("iterations"); err != store.ErrNotFound {
+		t.Errorf("Expected legacy unlock metadata to be removed, got %v", err)
+	}
+	if len(slots) != 1 || slots[0].KDF != core.DefaultKDF() || slots[0].PublicKey == "" {
+		t.Errorf("Expected one sealed slot upgraded to %s, got %+v", core.DefaultKDF(), slots)
+	}
+	got, err := v2.GetByName(ctx, "github")
+	if err != nil {
+		t.Fatalf("GetByName after upgrade failed: %v", err)
+	}
+	if got.Fields[0].Value != "ghp_legacy" {
+		t.Errorf("Expected 'ghp_legacy', got %q", got.Fields[0].Value)
+	}
+	v2.Close()
+
+	// The upgraded vault opens with the same password
+	v3, err := Open(path, password)
+	if err != nil {
+		t.Fatalf("Open after upgrade failed: %v", err)
+	}
+	defer v3.Close()
+	if _, err := v3.GetByName(ctx, "github"); err != nil {
+		t.Errorf("GetByName after reopen failed: %v", err)
+	}
+
+	if _, err := Open(path, "wrongpassword"); err != store.ErrInvalidPassword {
+		t.Errorf("Expected ErrInvalidPassword, got %v", err)
+	}
+}
+
+func TestVaultMigrateToSQLCipher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	password := "testpassword123"
+	ctx := context.Background()
+
+	v, err := Init(path, password)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	secret := model.NewSecretObject("bank")
+	secret.AddField(model.NewField("pin", "1234"))
+	if err := v.Create(ctx, secret); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	backupPath, err := v.MigrateToSQLCipher(ctx)
+	if !store.SQLCipherAvailable() {
+		if err != store.ErrSQLCipherUnavailable {
+			t.Fatalf("Expected ErrSQLCipherUnavailable, got %v", err)
+		}
+		if backupPath != "" {
+			t.Errorf("Expected no backup when SQLCipher is unavailable")
+		}
+		// Vault must remain usable
+		if _, err := v.GetByName(ctx, "bank"); err != nil {
+			t.Errorf("GetByName after failed migration: %v", err)
+		}
+		v.Close()
+		return
+	}
+	if err != nil {
+		t.Fatalf("MigrateToSQLCipher failed: %v", err)
+	}
+	v.Close()
+
+	encrypted, _ := store.IsEncrypted(path)
+	if !encrypted {
+		t.Fatal("Expected vault file to be encrypted after migration")
+	}
+
+	v2, err := Open(path, password)
+	if err != nil {
+		t.Fatalf("Open after migration failed: %v", err)
+	}
+	defer v2.Close()
+
+	got, err := v2.GetByName(ctx, "bank")
+	if err != nil {
+		t.Fatalf("GetByName after migration failed: %v", err)
+	}
+	if got.Fields[0].Value != "1234" {
+		t.Errorf("Expected '1234', got %q", got.Fields[0].Value)
+	}
+}
+
+func TestVaultChangePassword(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+
+	v, err := Init(path, "oldpassword")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	secret := model.NewSecretObject("email")
+	secret.AddField(model.NewField("password", "hunter2"))
+	if err := v.Create(ctx, secret); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if err := v.ChangePassword(ctx, "wrongpassword", "newpassword"); err != store.ErrInvalidPassword {
+		t.Errorf("Expected ErrInvalidPassword for wrong current password, got %v", err)
+	}
+
+	// A failed write must leave the old password working
+	v.store.Close()
+	if err := v.ChangePassword(ctx, "oldpassword", "newpassword"); err == nil {
+		t.Fatal("Expected ChangePassword to fail on a closed store")
+	}
+	v.store = nil
+	v, err = Open(path, "oldpassword")
+	if err != nil {
+		t.Fatalf("Open with old password after failed change: %v", err)
+	}
+
+	before, _ := v.store.GetByName(ctx, "email")
+	if err := v.ChangePassword(ctx, "oldpassword", "newpassword"); err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+	after, _ := v.store.GetByName(ctx, "email")
+	if before.Fields[0].Value != after.Fields[0].Value {
+		t.Error("Expected field ciphertext to be untouched by a password change")
+	}
+	if got, err := v.GetByName(ctx, "email"); err != nil || got.Fields[0].Value != "hunter2" {
+		t.Errorf("GetByName after change failed: %v", err)
+	}
+	v.Close()
+
+	if _, err := Open(path, "oldpassword"); err != store.ErrInvalidPassword {
+		t.Errorf("Expected old password to be rejected, got %v", err)
+	}
+	v2, err := Open(path, "newpassword")
+	if err != nil {
+		t.Fatalf("Open with new password failed: %v", err)
+	}
+	defer v2.Close()
+	got, err := v2.GetByName(ctx, "email")
+	if err != nil {
+		t.Fatalf("GetByName failed: %v", err)
+	}
+	if got.Fields[0].Value != "hunter2" {
+		t.Errorf("Expected 'hunter2', got %q", got.Fields[0].Value)
+	}
+}
+
+func TestVaultConvertsToEnvelope(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	password := "testpassword123"
+	ctx := context.Background()
+
+	v, err := Init(path, password)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	secret := model.NewSecretObject("server")
+	secret.AddField(model.NewField("root", "toor"))
+	if err := v.Create(ctx, secret); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// Rewrite the vault the way older versions stored it: fields encrypted
+	// directly with the password key and no wrapped data key
+	slot := slotByLabel(t, v, defaultSlotLabel)
+	legacy := KeySlot{Salt: slot.Salt, KDF: slot.KDF}
+	kek, err := legacy.unwrap(credentials{password: password})
+	if err != nil {
+		t.Fatalf("unwrap failed: %v", err)
+	}
+	passwordKey := append([]byte(nil), kek.Bytes()...)
+	if err := v.replaceKey(ctx, kek, map[string]string{}); err != nil {
+		t.Fatalf("replaceKey failed: %v", err)
+	}
+	writeLegacyUnlockMeta(t, v.store, map[string]string{
+		"salt":  slot.Salt,
+		metaKDF: slot.KDF.String(),
+	})
+	v.Close()
+
+	v2, err := Open(path, password)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if slot := slotByLabel(t, v2, defaultSlotLabel); slot.WrappedKey == "" {
+		t.Error("Expected wrapped data key after conversion")
+	}
+	if string(v2.key.Bytes()) == string(passwordKey) {
+		t.Error("Expected fields to move to a data key separate from the password key")
+	}
+	got, err := v2.GetByName(ctx, "server")
+	if err != nil {
+		t.Fatalf("GetByName failed: %v", err)
+	}
+	if got.Fields[0].Value != "toor" {
+		t.Errorf("Expected 'toor', got %q", got.Fields[0].Value)
+	}
+	v2.Close()
+
+	v3, err := Open(path, password)
+	if err != nil {
+		t.Fatalf("Open after conversion failed: %v", err)
+	}
+	defer v3.Close()
+	if _, err := v3.GetByName(ctx, "server"); err != nil {
+		t.Errorf("GetByName after reopen failed: %v", err)
+	}
+}
+
+func TestVaultKeySlots(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+
+	v, err := Init(path, "parentpassword")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	secret := model.NewSecretObject("wifi")
+	secret.AddField(model.NewField("password", "family-wifi"))
+	if err := v.Create(ctx, secret); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if err := v.AddSlot("kid", "kidpassword", ""); err != nil {
+		t.Fatalf("AddSlot failed: %v", err)
+	}
+	if err := v.AddSlot("kid", "otherpassword", ""); err != ErrSlotExists {
+		t.Errorf("Expected ErrSlotExists, got %v", err)
+	}
+	v.Close()
+
+	// Each slot opens the same vault
+	for _, password := range []string{"parentpassword", "kidpassword"} {
+		v, err := Open(path, password)
+		if err != nil {
+			t.Fatalf("Open with %q failed: %v", password, err)
+		}
+		got, err := v.GetByName(ctx, "wifi")
+		if err != nil || got.Fields[0].Value != "family-wifi" {
+			t.Errorf("GetByName with %q failed: %v", password, err)
+		}
+		v.Close()
+	}
+
+	v, err = Open(path, "parentpassword")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	slots, err := v.Slots()
+	if err != nil {
+		t.Fatalf("Slots failed: %v", err)
+	}
+	if len(slots) != 2 {
+		t.Fatalf("Expected 2 slots, got %d", len(slots))
+	}
+
+	// Changing one password leaves the other slot alone
+	if err := v.ChangePassword(ctx, "kidpassword", "newkidpassword"); err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+	if err := v.RemoveSlot(ctx, "nobody"); err != ErrSlotNotFound {
+		t.Errorf("Expected ErrSlotNotFound, got %v", err)
+	}
+	if err := v.RemoveSlot(ctx, "kid"); err != nil {
+		t.Fatalf("RemoveSlot failed: %v", err)
+	}
+	if err := v.RemoveSlot(ctx, defaultSlotLabel); err != ErrLastSlot {
+		t.Errorf("Expected ErrLastSlot, got %v", err)
+	}
+	v.Close()
+
+	if _, err := Open(path, "newkidpassword"); err != store.ErrInvalidPassword {
+		t.Errorf("Expected removed slot to be rejected, got %v", err)
+	}
+	v, err = Open(path, "parentpassword")
+	if err != nil {
+		t.Fatalf("Open with remaining slot failed: %v", err)
+	}
+	v.Close()
+}
+
+func TestVaultRemoveSlotRotatesKey(t *testing.T) {
+	for _, metadata := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "test.db")
+		ctx := context.Background()
+
+		v, err := Init(path, "parentpassword")
+		if err != nil {
+			t.Fatalf("Init failed: %v", err)
+		}
+		if metadata {
+			if _, err := v.EncryptMetadata(ctx); err != nil {
+				t.Fatalf("EncryptMetadata failed: %v", err)
+			}
+		}
+		if err := v.AddSlot("kid", "kidpassword", ""); err != nil {
+			t.Fatalf("AddSlot failed: %v", err)
+		}
+
+		secret := model.NewSecretObject("github")
+		secret.Tags = []string{"dev"}
+		secret.AddField(model.NewField("password", "old-password"))
+		if err := v.Create(ctx, secret); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		secret.Fields[0].Value = "new-password"
+		if err := v.Update(ctx, secret); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+		content := bytes.Repeat([]byte("private key "), core.StreamChunkSize/4)
+		if _, err := v.Attach(ctx, "github", "id_rsa", bytes.NewReader(content)); err != nil {
+			t.Fatalf("Attach failed: %v", err)
+		}
+		trashed := model.NewSecretObject("retired")
+		trashed.AddField(model.NewField("password", "retired-password"))
+		if err := v.Create(ctx, trashed); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if err := v.Delete(ctx, "retired"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+
+		oldKey := append([]byte(nil), v.key.Bytes()...)
+		if err := v.RemoveSlot(ctx, "kid"); err != nil {
+			t.Fatalf("RemoveSlot failed: %v", err)
+		}
+		if bytes.Equal(v.key.Bytes(), oldKey) {
+			t.Fatal("Expected RemoveSlot to move the vault to a new data key")
+		}
+
+		// The old data key opens nothing that is stored now
+		stored, err := v.storedSecret(ctx, "github")
+		if err != nil {
+			t.Fatalf("storedSecret failed: %v", err)
+		}
+		key, err := core.SecretBufferFrom(oldKey)
+		if err != nil {
+			t.Fatalf("SecretBufferFrom failed: %v", err)
+		}
+		old := &Vault{key: key, metadataEncrypted: metadata}
+		if _, err := old.decryptSecret(stored); err == nil {
+			t.Error("Expected the old data key to fail on a re-encrypted secret")
+		}
+		v.Close()
+
+		if _, err := Open(path, "kidpassword"); err != store.ErrInvalidPassword {
+			t.Errorf("Expected removed slot to be rejected, got %v", err)
+		}
+		v, err = Open(path, "parentpassword")
+		if err != nil {
+			t.Fatalf("Open with remaining slot failed: %v", err)
+		}
+		got, err := v.GetByName(ctx, "github")
+		if err != nil || got.Fields[0].Value != "new-password" || len(got.Tags) != 1 {
+			t.Errorf("GetByName after rotation = %+v, %v", got, err)
+		}
+		if found, err := v.List(ctx, &store.SearchOptions{Tags: []string{"dev"}}); err != nil || len(found) != 1 {
+			t.Errorf("Expected the tag index to follow the new key, got %v, %v", found, err)
+		}
+		if version, err := v.GetVersion(ctx, "github", 1); err != nil || version.Fields[0].Value != "old-password" {
+			t.Errorf("GetVersion after rotation = %+v, %v", version, err)
+		}
+		var out bytes.Buffer
+		if _, err := v.Extract(ctx, "github", "id_rsa", &out); err != nil || !bytes.Equal(out.Bytes(), content) {
+			t.Errorf("Extract after rotation returned %d bytes, %v", out.Len(), err)
+		}
+		if trash, err := v.Trash(ctx); err != nil || len(trash) != 1 || trash[0].Fields[0].Value != "retired-password" {
+			t.Errorf("Trash after rotation = %+v, %v", trash, err)
+		}
+		v.Close()
+	}
+}
+
+func TestVaultRemoveSlotSQLCipher(t *testing.T) {
+	if !store.SQLCipherAvailable() {
+		t.Skip("SQLCipher is not available in this build")
+	}
+	path := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+
+	v, err := Init(path, "parentpassword")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	secret := model.NewSecretObject("bank")
+	secret.AddField(model.NewField("pin", "1234"))
+	if err := v.Create(ctx, secret); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := v.Attach(ctx, "bank", "card.txt", strings.NewReader("4111")); err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+	if err := v.AddSlot("kid", "kidpassword", ""); err != nil {
+		t.Fatalf("AddSlot failed: %v", err)
+	}
+	if _, err := v.MigrateToSQLCipher(ctx); err != nil {
+		t.Fatalf("MigrateToSQLCipher failed: %v", err)
+	}
+	if err := v.RemoveSlot(ctx, "kid"); err != nil {
+		t.Fatalf("RemoveSlot failed: %v", err)
+	}
+	v.Close()
+
+	if _, err := Open(path, "kidpassword"); err != store.ErrInvalidPassword {
+		t.Errorf("Expected removed slot to be rejected, got %v", err)
+	}
+	v, err = Open(path, "parentpassword")
+	if err != nil {
+		t.Fatalf("Open with remaining slot failed: %v", err)
+	}
+	defer v.Close()
+	if got, err := v.GetByName(ctx, "bank"); err != nil || got.Fields[0].Value != "1234" {
+		t.Errorf("GetByName after rotation = %+v, %v", got, err)
+	}
+	var out bytes.Buffer
+	if _, err := v.Extract(ctx, "bank", "card.txt", &out); err != nil || out.String() != "4111" {
+		t.Errorf("Extract after rotation = %q, %v", out.String(), err)
+	}
+}
+
+func TestVaultSealsLegacySlots(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+
+	v, err := Init(path, "parentpassword")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	for label, password := range map[string]string{"kid": "kidpassword", "guest": "guestpassword"} {
+		if err := v.AddSlot(label, password, ""); err != nil {
+			t.Fatalf("AddSlot failed: %v", err)
+		}
+	}
+
+	// Store the kid slot the way older versions did, wrapped with its password key
+	slots, err := loadSlots(v.meta)
+	if err != nil {
+		t.Fatalf("loadSlots failed: %v", err)
+	}
+	for i := range slots {
+		if slots[i].Label == "kid" {
+			slots[i].WrappedKey = legacyWrap(t, v, slots[i], "kidpassword")
+			slots[i].PublicKey = ""
+		}
+	}
+	if err := saveSlots(v.meta, slots); err != nil {
+		t.Fatalf("saveSlots failed: %v", err)
+	}
+
+	// The new data key could not be given to the kid slot
+	err = v.RemoveSlot(ctx, "guest")
+	if !errors.Is(err, ErrSlotNotSealed) || !strings.Contains(err.Error(), "kid") {
+		t.Errorf("Expected ErrSlotNotSealed naming the kid slot, got %v", err)
+	}
+	v.Close()
+
+	// Unlocking with the kid slot seals it
+	v, err = Open(path, "kidpassword")
+	if err != nil {
+		t.Fatalf("Open with legacy slot failed: %v", err)
+	}
+	if slot := slotByLabel(t, v, "kid"); slot.PublicKey == "" {
+		t.Error("Expected the legacy slot to be sealed on unlock")
+	}
+	if err := v.RemoveSlot(ctx, "guest"); err != nil {
+		t.Fatalf("RemoveSlot failed: %v", err)
+	}
+	v.Close()
+
+	for _, password := range []string{"parentpassword", "kidpassword"} {
+		v, err := Open(path, password)
+		if err != nil {
+			t.Fatalf("Open with %q after removal failed: %v", password, err)
+		}
+		v.Close()
+	}
+}
+
+// slotByLabel returns the named key slot of an open vault
+func slotByLabel(t *testing.T, v *Vault, label string) KeySlot {
 	t.Helper()
+	slots, err := loadSlots(v.meta)
+	if err != nil {
+		t.Fatalf("loadSlots failed: %v", err)
+	}
+	for _, s := range slots {
+		if s.Label == label {
+			return s
+		}
+	}
+	t.Fatalf("slot %q not found", label)
+	return KeySlot{}
+}
+
+// writeLegacyUnlockMeta replaces the key slots with the single set of unlock
+// parameters older versions stored
+func writeLegacyUnlockMeta(t *testing.T, s *store.Store, values map[string]string) {
+	t.Helper()
+	if err := s.SetMetaValues(values, metaSlots); err != nil {
+		t.Fatalf("SetMetaValues failed: %v", err)
+	}
+}
+
+// legacyWrap returns the data key wrapped directly with the password key
+// of a slot, as slots held it before they were sealed
+func legacyWrap(t *testing.T, v *Vault, slot KeySlot, password string) string {
+	t.Helper()
+	salt, err := base64.StdEncoding.DecodeString(slot.Salt)
+	if err != nil {
+		t.Fatalf("DecodeString failed: %v", err)
+	}
+	kek, err := slot.KDF.Derive(password, salt)
+	if err != nil {
+		t.Fatalf("Derive failed: %v", err)
+	}
+	wrapped, err := wrapKey(kek, v.key.Bytes())
+	if err != nil {
+		t.Fatalf("wrapKey failed: %v", err)
+	}
+	return wrapped
+}
+
+func TestVaultKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	keyFile := filepath.Join(dir, "vault.key")
+	otherKeyFile := filepath.Join(dir, "other.key")
+	ctx := context.Background()
+
+	if err := GenerateKeyFile(keyFile); err != nil {
+		t.Fatalf("GenerateKeyFile failed: %v", err)
+	}
+	if err := GenerateKeyFile(keyFile); err == nil {
+		t.Error("Expected GenerateKeyFile to refuse an existing file")
+	}
+	if err := GenerateKeyFile(otherKeyFile); err != nil {
+		t.Fatalf("GenerateKeyFile failed: %v", err)
+	}
+
+	v, err := InitWithKeyFile(path, "testpassword123", keyFile)
+	if err != nil {
+		t.Fatalf("InitWithKeyFile failed: %v", err)
+	}
+	if !slotByLabel(t, v, defaultSlotLabel).KeyFile {
+		t.Error("Expected slot to record that a key file is required")
+	}
+	v.Close()
+
+	if _, err := Open(path, "testpassword123"); !errors.Is(err, ErrKeyFileRequired) {
+		t.Errorf("Expected ErrKeyFileRequired, got %v", err)
+	}
+	if _, err := OpenWithKeyFile(path, "testpassword123", filepath.Join(dir, "missing.key")); !errors.Is(err, ErrKeyFileMissing) {
+		t.Errorf("Expected ErrKeyFileMissing, got %v", err)
+	}
+	if _, err := OpenWithKeyFile(path, "testpassword123", otherKeyFile); err != store.ErrInvalidPassword {
+		t.Errorf("Expected ErrInvalidPassword with wrong key file, got %v", err)
+	}
+
+	v, err = OpenWithKeyFile(path, "testpassword123", keyFile)
+	if err != nil {
+		t.Fatalf("OpenWithKeyFile failed: %v", err)
+	}
+	// The new password keeps the key file requirement
+	if err := v.ChangePassword(ctx, "testpassword123", "newpassword123"); err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+	digest := v.keyFile
+	if digest.Len() == 0 {
+		t.Error("Expected the key file digest to be kept in a secret buffer")
+	}
+	v.Close()
+	if !digest.Destroyed() {
+		t.Error("Expected Close to destroy the key file digest")
+	}
+
+	if _, err := Open(path, "newpassword123"); !errors.Is(err, ErrKeyFileRequired) {
+		t.Errorf("Expected ErrKeyFileRequired after password change, got %v", err)
+	}
+	v, err = OpenWithKeyFile(path, "newpassword123", keyFile)
+	if err != nil {
+		t.Fatalf("OpenWithKeyFile after password change failed: %v", err)
+	}
+	v.Close()
+}
+
+func TestVaultRecoveryKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+
+	v, err := Init(path, "forgottenpassword")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	secret := model.NewSecretObject("insurance")
+	secret.AddField(model.NewField("policy", "P-12345"))
+	if err := v.Create(ctx, secret); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	recoveryKey, err := v.AddRecoveryKey()
+	if err != nil {
+		t.Fatalf("AddRecoveryKey failed: %v", err)
+	}
+	if _, err := v.AddRecoveryKey(); err != ErrSlotExists {
+		t.Errorf("Expected ErrSlotExists for a second recovery key, got %v", err)
+	}
+	if err := v.RemoveSlot(ctx, defaultSlotLabel); err != ErrLastSlot {
+		t.Errorf("Expected ErrLastSlot when only the recovery slot would remain, got %v", err)
+	}
+	v.Close()
+
+	// The recovery key is not accepted as a password
+	if _, err := Open(path, recoveryKey); err != store.ErrInvalidPassword {
+		t.Errorf("Expected ErrInvalidPassword, got %v", err)
+	}
+	if _, err := OpenWithRecoveryKey(path, "AAAA-BBBB"); err != ErrInvalidRecoveryKey {
+		t.Errorf("Expected ErrInvalidRecoveryKey for malformed key, got %v", err)
+	}
+
+	// Case and separators do not matter
+	typed := strings.ToLower(strings.ReplaceAll(recoveryKey, "-", " "))
+	v, err = OpenWithRecoveryKey(path, typed)
+	if err != nil {
+		t.Fatalf("OpenWithRecoveryKey failed: %v", err)
+	}
+	if err := v.ResetPassword(defaultSlotLabel, "rememberedpassword", ""); err != nil {
+		t.Fatalf("ResetPassword failed: %v", err)
+	}
+	v.Close()
+
+	if _, err := Open(path, "forgottenpassword"); err != store.ErrInvalidPassword {
+		t.Errorf("Expected old password to be rejected, got %v", err)
+	}
+	v, err = Open(path, "rememberedpassword")
+	if err != nil {
+		t.Fatalf("Open with new password failed: %v", err)
+	}
+	defer v.Close()
+	got, err := v.GetByName(ctx, "insurance")
+	if err != nil || got.Fields[0].Value != "P-12345" {
+		t.Errorf("GetByName after recovery failed: %v", err)
+	}
+}
+
+func TestVaultDetectsSwappedFieldValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+
+	v, err := Init(path, "testpassword123")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	defer v.Close()
+
+	for name, value := range map[string]string{"low": "public-pin", "high": "bank-pin"} {
+		secret := model.NewSecretObject(name)
+		secret.AddField(model.NewField("pin", value))
+		if err := v.Create(ctx, secret); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+
+	// Copy the ciphertext of one field over another, as someone with write
+	// access to the file could
+	low, _ := v.store.GetByName(ctx, "low")
+	high, _ := v.store.GetByName(ctx, "high")
+	swapped := store.FieldRow{SecretID: low.ID, Field: low.Fields[0]}
+	swapped.Value = high.Fields[0].Value
+	if err := v.store.UpdateFieldValues(ctx, []store.FieldRow{swapped}, nil); err != nil {
+		t.Fatalf("UpdateFieldValues failed: %v", err)
+	}
+
+	if _, err := v.GetByName(ctx, "low"); err == nil {
+		t.Error("Expected swapped field value to fail authentication")
+	}
+	if got, err := v.GetByName(ctx, "high"); err != nil || got.Fields[0].Value != "bank-pin" {
+		t.Errorf("Untouched secret unreadable: %v", err)
+	}
+}
+
+func TestVaultResealsUnboundFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	password := "testpassword123"
+	ctx := context.Background()
+
+	v, err := Init(path, password)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	secret := model.NewSecretObject("router")
+	secret.AddField(model.NewField("admin", "s3cret"))
+	if err := v.Create(ctx, secret); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// Rewrite the field the way older versions sealed it: no prefix, no associated data
+	raw, _ := v.store.GetByName(ctx, "router")
+	legacy, err := encryptWithKey(core.DefaultCipher, v.key.Bytes(), "s3cret", nil)
+	if err != nil {
+		t.Fatalf("encryptWithKey failed: %v", err)
+	}
+	row := store.FieldRow{SecretID: raw.ID, Field: raw.Fields[0]}
+	row.Value = legacy
+	if err := v.store.UpdateFieldValues(ctx, []store.FieldRow{row}, nil); err != nil {
+		t.Fatalf("UpdateFieldValues failed: %v", err)
+	}
+	if err := v.store.DeleteMeta(metaFieldFormat); err != nil {
+		t.Fatalf("DeleteMeta failed: %v", err)
+	}
+	v.Close()
+
+	v2, err := Open(path, password)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer v2.Close()
+
+	resealed, _ := v2.store.GetByName(ctx, "router")
+	if !strings.HasPrefix(resealed.Fields[0].Value, fieldCipherPrefix) {
+		t.Errorf("Expected field to be resealed, got %q", resealed.Fields[0].Value)
+	}
+	got, err := v2.GetByName(ctx, "router")
+	if err != nil {
+		t.Fatalf("GetByName failed: %v", err)
+	}
+	if got.Fields[0].Value != "s3cret" {
+		t.Errorf("Expected 's3cret', got %q", got.Fields[0].Value)
+	}
+}
+
+func TestVaultEncryptMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+
+	v, err := Init(path, "testpassword123")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	bank := model.NewSecretObject("acme-bank")
+	bank.Tags = []string{"finance"}
+	bank.Notes = "joint account"
+	bank.AddField(model.NewField("username", "alice"))
+	bank.Fields[0].Sensitive = false
+	bank.AddField(model.NewField("password", "hunter2"))
+	mail := model.NewSecretObject("mailbox")
+	mail.Tags = []string{"personal"}
+	for _, s := range []*model.SecretObject{mail, bank} {
+		if err := v.Create(ctx, s); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+
+	backupPath, err := v.EncryptMetadata(ctx)
+	if err != nil {
+		t.Fatalf("EncryptMetadata failed: %v", err)
+	}
+	if backupPath == "" {
+		t.Error("Expected a backup of the non-empty vault")
+	}
+	if _, err := v.EncryptMetadata(ctx); !errors.Is(err, ErrMetadataEncrypted) {
+		t.Errorf("Expected ErrMetadataEncrypted, got %v", err)
+	}
+	v.Close()
+
+	// Nothing descriptive is left in the rows
+	s, err := store.Open(path)
+	if err != nil {
+		t.Fatalf("store.Open failed: %v", err)
+	}
+	rows, err := s.List(ctx, nil)
+	s.Close()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	for _, row := range rows {
+		stored := row.Name + row.TagsJSON() + row.Notes
+		for _, f := range row.Fields {
+			stored += f.Label + f.Value
+		}
+		for _, plain := range []string{"acme", "mailbox", "finance", "personal", "joint", "username", "alice"} {
+			if strings.Contains(stored, plain) {
+				t.Errorf("Stored row still contains %q", plain)
+			}
+		}
+	}
+
+	v, err = Open(path, "testpassword123")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer v.Close()
+	if !v.MetadataEncrypted() {
+		t.Fatal("Expected metadata encryption to persist")
+	}
+
+	got, err := v.GetByName(ctx, "acme-bank")
+	if err != nil {
+		t.Fatalf("GetByName failed: %v", err)
+	}
+	if got.Notes != "joint account" || len(got.Tags) != 1 || got.Tags[0] != "finance" {
+		t.Errorf("Metadata mismatch: %+v", got)
+	}
+	if got.Fields[0].Label != "username" || got.Fields[0].Value != "alice" || got.Fields[1].Value != "hunter2" {
+		t.Errorf("Fields mismatch: %+v", got.Fields)
+	}
+
+	tagged, err := v.List(ctx, &store.SearchOptions{Tags: []string{"finance"}})
+	if err != nil || len(tagged) != 1 || tagged[0].Name != "acme-bank" {
+		t.Errorf("Tag filter returned %v, %v", tagged, err)
+	}
+	limited, err := v.List(ctx, &store.SearchOptions{Limit: 1})
+	if err != nil || len(limited) != 1 || limited[0].Name != "acme-bank" {
+		t.Errorf("Expected the first secret by name, got %v, %v", limited, err)
+	}
+	found, err := v.Search(ctx, "USER", nil)
+	if err != nil || len(found) != 1 || found[0].Name != "acme-bank" {
+		t.Errorf("Search by label returned %v, %v", found, err)
+	}
+
+	// New and updated secrets are sealed too
+	got.Name = "acme-credit-union"
+	if err := v.Update(ctx, got); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if _, err := v.GetByName(ctx, "acme-bank"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected old name to be gone, got %v", err)
+	}
+	if err := v.Delete(ctx, "acme-credit-union"); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+}
+
+func TestVaultHistory(t *testing.T) {
+	for _, metadata := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "test.db")
+		ctx := context.Background()
+
+		v, err := Init(path, "testpassword123")
+		if err != nil {
+			t.Fatalf("Init failed: %v", err)
+		}
+		if metadata {
+			if _, err := v.EncryptMetadata(ctx); err != nil {
+				t.Fatalf("EncryptMetadata failed: %v", err)
+			}
+		}
+
+		secret := model.NewSecretObject("github")
+		secret.AddField(model.NewField("password", "old-password"))
+		if err := v.Create(ctx, secret); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		secret.Fields[0].Value = "new-password"
+		if err := v.Update(ctx, secret); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+
+		versions, err := v.History(ctx, "github")
+		if err != nil || len(versions) != 2 {
+			t.Fatalf("History = %+v, %v", versions, err)
+		}
+		old, err := v.GetVersion(ctx, "github", 1)
+		if err != nil {
+			t.Fatalf("GetVersion failed: %v", err)
+		}
+		if old.Name != "github" || old.Fields[0].Value != "old-password" {
+			t.Errorf("Version 1 = %+v, want the old password", old)
+		}
+		current, err := v.GetVersion(ctx, "github", 2)
+		if err != nil || current.Fields[0].Value != "new-password" {
+			t.Errorf("Expected version 2 to be the current secret, got %+v, %v", current, err)
+		}
+		if _, err := v.GetVersion(ctx, "github", 3); !errors.Is(err, store.ErrVersionNotFound) {
+			t.Errorf("Expected ErrVersionNotFound, got %v", err)
+		}
+
+		// The old password is kept encrypted
+		history, err := v.store.AllVersions(ctx)
+		if err != nil {
+			t.Fatalf("AllVersions failed: %v", err)
+		}
+		if len(history) != 1 || strings.Contains(history[0].Secret.Fields[0].Value, "old-password") {
+			t.Errorf("Expected one version with an encrypted value, got %+v", history)
+		}
+
+		if _, err := v.Rollback(ctx, "github", 1); err != nil {
+			t.Fatalf("Rollback failed: %v", err)
+		}
+		got, err := v.GetByName(ctx, "github")
+		if err != nil || got.Fields[0].Value != "old-password" {
+			t.Errorf("Expected the old password after rollback, got %+v, %v", got, err)
+		}
+		if versions, err := v.History(ctx, "github"); err != nil || len(versions) != 3 {
+			t.Errorf("Expected rollback to keep the replaced version, got %+v, %v", versions, err)
+		}
+		v.Close()
+	}
+}
+
+func TestVaultTrash(t *testing.T) {
+	for _, metadata := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "test.db")
+		ctx := context.Background()
+
+		v, err := Init(path, "testpassword123")
+		if err != nil {
+			t.Fatalf("Init failed: %v", err)
+		}
+		if metadata {
+			if _, err := v.EncryptMetadata(ctx); err != nil {
+				t.Fatalf("EncryptMetadata failed: %v", err)
+			}
+		}
+
+		secret := model.NewSecretObject("github")
+		secret.Tags = []string{"dev"}
+		secret.AddField(model.NewField("password", "hunter2"))
+		if err := v.Create(ctx, secret); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if err := v.Delete(ctx, "github"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+
+		if _, err := v.GetByName(ctx, "github"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for a trashed secret, got %v", err)
+		}
+		if found, err := v.Search(ctx, "git", nil); err != nil || len(found) != 0 {
+			t.Errorf("Search returned trashed secrets: %v, %v", found, err)
+		}
+		trash, err := v.Trash(ctx)
+		if err != nil || len(trash) != 1 {
+			t.Fatalf("Trash = %v, %v", trash, err)
+		}
+		if trash[0].Name != "github" || trash[0].Fields[0].Value != "hunter2" {
+			t.Errorf("Trash entry = %+v, want it decrypted", trash[0])
+		}
+
+		if err := v.Restore(ctx, "github"); err != nil {
+			t.Fatalf("Restore failed: %v", err)
+		}
+		if got, err := v.GetByName(ctx, "github"); err != nil || got.Fields[0].Value != "hunter2" {
+			t.Errorf("Restored secret = %+v, %v", got, err)
+		}
+
+		if err := v.Delete(ctx, "github"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if n, err := v.Purge(ctx, time.Hour); err != nil || n != 0 {
+			t.Errorf("Purge of old secrets = %d, %v; want 0", n, err)
+		}
+		if n, err := v.Purge(ctx, 0); err != nil || n != 1 {
+			t.Errorf("Purge = %d, %v; want 1", n, err)
+		}
+		if err := v.Restore(ctx, "github"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound after purge, got %v", err)
+		}
+		v.Close()
+	}
+}
+
+func TestVaultEncryptMetadataSealsTrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+
+	v, err := Init(path, "testpassword123")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	defer v.Close()
+
+	if err := v.Create(ctx, model.NewSecretObject("acme-bank")); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := v.Delete(ctx, "acme-bank"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := v.EncryptMetadata(ctx); err != nil {
+		t.Fatalf("EncryptMetadata failed: %v", err)
+	}
+
+	stored, err := v.store.Trash(ctx)
+	if err != nil || len(stored) != 1 {
+		t.Fatalf("store.Trash = %v, %v", stored, err)
+	}
+	if stored[0].Name == "acme-bank" || strings.Contains(stored[0].Notes, "acme") {
+		t.Errorf("Expected the trashed secret to be sealed, got %+v", stored[0])
+	}
+	if err := v.Restore(ctx, "acme-bank"); err != nil {
+		t.Errorf("Restore failed: %v", err)
+	}
+}
+
+func TestVaultRename(t *testing.T) {
+	for _, metadata := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "test.db")
+		ctx := context.Background()
+
+		v, err := Init(path, "testpassword123")
+		if err != nil {
+			t.Fatalf("Init failed: %v", err)
+		}
+		if metadata {
+			if _, err := v.EncryptMetadata(ctx); err != nil {
+				t.Fatalf("EncryptMetadata failed: %v", err)
+			}
+		}
+
+		for _, name := range []string{"github", "gitlab"} {
+			secret := model.NewSecretObject(name)
+			secret.AddField(model.NewField("password", name+"-pw"))
+			if err := v.Create(ctx, secret); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+		}
+		if err := v.Create(ctx, model.NewSecretObject("github")); !errors.Is(err, store.ErrAlreadyExists) {
+			t.Errorf("Expected ErrAlreadyExists for a duplicate name, got %v", err)
+		}
+
+		renamed, err := v.Rename(ctx, "github", "github-work")
+		if err != nil {
+			t.Fatalf("Rename failed: %v", err)
+		}
+		if renamed.Name != "github-work" {
+			t.Errorf("Rename returned %q", renamed.Name)
+		}
+		if got, err := v.GetByName(ctx, "github-work"); err != nil || got.Fields[0].Value != "github-pw" {
+			t.Errorf("GetByName after rename = %+v, %v", got, err)
+		}
+		if _, err := v.GetByName(ctx, "github"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for the old name, got %v", err)
+		}
+		if versions, err := v.History(ctx, "github-work"); err != nil || len(versions) != 2 {
+			t.Errorf("History after rename = %v, %v; want the old name kept", versions, err)
+		}
+
+		if _, err := v.Rename(ctx, "gitlab", "github-work"); !errors.Is(err, store.ErrAlreadyExists) {
+			t.Errorf("Expected ErrAlreadyExists renaming onto a taken name, got %v", err)
+		}
+		if _, err := v.Rename(ctx, "missing", "other"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound renaming a missing secret, got %v", err)
+		}
+
+		// A trashed secret does not hold on to its name
+		if err := v.Delete(ctx, "gitlab"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if _, err := v.Rename(ctx, "github-work", "gitlab"); err != nil {
+			t.Errorf("Rename onto a trashed name failed: %v", err)
+		}
+
+		// Rolling back restores the content but keeps the current name, even
+		// when the old one has been taken since
+		if err := v.Create(ctx, model.NewSecretObject("github")); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		restored, err := v.Rollback(ctx, "gitlab", 1)
+		if err != nil {
+			t.Fatalf("Rollback after rename failed: %v", err)
+		}
+		if restored.Name != "gitlab" {
+			t.Errorf("Rollback restored the name %q, want gitlab", restored.Name)
+		}
+		if got, err := v.GetByName(ctx, "gitlab"); err != nil || got.Fields[0].Value != "github-pw" {
+			t.Errorf("GetByName after rollback = %+v, %v", got, err)
+		}
+		v.Close()
+	}
+}
+
+func TestVaultCaseInsensitiveNames(t *testing.T) {
+	for _, metadata := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "test.db")
+		ctx := context.Background()
+
+		v, err := Init(path, "testpassword123")
+		if err != nil {
+			t.Fatalf("Init failed: %v", err)
+		}
+		if metadata {
+			if _, err := v.EncryptMetadata(ctx); err != nil {
+				t.Fatalf("EncryptMetadata failed: %v", err)
+			}
+		}
+
+		for _, name := range []string{"GitHub", "github"} {
+			if err := v.Create(ctx, model.NewSecretObject(name)); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+		}
+		if err := v.SetCaseInsensitiveNames(ctx, true); !errors.Is(err, store.ErrAlreadyExists) {
+			t.Fatalf("Expected ErrAlreadyExists with names differing in case, got %v", err)
+		}
+		if v.CaseInsensitiveNames() {
+			t.Error("Setting was changed despite the conflict")
+		}
+
+		if err := v.Delete(ctx, "github"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if err := v.SetCaseInsensitiveNames(ctx, true); err != nil {
+			t.Fatalf("SetCaseInsensitiveNames failed: %v", err)
+		}
+		if got, err := v.GetByName(ctx, "GITHUB"); err != nil || got.Name != "GitHub" {
+			t.Errorf("GetByName ignoring case = %+v, %v", got, err)
+		}
+		if err := v.Create(ctx, model.NewSecretObject("gitHUB")); !errors.Is(err, store.ErrAlreadyExists) {
+			t.Errorf("Expected ErrAlreadyExists for a name differing in case, got %v", err)
+		}
+		if err := v.Restore(ctx, "github"); !errors.Is(err, store.ErrAlreadyExists) {
+			t.Errorf("Expected ErrAlreadyExists restoring a name differing in case, got %v", err)
+		}
+		v.Close()
+
+		// The setting is kept in the vault
+		v, err = Open(path, "testpassword123")
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		if !v.CaseInsensitiveNames() {
+			t.Error("Expected case-insensitive names after reopening")
+		}
+		if err := v.SetCaseInsensitiveNames(ctx, false); err != nil {
+			t.Fatalf("SetCaseInsensitiveNames failed: %v", err)
+		}
+		if _, err := v.GetByName(ctx, "GITHUB"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound with exact matching, got %v", err)
+		}
+		if err := v.Restore(ctx, "github"); err != nil {
+			t.Errorf("Restore with exact matching failed: %v", err)
+		}
+		v.Close()
+	}
+}
+
+func TestVaultPaths(t *testing.T) {
+	for _, metadata := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "test.db")
+		ctx := context.Background()
+
+		v, err := Init(path, "testpassword123")
+		if err != nil {
+			t.Fatalf("Init failed: %v", err)
+		}
+		if metadata {
+			if _, err := v.EncryptMetadata(ctx); err != nil {
+				t.Fatalf("EncryptMetadata failed: %v", err)
+			}
+		}
+
+		for _, name := range []string{"work/aws/prod", "work/aws/staging", "work/aws", "work/github", "personal"} {
+			secret := model.NewSecretObject(name)
+			secret.AddField(model.NewField("password", name+"-pw"))
+			if err := v.Create(ctx, secret); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+		}
+		for _, name := range []string{"", "/work", "work/", "work//aws"} {
+			if err := v.Create(ctx, model.NewSecretObject(name)); !errors.Is(err, model.ErrInvalidName) {
+				t.Errorf("Create(%q) = %v, want ErrInvalidName", name, err)
+			}
+		}
+
+		inside, err := v.List(ctx, &store.SearchOptions{Prefix: "work/"})
+		if err != nil || len(inside) != 4 {
+			t.Fatalf("List with prefix = %v, %v; want the four secrets inside work", inside, err)
+		}
+		entries := model.ListFolder(inside, "work")
+		if len(entries) != 3 || !entries[0].IsFolder() || entries[0].Path != "work/aws" || entries[0].Secrets != 2 ||
+			entries[1].Name != "aws" || entries[2].Name != "github" {
+			t.Errorf("ListFolder = %+v, want folder aws, then secrets aws and github", entries)
+		}
+
+		moved, err := v.Move(ctx, "work/aws", "personal")
+		if !errors.Is(err, store.ErrAlreadyExists) || moved != 0 {
+			t.Errorf("Expected ErrAlreadyExists moving onto a taken name, got %d, %v", moved, err)
+		}
+		if _, err := v.Move(ctx, "work", "work/old"); err == nil {
+			t.Error("Expected an error moving a folder into itself")
+		}
+		if _, err := v.Move(ctx, "missing", "other"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound moving a missing folder, got %v", err)
+		}
+
+		// A folder moves with the secret named like it
+		moved, err = v.Move(ctx, "work/aws/", "cloud/aws")
+		if err != nil || moved != 3 {
+			t.Fatalf("Move = %d, %v; want 3", moved, err)
+		}
+		for _, name := range []string{"cloud/aws", "cloud/aws/prod", "cloud/aws/staging"} {
+			if _, err := v.GetByName(ctx, name); err != nil {
+				t.Errorf("GetByName(%q) after move failed: %v", name, err)
+			}
+		}
+		if got, err := v.GetByName(ctx, "cloud/aws/prod"); err != nil || got.Fields[0].Value != "work/aws/prod-pw" {
+			t.Errorf("Moved secret = %+v, %v", got, err)
+		}
+		if left, err := v.List(ctx, &store.SearchOptions{Prefix: "work/"}); err != nil || len(left) != 1 {
+			t.Errorf("List after move = %v, %v; want only work/github", left, err)
+		}
+
+		// Moving into the parent, cloud/aws/aws takes the name cloud/aws gives up
+		nested := model.NewSecretObject("cloud/aws/aws")
+		nested.AddField(model.NewField("password", "nested-pw"))
+		if err := v.Create(ctx, nested); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if moved, err := v.Move(ctx, "cloud/aws", "cloud"); err != nil || moved != 4 {
+			t.Fatalf("Move into parent = %d, %v; want 4", moved, err)
+		}
+		if got, err := v.GetByName(ctx, "cloud/aws"); err != nil || got.Fields[0].Value != "nested-pw" {
+			t.Errorf("GetByName after moving into parent = %+v, %v", got, err)
+		}
+		v.Close()
+	}
+}
+
+func TestVaultTOTP(t *testing.T) {
+	for _, metadata := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "test.db")
+		ctx := context.Background()
+
+		v, err := Init(path, "testpassword123")
+		if err != nil {
+			t.Fatalf("Init failed: %v", err)
+		}
+		if metadata {
+			if _, err := v.EncryptMetadata(ctx); err != nil {
+				t.Fatalf("EncryptMetadata failed: %v", err)
+			}
+		}
+
+		secret := model.NewSecretObject("github")
+		secret.AddField(model.NewField("password", "hunter2"))
+		invalid := model.NewField("totp", "not a seed!")
+		invalid.Type = model.FieldTypeTOTP
+		secret.AddField(invalid)
+		if err := v.Create(ctx, secret); !errors.Is(err, model.ErrInvalidField) {
+			t.Fatalf("Expected ErrInvalidField for an invalid seed, got %v", err)
+		}
+
+		secret.Fields[1].Value = "JBSWY3DPEHPK3PXP"
+		if err := v.Create(ctx, secret); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+
+		at := time.Unix(1700000000, 0)
+		key, _ := core.ParseTOTP("JBSWY3DPEHPK3PXP")
+		want := key.Generate(at)
+		if code, err := v.TOTP(ctx, "github", "", at); err != nil || code != want {
+			t.Errorf("TOTP = %+v, %v; want %+v", code, err, want)
+		}
+		if _, err := v.TOTP(ctx, "github", "password", at); !errors.Is(err, ErrFieldNotFound) {
+			t.Errorf("Expected ErrFieldNotFound for a field that is not TOTP, got %v", err)
+		}
+
+		secret.Fields[1].Value = "otpauth://hotp/ACME?secret=JBSWY3DPEHPK3PXP"
+		if err := v.Update(ctx, secret); !errors.Is(err, model.ErrInvalidField) {
+			t.Errorf("Expected ErrInvalidField updating to an invalid seed, got %v", err)
+		}
+		v.Close()
+	}
+}
+
+func TestVaultAttachments(t *testing.T) {
+	for _, metadata := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "test.db")
+		ctx := context.Background()
+
+		v, err := Init(path, "testpassword123")
+		if err != nil {
+			t.Fatalf("Init failed: %v", err)
+		}
+		if metadata {
+			if _, err := v.EncryptMetadata(ctx); err != nil {
+				t.Fatalf("EncryptMetadata failed: %v", err)
+			}
+		}
+		if err := v.Create(ctx, model.NewSecretObject("server")); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+
+		// Several chunks, the last one partial, and a file ending on a chunk boundary
+		content := bytes.Repeat([]byte("private key "), core.StreamChunkSize/4)
+		boundary := bytes.Repeat([]byte{0x42}, core.StreamChunkSize)
+		for file, data := range map[string][]byte{"id_rsa": content, "boundary.bin": boundary, "empty.txt": nil} {
+			a, err := v.Attach(ctx, "server", file, bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Attach %s failed: %v", file, err)
+			}
+			if a.Name != file || a.Size != int64(len(data)) {
+				t.Errorf("Attach = %+v", a)
+			}
+			var out bytes.Buffer
+			if _, err := v.Extract(ctx, "server", file, &out); err != nil {
+				t.Fatalf("Extract %s failed: %v", file, err)
+			}
+			if !bytes.Equal(out.Bytes(), data) {
+				t.Errorf("Extract %s returned %d bytes, want %d", file, out.Len(), len(data))
+			}
+		}
+
+		list, err := v.Attachments(ctx, "server")
+		if err != nil {
+			t.Fatalf("Attachments failed: %v", err)
+		}
+		if len(list) != 3 || list[0].Name != "boundary.bin" || list[2].Name != "id_rsa" {
+			t.Errorf("Attachments = %+v", list)
+		}
+
+		if _, err := v.Attach(ctx, "server", "id_rsa", strings.NewReader("again")); !errors.Is(err, ErrAttachmentExists) {
+			t.Errorf("Expected ErrAttachmentExists, got %v", err)
+		}
+		if _, err := v.Attach(ctx, "server", "../id_rsa", strings.NewReader("x")); !errors.Is(err, model.ErrInvalidAttachmentName) {
+			t.Errorf("Expected ErrInvalidAttachmentName, got %v", err)
+		}
+		if _, err := v.Attach(ctx, "missing", "id_rsa", strings.NewReader("x")); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for a missing secret, got %v", err)
+		}
+		tooLarge := io.LimitReader(zeroReader{}, MaxAttachmentSize+1)
+		if _, err := v.Attach(ctx, "server", "large.bin", tooLarge); !errors.Is(err, ErrAttachmentTooLarge) {
+			t.Errorf("Expected ErrAttachmentTooLarge, got %v", err)
+		}
+		if _, err := v.Attachment(ctx, "server", "large.bin"); !errors.Is(err, ErrAttachmentNotFound) {
+			t.Errorf("Expected nothing stored for a rejected attachment, got %v", err)
+		}
+
+		if err := v.Detach(ctx, "server", "empty.txt"); err != nil {
+			t.Fatalf("Detach failed: %v", err)
+		}
+		if _, err := v.Extract(ctx, "server", "empty.txt", io.Discard); !errors.Is(err, ErrAttachmentNotFound) {
+			t.Errorf("Expected ErrAttachmentNotFound after Detach, got %v", err)
+		}
+		v.Close()
+	}
+}
+
+func TestVaultAttachmentTampering(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
 
-	tmpfile, err := os.CreateTemp("", "keyp-vault-test-*.db")
+	v, err := Init(path, "testpassword123")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	defer v.Close()
+	if err := v.Create(ctx, model.NewSecretObject("server")); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	content := bytes.Repeat([]byte{1}, 3*core.StreamChunkSize)
+	if _, err := v.Attach(ctx, "server", "key.p12", bytes.NewReader(content)); err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+
+	db, err := sql.Open("sqlite3", path)
 	if err != nil {
 		t.Fatal(err)
 	}
-	tmpfile.Close()
+	defer db.Close()
 
-	t.Cleanup(func() {
-		os.Remove(tmpfile.Name())
-	})
+	// Dropping the last chunk must not pass for a shorter file
+	if _, err := db.Exec("DELETE FROM attachment_chunks WHERE seq = 2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Extract(ctx, "server", "key.p12", io.Discard); !errors.Is(err, core.ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for a truncated attachment, got %v", err)
+	}
+
+	// Nor swapping chunks around
+	for _, swap := range []string{"seq = 9 WHERE seq = 0", "seq = 0 WHERE seq = 1", "seq = 1 WHERE seq = 9"} {
+		if _, err := db.Exec("UPDATE attachment_chunks SET " + swap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := v.Extract(ctx, "server", "key.p12", io.Discard); !errors.Is(err, core.ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for reordered chunks, got %v", err)
+	}
+}
+
+// zeroReader reads endless zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestVaultEncryptMetadataSealsHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+
+	v, err := Init(path, "testpassword123")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	defer v.Close()
+
+	secret := model.NewSecretObject("acme-bank")
+	secret.Tags = []string{"finance"}
+	secret.AddField(model.NewField("username", "alice"))
+	secret.Fields[0].Sensitive = false
+	if err := v.Create(ctx, secret); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	secret.Name = "acme-credit-union"
+	if err := v.Update(ctx, secret); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
 
-	v, err := Init(tmpfile.Name(), password)
+	if _, err := v.EncryptMetadata(ctx); err != nil {
+		t.Fatalf("EncryptMetadata failed: %v", err)
+	}
+
+	history, err := v.store.AllVersions(ctx)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("AllVersions = %+v, %v", history, err)
+	}
+	row := history[0].Secret
+	stored := row.Name + row.TagsJSON() + row.Notes + row.Fields[0].Label + row.Fields[0].Value
+	for _, plain := range []string{"acme", "finance", "username", "alice"} {
+		if strings.Contains(stored, plain) {
+			t.Errorf("Stored version still contains %q", plain)
+		}
+	}
+
+	old, err := v.GetVersion(ctx, "acme-credit-union", 1)
+	if err != nil {
+		t.Fatalf("GetVersion failed: %v", err)
+	}
+	if old.Name != "acme-bank" || old.Fields[0].Value != "alice" {
+		t.Errorf("Version 1 = %+v, want the state before the rename", old)
+	}
+}
+
+func TestVaultDetectsSwappedNameIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+
+	v, err := Init(path, "testpassword123")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	defer v.Close()
+	if _, err := v.EncryptMetadata(ctx); err != nil {
+		t.Fatalf("EncryptMetadata failed: %v", err)
+	}
+
+	for _, name := range []string{"low", "high"} {
+		if err := v.Create(ctx, model.NewSecretObject(name)); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+
+	// Point the index of "low" at the row of "high"
+	lowIndex, _ := v.nameKey("low")
+	highIndex, _ := v.nameKey("high")
+	high, err := v.store.GetByName(ctx, highIndex)
+	if err != nil {
+		t.Fatalf("store.GetByName failed: %v", err)
+	}
+	if err := v.store.Delete(ctx, lowIndex); err != nil {
+		t.Fatalf("store.Delete failed: %v", err)
+	}
+	high.Name, high.NameKey = lowIndex, lowIndex
+	if err := v.store.RewriteSecrets(ctx, []*model.SecretObject{high}, nil, nil, nil); err != nil {
+		t.Fatalf("RewriteSecrets failed: %v", err)
+	}
+
+	if _, err := v.GetByName(ctx, "low"); !errors.Is(err, errIndexMismatch) {
+		t.Errorf("Expected errIndexMismatch, got %v", err)
+	}
+}
+
+func TestVaultCipherSetting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+
+	v, err := Init(path, "testpassword123")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if v.Cipher() != core.DefaultCipher {
+		t.Errorf("Cipher = %q, want %q", v.Cipher(), core.DefaultCipher)
+	}
+
+	old := model.NewSecretObject("old")
+	old.AddField(model.NewField("password", "written-with-aes"))
+	if err := v.Create(ctx, old); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if err := v.SetCipher("rot13"); err == nil {
+		t.Error("Expected error for unsupported cipher")
+	}
+	if err := v.SetCipher(core.CipherXChaCha20Poly1305); err != nil {
+		t.Fatalf("SetCipher failed: %v", err)
+	}
+	v.Close()
+
+	v, err = Open(path, "testpassword123")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer v.Close()
+	if v.Cipher() != core.CipherXChaCha20Poly1305 {
+		t.Errorf("Cipher setting not persisted: %q", v.Cipher())
+	}
+
+	fresh := model.NewSecretObject("new")
+	fresh.AddField(model.NewField("password", "written-with-xchacha"))
+	if err := v.Create(ctx, fresh); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	for name, want := range map[string]string{"old": core.CipherAES256GCM, "new": core.CipherXChaCha20Poly1305} {
+		raw, _ := v.store.GetByName(ctx, name)
+		got, err := core.EnvelopeCipher(strings.TrimPrefix(raw.Fields[0].Value, fieldCipherPrefix))
+		if err != nil || got != want {
+			t.Errorf("%s: stored with %q (%v), want %q", name, got, err, want)
+		}
+		if _, err := v.GetByName(ctx, name); err != nil {
+			t.Errorf("%s: GetByName failed: %v", name, err)
+		}
+	}
+}
+
+// damageVault runs raw SQL against a vault file, as a crash or an older
+// version could have left it
+func damageVault(t *testing.T, path string, statements ...string) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer db.Close()
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+}
+
+func TestVaultDoctor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+
+	v, err := Init(path, "testpassword123")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	defer v.Close()
+
+	for _, name := range []string{"intact", "damaged", "gone", "twin", "twin2"} {
+		secret := model.NewSecretObject(name)
+		secret.AddField(model.NewField("pin", name+"-pin"))
+		if err := v.Create(ctx, secret); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+	// A duplicate as the schema migration leaves it, unreachable by name
+	damageVault(t, path, "UPDATE secrets SET name = 'twin', name_key = 'twin#' || id WHERE name = 'twin2'")
+
+	report, err := v.Doctor(ctx, false)
+	if err != nil {
+		t.Fatalf("Doctor failed: %v", err)
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Check != CheckDuplicateNames || report.Problems[0].Secret != "twin" {
+		t.Fatalf("Expected only the duplicate name, got %+v", report.Problems)
+	}
+
+	intact, _ := v.store.GetByName(ctx, "intact")
+	damaged, _ := v.store.GetByName(ctx, "damaged")
+	swapped := store.FieldRow{SecretID: damaged.ID, Field: damaged.Fields[0]}
+	swapped.Value = intact.Fields[0].Value
+	if err := v.store.UpdateFieldValues(ctx, []store.FieldRow{swapped}, nil); err != nil {
+		t.Fatalf("UpdateFieldValues failed: %v", err)
+	}
+	damageVault(t, path,
+		"DELETE FROM secrets WHERE name = 'gone'",
+		"UPDATE secrets SET tags = '{' WHERE name = 'intact'",
+	)
+
+	counts := func(r *DoctorReport) map[string]int {
+		c := make(map[string]int)
+		for _, p := range r.Problems {
+			if !p.Repaired {
+				c[p.Check]++
+			}
+		}
+		return c
+	}
+
+	report, err = v.Doctor(ctx, false)
+	if err != nil {
+		t.Fatalf("Doctor failed: %v", err)
+	}
+	want := map[string]int{CheckDecrypt: 1, CheckTags: 1, CheckOrphanedFields: 1, CheckDuplicateNames: 1}
+	if got := counts(report); len(got) != len(want) || got[CheckDecrypt] != 1 || got[CheckTags] != 1 || got[CheckOrphanedFields] != 1 {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	if report.Healthy() || report.Backup != "" {
+		t.Errorf("Check without repair: healthy=%v backup=%q", report.Healthy(), report.Backup)
+	}
+
+	report, err = v.Doctor(ctx, true)
+	if err != nil {
+		t.Fatalf("Doctor repair failed: %v", err)
+	}
+	if report.Backup == "" {
+		t.Error("Expected a backup before repairing")
+	}
+	if got := counts(report); len(got) != 1 || got[CheckDecrypt] != 1 {
+		t.Errorf("Expected only unrepairable problems left, got %v", got)
+	}
+	renamed, err := v.GetByName(ctx, "twin-2")
+	if err != nil || renamed.Fields[0].Value != "twin2-pin" {
+		t.Errorf("Expected the duplicate renamed to twin-2, got %+v, %v", renamed, err)
+	}
+
+	report, err = v.Doctor(ctx, false)
+	if err != nil {
+		t.Fatalf("Doctor failed: %v", err)
+	}
+	if got := counts(report); len(got) != 1 {
+		t.Errorf("Repair did not stick: %v", got)
+	}
+}
+
+func TestVaultDoctorChecksHistoryAndAttachments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+
+	v, err := Init(path, "testpassword123")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	defer v.Close()
+
+	secret := model.NewSecretObject("server")
+	secret.AddField(model.NewField("password", "old-password"))
+	if err := v.Create(ctx, secret); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	secret.Fields[0].Value = "new-password"
+	if err := v.Update(ctx, secret); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if _, err := v.Attach(ctx, "server", "notes.txt", strings.NewReader("root login")); err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+
+	report, err := v.Doctor(ctx, false)
+	if err != nil || !report.Healthy() {
+		t.Fatalf("Expected a healthy vault, got %+v, %v", report, err)
+	}
+
+	damageVault(t, path,
+		"UPDATE secret_versions SET fields = json_set(fields, '$[0].value', '"+fieldCipherPrefix+"garbage')",
+		"DELETE FROM attachment_chunks",
+	)
+	report, err = v.Doctor(ctx, false)
+	if err != nil {
+		t.Fatalf("Doctor failed: %v", err)
+	}
+	var version, attachment bool
+	for _, p := range report.Problems {
+		if p.Check != CheckDecrypt || p.Secret != "server" {
+			t.Errorf("Unexpected problem %+v", p)
+		}
+		version = version || (p.Version == 1 && p.Field == "password")
+		attachment = attachment || p.Attachment == "notes.txt"
+	}
+	if len(report.Problems) != 2 || !version || !attachment {
+		t.Errorf("Expected the damaged version and attachment, got %+v", report.Problems)
+	}
+}
+
+func TestVaultDoctorRebuildsTagIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+
+	v, err := Init(path, "testpassword123")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	defer v.Close()
+	if _, err := v.EncryptMetadata(ctx); err != nil {
+		t.Fatalf("EncryptMetadata failed: %v", err)
+	}
+
+	secret := model.NewSecretObject("tagged")
+	secret.Tags = []string{"work"}
+	secret.AddField(model.NewField("pin", "1234"))
+	if err := v.Create(ctx, secret); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	damageVault(t, path, "UPDATE secrets SET tags = 'null'")
+
+	report, err := v.Doctor(ctx, true)
+	if err != nil {
+		t.Fatalf("Doctor failed: %v", err)
+	}
+	if !report.Healthy() || len(report.Problems) != 1 || report.Problems[0].Secret != "tagged" {
+		t.Fatalf("Expected one repaired tag problem, got %+v", report.Problems)
+	}
+
+	found, err := v.List(ctx, &store.SearchOptions{Tags: []string{"work"}})
+	if err != nil || len(found) != 1 {
+		t.Errorf("Tag filter after repair = %d secrets, %v", len(found), err)
+	}
+}
+
+func TestVaultID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	password := "testpassword123"
+
+	v, err := Init(path, password)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	id := v.ID()
+	v.Close()
+
+	if got, err := ReadID(path); err != nil || got != id || id == "" {
+		t.Fatalf("ReadID = %q, %v; want %q", got, err, id)
+	}
+	if _, err := ReadID(filepath.Join(t.TempDir(), "missing.db")); !errors.Is(err, ErrNotExists) {
+		t.Errorf("Expected ErrNotExists, got %v", err)
+	}
+
+	// Vaults from before vault IDs get one when next unlocked
+	damageVault(t, path, "DELETE FROM vault_meta WHERE key = 'vault_id'")
+	if _, err := ReadID(path); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected store.ErrNotFound, got %v", err)
+	}
+	v, err = Open(path, password)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	assigned := v.ID()
+	v.Close()
+	if assigned == "" || assigned == id {
+		t.Errorf("Expected a new vault ID, got %q", assigned)
+	}
+	if got, _ := ReadID(path); got != assigned {
+		t.Errorf("ReadID = %q, want %q", got, assigned)
+	}
+}
+
+func TestVaultLifecycle(t *testing.T) {
+	for _, metadata := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "test.db")
+		ctx := context.Background()
+
+		v, err := Init(path, "testpassword123")
+		if err != nil {
+			t.Fatalf("Init failed: %v", err)
+		}
+		if metadata {
+			if _, err := v.EncryptMetadata(ctx); err != nil {
+				t.Fatalf("EncryptMetadata failed: %v", err)
+			}
+		}
+
+		// Rotation counts from creation unless told otherwise
+		fresh := model.NewSecretObject("fresh")
+		fresh.RotateEvery = model.Period(90 * 24 * time.Hour)
+		fresh.AddField(model.NewField("value", "one"))
+		if err := v.Create(ctx, fresh); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if !fresh.RotatedAt.Equal(fresh.CreatedAt) {
+			t.Errorf("RotatedAt = %v, want the creation time %v", fresh.RotatedAt, fresh.CreatedAt)
+		}
+
+		now := time.Now()
+		stale := model.NewSecretObject("stale")
+		stale.RotateEvery = model.Period(30 * 24 * time.Hour)
+		stale.RotatedAt = now.Add(-40 * 24 * time.Hour)
+		stale.AddField(model.NewField("password", "old"))
+		expiring := model.NewSecretObject("cert")
+		expiring.ExpiresAt = now.Add(10 * 24 * time.Hour)
+		expired := model.NewSecretObject("legacy")
+		expired.ExpiresAt = now.Add(-time.Hour)
+		for _, s := range []*model.SecretObject{stale, expiring, expired, model.NewSecretObject("plain")} {
+			if err := v.Create(ctx, s); err != nil {
+				t.Fatalf("Create %s failed: %v", s.Name, err)
+			}
+		}
+
+		due, err := v.Expiring(ctx, 30*24*time.Hour)
+		if err != nil {
+			t.Fatalf("Expiring failed: %v", err)
+		}
+		var names []string
+		for _, s := range due {
+			names = append(names, s.Name)
+		}
+		if got := strings.Join(names, ","); got != "stale,legacy,cert" {
+			t.Errorf("Expiring = %s, want stale,legacy,cert", got)
+		}
+		if due, err := v.Expiring(ctx, 0); err != nil || len(due) != 2 {
+			t.Errorf("Expiring(0) = %d secrets, %v; want the two overdue", len(due), err)
+		}
+
+		// Changing tags leaves the rotation alone, changing a value resets it
+		got, err := v.GetByName(ctx, "stale")
+		if err != nil {
+			t.Fatalf("GetByName failed: %v", err)
+		}
+		if got.RotateEvery != stale.RotateEvery || !got.RotatedAt.Equal(stale.RotatedAt.Truncate(time.Second)) {
+			t.Errorf("GetByName lost the lifecycle: %+v", got)
+		}
+		got.Tags = []string{"db"}
+		if err := v.Update(ctx, got); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+		if got, _ = v.GetByName(ctx, "stale"); !got.RotatedAt.Equal(stale.RotatedAt.Truncate(time.Second)) {
+			t.Errorf("Tag change moved RotatedAt to %v", got.RotatedAt)
+		}
+		got.Fields[0].Value = "new"
+		if err := v.Update(ctx, got); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+		if got, _ = v.GetByName(ctx, "stale"); time.Since(got.RotatedAt) > time.Minute {
+			t.Errorf("Value change left RotatedAt at %v", got.RotatedAt)
+		}
 
-	return v, tmpfile.Name()
+		// Rolling back restores the old value, keeping the current lifecycle
+		got.ExpiresAt = now.Add(365 * 24 * time.Hour).Truncate(time.Second)
+		if err := v.Update(ctx, got); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+		restored, err := v.Rollback(ctx, "stale", 1)
+		if err != nil {
+			t.Fatalf("Rollback failed: %v", err)
+		}
+		if restored.Fields[0].Value != "old" || !restored.ExpiresAt.Equal(got.ExpiresAt) || restored.RotateEvery != stale.RotateEvery {
+			t.Errorf("Rollback = %+v", restored)
+		}
+		v.Close()
+	}
 }
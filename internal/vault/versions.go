@@ -0,0 +1,113 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/TheEditor/keyp/internal/model"
+	"github.com/TheEditor/keyp/internal/store"
+)
+
+// History lists the versions of a secret, oldest first. The last one is
+// its current state.
+func (v *Vault) History(ctx context.Context, name string) ([]store.VersionInfo, error) {
+	if v.IsLocked() {
+		return nil, ErrLocked
+	}
+	current, err := v.storedSecret(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return v.store.Versions(ctx, current.ID)
+}
+
+// GetVersion retrieves and decrypts a version of a secret. The number of
+// the current version selects the secret as it is now.
+func (v *Vault) GetVersion(ctx context.Context, name string, number int) (*model.SecretObject, error) {
+	if v.IsLocked() {
+		return nil, ErrLocked
+	}
+	current, err := v.storedSecret(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := v.store.GetVersion(ctx, current.ID, number)
+	if errors.Is(err, store.ErrVersionNotFound) {
+		versions, listErr := v.store.Versions(ctx, current.ID)
+		if listErr != nil {
+			return nil, listErr
+		}
+		if versions[len(versions)-1].Version == number {
+			return v.decryptSecret(current)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v.decryptSecret(version.Secret)
+}
+
+// Rollback makes an earlier version of a secret current again, under its
+// current name, and returns the restored secret. The state it replaces is
+// kept in the history, like on any update.
+func (v *Vault) Rollback(ctx context.Context, name string, number int) (*model.SecretObject, error) {
+	if v.IsLocked() {
+		return nil, ErrLocked
+	}
+	current, err := v.storedSecret(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	version, err := v.store.GetVersion(ctx, current.ID, number)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decrypting first checks the version is intact; it is then sealed
+	// again like any update, with the current cipher
+	restored, err := v.decryptSecret(version.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt version %d: %w", number, err)
+	}
+	// The secret keeps its current name, which may have changed since;
+	// encryptSecret derives the name key from it
+	if v.metadataEncrypted {
+		if err := v.revealMetadata(current); err != nil {
+			return nil, err
+		}
+	}
+	restored.Name = current.Name
+	restored.CreatedAt = current.CreatedAt
+	// Expiry and rotation are not kept in the history
+	restored.ExpiresAt = current.ExpiresAt
+	restored.RotateEvery = current.RotateEvery
+	if err := v.Update(ctx, restored); err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
+
+// storedSecret returns a secret by name as it is stored, without decrypting it
+func (v *Vault) storedSecret(ctx context.Context, name string) (*model.SecretObject, error) {
+	key, err := v.nameKey(name)
+	if err != nil {
+		return nil, err
+	}
+	return v.store.GetByName(ctx, key)
+}
+
+// decryptVersions decrypts the stored history of every secret
+func (v *Vault) decryptVersions(ctx context.Context) ([]*store.Version, error) {
+	versions, err := v.store.AllVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range versions {
+		if version.Secret, err = v.decryptSecret(version.Secret); err != nil {
+			return nil, fmt.Errorf("failed to decrypt version %d: %w", version.Version, err)
+		}
+	}
+	return versions, nil
+}